@@ -0,0 +1,69 @@
+// A user of a zcluster gateway (zcluster网关的用户)
+//
+//	go run ./examples/zinx_cluster/client -port 8002 -user bob
+//	go run ./examples/zinx_cluster/client -port 8001 -user alice -to bob -text hello
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"time"
+
+	"github.com/aceld/zinx/ziface"
+	"github.com/aceld/zinx/zlog"
+	"github.com/aceld/zinx/znet"
+)
+
+const (
+	LoginMsgID  = 1
+	SendMsgID   = 2
+	NoticeMsgID = 10
+	PingMsgID   = 2001
+)
+
+// PrintRouter prints the messages received (打印收到的消息)
+type PrintRouter struct {
+	znet.BaseRouter
+}
+
+func (r *PrintRouter) Handle(request ziface.IRequest) {
+	fmt.Printf("<== msgID = %d, data = %s\n", request.GetMsgID(), request.GetData())
+}
+
+func main() {
+	port := flag.Int("port", 8001, "gateway port on 127.0.0.1")
+	user := flag.String("user", "alice", "user ID to login with")
+	to := flag.String("to", "", "user to send a text to")
+	text := flag.String("text", "hello", "text sent to the user")
+	flag.Parse()
+
+	client := znet.NewClient("127.0.0.1", *port)
+	client.AddRouter(NoticeMsgID, &PrintRouter{})
+	client.AddRouter(PingMsgID, &PrintRouter{})
+	client.SetOnConnStart(func(conn ziface.IConnection) {
+		go func() {
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+			defer cancel()
+			reply, err := conn.Call(ctx, LoginMsgID, []byte(*user))
+			if err != nil {
+				zlog.Ins().ErrorF("login err: %v", err)
+				return
+			}
+			fmt.Println(string(reply.GetData()))
+
+			_ = conn.SendMsg(PingMsgID, nil)
+			if *to != "" {
+				_ = conn.SendMsg(SendMsgID, []byte(*to+":"+*text))
+			}
+		}()
+	})
+	client.Start()
+	defer client.Stop()
+
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, os.Interrupt)
+	<-c
+}
@@ -0,0 +1,104 @@
+// A node of a zcluster, run several of them on loopback (zcluster的一个节点, 可在本机运行多个)
+//
+//	go run ./examples/zinx_cluster/node -id A -cluster 127.0.0.1:7001 -gateway 127.0.0.1:8001 -backend C
+//	go run ./examples/zinx_cluster/node -id B -cluster 127.0.0.1:7002 -gateway 127.0.0.1:8002 -backend C -peers 127.0.0.1:7001
+//	go run ./examples/zinx_cluster/node -id C -cluster 127.0.0.1:7003 -peers 127.0.0.1:7001
+//
+// then connect users with examples/zinx_cluster/client (然后用examples/zinx_cluster/client连接用户)
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+
+	"github.com/aceld/zinx/zcluster"
+	"github.com/aceld/zinx/ziface"
+	"github.com/aceld/zinx/zlog"
+	"github.com/aceld/zinx/znet"
+)
+
+const (
+	LoginMsgID  = 1    // Data is the user ID (数据为用户ID)
+	SendMsgID   = 2    // Data is "user:text" (数据为"用户:内容")
+	NoticeMsgID = 10   // Pushed to the users (推送给用户)
+	PingMsgID   = 2001 // Forwarded to the backend (转发给后端)
+)
+
+// SendRouter sends a text to a user on any node (向任意节点上的用户发送文本)
+type SendRouter struct {
+	znet.BaseRouter
+	cluster *zcluster.Cluster
+}
+
+func (r *SendRouter) Handle(request ziface.IRequest) {
+	parts := strings.SplitN(string(request.GetData()), ":", 2)
+	if len(parts) != 2 {
+		return
+	}
+	from := fmt.Sprint(request.GetConnection().GetIdentity())
+	if err := r.cluster.SendToUser(parts[0], NoticeMsgID, []byte(from+": "+parts[1])); err != nil {
+		zlog.Ins().ErrorF("send to %s err: %v", parts[0], err)
+	}
+}
+
+func main() {
+	id := flag.String("id", "A", "node ID")
+	clusterAddr := flag.String("cluster", "127.0.0.1:7001", "ip:port of the cluster port")
+	gatewayAddr := flag.String("gateway", "", "ip:port of the gateway, none for a backend")
+	peers := flag.String("peers", "", "comma separated ip:port of the cluster ports of other nodes")
+	backend := flag.String("backend", "", "node ID the PingMsgID messages are forwarded to")
+	gossip := flag.Bool("gossip", true, "gossip the membership")
+	flag.Parse()
+
+	conf := zcluster.Config{NodeID: *id, Addr: *clusterAddr, Gossip: *gossip}
+	if *peers != "" {
+		conf.Peers = strings.Split(*peers, ",")
+	}
+
+	var gateway ziface.IServer
+	if *gatewayAddr != "" {
+		gateway = znet.NewServer(znet.WithListener(ziface.ListenerConfig{
+			Name:    "gateway",
+			Network: ziface.ListenerTCP,
+			Addr:    *gatewayAddr,
+		}))
+		gateway.SetAuthenticator(func(request ziface.IRequest) (interface{}, bool, error) {
+			if request.GetMsgID() != LoginMsgID || len(request.GetData()) == 0 {
+				return nil, false, fmt.Errorf("login expected")
+			}
+			_ = request.Reply([]byte("welcome to node " + *id))
+			return string(request.GetData()), true, nil
+		}, nil)
+	}
+
+	cluster, err := zcluster.New(conf, gateway)
+	if err != nil {
+		zlog.Ins().ErrorF("%v", err)
+		return
+	}
+	if gateway != nil {
+		gateway.AddRouter(SendMsgID, &SendRouter{cluster: cluster})
+		if *backend != "" {
+			if err := cluster.Forward(PingMsgID, PingMsgID, *backend); err != nil {
+				zlog.Ins().ErrorF("%v", err)
+				return
+			}
+		}
+		gateway.Start()
+		defer gateway.Stop()
+	}
+	cluster.HandleForward(PingMsgID, func(request *zcluster.ForwardRequest) {
+		_ = request.Reply([]byte(fmt.Sprintf("pong to %s from node %s", request.UserID, *id)))
+	})
+
+	cluster.Start()
+	defer cluster.Stop()
+
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, os.Interrupt)
+	sig := <-c
+	fmt.Println("===exit===", sig, "members:", cluster.Members())
+}
@@ -32,8 +32,9 @@ func wait() {
 }
 
 func main() {
-	// Create a TLS client.
-	c := znet.NewTLSClient("127.0.0.1", 8899)
+	// Create a TLS client, the server of the example uses a self-signed certificate
+	// (创建TLS客户端, 示例服务端使用的是自签名证书, 因此跳过校验)
+	c := znet.NewTLSClient("127.0.0.1", 8899, znet.WithInsecureSkipVerifyClient())
 
 	c.SetOnConnStart(func(connection ziface.IConnection) {
 		go func() {
@@ -10,7 +10,12 @@ require (
 	google.golang.org/protobuf v1.33.0 // indirect
 )
 
-require github.com/golang/protobuf v1.5.0
+require (
+	github.com/golang/protobuf v1.5.0
+	github.com/golang/snappy v1.0.0
+	github.com/klauspost/compress v1.18.0
+	golang.org/x/crypto v0.45.0
+)
 
 require (
 	github.com/davecgh/go-spew v1.1.1 // indirect
@@ -22,7 +27,6 @@ require (
 	github.com/templexxx/xor v0.0.0-20191217153810-f85b25db303b // indirect
 	github.com/tjfoc/gmsm v1.4.1 // indirect
 	github.com/xtaci/lossyconn v0.0.0-20200209145036-adba10fffc37 // indirect
-	golang.org/x/crypto v0.45.0 // indirect
 	golang.org/x/sys v0.38.0 // indirect
 	gopkg.in/yaml.v3 v3.0.1 // indirect
 )
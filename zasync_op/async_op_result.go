@@ -16,6 +16,8 @@ import (
 type AsyncOpResult struct {
 	// Player connection (玩家连接)
 	conn ziface.IConnection
+	// Dispatch key whose worker runs the completion in zconf.WorkerModeKey (zconf.WorkerModeKey模式下执行完成回调的分发key)
+	key string
 	// Returned object (已返回对象)
 	returnedObj interface{}
 	// Completion callback function(完成回调函数)
@@ -76,7 +78,7 @@ func (aor *AsyncOpResult) doComplete() {
 		// Prevent cross-thread calling problems,
 		// throw it to the corresponding business thread to execute
 		// (防止跨线程调用问题,扔到所属业务线程里去执行)
-		request := znet.NewFuncRequest(aor.conn, cf)
+		request := znet.NewKeyFuncRequest(aor.conn, aor.key, cf)
 		aor.conn.GetMsgHandler().SendMsgToTaskQueue(request)
 	}
 }
@@ -87,3 +89,12 @@ func NewAsyncOpResult(conn ziface.IConnection) *AsyncOpResult {
 	result.conn = conn
 	return result
 }
+
+// NewAsyncOpResultWithKey creates a new asynchronous result whose completion runs on the worker of the dispatch key
+// in zconf.WorkerModeKey, so it stays ordered with the other requests of that key
+// (新建异步结果, zconf.WorkerModeKey模式下完成回调回到分发key所属的worker执行, 与该key的其他请求保持顺序)
+func NewAsyncOpResultWithKey(conn ziface.IConnection, key string) *AsyncOpResult {
+	result := NewAsyncOpResult(conn)
+	result.key = key
+	return result
+}
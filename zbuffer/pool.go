@@ -0,0 +1,68 @@
+// @Title pool.go
+// @Description Size-classed byte buffer pools shared by the read and write paths
+package zbuffer
+
+import (
+	"math/bits"
+	"sync"
+)
+
+const (
+	minShift = 6  // The smallest class holds 64 bytes (最小的规格为64字节)
+	maxShift = 16 // The largest class holds 64KB, bigger buffers are not pooled (最大的规格为64KB, 更大的缓冲不进行池化)
+
+	// MinSize is the capacity of the smallest pooled buffer (最小池化缓冲的容量)
+	MinSize = 1 << minShift
+	// MaxSize is the capacity of the largest pooled buffer (最大池化缓冲的容量)
+	MaxSize = 1 << maxShift
+)
+
+// pools holds one sync.Pool per power of two between MinSize and MaxSize
+// (MinSize到MaxSize之间每个2的幂对应一个sync.Pool)
+var pools [maxShift - minShift + 1]sync.Pool
+
+func init() {
+	for i := range pools {
+		size := 1 << (i + minShift)
+		pools[i].New = func() interface{} {
+			buf := make([]byte, size)
+			return &buf
+		}
+	}
+}
+
+// class returns the index of the smallest class that can hold size bytes, -1 if size is too big
+// (返回能容纳size字节的最小规格下标, 超过MaxSize时返回-1)
+func class(size int) int {
+	if size <= MinSize {
+		return 0
+	}
+	if size > MaxSize {
+		return -1
+	}
+	return bits.Len(uint(size-1)) - minShift
+}
+
+// Get returns a buffer of length size, its capacity is rounded up to the size class.
+// Buffers bigger than MaxSize are allocated directly.
+// (获取长度为size的缓冲, 容量向上取整到对应规格, 超过MaxSize的缓冲直接分配)
+func Get(size int) []byte {
+	i := class(size)
+	if i < 0 {
+		return make([]byte, size)
+	}
+	buf := pools[i].Get().(*[]byte)
+	return (*buf)[:size]
+}
+
+// Put returns a buffer obtained by Get, the buffer must not be used after Put.
+// Buffers whose capacity is not a size class are left to the GC.
+// (归还通过Get获取的缓冲, 归还后不能再使用; 容量不是标准规格的缓冲交给GC回收)
+func Put(buf []byte) {
+	c := cap(buf)
+	if c < MinSize || c > MaxSize || c&(c-1) != 0 {
+		return
+	}
+	buf = buf[:c]
+	pools[bits.Len(uint(c))-1-minShift].Put(&buf)
+}
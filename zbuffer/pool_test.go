@@ -0,0 +1,37 @@
+package zbuffer
+
+import "testing"
+
+func TestGetPut(t *testing.T) {
+	cases := []struct {
+		size, cap int
+	}{
+		{0, MinSize},
+		{1, MinSize},
+		{MinSize, MinSize},
+		{MinSize + 1, MinSize * 2},
+		{1000, 1024},
+		{MaxSize, MaxSize},
+		{MaxSize + 1, MaxSize + 1},
+	}
+
+	for _, c := range cases {
+		buf := Get(c.size)
+		if len(buf) != c.size || cap(buf) != c.cap {
+			t.Fatalf("Get(%d) len = %d cap = %d, want %d %d", c.size, len(buf), cap(buf), c.size, c.cap)
+		}
+		Put(buf)
+	}
+
+	// Buffers that are not a size class are ignored (非标准规格的缓冲会被忽略)
+	Put(make([]byte, 100))
+	Put(nil)
+}
+
+func BenchmarkGetPut(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		buf := Get(512)
+		Put(buf)
+	}
+}
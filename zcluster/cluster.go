@@ -0,0 +1,411 @@
+// Package zcluster connects Zinx servers into a cluster, so that a node can send to the users and
+// connections of any other node, broadcast to the whole cluster and forward ranges of MsgIDs to a backend node.
+// The nodes talk to each other over Zinx itself, each node listens on a cluster port with a Server
+// and dials every other member with a Client.
+// (将多个Zinx服务组成集群, 节点可以向其他任意节点上的用户和连接发送消息、向整个集群广播, 以及将一段MsgID转发给后端节点。
+// 节点之间使用Zinx本身通信, 每个节点用一个Server监听集群端口, 并用Client连接其他每个成员)
+package zcluster
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/aceld/zinx/zconf"
+	"github.com/aceld/zinx/ziface"
+	"github.com/aceld/zinx/zlog"
+	"github.com/aceld/zinx/znet"
+)
+
+const (
+	DefaultGossipInterval = time.Second
+	DefaultSuspectTimeout = 5 * time.Second
+
+	// helloTimeout is how long a node waits for a peer to answer its hello (节点等待对端应答握手的时长)
+	helloTimeout = 5 * time.Second
+)
+
+var (
+	// ErrNodeUnreachable is returned when the node has no link to the destination node
+	// (节点与目标节点之间没有连接时返回的错误)
+	ErrNodeUnreachable = errors.New("zcluster: node unreachable")
+
+	// ErrUserNotFound is returned by SendToUser when the user is not local and no other node is connected
+	// (用户不在本节点且没有连接其他节点时SendToUser返回的错误)
+	ErrUserNotFound = errors.New("zcluster: user not found")
+
+	errBadSecret = errors.New("zcluster: bad cluster secret")
+)
+
+// Config is the setting of a cluster node (集群节点的设置)
+type Config struct {
+	// Unique name of the node in the cluster (节点在集群中的唯一名称)
+	NodeID string
+
+	// Address of the cluster port, "ip:port" (集群端口的监听地址, "ip:port")
+	Addr string
+
+	// Address the other nodes dial, Addr by default (其他节点连接时使用的地址, 默认为Addr)
+	AdvertiseAddr string
+
+	// Addresses of the nodes dialed at start, "ip:port" (启动时连接的节点地址, "ip:port")
+	Peers []string
+
+	// Exchange the membership with gossip, so the nodes learn the members they were not given in Peers,
+	// and drop the members not heard from for SuspectTimeout. Without gossip the members are Peers and
+	// the nodes that dialed this node.
+	// (通过gossip交换成员信息, 节点可以得知Peers以外的成员, 并移除SuspectTimeout内没有消息的成员;
+	// 不开启时成员为Peers及连接到本节点的节点)
+	Gossip         bool
+	GossipInterval time.Duration // 1s by default (默认1秒)
+	SuspectTimeout time.Duration // 5s by default (默认5秒)
+
+	// Shared by every node, a node with another secret can not join. It is sent in plaintext in the hello,
+	// so keep the cluster port on a private network or behind TLS or an encrypted tunnel.
+	// (所有节点共享的密钥, 密钥不同的节点无法加入; 握手时以明文发送, 集群端口应位于内网或使用TLS、加密隧道)
+	Secret string
+}
+
+// Member is a node of the cluster as seen by this node (本节点看到的集群节点)
+type Member struct {
+	ID        string
+	Addr      string
+	Connected bool // This node has a link to it (本节点与其有连接)
+}
+
+// Option sets up a cluster node (集群节点的设置)
+type Option func(c *Cluster)
+
+// WithSessions finds the local users through their sessions, otherwise the identity of the connections
+// accepted by the AuthHandler is used as the user ID
+// (通过会话查找本地用户, 未设置时使用AuthHandler认证得到的连接身份作为用户ID)
+func WithSessions(sessions ziface.ISessionManager) Option {
+	return func(c *Cluster) {
+		c.sessions = sessions
+	}
+}
+
+// Cluster is a node of a cluster, the messages sent to the users and connections of the node are
+// sent through its gateway server, a node without a gateway only handles the messages forwarded to it
+// (集群中的一个节点, 发给本节点用户和连接的消息通过其网关服务发送, 没有网关的节点只处理转发给它的消息)
+type Cluster struct {
+	conf     Config
+	gateway  ziface.IServer
+	sessions ziface.ISessionManager
+	server   ziface.IServer
+
+	lock      sync.RWMutex
+	heartbeat uint64             // Starts from the start time so a restarted node outruns its old heartbeat (从启动时间开始, 使重启的节点超过旧的心跳)
+	members   map[string]*member // By node ID, without this node (按节点ID保存, 不含本节点)
+	links     map[string]*link   // By the address dialed (按拨号地址保存)
+	byNode    map[string]*link   // The links that finished the hello, by node ID (完成握手的连接, 按节点ID保存)
+	dead      map[string]uint64  // Heartbeats of the members dropped (被移除成员的心跳)
+	handlers  map[uint32]ForwardHandler
+
+	quit chan struct{}
+	wg   sync.WaitGroup
+}
+
+// member is a node known to this node (本节点已知的节点)
+type member struct {
+	id        string
+	addr      string
+	heartbeat uint64    // Heartbeat of the member gossiped last (最近一次gossip得到的成员心跳)
+	seen      time.Time // When the heartbeat last increased (心跳最近一次增加的时间)
+}
+
+// New returns a node of a cluster, gateway is the server the users connect to and may be nil for a backend node
+// (返回集群的一个节点, gateway为用户连接的服务, 后端节点可以为nil)
+func New(conf Config, gateway ziface.IServer, opts ...Option) (*Cluster, error) {
+	if conf.NodeID == "" {
+		return nil, errors.New("zcluster: NodeID required")
+	}
+	if _, _, err := splitAddr(conf.Addr); err != nil {
+		return nil, err
+	}
+	if conf.AdvertiseAddr == "" {
+		conf.AdvertiseAddr = conf.Addr
+	}
+	if conf.GossipInterval <= 0 {
+		conf.GossipInterval = DefaultGossipInterval
+	}
+	if conf.SuspectTimeout <= 0 {
+		conf.SuspectTimeout = DefaultSuspectTimeout
+	}
+
+	c := &Cluster{
+		conf:      conf,
+		gateway:   gateway,
+		heartbeat: uint64(time.Now().UnixNano()),
+		members:   make(map[string]*member),
+		links:     make(map[string]*link),
+		byNode:    make(map[string]*link),
+		dead:      make(map[string]uint64),
+		handlers:  make(map[uint32]ForwardHandler),
+		quit:      make(chan struct{}),
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+
+	// The links carry the traffic of every user of a gateway and may be quiet without gossip,
+	// so the cluster port has no rate limits or idle timeouts of its own
+	// (连接承载网关所有用户的流量, 未开启gossip时可能长时间没有消息, 因此集群端口不开启限流和空闲超时)
+	c.server = znet.NewUserConfServer(&zconf.Config{}, znet.WithListener(ziface.ListenerConfig{
+		Name:    "zcluster://" + conf.Addr,
+		Network: ziface.ListenerTCP,
+		Addr:    conf.Addr,
+	}))
+	// Nothing but the hello is accepted before a node identifies itself (节点表明身份前只接受握手消息)
+	c.server.SetAuthenticator(c.accept, nil)
+	c.handle(msgGossip, c.onGossip)
+	c.handle(msgToUser, c.onToUser)
+	c.handle(msgToConn, c.onToConn)
+	c.handle(msgBroadcast, c.onBroadcast)
+	c.handle(msgForward, c.onForward)
+	return c, nil
+}
+
+// handle adds a router of the cluster server in either router mode (以任一路由模式添加集群服务的路由)
+func (c *Cluster) handle(msgID uint32, fn func(request ziface.IRequest)) {
+	if c.server.IsRouterSlicesMode() {
+		c.server.AddRouterSlices(msgID, fn)
+		return
+	}
+	c.server.AddRouter(msgID, &clusterRouter{handle: fn})
+}
+
+type clusterRouter struct {
+	znet.BaseRouter
+	handle func(request ziface.IRequest)
+}
+
+func (r *clusterRouter) Handle(request ziface.IRequest) {
+	r.handle(request)
+}
+
+// NodeID returns the ID of this node (返回本节点的ID)
+func (c *Cluster) NodeID() string {
+	return c.conf.NodeID
+}
+
+// Start listens on the cluster port and dials the peers (监听集群端口并连接Peers)
+func (c *Cluster) Start() {
+	c.server.Start()
+	for _, addr := range c.conf.Peers {
+		if addr != c.conf.AdvertiseAddr && addr != c.conf.Addr {
+			c.dial(addr)
+		}
+	}
+	c.wg.Add(1)
+	go c.gossipLoop()
+}
+
+// Stop closes the links to the other nodes and the cluster port (关闭与其他节点的连接及集群端口)
+func (c *Cluster) Stop() {
+	select {
+	case <-c.quit:
+		return
+	default:
+	}
+	close(c.quit)
+	c.wg.Wait()
+
+	c.lock.Lock()
+	links := c.links
+	c.links = make(map[string]*link)
+	c.byNode = make(map[string]*link)
+	c.lock.Unlock()
+	for _, l := range links {
+		l.client.Stop()
+	}
+	c.server.Stop()
+}
+
+// Members returns the other nodes known to this node, sorted by ID (返回本节点已知的其他节点, 按ID排序)
+func (c *Cluster) Members() []Member {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	members := make([]Member, 0, len(c.members))
+	for _, m := range c.members {
+		members = append(members, Member{ID: m.id, Addr: m.addr, Connected: c.byNode[m.id] != nil})
+	}
+	sort.Slice(members, func(i, j int) bool { return members[i].ID < members[j].ID })
+	return members
+}
+
+// SendToUser sends a message to the user on whichever node the user is connected to. A user not on this node
+// is looked for on every connected node, so nil does not mean the user was found.
+// (向用户所在的节点发送消息; 用户不在本节点时会发给所有已连接的节点查找, 因此返回nil不代表找到了用户)
+func (c *Cluster) SendToUser(userID string, msgID uint32, data []byte) error {
+	if conn := c.localUser(userID); conn != nil {
+		return conn.SendMsg(msgID, data)
+	}
+	if c.sendAll(msgToUser, &envelope{UserID: userID, MsgID: msgID, Data: data}) == 0 {
+		return ErrUserNotFound
+	}
+	return nil
+}
+
+// SendToConn sends a message to the connection connID of the gateway of node nodeID
+// (向nodeID节点网关上的connID连接发送消息)
+func (c *Cluster) SendToConn(nodeID string, connID uint64, msgID uint32, data []byte) error {
+	if nodeID == c.conf.NodeID {
+		return c.sendLocalConn(connID, msgID, data)
+	}
+	return c.send(nodeID, msgToConn, &envelope{ConnID: connID, MsgID: msgID, Data: data})
+}
+
+// Broadcast sends a message to every connection of the gateways of the cluster
+// (向集群中所有网关的全部连接发送消息)
+func (c *Cluster) Broadcast(msgID uint32, data []byte) {
+	c.broadcastLocal(msgID, data)
+	c.sendAll(msgBroadcast, &envelope{MsgID: msgID, Data: data})
+}
+
+// localUser returns the connection of the user on the gateway of this node, or nil
+// (返回用户在本节点网关上的连接, 没有时为nil)
+func (c *Cluster) localUser(userID string) ziface.IConnection {
+	if c.sessions != nil {
+		conn, _ := c.sessions.GetConnection(userID)
+		return conn
+	}
+	if c.gateway == nil {
+		return nil
+	}
+	var found ziface.IConnection
+	_ = c.gateway.GetConnMgr().Range(func(_ uint64, conn ziface.IConnection, _ interface{}) error {
+		if found == nil && c.userOf(conn) == userID {
+			found = conn
+		}
+		return nil
+	}, nil)
+	return found
+}
+
+// userOf returns the user ID of a connection of the gateway, "" before it is authenticated
+// (返回网关连接的用户ID, 认证前为"")
+func (c *Cluster) userOf(conn ziface.IConnection) string {
+	if c.sessions != nil {
+		if s := c.sessions.GetByConn(conn); s != nil {
+			return s.UserID()
+		}
+		return ""
+	}
+	if identity := conn.GetIdentity(); identity != nil {
+		return fmt.Sprint(identity)
+	}
+	return ""
+}
+
+func (c *Cluster) sendLocalConn(connID uint64, msgID uint32, data []byte) error {
+	if c.gateway == nil {
+		return fmt.Errorf("zcluster: node %s has no gateway", c.conf.NodeID)
+	}
+	conn, err := c.gateway.GetConnMgr().Get(connID)
+	if err != nil {
+		return err
+	}
+	return conn.SendMsg(msgID, data)
+}
+
+func (c *Cluster) broadcastLocal(msgID uint32, data []byte) {
+	if c.gateway == nil {
+		return
+	}
+	_ = c.gateway.GetConnMgr().Range(func(_ uint64, conn ziface.IConnection, _ interface{}) error {
+		if err := conn.SendBuffMsg(msgID, data); err != nil {
+			zlog.Ins().ErrorF("zcluster broadcast msgID = %d to ConnID = %d err: %v", msgID, conn.GetConnID(), err)
+		}
+		return nil
+	}, nil)
+}
+
+// send sends a cluster message to a node (向节点发送集群消息)
+func (c *Cluster) send(nodeID string, msgID uint32, v interface{}) error {
+	c.lock.RLock()
+	l := c.byNode[nodeID]
+	c.lock.RUnlock()
+	if l == nil {
+		return ErrNodeUnreachable
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return l.send(msgID, data)
+}
+
+// sendAll sends a cluster message to every connected node and returns how many it was sent to
+// (向所有已连接的节点发送集群消息, 返回发送的节点数)
+func (c *Cluster) sendAll(msgID uint32, v interface{}) int {
+	data, err := json.Marshal(v)
+	if err != nil {
+		zlog.Ins().ErrorF("zcluster marshal msgID = %d err: %v", msgID, err)
+		return 0
+	}
+	c.lock.RLock()
+	links := make([]*link, 0, len(c.byNode))
+	for _, l := range c.byNode {
+		links = append(links, l)
+	}
+	c.lock.RUnlock()
+
+	sent := 0
+	for _, l := range links {
+		if err := l.send(msgID, data); err != nil {
+			zlog.Ins().ErrorF("zcluster send msgID = %d to node %s err: %v", msgID, l.nodeID(), err)
+			continue
+		}
+		sent++
+	}
+	return sent
+}
+
+func (c *Cluster) onToUser(request ziface.IRequest) {
+	env, ok := decode(request)
+	if !ok {
+		return
+	}
+	// The other nodes were asked too, a user not here is none of this node's business
+	// (其他节点也收到了该消息, 用户不在本节点时无需处理)
+	if conn := c.localUser(env.UserID); conn != nil {
+		if err := conn.SendMsg(env.MsgID, env.Data); err != nil {
+			zlog.Ins().ErrorF("zcluster send msgID = %d to user %s err: %v", env.MsgID, env.UserID, err)
+		}
+	}
+}
+
+func (c *Cluster) onToConn(request ziface.IRequest) {
+	if env, ok := decode(request); ok {
+		if err := c.sendLocalConn(env.ConnID, env.MsgID, env.Data); err != nil {
+			zlog.Ins().ErrorF("zcluster send msgID = %d to ConnID = %d err: %v", env.MsgID, env.ConnID, err)
+		}
+	}
+}
+
+func (c *Cluster) onBroadcast(request ziface.IRequest) {
+	if env, ok := decode(request); ok {
+		c.broadcastLocal(env.MsgID, env.Data)
+	}
+}
+
+func splitAddr(addr string) (string, int, error) {
+	host, portStr, err := net.SplitHostPort(addr)
+	if err != nil {
+		return "", 0, fmt.Errorf("zcluster: bad address %q: %v", addr, err)
+	}
+	if net.ParseIP(host) == nil {
+		return "", 0, fmt.Errorf("zcluster: address %q is not ip:port", addr)
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil {
+		return "", 0, fmt.Errorf("zcluster: bad port in %q", addr)
+	}
+	return host, port, nil
+}
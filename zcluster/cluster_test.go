@@ -0,0 +1,238 @@
+package zcluster
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/aceld/zinx/zconf"
+	"github.com/aceld/zinx/ziface"
+	"github.com/aceld/zinx/znet"
+)
+
+// run in terminal:
+// go test -v ./zcluster -run=TestCluster
+
+const (
+	testLoginMsgID = 1
+	testPushMsgID  = 10
+)
+
+// testRouter hands the messages a client received to a channel (将客户端收到的消息交给channel)
+type testRouter struct {
+	znet.BaseRouter
+	got chan string
+}
+
+func (r *testRouter) Handle(request ziface.IRequest) {
+	r.got <- string(request.GetData())
+}
+
+// newGateway returns a gateway whose users log in with their user ID (返回以用户ID登录的网关)
+func newGateway(addr string) ziface.IServer {
+	s := znet.NewServer(znet.WithListener(ziface.ListenerConfig{Name: addr, Network: ziface.ListenerTCP, Addr: addr}))
+	s.SetAuthenticator(func(request ziface.IRequest) (interface{}, bool, error) {
+		_ = request.Reply([]byte("ok"))
+		return string(request.GetData()), true, nil
+	}, nil)
+	return s
+}
+
+// login connects a user to a gateway, the messages pushed to the user come out of the channel
+// (用户连接并登录网关, 推送给用户的消息从channel取出)
+func login(t *testing.T, port int, userID string) (ziface.IClient, chan string) {
+	got := make(chan string, 8)
+	client := znet.NewClient("127.0.0.1", port)
+	client.AddRouter(testPushMsgID, &testRouter{got: got})
+	client.AddRouter(2001, &testRouter{got: got})
+	client.Start()
+
+	deadline := time.Now().Add(time.Second * 3)
+	for client.Conn() == nil && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond * 50)
+	}
+	if client.Conn() == nil {
+		t.Fatalf("%s not connected", userID)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+	defer cancel()
+	if _, err := client.Conn().Call(ctx, testLoginMsgID, []byte(userID)); err != nil {
+		t.Fatalf("%s login err: %v", userID, err)
+	}
+	return client, got
+}
+
+func expect(t *testing.T, got chan string, want string) {
+	t.Helper()
+	select {
+	case data := <-got:
+		if data != want {
+			t.Fatalf("got %q, want %q", data, want)
+		}
+	case <-time.After(time.Second * 3):
+		t.Fatalf("%q not received", want)
+	}
+}
+
+func waitMembers(t *testing.T, c *Cluster, want ...string) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second * 5)
+	for {
+		members := c.Members()
+		ok := len(members) == len(want)
+		for i := 0; ok && i < len(want); i++ {
+			ok = members[i].ID == want[i] && members[i].Connected
+		}
+		if ok {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("node %s members = %+v, want %v", c.NodeID(), members, want)
+		}
+		time.Sleep(time.Millisecond * 50)
+	}
+}
+
+// waitPortFree waits for the listener of a node stopped to release its port (等待已停止节点的监听释放端口)
+func waitPortFree(t *testing.T, addr string) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second * 3)
+	for {
+		l, err := net.Listen("tcp", addr)
+		if err == nil {
+			_ = l.Close()
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("%s not released: %v", addr, err)
+		}
+		time.Sleep(time.Millisecond * 50)
+	}
+}
+
+func TestCluster(t *testing.T) {
+	conf := func(id, addr string, peers ...string) Config {
+		return Config{
+			NodeID:         id,
+			Addr:           addr,
+			Peers:          peers,
+			Gossip:         true,
+			GossipInterval: time.Millisecond * 100,
+			SuspectTimeout: time.Millisecond * 800,
+			Secret:         "s3cret",
+		}
+	}
+
+	// A and B are gateways, C is a backend only A and B were told of
+	// (A和B为网关, C为后端, 只知道A; 其余节点通过gossip得知)
+	gatewayA, gatewayB := newGateway("127.0.0.1:19017"), newGateway("127.0.0.1:19018")
+	a, err := New(conf("A", "127.0.0.1:19019"), gatewayA)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, _ := New(conf("B", "127.0.0.1:19020", "127.0.0.1:19019"), gatewayB)
+	c, _ := New(conf("C", "127.0.0.1:19021", "127.0.0.1:19019"), nil)
+
+	// Messages 2000-2009 of A's users are handled by C (A的用户的2000-2009消息由C处理)
+	if err := a.Forward(2000, 2009, "C"); err != nil {
+		t.Fatal(err)
+	}
+	c.HandleForward(2001, func(request *ForwardRequest) {
+		_ = request.Reply([]byte("pong:" + request.UserID + ":" + string(request.Data)))
+	})
+
+	gatewayA.Start()
+	gatewayB.Start()
+	defer gatewayA.Stop()
+	defer gatewayB.Stop()
+	for _, n := range []*Cluster{a, b, c} {
+		n.Start()
+		defer n.Stop()
+	}
+
+	waitMembers(t, a, "B", "C")
+	waitMembers(t, b, "A", "C")
+	waitMembers(t, c, "A", "B")
+
+	alice, aliceGot := login(t, 19017, "alice")
+	defer alice.Stop()
+	bob, bobGot := login(t, 19018, "bob")
+	defer bob.Stop()
+
+	// To a user on another node, and on this node (发给其他节点上的用户, 以及本节点上的用户)
+	if err := a.SendToUser("bob", testPushMsgID, []byte("hi bob")); err != nil {
+		t.Fatal(err)
+	}
+	expect(t, bobGot, "hi bob")
+	if err := a.SendToUser("alice", testPushMsgID, []byte("hi alice")); err != nil {
+		t.Fatal(err)
+	}
+	expect(t, aliceGot, "hi alice")
+
+	// Broadcast by a backend reaches the users of every gateway (后端的广播到达所有网关的用户)
+	c.Broadcast(testPushMsgID, []byte("news"))
+	expect(t, aliceGot, "news")
+	expect(t, bobGot, "news")
+
+	// Forwarded to C and replied to the connection on A (转发到C, 并回复到A上的连接)
+	if err := alice.Conn().SendMsg(2001, []byte("ping")); err != nil {
+		t.Fatal(err)
+	}
+	expect(t, aliceGot, "pong:alice:ping")
+
+	// A node restarted within SuspectTimeout stays a member, its new heartbeat outruns the old one
+	// (在SuspectTimeout内重启的节点仍是成员, 新的心跳超过旧的心跳)
+	b.lock.Lock()
+	b.heartbeat += 1000 // As if B had been up for long (如同B已运行了很久)
+	b.lock.Unlock()
+	time.Sleep(time.Millisecond * 300)
+	b.Stop()
+	waitPortFree(t, "127.0.0.1:19020")
+	b, _ = New(conf("B", "127.0.0.1:19020", "127.0.0.1:19019"), nil)
+	b.Start()
+	time.Sleep(time.Millisecond * 1600)
+	waitMembers(t, a, "B", "C")
+
+	// A node gone is dropped from the members (离开的节点会从成员中移除)
+	b.Stop()
+	waitMembers(t, a, "C")
+	waitMembers(t, c, "A")
+	if err := a.SendToConn("B", 1, testPushMsgID, nil); err != ErrNodeUnreachable {
+		t.Fatalf("SendToConn to a node gone err = %v", err)
+	}
+}
+
+func TestConfig(t *testing.T) {
+	if _, err := New(Config{Addr: "127.0.0.1:19022"}, nil); err == nil {
+		t.Fatal("NodeID not required")
+	}
+	if _, err := New(Config{NodeID: "A", Addr: "localhost:19022"}, nil); err == nil {
+		t.Fatal("host name accepted, ip:port required")
+	}
+	c, err := New(Config{NodeID: "A", Addr: "127.0.0.1:19022"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Forward(1, 2, "B"); err == nil {
+		t.Fatal("forwarded without a gateway")
+	}
+}
+
+func TestForwardRouterMode(t *testing.T) {
+	gateway := znet.NewServer()
+
+	// Another server of the process turned the global flag on (进程中的其他Server开启了全局标志)
+	mode := zconf.GlobalObject.RouterSlicesMode
+	zconf.GlobalObject.RouterSlicesMode = true
+	defer func() { zconf.GlobalObject.RouterSlicesMode = mode }()
+
+	c, err := New(Config{NodeID: "A", Addr: "127.0.0.1:19027"}, gateway)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The routers follow the mode of the gateway, not the global flag (路由跟随网关的模式, 而不是全局标志)
+	if err := c.Forward(1, 2, "B"); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,108 @@
+package zcluster
+
+import (
+	"fmt"
+
+	"github.com/aceld/zinx/ziface"
+	"github.com/aceld/zinx/zlog"
+	"github.com/aceld/zinx/znet"
+)
+
+// ForwardRequest is a message of a user forwarded by its gateway node (由用户所在网关节点转发来的消息)
+type ForwardRequest struct {
+	cluster *Cluster
+
+	Node   string // The gateway node the user is connected to (用户所连接的网关节点)
+	ConnID uint64 // The connection of the user on that node (用户在该节点上的连接)
+	UserID string // "" when the connection was not authenticated (连接未认证时为"")
+	MsgID  uint32
+	Data   []byte
+}
+
+// Reply sends data back to the connection of the user with the MsgID of the request
+// (以请求的MsgID向用户的连接回复data)
+func (r *ForwardRequest) Reply(data []byte) error {
+	return r.Send(r.MsgID, data)
+}
+
+// Send sends a message to the connection of the user (向用户的连接发送消息)
+func (r *ForwardRequest) Send(msgID uint32, data []byte) error {
+	return r.cluster.SendToConn(r.Node, r.ConnID, msgID, data)
+}
+
+// ForwardHandler handles the messages forwarded to a backend node (处理转发到后端节点的消息)
+type ForwardHandler func(request *ForwardRequest)
+
+// Forward sends the messages with MsgIDs from first to last received by the gateway to node nodeID,
+// after they passed the authentication and the other checks of the gateway. It adds a router of the
+// gateway for every MsgID, so it is called before the gateway starts.
+// (将网关收到的MsgID在first到last之间的消息在通过网关的认证等检查后发送给nodeID节点;
+// 会为每个MsgID添加网关的路由, 因此需在网关启动前调用)
+func (c *Cluster) Forward(first, last uint32, nodeID string) error {
+	if c.gateway == nil {
+		return fmt.Errorf("zcluster: node %s has no gateway to forward from", c.conf.NodeID)
+	}
+	if first > last {
+		return fmt.Errorf("zcluster: bad MsgID range %d-%d", first, last)
+	}
+	router := &forwardRouter{cluster: c, node: nodeID}
+	for msgID := first; ; msgID++ {
+		if c.gateway.IsRouterSlicesMode() {
+			c.gateway.AddRouterSlices(msgID, router.Handle)
+		} else {
+			c.gateway.AddRouter(msgID, router)
+		}
+		if msgID == last {
+			return nil
+		}
+	}
+}
+
+// HandleForward handles the messages with msgID forwarded to this node (处理转发到本节点的msgID消息)
+func (c *Cluster) HandleForward(msgID uint32, handler ForwardHandler) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.handlers[msgID] = handler
+}
+
+type forwardRouter struct {
+	znet.BaseRouter
+	cluster *Cluster
+	node    string
+}
+
+func (r *forwardRouter) Handle(request ziface.IRequest) {
+	c, conn := r.cluster, request.GetConnection()
+	env := &envelope{
+		Node:   c.conf.NodeID,
+		ConnID: conn.GetConnID(),
+		UserID: c.userOf(conn),
+		MsgID:  request.GetMsgID(),
+		Data:   request.GetData(),
+	}
+	if err := c.send(r.node, msgForward, env); err != nil {
+		zlog.Ins().ErrorF("zcluster forward msgID = %d to node %s err: %v", env.MsgID, r.node, err)
+	}
+}
+
+func (c *Cluster) onForward(request ziface.IRequest) {
+	env, ok := decode(request)
+	if !ok {
+		return
+	}
+	c.lock.RLock()
+	handler := c.handlers[env.MsgID]
+	c.lock.RUnlock()
+	if handler == nil {
+		zlog.Ins().ErrorF("zcluster no handler of forwarded msgID = %d from node %s, drop it", env.MsgID, env.Node)
+		return
+	}
+	handler(&ForwardRequest{
+		cluster: c,
+		Node:    env.Node,
+		ConnID:  env.ConnID,
+		UserID:  env.UserID,
+		MsgID:   env.MsgID,
+		Data:    env.Data,
+	})
+}
@@ -0,0 +1,119 @@
+package zcluster
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/aceld/zinx/ziface"
+	"github.com/aceld/zinx/zlog"
+)
+
+// gossipMember is a member in the gossip, each node increases its own heartbeat every GossipInterval
+// (gossip中的成员, 每个节点每隔GossipInterval增加自己的心跳)
+type gossipMember struct {
+	ID        string `json:"id"`
+	Addr      string `json:"addr"`
+	Heartbeat uint64 `json:"heartbeat"`
+}
+
+// learn records a member heard of, heartbeat 0 means the member itself said hello, and dials it
+// when this node has no link to it yet
+// (记录得知的成员, heartbeat为0表示成员自己发来了握手; 本节点尚未连接该成员时进行连接)
+func (c *Cluster) learn(id, addr string, heartbeat uint64) {
+	c.lock.Lock()
+	m := c.members[id]
+	switch {
+	case m == nil:
+		// A member dropped comes back only with a newer heartbeat or by itself
+		// (被移除的成员只有心跳更新或自己连接时才会重新加入)
+		if dead, ok := c.dead[id]; ok && heartbeat != 0 && heartbeat <= dead {
+			c.lock.Unlock()
+			return
+		}
+		delete(c.dead, id)
+		m = &member{id: id, addr: addr, heartbeat: heartbeat, seen: time.Now()}
+		c.members[id] = m
+		zlog.Ins().InfoF("zcluster node %s learned member %s at %s", c.conf.NodeID, id, addr)
+	case heartbeat == 0 || heartbeat > m.heartbeat:
+		if heartbeat > m.heartbeat {
+			m.heartbeat = heartbeat
+		}
+		m.seen = time.Now()
+	}
+	linked := c.byNode[id] != nil
+	c.lock.Unlock()
+
+	if !linked {
+		c.dial(addr)
+	}
+}
+
+// gossipLoop spreads the membership and drops the members not heard from, with Config.Gossip only
+// (传播成员信息并移除没有消息的成员, 仅在开启Config.Gossip时运行)
+func (c *Cluster) gossipLoop() {
+	defer c.wg.Done()
+	if !c.conf.Gossip {
+		return
+	}
+
+	ticker := time.NewTicker(c.conf.GossipInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-c.quit:
+			return
+		case <-ticker.C:
+		}
+
+		c.lock.Lock()
+		c.heartbeat++
+		list := []gossipMember{{ID: c.conf.NodeID, Addr: c.conf.AdvertiseAddr, Heartbeat: c.heartbeat}}
+		for _, m := range c.members {
+			list = append(list, gossipMember{ID: m.id, Addr: m.addr, Heartbeat: m.heartbeat})
+		}
+		c.lock.Unlock()
+
+		c.sendAll(msgGossip, list)
+		c.dropSuspects()
+	}
+}
+
+// dropSuspects drops the members whose heartbeat did not increase for SuspectTimeout, with their links
+// (移除SuspectTimeout内心跳没有增加的成员及其连接)
+func (c *Cluster) dropSuspects() {
+	var stopped []*link
+	c.lock.Lock()
+	for id, m := range c.members {
+		if time.Since(m.seen) <= c.conf.SuspectTimeout {
+			continue
+		}
+		zlog.Ins().InfoF("zcluster node %s drops member %s not heard from for %v", c.conf.NodeID, id, c.conf.SuspectTimeout)
+		delete(c.members, id)
+		c.dead[id] = m.heartbeat
+		for addr, l := range c.links {
+			if addr == m.addr || l.nodeID() == id {
+				delete(c.links, addr)
+				stopped = append(stopped, l)
+			}
+		}
+		delete(c.byNode, id)
+	}
+	c.lock.Unlock()
+
+	for _, l := range stopped {
+		l.client.Stop()
+	}
+}
+
+func (c *Cluster) onGossip(request ziface.IRequest) {
+	var list []gossipMember
+	if err := json.Unmarshal(request.GetData(), &list); err != nil {
+		zlog.Ins().ErrorF("zcluster bad gossip: %v", err)
+		return
+	}
+	for _, m := range list {
+		if m.ID != "" && m.ID != c.conf.NodeID && m.Heartbeat > 0 {
+			c.learn(m.ID, m.Addr, m.Heartbeat)
+		}
+	}
+}
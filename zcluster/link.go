@@ -0,0 +1,190 @@
+package zcluster
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/aceld/zinx/ziface"
+	"github.com/aceld/zinx/zlog"
+	"github.com/aceld/zinx/znet"
+)
+
+// MsgIDs of the messages between the nodes, on the cluster port only (节点之间消息的MsgID, 仅用于集群端口)
+const (
+	msgHello uint32 = iota + 1
+	msgGossip
+	msgToUser
+	msgToConn
+	msgBroadcast
+	msgForward
+)
+
+// hello is what a node tells about itself when it dials another, Secret is sent as it is
+// (节点连接其他节点时的自我介绍, Secret以明文发送)
+type hello struct {
+	NodeID string `json:"node_id"`
+	Addr   string `json:"addr"`
+	Secret string `json:"secret,omitempty"`
+}
+
+// envelope carries a message of a user or a connection between the nodes (在节点之间携带用户或连接的消息)
+type envelope struct {
+	Node   string `json:"node,omitempty"` // The gateway node of a forwarded message (转发消息来自的网关节点)
+	ConnID uint64 `json:"conn_id,omitempty"`
+	UserID string `json:"user_id,omitempty"`
+	MsgID  uint32 `json:"msg_id"`
+	Data   []byte `json:"data,omitempty"`
+}
+
+func decode(request ziface.IRequest) (*envelope, bool) {
+	env := &envelope{}
+	if err := json.Unmarshal(request.GetData(), env); err != nil {
+		zlog.Ins().ErrorF("zcluster bad message msgID = %d: %v", request.GetMsgID(), err)
+		return nil, false
+	}
+	return env, true
+}
+
+// link is the Client a node dials another node with, the messages to that node are sent through it
+// and the replies come back on the link of the other direction. It reconnects until the member is dropped.
+// (节点连接另一个节点的Client, 发往该节点的消息都通过它发送, 反方向的消息走对方的连接; 在成员被移除前持续重连)
+type link struct {
+	addr   string
+	client ziface.IClient
+
+	lock sync.Mutex
+	id   string             // Node ID answered to the hello (握手应答的节点ID)
+	conn ziface.IConnection // nil until the hello is answered (握手应答前为nil)
+}
+
+func (l *link) nodeID() string {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	return l.id
+}
+
+func (l *link) send(msgID uint32, data []byte) error {
+	l.lock.Lock()
+	conn := l.conn
+	l.lock.Unlock()
+	if conn == nil {
+		return ErrNodeUnreachable
+	}
+	return conn.SendBuffMsg(msgID, data)
+}
+
+// dial starts a link to addr unless there is one already (没有到addr的连接时开始连接)
+func (c *Cluster) dial(addr string) {
+	ip, port, err := splitAddr(addr)
+	if err != nil {
+		zlog.Ins().ErrorF("%v", err)
+		return
+	}
+
+	c.lock.Lock()
+	select {
+	case <-c.quit:
+		// Stopped, a hello accepted meanwhile dials no more (已停止, 期间接受的握手不再发起连接)
+		c.lock.Unlock()
+		return
+	default:
+	}
+	if c.links[addr] != nil {
+		c.lock.Unlock()
+		return
+	}
+	policy := znet.DefaultReconnectPolicy()
+	policy.MinBackoff = 200 * time.Millisecond
+	policy.MaxBackoff = c.conf.SuspectTimeout
+	l := &link{addr: addr}
+	l.client = znet.NewClient(ip, port, znet.WithNameClient("zcluster-"+c.conf.NodeID), znet.WithReconnect(policy))
+	c.links[addr] = l
+	c.lock.Unlock()
+
+	l.client.SetOnConnStart(func(conn ziface.IConnection) {
+		go c.greet(l, conn)
+	})
+	l.client.SetOnConnStop(func(conn ziface.IConnection) {
+		c.linkDown(l, conn)
+	})
+	l.client.Start()
+}
+
+// greet says hello on a new connection of a link, the link is ready once the peer answers with its node ID
+// (在连接的新连接上发送握手, 对端应答其节点ID后连接可用)
+func (c *Cluster) greet(l *link, conn ziface.IConnection) {
+	data, _ := json.Marshal(&hello{NodeID: c.conf.NodeID, Addr: c.conf.AdvertiseAddr, Secret: c.conf.Secret})
+	ctx, cancel := context.WithTimeout(conn.Context(), helloTimeout)
+	defer cancel()
+
+	reply, err := conn.Call(ctx, msgHello, data)
+	if err != nil {
+		zlog.Ins().ErrorF("zcluster hello to %s err: %v", l.addr, err)
+		conn.Stop()
+		return
+	}
+	peer := &hello{}
+	if err := json.Unmarshal(reply.GetData(), peer); err != nil || peer.NodeID == "" {
+		zlog.Ins().ErrorF("zcluster bad hello reply from %s", l.addr)
+		conn.Stop()
+		return
+	}
+
+	c.lock.Lock()
+	other := c.byNode[peer.NodeID]
+	if peer.NodeID == c.conf.NodeID || (other != nil && other != l) {
+		// Dialed this node itself, or a node already linked under another address
+		// (连接到了本节点自身, 或以其他地址已连接的节点)
+		delete(c.links, l.addr)
+		c.lock.Unlock()
+		go l.client.Stop()
+		return
+	}
+	l.lock.Lock()
+	l.id, l.conn = peer.NodeID, conn
+	l.lock.Unlock()
+	c.byNode[peer.NodeID] = l
+	c.lock.Unlock()
+
+	c.learn(peer.NodeID, peer.Addr, 0)
+	zlog.Ins().InfoF("zcluster node %s linked to node %s at %s", c.conf.NodeID, peer.NodeID, l.addr)
+}
+
+func (c *Cluster) linkDown(l *link, conn ziface.IConnection) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	if l.conn != conn {
+		return
+	}
+	l.conn = nil
+	if c.byNode[l.id] == l {
+		delete(c.byNode, l.id)
+	}
+}
+
+// accept is the AuthHandler of the cluster port, a node is accepted by its hello and answered with this node's
+// (集群端口的AuthHandler, 通过握手接受节点, 并以本节点的信息应答)
+func (c *Cluster) accept(request ziface.IRequest) (interface{}, bool, error) {
+	peer := &hello{}
+	if request.GetMsgID() != msgHello || json.Unmarshal(request.GetData(), peer) != nil || peer.NodeID == "" {
+		return nil, false, errors.New("zcluster: hello expected")
+	}
+	if subtle.ConstantTimeCompare([]byte(peer.Secret), []byte(c.conf.Secret)) != 1 {
+		_ = request.ReplyError(errBadSecret)
+		return nil, false, errBadSecret
+	}
+	data, _ := json.Marshal(&hello{NodeID: c.conf.NodeID, Addr: c.conf.AdvertiseAddr})
+	if err := request.Reply(data); err != nil {
+		return nil, false, err
+	}
+	if peer.NodeID != c.conf.NodeID {
+		c.learn(peer.NodeID, peer.Addr, 0)
+	}
+	return peer.NodeID, true, nil
+}
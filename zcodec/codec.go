@@ -0,0 +1,42 @@
+// @Title codec.go
+// @Description Registry of the serialization codecs used by typed routers
+package zcodec
+
+import (
+	"sync"
+
+	"github.com/aceld/zinx/ziface"
+)
+
+var (
+	codecsLock sync.RWMutex
+	codecs     = map[string]ziface.ICodec{
+		ziface.ZinxCodecJSON:     JSONCodec{},
+		ziface.ZinxCodecProtobuf: ProtobufCodec{},
+		ziface.ZinxCodecMsgpack:  MsgpackCodec{},
+	}
+)
+
+// Register adds a custom codec, a codec with the same name is replaced
+// (注册自定义的序列化方式, 同名的会被替换)
+func Register(codec ziface.ICodec) {
+	codecsLock.Lock()
+	defer codecsLock.Unlock()
+
+	codecs[codec.Name()] = codec
+}
+
+// Get returns the codec registered under name, or nil
+// (获取指定名称的序列化方式, 不存在时返回nil)
+func Get(name string) ziface.ICodec {
+	codecsLock.RLock()
+	defer codecsLock.RUnlock()
+
+	return codecs[name]
+}
+
+// Default returns the codec used when none is set, JSON
+// (未设置时默认使用的序列化方式, JSON)
+func Default() ziface.ICodec {
+	return JSONCodec{}
+}
@@ -0,0 +1,104 @@
+package zcodec
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/aceld/zinx/ziface"
+)
+
+type msgpackInner struct {
+	Tags []string `msgpack:"tags"`
+}
+
+type msgpackSample struct {
+	ID      uint64            `msgpack:"id"`
+	Name    string            `msgpack:"name"`
+	Score   float64           `msgpack:"score"`
+	Neg     int32             `msgpack:"neg"`
+	OK      bool              `msgpack:"ok"`
+	Raw     []byte            `msgpack:"raw"`
+	Attrs   map[string]int    `msgpack:"attrs"`
+	Inner   *msgpackInner     `msgpack:"inner"`
+	Skip    string            `msgpack:"-"`
+	Empty   string            `msgpack:"empty,omitempty"`
+	Any     interface{}       `msgpack:"any"`
+	Nested  []msgpackInner    `msgpack:"nested"`
+	Counter map[string]uint16 `msgpack:"counter"`
+}
+
+func TestMsgpackRoundTrip(t *testing.T) {
+	codec := Get(ziface.ZinxCodecMsgpack)
+
+	in := msgpackSample{
+		ID:      1 << 40,
+		Name:    "zinx",
+		Score:   9.5,
+		Neg:     -300,
+		OK:      true,
+		Raw:     []byte{0, 1, 2},
+		Attrs:   map[string]int{"hp": 100, "mp": -1},
+		Inner:   &msgpackInner{Tags: []string{"a", "b"}},
+		Skip:    "skip",
+		Any:     "text",
+		Nested:  []msgpackInner{{Tags: []string{"c"}}},
+		Counter: map[string]uint16{"x": 65535},
+	}
+
+	data, err := codec.Marshal(&in)
+	if err != nil {
+		t.Fatalf("marshal err: %v", err)
+	}
+
+	var out msgpackSample
+	if err := codec.Unmarshal(data, &out); err != nil {
+		t.Fatalf("unmarshal err: %v", err)
+	}
+
+	in.Skip = ""
+	if !reflect.DeepEqual(in, out) {
+		t.Fatalf("round trip = %+v, want %+v", out, in)
+	}
+}
+
+func TestMsgpackRejectsOversizedLength(t *testing.T) {
+	codec := Get(ziface.ZinxCodecMsgpack)
+
+	inputs := [][]byte{
+		{0xdd, 0x7f, 0xff, 0xff, 0xff},                  // array32 claiming 2^31-1 elements
+		{0xdf, 0x7f, 0xff, 0xff, 0xff},                  // map32 claiming 2^31-1 entries
+		{0xdc, 0xff, 0xff, 0x01},                        // array16 longer than the data
+		{0x9f, 0x01},                                    // fixarray of 15 with one element
+		{0x81, 0xa1, 'a', 0xdd, 0xff, 0xff, 0xff, 0xff}, // nested array32
+	}
+	targets := []func() interface{}{
+		func() interface{} { var v interface{}; return &v },
+		func() interface{} { var v []int; return &v },
+		func() interface{} { var v map[string]int; return &v },
+		func() interface{} { var v msgpackSample; return &v },
+		func() interface{} { var v [2]int; return &v },
+	}
+
+	for _, in := range inputs {
+		for _, target := range targets {
+			if err := codec.Unmarshal(in, target()); err == nil {
+				t.Fatalf("unmarshal % x into %T: expect error", in, target())
+			}
+		}
+	}
+}
+
+func TestRegistry(t *testing.T) {
+	for _, name := range []string{ziface.ZinxCodecJSON, ziface.ZinxCodecProtobuf, ziface.ZinxCodecMsgpack} {
+		if codec := Get(name); codec == nil || codec.Name() != name {
+			t.Fatalf("Get(%q) = %v", name, codec)
+		}
+	}
+	if Default().Name() != ziface.ZinxCodecJSON {
+		t.Fatalf("default codec = %s, want json", Default().Name())
+	}
+
+	if err := Get(ziface.ZinxCodecProtobuf).Unmarshal(nil, &msgpackSample{}); err == nil {
+		t.Fatal("protobuf codec accepted a non proto.Message")
+	}
+}
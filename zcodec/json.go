@@ -0,0 +1,23 @@
+package zcodec
+
+import (
+	"encoding/json"
+
+	"github.com/aceld/zinx/ziface"
+)
+
+// JSONCodec serializes messages with encoding/json
+// (使用JSON序列化消息)
+type JSONCodec struct{}
+
+func (JSONCodec) Name() string {
+	return ziface.ZinxCodecJSON
+}
+
+func (JSONCodec) Marshal(v interface{}) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (JSONCodec) Unmarshal(data []byte, v interface{}) error {
+	return json.Unmarshal(data, v)
+}
@@ -0,0 +1,762 @@
+package zcodec
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/aceld/zinx/ziface"
+)
+
+// MsgpackCodec serializes messages in the MessagePack format (https://msgpack.org).
+// Structs are encoded as maps keyed by field name, the `msgpack:"name,omitempty"` tag renames or skips a field.
+// (使用MessagePack格式序列化消息, 结构体编码为以字段名为key的map, 可以使用`msgpack:"name,omitempty"`标签重命名或忽略字段)
+type MsgpackCodec struct{}
+
+func (MsgpackCodec) Name() string {
+	return ziface.ZinxCodecMsgpack
+}
+
+func (MsgpackCodec) Marshal(v interface{}) ([]byte, error) {
+	e := &msgpackEncoder{}
+	if err := e.encode(reflect.ValueOf(v)); err != nil {
+		return nil, err
+	}
+	return e.buf, nil
+}
+
+func (MsgpackCodec) Unmarshal(data []byte, v interface{}) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() {
+		return fmt.Errorf("msgpack codec: Unmarshal(non-pointer %T)", v)
+	}
+	d := &msgpackDecoder{data: data}
+	return d.decode(rv.Elem())
+}
+
+// MessagePack type codes (MessagePack类型码)
+const (
+	mpNil      byte = 0xc0
+	mpFalse    byte = 0xc2
+	mpTrue     byte = 0xc3
+	mpBin8     byte = 0xc4
+	mpBin16    byte = 0xc5
+	mpBin32    byte = 0xc6
+	mpFloat32  byte = 0xca
+	mpFloat64  byte = 0xcb
+	mpUint8    byte = 0xcc
+	mpUint16   byte = 0xcd
+	mpUint32   byte = 0xce
+	mpUint64   byte = 0xcf
+	mpInt8     byte = 0xd0
+	mpInt16    byte = 0xd1
+	mpInt32    byte = 0xd2
+	mpInt64    byte = 0xd3
+	mpStr8     byte = 0xd9
+	mpStr16    byte = 0xda
+	mpStr32    byte = 0xdb
+	mpArray16  byte = 0xdc
+	mpArray32  byte = 0xdd
+	mpMap16    byte = 0xde
+	mpMap32    byte = 0xdf
+	mpFixMap   byte = 0x80
+	mpFixArray byte = 0x90
+	mpFixStr   byte = 0xa0
+)
+
+var errMsgpackShort = errors.New("msgpack codec: unexpected end of data")
+
+// msgpackField is an exported struct field and the key it is encoded with
+// (结构体的导出字段及其编码时使用的key)
+type msgpackField struct {
+	name      string
+	index     int
+	omitEmpty bool
+}
+
+var msgpackFieldCache sync.Map // map[reflect.Type][]msgpackField
+
+func msgpackFields(t reflect.Type) []msgpackField {
+	if cached, ok := msgpackFieldCache.Load(t); ok {
+		return cached.([]msgpackField)
+	}
+
+	fields := make([]msgpackField, 0, t.NumField())
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		if sf.PkgPath != "" {
+			continue
+		}
+		field := msgpackField{name: sf.Name, index: i}
+		if tag, ok := sf.Tag.Lookup("msgpack"); ok {
+			if tag == "-" {
+				continue
+			}
+			parts := strings.Split(tag, ",")
+			if parts[0] != "" {
+				field.name = parts[0]
+			}
+			for _, opt := range parts[1:] {
+				if opt == "omitempty" {
+					field.omitEmpty = true
+				}
+			}
+		}
+		fields = append(fields, field)
+	}
+
+	msgpackFieldCache.Store(t, fields)
+	return fields
+}
+
+type msgpackEncoder struct {
+	buf []byte
+}
+
+func (e *msgpackEncoder) encode(v reflect.Value) error {
+	if !v.IsValid() {
+		e.buf = append(e.buf, mpNil)
+		return nil
+	}
+
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		if v.IsNil() {
+			e.buf = append(e.buf, mpNil)
+			return nil
+		}
+		return e.encode(v.Elem())
+	case reflect.Bool:
+		if v.Bool() {
+			e.buf = append(e.buf, mpTrue)
+		} else {
+			e.buf = append(e.buf, mpFalse)
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		e.encodeInt(v.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		e.encodeUint(v.Uint())
+	case reflect.Float32:
+		e.buf = append(e.buf, mpFloat32)
+		e.buf = binary.BigEndian.AppendUint32(e.buf, math.Float32bits(float32(v.Float())))
+	case reflect.Float64:
+		e.buf = append(e.buf, mpFloat64)
+		e.buf = binary.BigEndian.AppendUint64(e.buf, math.Float64bits(v.Float()))
+	case reflect.String:
+		e.encodeStr(v.String())
+	case reflect.Slice:
+		if v.IsNil() {
+			e.buf = append(e.buf, mpNil)
+			return nil
+		}
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			e.encodeBin(v.Bytes())
+			return nil
+		}
+		return e.encodeArray(v)
+	case reflect.Array:
+		return e.encodeArray(v)
+	case reflect.Map:
+		if v.IsNil() {
+			e.buf = append(e.buf, mpNil)
+			return nil
+		}
+		return e.encodeMap(v)
+	case reflect.Struct:
+		return e.encodeStruct(v)
+	default:
+		return fmt.Errorf("msgpack codec: unsupported type %s", v.Type())
+	}
+	return nil
+}
+
+func (e *msgpackEncoder) encodeInt(n int64) {
+	switch {
+	case n >= 0:
+		e.encodeUint(uint64(n))
+	case n >= -32:
+		e.buf = append(e.buf, byte(int8(n)))
+	case n >= math.MinInt8:
+		e.buf = append(e.buf, mpInt8, byte(int8(n)))
+	case n >= math.MinInt16:
+		e.buf = append(e.buf, mpInt16)
+		e.buf = binary.BigEndian.AppendUint16(e.buf, uint16(int16(n)))
+	case n >= math.MinInt32:
+		e.buf = append(e.buf, mpInt32)
+		e.buf = binary.BigEndian.AppendUint32(e.buf, uint32(int32(n)))
+	default:
+		e.buf = append(e.buf, mpInt64)
+		e.buf = binary.BigEndian.AppendUint64(e.buf, uint64(n))
+	}
+}
+
+func (e *msgpackEncoder) encodeUint(n uint64) {
+	switch {
+	case n <= 0x7f:
+		e.buf = append(e.buf, byte(n))
+	case n <= math.MaxUint8:
+		e.buf = append(e.buf, mpUint8, byte(n))
+	case n <= math.MaxUint16:
+		e.buf = append(e.buf, mpUint16)
+		e.buf = binary.BigEndian.AppendUint16(e.buf, uint16(n))
+	case n <= math.MaxUint32:
+		e.buf = append(e.buf, mpUint32)
+		e.buf = binary.BigEndian.AppendUint32(e.buf, uint32(n))
+	default:
+		e.buf = append(e.buf, mpUint64)
+		e.buf = binary.BigEndian.AppendUint64(e.buf, n)
+	}
+}
+
+func (e *msgpackEncoder) encodeStr(s string) {
+	n := len(s)
+	switch {
+	case n < 32:
+		e.buf = append(e.buf, mpFixStr|byte(n))
+	case n <= math.MaxUint8:
+		e.buf = append(e.buf, mpStr8, byte(n))
+	case n <= math.MaxUint16:
+		e.buf = append(e.buf, mpStr16)
+		e.buf = binary.BigEndian.AppendUint16(e.buf, uint16(n))
+	default:
+		e.buf = append(e.buf, mpStr32)
+		e.buf = binary.BigEndian.AppendUint32(e.buf, uint32(n))
+	}
+	e.buf = append(e.buf, s...)
+}
+
+func (e *msgpackEncoder) encodeBin(b []byte) {
+	n := len(b)
+	switch {
+	case n <= math.MaxUint8:
+		e.buf = append(e.buf, mpBin8, byte(n))
+	case n <= math.MaxUint16:
+		e.buf = append(e.buf, mpBin16)
+		e.buf = binary.BigEndian.AppendUint16(e.buf, uint16(n))
+	default:
+		e.buf = append(e.buf, mpBin32)
+		e.buf = binary.BigEndian.AppendUint32(e.buf, uint32(n))
+	}
+	e.buf = append(e.buf, b...)
+}
+
+func (e *msgpackEncoder) encodeArrayLen(n int) {
+	switch {
+	case n < 16:
+		e.buf = append(e.buf, mpFixArray|byte(n))
+	case n <= math.MaxUint16:
+		e.buf = append(e.buf, mpArray16)
+		e.buf = binary.BigEndian.AppendUint16(e.buf, uint16(n))
+	default:
+		e.buf = append(e.buf, mpArray32)
+		e.buf = binary.BigEndian.AppendUint32(e.buf, uint32(n))
+	}
+}
+
+func (e *msgpackEncoder) encodeMapLen(n int) {
+	switch {
+	case n < 16:
+		e.buf = append(e.buf, mpFixMap|byte(n))
+	case n <= math.MaxUint16:
+		e.buf = append(e.buf, mpMap16)
+		e.buf = binary.BigEndian.AppendUint16(e.buf, uint16(n))
+	default:
+		e.buf = append(e.buf, mpMap32)
+		e.buf = binary.BigEndian.AppendUint32(e.buf, uint32(n))
+	}
+}
+
+func (e *msgpackEncoder) encodeArray(v reflect.Value) error {
+	e.encodeArrayLen(v.Len())
+	for i := 0; i < v.Len(); i++ {
+		if err := e.encode(v.Index(i)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (e *msgpackEncoder) encodeMap(v reflect.Value) error {
+	keys := v.MapKeys()
+	// Sort string keys so that the output is stable (字符串key排序, 保证输出稳定)
+	if v.Type().Key().Kind() == reflect.String {
+		sort.Slice(keys, func(i, j int) bool { return keys[i].String() < keys[j].String() })
+	}
+
+	e.encodeMapLen(len(keys))
+	for _, key := range keys {
+		if err := e.encode(key); err != nil {
+			return err
+		}
+		if err := e.encode(v.MapIndex(key)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (e *msgpackEncoder) encodeStruct(v reflect.Value) error {
+	fields := msgpackFields(v.Type())
+
+	n := 0
+	for _, f := range fields {
+		if !f.omitEmpty || !v.Field(f.index).IsZero() {
+			n++
+		}
+	}
+
+	e.encodeMapLen(n)
+	for _, f := range fields {
+		fv := v.Field(f.index)
+		if f.omitEmpty && fv.IsZero() {
+			continue
+		}
+		e.encodeStr(f.name)
+		if err := e.encode(fv); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type msgpackDecoder struct {
+	data []byte
+	pos  int
+}
+
+func (d *msgpackDecoder) next(n int) ([]byte, error) {
+	if n < 0 || d.pos+n > len(d.data) {
+		return nil, errMsgpackShort
+	}
+	b := d.data[d.pos : d.pos+n]
+	d.pos += n
+	return b, nil
+}
+
+func (d *msgpackDecoder) readCode() (byte, error) {
+	b, err := d.next(1)
+	if err != nil {
+		return 0, err
+	}
+	return b[0], nil
+}
+
+func (d *msgpackDecoder) readUint(size int) (uint64, error) {
+	b, err := d.next(size)
+	if err != nil {
+		return 0, err
+	}
+	switch size {
+	case 1:
+		return uint64(b[0]), nil
+	case 2:
+		return uint64(binary.BigEndian.Uint16(b)), nil
+	case 4:
+		return uint64(binary.BigEndian.Uint32(b)), nil
+	default:
+		return binary.BigEndian.Uint64(b), nil
+	}
+}
+
+// readLen reads the length that follows a str/bin/array/map code of the given size
+// (读取str/bin/array/map类型码之后的长度)
+func (d *msgpackDecoder) readLen(size int) (int, error) {
+	n, err := d.readUint(size)
+	return int(n), err
+}
+
+// decodeAny decodes the next value into its natural Go type:
+// nil, bool, int64, uint64, float64, string, []byte, []interface{} or map[string]interface{}
+// (将下一个值解码为对应的Go类型)
+func (d *msgpackDecoder) decodeAny() (interface{}, error) {
+	code, err := d.readCode()
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case code <= 0x7f:
+		return int64(code), nil
+	case code >= 0xe0:
+		return int64(int8(code)), nil
+	case code&0xe0 == mpFixStr:
+		return d.readStr(int(code & 0x1f))
+	case code&0xf0 == mpFixArray:
+		return d.readAnyArray(int(code & 0x0f))
+	case code&0xf0 == mpFixMap:
+		return d.readAnyMap(int(code & 0x0f))
+	}
+
+	switch code {
+	case mpNil:
+		return nil, nil
+	case mpFalse:
+		return false, nil
+	case mpTrue:
+		return true, nil
+	case mpUint8, mpUint16, mpUint32, mpUint64:
+		return d.readUint(1 << (code - mpUint8))
+	case mpInt8:
+		n, err := d.readUint(1)
+		return int64(int8(n)), err
+	case mpInt16:
+		n, err := d.readUint(2)
+		return int64(int16(n)), err
+	case mpInt32:
+		n, err := d.readUint(4)
+		return int64(int32(n)), err
+	case mpInt64:
+		n, err := d.readUint(8)
+		return int64(n), err
+	case mpFloat32:
+		n, err := d.readUint(4)
+		return float64(math.Float32frombits(uint32(n))), err
+	case mpFloat64:
+		n, err := d.readUint(8)
+		return math.Float64frombits(n), err
+	case mpStr8, mpStr16, mpStr32:
+		n, err := d.readLen(1 << (code - mpStr8))
+		if err != nil {
+			return nil, err
+		}
+		return d.readStr(n)
+	case mpBin8, mpBin16, mpBin32:
+		n, err := d.readLen(1 << (code - mpBin8))
+		if err != nil {
+			return nil, err
+		}
+		b, err := d.next(n)
+		if err != nil {
+			return nil, err
+		}
+		return append([]byte(nil), b...), nil
+	case mpArray16, mpArray32:
+		n, err := d.readLen(2 << (code - mpArray16))
+		if err != nil {
+			return nil, err
+		}
+		return d.readAnyArray(n)
+	case mpMap16, mpMap32:
+		n, err := d.readLen(2 << (code - mpMap16))
+		if err != nil {
+			return nil, err
+		}
+		return d.readAnyMap(n)
+	}
+
+	return nil, fmt.Errorf("msgpack codec: unsupported type code 0x%x", code)
+}
+
+func (d *msgpackDecoder) readStr(n int) (string, error) {
+	b, err := d.next(n)
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
+func (d *msgpackDecoder) readAnyArray(n int) ([]interface{}, error) {
+	if err := d.checkContainerLen(n); err != nil {
+		return nil, err
+	}
+	arr := make([]interface{}, 0, n)
+	for i := 0; i < n; i++ {
+		v, err := d.decodeAny()
+		if err != nil {
+			return nil, err
+		}
+		arr = append(arr, v)
+	}
+	return arr, nil
+}
+
+func (d *msgpackDecoder) readAnyMap(n int) (map[string]interface{}, error) {
+	if err := d.checkContainerLen(n); err != nil {
+		return nil, err
+	}
+	m := make(map[string]interface{}, n)
+	for i := 0; i < n; i++ {
+		k, err := d.decodeAny()
+		if err != nil {
+			return nil, err
+		}
+		v, err := d.decodeAny()
+		if err != nil {
+			return nil, err
+		}
+		m[fmt.Sprint(k)] = v
+	}
+	return m, nil
+}
+
+// peekCode returns the next type code without consuming it (查看下一个类型码但不消费)
+func (d *msgpackDecoder) peekCode() (byte, error) {
+	if d.pos >= len(d.data) {
+		return 0, errMsgpackShort
+	}
+	return d.data[d.pos], nil
+}
+
+// checkContainerLen rejects a length the remaining data cannot hold, before anything is allocated for it.
+// Every element takes at least one byte, so a longer length can only come from a truncated or hostile message
+// (在分配内存之前拒绝剩余数据无法容纳的长度, 每个元素至少占用一个字节)
+func (d *msgpackDecoder) checkContainerLen(n int) error {
+	if n < 0 || n > len(d.data)-d.pos {
+		return fmt.Errorf("msgpack codec: container length %d exceeds remaining %d bytes", n, len(d.data)-d.pos)
+	}
+	return nil
+}
+
+// readContainerLen consumes an array or map header and returns its length
+// (读取array或map的头部, 返回元素个数)
+func (d *msgpackDecoder) readContainerLen(isMap bool) (int, error) {
+	n, err := d.readContainerHeader(isMap)
+	if err != nil {
+		return 0, err
+	}
+	if err := d.checkContainerLen(n); err != nil {
+		return 0, err
+	}
+	return n, nil
+}
+
+func (d *msgpackDecoder) readContainerHeader(isMap bool) (int, error) {
+	code, err := d.readCode()
+	if err != nil {
+		return 0, err
+	}
+	if isMap {
+		switch {
+		case code&0xf0 == mpFixMap:
+			return int(code & 0x0f), nil
+		case code == mpMap16 || code == mpMap32:
+			return d.readLen(2 << (code - mpMap16))
+		}
+		return 0, fmt.Errorf("msgpack codec: expect map, got type code 0x%x", code)
+	}
+
+	switch {
+	case code&0xf0 == mpFixArray:
+		return int(code & 0x0f), nil
+	case code == mpArray16 || code == mpArray32:
+		return d.readLen(2 << (code - mpArray16))
+	}
+	return 0, fmt.Errorf("msgpack codec: expect array, got type code 0x%x", code)
+}
+
+func (d *msgpackDecoder) decode(v reflect.Value) error {
+	code, err := d.peekCode()
+	if err != nil {
+		return err
+	}
+
+	if code == mpNil {
+		d.pos++
+		v.Set(reflect.Zero(v.Type()))
+		return nil
+	}
+
+	switch v.Kind() {
+	case reflect.Ptr:
+		if v.IsNil() {
+			v.Set(reflect.New(v.Type().Elem()))
+		}
+		return d.decode(v.Elem())
+	case reflect.Struct:
+		return d.decodeStruct(v)
+	case reflect.Map:
+		return d.decodeMap(v)
+	case reflect.Slice:
+		if v.Type().Elem().Kind() != reflect.Uint8 {
+			return d.decodeSlice(v)
+		}
+	case reflect.Array:
+		return d.decodeArray(v)
+	}
+
+	// Scalars, []byte and interface{} (标量、[]byte以及interface{})
+	val, err := d.decodeAny()
+	if err != nil {
+		return err
+	}
+	return setMsgpackValue(v, val)
+}
+
+func (d *msgpackDecoder) decodeStruct(v reflect.Value) error {
+	n, err := d.readContainerLen(true)
+	if err != nil {
+		return err
+	}
+
+	fields := msgpackFields(v.Type())
+	for i := 0; i < n; i++ {
+		key, err := d.decodeAny()
+		if err != nil {
+			return err
+		}
+		name := fmt.Sprint(key)
+
+		var field *msgpackField
+		for j := range fields {
+			if fields[j].name == name {
+				field = &fields[j]
+				break
+			}
+		}
+		if field == nil {
+			// Skip unknown fields (忽略未知字段)
+			if _, err := d.decodeAny(); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := d.decode(v.Field(field.index)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (d *msgpackDecoder) decodeMap(v reflect.Value) error {
+	n, err := d.readContainerLen(true)
+	if err != nil {
+		return err
+	}
+
+	t := v.Type()
+	if v.IsNil() {
+		v.Set(reflect.MakeMapWithSize(t, n))
+	}
+	for i := 0; i < n; i++ {
+		key := reflect.New(t.Key()).Elem()
+		if err := d.decode(key); err != nil {
+			return err
+		}
+		elem := reflect.New(t.Elem()).Elem()
+		if err := d.decode(elem); err != nil {
+			return err
+		}
+		v.SetMapIndex(key, elem)
+	}
+	return nil
+}
+
+func (d *msgpackDecoder) decodeSlice(v reflect.Value) error {
+	n, err := d.readContainerLen(false)
+	if err != nil {
+		return err
+	}
+
+	slice := reflect.MakeSlice(v.Type(), n, n)
+	for i := 0; i < n; i++ {
+		if err := d.decode(slice.Index(i)); err != nil {
+			return err
+		}
+	}
+	v.Set(slice)
+	return nil
+}
+
+func (d *msgpackDecoder) decodeArray(v reflect.Value) error {
+	n, err := d.readContainerLen(false)
+	if err != nil {
+		return err
+	}
+
+	for i := 0; i < n; i++ {
+		if i < v.Len() {
+			if err := d.decode(v.Index(i)); err != nil {
+				return err
+			}
+		} else if _, err := d.decodeAny(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// setMsgpackValue stores a value returned by decodeAny into v, converting numbers as needed
+// (将decodeAny解码出的值存入v, 必要时转换数值类型)
+func setMsgpackValue(v reflect.Value, val interface{}) error {
+	switch v.Kind() {
+	case reflect.Interface:
+		if val == nil {
+			v.Set(reflect.Zero(v.Type()))
+		} else {
+			rv := reflect.ValueOf(val)
+			if !rv.Type().AssignableTo(v.Type()) {
+				return fmt.Errorf("msgpack: cannot assign %s to %s", rv.Type(), v.Type())
+			}
+			v.Set(rv)
+		}
+		return nil
+	case reflect.Bool:
+		if b, ok := val.(bool); ok {
+			v.SetBool(b)
+			return nil
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		switch n := val.(type) {
+		case int64:
+			if !v.OverflowInt(n) {
+				v.SetInt(n)
+				return nil
+			}
+		case uint64:
+			if n <= math.MaxInt64 && !v.OverflowInt(int64(n)) {
+				v.SetInt(int64(n))
+				return nil
+			}
+		}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		switch n := val.(type) {
+		case int64:
+			if n >= 0 && !v.OverflowUint(uint64(n)) {
+				v.SetUint(uint64(n))
+				return nil
+			}
+		case uint64:
+			if !v.OverflowUint(n) {
+				v.SetUint(n)
+				return nil
+			}
+		}
+	case reflect.Float32, reflect.Float64:
+		switch n := val.(type) {
+		case float64:
+			v.SetFloat(n)
+			return nil
+		case int64:
+			v.SetFloat(float64(n))
+			return nil
+		case uint64:
+			v.SetFloat(float64(n))
+			return nil
+		}
+	case reflect.String:
+		switch s := val.(type) {
+		case string:
+			v.SetString(s)
+			return nil
+		case []byte:
+			v.SetString(string(s))
+			return nil
+		}
+	case reflect.Slice:
+		switch b := val.(type) {
+		case []byte:
+			v.SetBytes(b)
+			return nil
+		case string:
+			v.SetBytes([]byte(b))
+			return nil
+		}
+	}
+
+	return fmt.Errorf("msgpack codec: cannot decode %T into %s", val, v.Type())
+}
@@ -0,0 +1,32 @@
+package zcodec
+
+import (
+	"fmt"
+
+	"github.com/aceld/zinx/ziface"
+	"github.com/golang/protobuf/proto"
+)
+
+// ProtobufCodec serializes messages generated by protoc, v must be a proto.Message
+// (使用protobuf序列化消息, v必须是protoc生成的proto.Message)
+type ProtobufCodec struct{}
+
+func (ProtobufCodec) Name() string {
+	return ziface.ZinxCodecProtobuf
+}
+
+func (ProtobufCodec) Marshal(v interface{}) ([]byte, error) {
+	msg, ok := v.(proto.Message)
+	if !ok {
+		return nil, fmt.Errorf("protobuf codec: %T is not a proto.Message", v)
+	}
+	return proto.Marshal(msg)
+}
+
+func (ProtobufCodec) Unmarshal(data []byte, v interface{}) error {
+	msg, ok := v.(proto.Message)
+	if !ok {
+		return fmt.Errorf("protobuf codec: %T is not a proto.Message", v)
+	}
+	return proto.Unmarshal(data, msg)
+}
@@ -0,0 +1,65 @@
+// @Title compress.go
+// @Description Registry of the message compression algorithms
+package zcompress
+
+import (
+	"errors"
+	"sync"
+
+	"github.com/aceld/zinx/ziface"
+)
+
+// MaxDecompressedSize limits the data a compressed message may expand to, protecting against compression bombs
+// (压缩消息解压后的最大长度, 防止压缩炸弹)
+var MaxDecompressedSize = 16 * 1024 * 1024
+
+// ErrTooLarge is returned when the decompressed data exceeds MaxDecompressedSize
+// (解压后的数据超过MaxDecompressedSize时返回)
+var ErrTooLarge = errors.New("zcompress: decompressed data too large")
+
+var (
+	compressorsLock sync.RWMutex
+	compressors     = map[string]ziface.ICompressor{}
+	compressorIDs   = map[uint8]ziface.ICompressor{}
+)
+
+func init() {
+	Register(GzipCompressor{})
+	Register(DeflateCompressor{})
+	Register(ZstdCompressor{})
+	Register(SnappyCompressor{})
+}
+
+// Register adds a custom compressor, a compressor with the same name or ID is replaced
+// (注册自定义的压缩算法, 同名或同ID的会被替换)
+func Register(compressor ziface.ICompressor) {
+	compressorsLock.Lock()
+	defer compressorsLock.Unlock()
+
+	if old, ok := compressors[compressor.Name()]; ok {
+		delete(compressorIDs, old.ID())
+	}
+	if old, ok := compressorIDs[compressor.ID()]; ok {
+		delete(compressors, old.Name())
+	}
+	compressors[compressor.Name()] = compressor
+	compressorIDs[compressor.ID()] = compressor
+}
+
+// Get returns the compressor registered under name, or nil
+// (获取指定名称的压缩算法, 不存在时返回nil)
+func Get(name string) ziface.ICompressor {
+	compressorsLock.RLock()
+	defer compressorsLock.RUnlock()
+
+	return compressors[name]
+}
+
+// GetByID returns the compressor registered with the algorithm ID, or nil
+// (获取指定算法ID的压缩算法, 不存在时返回nil)
+func GetByID(id uint8) ziface.ICompressor {
+	compressorsLock.RLock()
+	defer compressorsLock.RUnlock()
+
+	return compressorIDs[id]
+}
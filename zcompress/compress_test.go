@@ -0,0 +1,123 @@
+package zcompress
+
+import (
+	"bytes"
+	"math/rand"
+	"os/exec"
+	"strings"
+	"testing"
+
+	"github.com/aceld/zinx/ziface"
+	"github.com/klauspost/compress/s2"
+)
+
+func TestCompressRoundTrip(t *testing.T) {
+	random := make([]byte, 5000)
+	rand.New(rand.NewSource(1)).Read(random)
+
+	samples := [][]byte{
+		nil,
+		[]byte("a"),
+		[]byte(strings.Repeat("chat message from zinx, ", 400)),
+		random,
+		append(bytes.Repeat([]byte{7}, 70000), random...),
+	}
+
+	for _, name := range []string{ziface.ZinxCompressGzip, ziface.ZinxCompressDeflate, ziface.ZinxCompressZstd, ziface.ZinxCompressSnappy} {
+		compressor := Get(name)
+		if compressor == nil || GetByID(compressor.ID()) != compressor {
+			t.Fatalf("%s not registered", name)
+		}
+		for i, sample := range samples {
+			compressed, err := compressor.Compress(sample)
+			if err != nil {
+				t.Fatalf("%s compress sample %d: %v", name, i, err)
+			}
+			data, err := compressor.Decompress(compressed)
+			if err != nil {
+				t.Fatalf("%s decompress sample %d: %v", name, i, err)
+			}
+			if !bytes.Equal(data, sample) {
+				t.Fatalf("%s sample %d changed by the round trip", name, i)
+			}
+		}
+
+		// Repetitive data gets smaller (重复的数据被压缩)
+		compressed, _ := compressor.Compress(samples[2])
+		if len(compressed) >= len(samples[2])/4 {
+			t.Fatalf("%s compressed %d bytes to %d", name, len(samples[2]), len(compressed))
+		}
+	}
+}
+
+func TestDecompressLimit(t *testing.T) {
+	old := MaxDecompressedSize
+	MaxDecompressedSize = 1024
+	defer func() { MaxDecompressedSize = old }()
+
+	for _, name := range []string{ziface.ZinxCompressGzip, ziface.ZinxCompressZstd, ziface.ZinxCompressSnappy} {
+		compressor := Get(name)
+		compressed, _ := compressor.Compress(make([]byte, 4096))
+		if _, err := compressor.Decompress(compressed); err != ErrTooLarge {
+			t.Fatalf("%s decompress err = %v, want ErrTooLarge", name, err)
+		}
+	}
+
+	if _, err := Get(ziface.ZinxCompressSnappy).Decompress([]byte{10, 0x0d, 1}); err != ErrCorrupt {
+		t.Fatalf("snappy decompress of corrupt data err = %v", err)
+	}
+}
+
+// zstdReference is a frame written by the reference zstd CLI v1.5.6 (zstd -19) for zstdReferenceText
+// (参考实现zstd CLI v1.5.6 (zstd -19) 为zstdReferenceText生成的帧)
+var zstdReference = []byte{
+	0x28, 0xb5, 0x2f, 0xfd, 0x04, 0x68, 0x1d, 0x01, 0x00, 0xe0, 0x7a, 0x69, 0x6e, 0x78, 0x20, 0x7a,
+	0x73, 0x74, 0x64, 0x20, 0x72, 0x65, 0x66, 0x65, 0x72, 0x65, 0x6e, 0x63, 0x65, 0x20, 0x66, 0x72,
+	0x61, 0x6d, 0x65, 0x2c, 0x20, 0x7a, 0x01, 0x00, 0xc0, 0xcf, 0xe9, 0x04, 0x6b, 0x15, 0x87, 0x2f,
+}
+
+const zstdReferenceText = "zinx zstd reference frame, zinx zstd reference frame, zinx zstd reference frame"
+
+func TestZstdInterop(t *testing.T) {
+	zstd := Get(ziface.ZinxCompressZstd)
+	data, err := zstd.Decompress(zstdReference)
+	if err != nil || string(data) != zstdReferenceText {
+		t.Fatalf("reference frame decompressed to %q, err = %v", data, err)
+	}
+
+	// Frames written here are read by the reference CLI, when it is installed
+	// (安装了参考实现的CLI时, 验证其可以读取此处生成的帧)
+	cli, err := exec.LookPath("zstd")
+	if err != nil {
+		t.Skip("zstd CLI not installed")
+	}
+	text := strings.Repeat("chat message from zinx, ", 400)
+	compressed, _ := zstd.Compress([]byte(text))
+	cmd := exec.Command(cli, "-d", "-c", "-q")
+	cmd.Stdin = bytes.NewReader(compressed)
+	out, err := cmd.Output()
+	if err != nil || string(out) != text {
+		t.Fatalf("zstd CLI decompressed %d bytes, err = %v", len(out), err)
+	}
+}
+
+func TestSnappyInterop(t *testing.T) {
+	snappy := Get(ziface.ZinxCompressSnappy)
+	text := []byte(strings.Repeat("chat message from zinx, ", 400))
+
+	// Blocks written here are read by another implementation, and the other way round
+	// (此处生成的块可以被其他实现读取, 反之亦然)
+	compressed, _ := snappy.Compress(text)
+	if data, err := s2.Decode(nil, compressed); err != nil || !bytes.Equal(data, text) {
+		t.Fatalf("s2 decoded the snappy block to %d bytes, err = %v", len(data), err)
+	}
+	if data, err := snappy.Decompress(s2.EncodeSnappy(nil, text)); err != nil || !bytes.Equal(data, text) {
+		t.Fatalf("snappy block of s2 decompressed to %d bytes, err = %v", len(data), err)
+	}
+
+	// The example block of the snappy format description: literal "Wikipedia" (snappy格式说明中的示例块)
+	data, err := snappy.Decompress([]byte{0x09, 0x20, 'W', 'i', 'k', 'i', 'p', 'e', 'd', 'i', 'a'})
+	if err != nil || string(data) != "Wikipedia" {
+		t.Fatalf("literal block decompressed to %q, err = %v", data, err)
+	}
+}
@@ -0,0 +1,99 @@
+package zcompress
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"io"
+	"sync"
+
+	"github.com/aceld/zinx/ziface"
+)
+
+var (
+	gzipWriters  = sync.Pool{New: func() interface{} { return gzip.NewWriter(nil) }}
+	flateWriters = sync.Pool{New: func() interface{} {
+		w, _ := flate.NewWriter(nil, flate.DefaultCompression)
+		return w
+	}}
+)
+
+// GzipCompressor compresses messages in the gzip format (使用gzip格式压缩消息)
+type GzipCompressor struct{}
+
+func (GzipCompressor) Name() string {
+	return ziface.ZinxCompressGzip
+}
+
+func (GzipCompressor) ID() uint8 {
+	return ziface.ZinxCompressGzipID
+}
+
+func (GzipCompressor) Compress(data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	w := gzipWriters.Get().(*gzip.Writer)
+	defer gzipWriters.Put(w)
+
+	w.Reset(&buf)
+	if _, err := w.Write(data); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (GzipCompressor) Decompress(data []byte) ([]byte, error) {
+	r, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return readLimited(r)
+}
+
+// DeflateCompressor compresses messages in the raw deflate format (使用deflate格式压缩消息)
+type DeflateCompressor struct{}
+
+func (DeflateCompressor) Name() string {
+	return ziface.ZinxCompressDeflate
+}
+
+func (DeflateCompressor) ID() uint8 {
+	return ziface.ZinxCompressDeflateID
+}
+
+func (DeflateCompressor) Compress(data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	w := flateWriters.Get().(*flate.Writer)
+	defer flateWriters.Put(w)
+
+	w.Reset(&buf)
+	if _, err := w.Write(data); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (DeflateCompressor) Decompress(data []byte) ([]byte, error) {
+	r := flate.NewReader(bytes.NewReader(data))
+	defer r.Close()
+	return readLimited(r)
+}
+
+// readLimited reads r to the end, failing with ErrTooLarge beyond MaxDecompressedSize
+// (读取r的全部数据, 超过MaxDecompressedSize时返回ErrTooLarge)
+func readLimited(r io.Reader) ([]byte, error) {
+	data, err := io.ReadAll(io.LimitReader(r, int64(MaxDecompressedSize)+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > MaxDecompressedSize {
+		return nil, ErrTooLarge
+	}
+	return data, nil
+}
@@ -0,0 +1,45 @@
+package zcompress
+
+import (
+	"errors"
+
+	"github.com/aceld/zinx/ziface"
+	"github.com/golang/snappy"
+)
+
+// ErrCorrupt is returned when snappy data is malformed (snappy数据格式错误时返回)
+var ErrCorrupt = errors.New("zcompress: corrupt snappy data")
+
+// SnappyCompressor compresses messages in the snappy block format (https://github.com/google/snappy)
+// with the reference Go implementation, favoring speed over ratio
+// (使用snappy参考Go实现以块格式压缩消息, 速度优先于压缩率)
+type SnappyCompressor struct{}
+
+func (SnappyCompressor) Name() string {
+	return ziface.ZinxCompressSnappy
+}
+
+func (SnappyCompressor) ID() uint8 {
+	return ziface.ZinxCompressSnappyID
+}
+
+func (SnappyCompressor) Compress(data []byte) ([]byte, error) {
+	return snappy.Encode(nil, data), nil
+}
+
+func (SnappyCompressor) Decompress(data []byte) ([]byte, error) {
+	// The block starts with the decoded length, checked before anything is allocated
+	// (块以解压后的长度开头, 在分配内存前检查)
+	n, err := snappy.DecodedLen(data)
+	if err != nil {
+		return nil, ErrCorrupt
+	}
+	if n > MaxDecompressedSize {
+		return nil, ErrTooLarge
+	}
+	decoded, err := snappy.Decode(nil, data)
+	if err != nil {
+		return nil, ErrCorrupt
+	}
+	return decoded, nil
+}
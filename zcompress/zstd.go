@@ -0,0 +1,84 @@
+package zcompress
+
+import (
+	"errors"
+	"sync"
+
+	"github.com/aceld/zinx/ziface"
+	"github.com/klauspost/compress/zstd"
+)
+
+var (
+	zstdEncoderOnce sync.Once
+	zstdEncoder     *zstd.Encoder
+
+	zstdDecoderLock  sync.Mutex
+	zstdDecoder      *zstd.Decoder
+	zstdDecoderLimit int // MaxDecompressedSize the decoder was created with (创建解码器时的MaxDecompressedSize)
+)
+
+// ZstdCompressor compresses messages in the zstd format (https://github.com/facebook/zstd),
+// with a better ratio than gzip at a higher speed
+// (使用zstd格式压缩消息, 压缩率和速度均优于gzip)
+type ZstdCompressor struct{}
+
+func (ZstdCompressor) Name() string {
+	return ziface.ZinxCompressZstd
+}
+
+func (ZstdCompressor) ID() uint8 {
+	return ziface.ZinxCompressZstdID
+}
+
+func (ZstdCompressor) Compress(data []byte) ([]byte, error) {
+	zstdEncoderOnce.Do(func() {
+		zstdEncoder, _ = zstd.NewWriter(nil, zstd.WithEncoderConcurrency(1))
+	})
+	return zstdEncoder.EncodeAll(data, nil), nil
+}
+
+func (ZstdCompressor) Decompress(data []byte) ([]byte, error) {
+	decoder, err := getZstdDecoder()
+	if err != nil {
+		return nil, err
+	}
+	decoded, err := decoder.DecodeAll(data, nil)
+	if errors.Is(err, zstd.ErrDecoderSizeExceeded) || errors.Is(err, zstd.ErrWindowSizeExceeded) {
+		return nil, ErrTooLarge
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(decoded) > MaxDecompressedSize {
+		return nil, ErrTooLarge
+	}
+	return decoded, nil
+}
+
+// getZstdDecoder returns the shared decoder, which stops decoding beyond MaxDecompressedSize
+// (返回共享的解码器, 解码超过MaxDecompressedSize时停止)
+func getZstdDecoder() (*zstd.Decoder, error) {
+	zstdDecoderLock.Lock()
+	defer zstdDecoderLock.Unlock()
+
+	if zstdDecoder != nil && zstdDecoderLimit == MaxDecompressedSize {
+		return zstdDecoder, nil
+	}
+	window := uint64(zstd.MaxWindowSize)
+	if uint64(MaxDecompressedSize) < window {
+		window = uint64(MaxDecompressedSize)
+	}
+	if window < zstd.MinWindowSize {
+		window = zstd.MinWindowSize
+	}
+	decoder, err := zstd.NewReader(nil,
+		zstd.WithDecoderConcurrency(0),
+		zstd.WithDecoderMaxMemory(uint64(MaxDecompressedSize)),
+		zstd.WithDecoderMaxWindow(window))
+	if err != nil {
+		return nil, err
+	}
+	// The old decoder may still be decoding, it is left to the GC (旧解码器可能仍在使用, 交给GC回收)
+	zstdDecoder, zstdDecoderLimit = decoder, MaxDecompressedSize
+	return decoder, nil
+}
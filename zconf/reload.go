@@ -0,0 +1,319 @@
+// @Title  reload.go
+// @Description  Hot reload of the configuration file (配置文件热加载)
+package zconf
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"reflect"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/aceld/zinx/zlog"
+)
+
+// RateLimitFields are the names of the RateLimit* fields, for ReloadReport.Changed
+// (RateLimit*字段的名称, 用于ReloadReport.Changed)
+var RateLimitFields = []string{
+	"RateLimitConnRate", "RateLimitConnBurst",
+	"RateLimitIPRate", "RateLimitIPBurst",
+	"RateLimitMsgRate", "RateLimitMsgBurst",
+	"RateLimitAction", "RateLimitReplyMsgID",
+}
+
+// hotFields are the fields applied to a running server by HotReload, the others only take effect after a restart
+// (HotReload可以直接应用到运行中服务的字段, 其余字段需要重启后才生效)
+var hotFields = map[string]bool{
+	"LogIsolationLevel":   true,
+	"MaxConn":             true,
+	"HeartbeatMax":        true,
+	"RateLimitConnRate":   true,
+	"RateLimitConnBurst":  true,
+	"RateLimitIPRate":     true,
+	"RateLimitIPBurst":    true,
+	"RateLimitMsgRate":    true,
+	"RateLimitMsgBurst":   true,
+	"RateLimitAction":     true,
+	"RateLimitReplyMsgID": true,
+}
+
+// ReloadReport tells which changed fields were applied and which need a restart
+// (记录本次热加载中已应用的字段和需要重启才能生效的字段)
+type ReloadReport struct {
+	Applied         []string
+	RestartRequired []string
+}
+
+// Changed reports whether any of the fields was applied (判断指定字段中是否有字段被应用)
+func (r *ReloadReport) Changed(fields ...string) bool {
+	for _, applied := range r.Applied {
+		for _, field := range fields {
+			if applied == field {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// ReloadHandler is called after a hot reload applied some fields,
+// old is a copy of the config before the reload and conf is the reloaded config itself
+// (热加载应用了字段后的回调, old为热加载前配置的副本, conf为热加载后的配置本身)
+type ReloadHandler func(old, conf *Config, report *ReloadReport)
+
+var (
+	// reloadLock serializes the hot reloads (串行执行热加载)
+	reloadLock sync.Mutex
+
+	// hotLock guards the hot fields while a reload writes them, read them with the accessors such as GetMaxConn
+	// (热加载写入可热加载字段时加锁, 读取时使用GetMaxConn等方法)
+	hotLock sync.RWMutex
+
+	handlerLock    sync.Mutex
+	reloadHandlers []*ReloadHandler
+)
+
+// OnReload registers a handler called after every hot reload that applied some fields,
+// call the returned function to unregister it
+// (注册热加载回调, 每次热加载应用了字段后调用, 调用返回的函数取消注册)
+func OnReload(handler ReloadHandler) (unsubscribe func()) {
+	handlerLock.Lock()
+	defer handlerLock.Unlock()
+
+	entry := &handler
+	reloadHandlers = append(reloadHandlers, entry)
+
+	return func() {
+		handlerLock.Lock()
+		defer handlerLock.Unlock()
+
+		for i, h := range reloadHandlers {
+			if h == entry {
+				// Copy instead of removing in place, a reload may be ranging the old slice
+				// (复制而非原地删除, 热加载可能正在遍历旧的切片)
+				handlers := make([]*ReloadHandler, 0, len(reloadHandlers)-1)
+				handlers = append(handlers, reloadHandlers[:i]...)
+				reloadHandlers = append(handlers, reloadHandlers[i+1:]...)
+				return
+			}
+		}
+	}
+}
+
+// Validate checks the values of the config (校验配置的取值)
+func (g *Config) Validate() error {
+	var errs []string
+	check := func(ok bool, format string, v ...interface{}) {
+		if !ok {
+			errs = append(errs, fmt.Sprintf(format, v...))
+		}
+	}
+
+	for name, port := range map[string]int{"TCPPort": g.TCPPort, "WsPort": g.WsPort, "KcpPort": g.KcpPort} {
+		check(port >= 0 && port <= 65535, "%s %d out of range", name, port)
+	}
+	check(g.MaxConn > 0, "MaxConn must be positive")
+	check(g.MaxPacketSize > 0, "MaxPacketSize must be positive")
+	check(g.IOReadBuffSize > 0, "IOReadBuffSize must be positive")
+	check(g.HeartbeatMax > 0, "HeartbeatMax must be positive")
+	check(g.ReaderIdleTime >= 0 && g.WriterIdleTime >= 0 && g.AllIdleTime >= 0, "idle timeouts must not be negative")
+	check(g.LogIsolationLevel >= zlog.LogDebug && g.LogIsolationLevel <= zlog.LogPanic+1,
+		"LogIsolationLevel %d out of range", g.LogIsolationLevel)
+
+	switch g.Mode {
+	case "", ServerModeTcp, ServerModeWebsocket, ServerModeKcp, ServerModeEpoll, ServerModeUnix:
+	default:
+		check(false, "unknown Mode %q", g.Mode)
+	}
+	if g.UnixSocketPerm != "" {
+		_, err := g.UnixSocketFileMode()
+		check(err == nil, "UnixSocketPerm %q is not an octal permission", g.UnixSocketPerm)
+	}
+	switch g.TLSClientAuth {
+	case "", TLSClientAuthNone, TLSClientAuthRequest, TLSClientAuthRequire, TLSClientAuthVerify, TLSClientAuthRequireAndVerify:
+	default:
+		check(false, "unknown TLSClientAuth %q", g.TLSClientAuth)
+	}
+	check(g.TLSClientCAFile != "" || (g.TLSClientAuth != TLSClientAuthVerify && g.TLSClientAuth != TLSClientAuthRequireAndVerify),
+		"TLSClientAuth %q requires TLSClientCAFile", g.TLSClientAuth)
+	switch g.TLSMinVersion {
+	case "", "1.0", "1.1", "1.2", "1.3":
+	default:
+		check(false, "unknown TLSMinVersion %q", g.TLSMinVersion)
+	}
+	switch g.WorkerMode {
+	case "", WorkerModeHash, WorkerModeBind, WorkerModeDynamicBind, WorkerModeKey:
+	default:
+		check(false, "unknown WorkerMode %q", g.WorkerMode)
+	}
+	switch g.WorkerOverflowAction {
+	case "", WorkerOverflowBlock, WorkerOverflowReject, WorkerOverflowShed, WorkerOverflowSpill:
+	default:
+		check(false, "unknown WorkerOverflowAction %q", g.WorkerOverflowAction)
+	}
+	check(g.WorkerScaleInterval >= 0 && g.WorkerScaleLatency >= 0, "WorkerScale settings must not be negative")
+	check(g.WorkerMode != WorkerModeKey || g.WorkerPoolSize > 0, "WorkerMode %q needs a positive WorkerPoolSize", WorkerModeKey)
+	switch g.RateLimitAction {
+	case "", RateLimitActionDrop, RateLimitActionDelay, RateLimitActionReply, RateLimitActionClose:
+	default:
+		check(false, "unknown RateLimitAction %q", g.RateLimitAction)
+	}
+
+	for name, policy := range map[string]string{"SendQueueLowPolicy": g.SendQueueLowPolicy, "SendQueueNormalPolicy": g.SendQueueNormalPolicy, "SendQueueHighPolicy": g.SendQueueHighPolicy} {
+		switch policy {
+		case "", SendQueueDropNewest, SendQueueDropOldest, SendQueueBlock, SendQueueDisconnect, SendQueueCoalesce:
+		default:
+			check(false, "unknown %s %q", name, policy)
+		}
+	}
+
+	check(g.RateLimitConnRate >= 0 && g.RateLimitIPRate >= 0 && g.RateLimitMsgRate >= 0, "RateLimit rates must not be negative")
+	check(g.RateLimitConnBurst >= 0 && g.RateLimitIPBurst >= 0 && g.RateLimitMsgBurst >= 0, "RateLimit bursts must not be negative")
+
+	if len(errs) > 0 {
+		return errors.New("invalid config: " + strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// HotReload reads the configuration file again and applies the changed fields that are safe to change
+// on a running server (log isolation level, MaxConn, HeartbeatMax and the rate limits).
+// Other changed fields are listed in ReloadReport.RestartRequired and keep their current values.
+// Nothing is applied when the file can not be read or the new config is invalid.
+// (重新读取配置文件, 将可以在运行中修改的字段(日志隔离级别、MaxConn、HeartbeatMax和限流参数)直接应用,
+// 其余有变化的字段记录在ReloadReport.RestartRequired中并保持原值; 文件读取失败或新配置不合法时不做任何修改)
+func (g *Config) HotReload() (*ReloadReport, error) {
+	return g.hotReload(GetConfigFilePath())
+}
+
+func (g *Config) hotReload(path string) (*ReloadReport, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	// Handlers run under reloadLock, so they see the reloads one by one
+	// (回调在reloadLock内执行, 因此按顺序看到每次热加载)
+	reloadLock.Lock()
+	defer reloadLock.Unlock()
+
+	report, old, err := g.apply(data)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(report.Applied) > 0 {
+		zlog.Ins().InfoF("config %s reloaded, applied: %v", path, report.Applied)
+
+		handlerLock.Lock()
+		handlers := reloadHandlers
+		handlerLock.Unlock()
+		for _, handler := range handlers {
+			(*handler)(old, g, report)
+		}
+	}
+	if len(report.RestartRequired) > 0 {
+		zlog.Ins().InfoF("config %s reloaded, restart required by: %v", path, report.RestartRequired)
+	}
+
+	return report, nil
+}
+
+// apply applies the hot fields of the JSON data to g, it must be called with reloadLock held
+// (将JSON数据中的可热加载字段应用到g, 调用时需持有reloadLock)
+func (g *Config) apply(data []byte) (*ReloadReport, *Config, error) {
+	// Fields missing in the file keep their current values, the same as Reload
+	// (文件中未配置的字段保持当前值, 与Reload一致)
+	old := *g
+	conf := *g
+	if err := json.Unmarshal(data, &conf); err != nil {
+		return nil, nil, err
+	}
+	if err := conf.Validate(); err != nil {
+		return nil, nil, err
+	}
+
+	report := &ReloadReport{}
+	hotLock.Lock()
+	defer hotLock.Unlock()
+	oldVal, newVal, dst := reflect.ValueOf(&old).Elem(), reflect.ValueOf(&conf).Elem(), reflect.ValueOf(g).Elem()
+	for i := 0; i < newVal.NumField(); i++ {
+		if reflect.DeepEqual(oldVal.Field(i).Interface(), newVal.Field(i).Interface()) {
+			continue
+		}
+		name := newVal.Type().Field(i).Name
+		if !hotFields[name] {
+			report.RestartRequired = append(report.RestartRequired, name)
+			continue
+		}
+		dst.Field(i).Set(newVal.Field(i))
+		report.Applied = append(report.Applied, name)
+	}
+
+	if report.Changed("LogIsolationLevel") {
+		zlog.SetLogLevel(g.LogIsolationLevel)
+	}
+
+	return report, &old, nil
+}
+
+// Watch hot reloads g whenever the process receives SIGHUP or the configuration file is modified,
+// the file is checked every interval (1 second if interval <= 0). Call the returned function to stop watching.
+// (进程收到SIGHUP或配置文件被修改时热加载g, 每隔interval检查一次文件(interval<=0时为1秒), 调用返回的函数停止监听)
+func (g *Config) Watch(interval time.Duration) (stop func()) {
+	return g.watch(GetConfigFilePath(), interval)
+}
+
+func (g *Config) watch(path string, interval time.Duration) func() {
+	if interval <= 0 {
+		interval = time.Second
+	}
+
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	done := make(chan struct{})
+
+	modTime := func() time.Time {
+		if info, err := os.Stat(path); err == nil {
+			return info.ModTime()
+		}
+		return time.Time{}
+	}
+
+	last := modTime()
+	go func() {
+		defer signal.Stop(hup)
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-done:
+				return
+			case <-hup:
+				last = modTime()
+			case <-ticker.C:
+				mod := modTime()
+				if mod.Equal(last) || mod.IsZero() {
+					continue
+				}
+				last = mod
+			}
+
+			if _, err := g.hotReload(path); err != nil {
+				zlog.Ins().ErrorF("config %s hot reload err: %v", path, err)
+			}
+		}
+	}()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() { close(done) })
+	}
+}
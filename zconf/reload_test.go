@@ -0,0 +1,154 @@
+package zconf
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+// run in terminal:
+// go test -v ./zconf -run=TestHotReload
+
+func writeConf(t *testing.T, path, data string) {
+	if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestHotReload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "zinx.json")
+	conf := &Config{TCPPort: 8999, MaxConn: 100, MaxPacketSize: 4096, IOReadBuffSize: 1024, HeartbeatMax: 10}
+
+	var got *ReloadReport
+	unsubscribe := OnReload(func(old, c *Config, report *ReloadReport) {
+		if c == conf {
+			got = report
+		}
+	})
+	defer unsubscribe()
+
+	writeConf(t, path, `{"MaxConn": 200, "HeartbeatMax": 20, "TCPPort": 9000, "RateLimitMsgRate": 5}`)
+	report, err := conf.hotReload(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if conf.MaxConn != 200 || conf.HeartbeatMax != 20 || conf.RateLimitMsgRate != 5 {
+		t.Fatalf("hot fields not applied: %+v", conf)
+	}
+	// Restart-only fields keep their values (需要重启的字段保持原值)
+	if conf.TCPPort != 8999 {
+		t.Fatalf("TCPPort = %d, want 8999", conf.TCPPort)
+	}
+	if !reflect.DeepEqual(report.Applied, []string{"MaxConn", "HeartbeatMax", "RateLimitMsgRate"}) ||
+		!reflect.DeepEqual(report.RestartRequired, []string{"TCPPort"}) {
+		t.Fatalf("report = %+v", report)
+	}
+	if got != report {
+		t.Fatal("reload handler not called")
+	}
+
+	// An invalid config changes nothing (不合法的配置不做任何修改)
+	writeConf(t, path, `{"MaxConn": 300, "RateLimitAction": "ignore"}`)
+	if _, err := conf.hotReload(path); err == nil || conf.MaxConn != 200 {
+		t.Fatalf("err = %v MaxConn = %d, want an error and 200", err, conf.MaxConn)
+	}
+}
+
+func TestWatch(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "zinx.json")
+	conf := &Config{MaxConn: 100, MaxPacketSize: 4096, IOReadBuffSize: 1024, HeartbeatMax: 10}
+	writeConf(t, path, `{"MaxConn": 100}`)
+
+	reloaded := make(chan int, 1)
+	unsubscribe := OnReload(func(old, c *Config, report *ReloadReport) {
+		if c == conf {
+			reloaded <- c.MaxConn
+		}
+	})
+	defer unsubscribe()
+
+	stop := conf.watch(path, 10*time.Millisecond)
+	defer stop()
+
+	writeConf(t, path, `{"MaxConn": 150}`)
+	// Make sure the modification time moves forward (确保文件修改时间发生变化)
+	future := time.Now().Add(time.Second)
+	_ = os.Chtimes(path, future, future)
+
+	select {
+	case maxConn := <-reloaded:
+		if maxConn != 150 {
+			t.Fatalf("MaxConn = %d, want 150", maxConn)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("config not reloaded after the file changed")
+	}
+}
+
+func TestReloadUnsubscribe(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "zinx.json")
+	conf := &Config{MaxConn: 100, MaxPacketSize: 4096, IOReadBuffSize: 1024, HeartbeatMax: 10}
+
+	calls := 0
+	unsubscribe := OnReload(func(old, c *Config, report *ReloadReport) {
+		if c == conf {
+			calls++
+		}
+	})
+
+	writeConf(t, path, `{"MaxConn": 200}`)
+	if _, err := conf.hotReload(path); err != nil || calls != 1 {
+		t.Fatalf("err = %v calls = %d, want 1 call", err, calls)
+	}
+
+	// Unregistered handlers are not called any more, calling twice is harmless (取消注册后不再调用, 重复调用无影响)
+	unsubscribe()
+	unsubscribe()
+	writeConf(t, path, `{"MaxConn": 300}`)
+	if _, err := conf.hotReload(path); err != nil || calls != 1 {
+		t.Fatalf("err = %v calls = %d, want no more calls", err, calls)
+	}
+}
+
+// run in terminal:
+// go test -race -v ./zconf -run=TestHotReloadConcurrentRead
+func TestHotReloadConcurrentRead(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "zinx.json")
+	conf := &Config{MaxConn: 100, MaxPacketSize: 4096, IOReadBuffSize: 1024, HeartbeatMax: 10}
+
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for {
+			select {
+			case <-done:
+				return
+			default:
+				if conf.GetMaxConn() <= 0 || conf.HeartbeatMaxDuration() <= 0 {
+					t.Error("read a config being reloaded")
+					return
+				}
+			}
+		}
+	}()
+
+	for i := 1; i <= 20; i++ {
+		writeConf(t, path, fmt.Sprintf(`{"MaxConn": %d, "HeartbeatMax": %d}`, 100+i, 10+i))
+		if _, err := conf.hotReload(path); err != nil {
+			t.Fatal(err)
+		}
+	}
+	close(done)
+	wg.Wait()
+
+	if conf.GetMaxConn() != 120 || conf.HeartbeatMaxDuration() != 30*time.Second {
+		t.Fatalf("MaxConn = %d HeartbeatMax = %v", conf.GetMaxConn(), conf.HeartbeatMaxDuration())
+	}
+}
@@ -38,6 +38,21 @@ func UserConfToGlobal(config *Config) {
 	if config.WorkerMode != "" {
 		GlobalObject.WorkerMode = config.WorkerMode
 	}
+	if config.WorkerPoolMaxSize != 0 {
+		GlobalObject.WorkerPoolMaxSize = config.WorkerPoolMaxSize
+	}
+	if config.WorkerScaleInterval != 0 {
+		GlobalObject.WorkerScaleInterval = config.WorkerScaleInterval
+	}
+	if config.WorkerScaleLatency != 0 {
+		GlobalObject.WorkerScaleLatency = config.WorkerScaleLatency
+	}
+	if config.WorkerOverflowAction != "" {
+		GlobalObject.WorkerOverflowAction = config.WorkerOverflowAction
+	}
+	if config.WorkerRejectMsgID != 0 {
+		GlobalObject.WorkerRejectMsgID = config.WorkerRejectMsgID
+	}
 
 	if config.MaxMsgChanLen != 0 {
 		GlobalObject.MaxMsgChanLen = config.MaxMsgChanLen
@@ -78,6 +93,15 @@ func UserConfToGlobal(config *Config) {
 	if config.HeartbeatMax != 0 {
 		GlobalObject.HeartbeatMax = config.HeartbeatMax
 	}
+	if config.ReaderIdleTime != 0 {
+		GlobalObject.ReaderIdleTime = config.ReaderIdleTime
+	}
+	if config.WriterIdleTime != 0 {
+		GlobalObject.WriterIdleTime = config.WriterIdleTime
+	}
+	if config.AllIdleTime != 0 {
+		GlobalObject.AllIdleTime = config.AllIdleTime
+	}
 
 	// TLS
 	if config.CertFile != "" {
@@ -86,10 +110,34 @@ func UserConfToGlobal(config *Config) {
 	if config.PrivateKeyFile != "" {
 		GlobalObject.PrivateKeyFile = config.PrivateKeyFile
 	}
+	if len(config.TLSCertificates) > 0 {
+		GlobalObject.TLSCertificates = config.TLSCertificates
+	}
+	if config.TLSClientCAFile != "" {
+		GlobalObject.TLSClientCAFile = config.TLSClientCAFile
+	}
+	if config.TLSClientAuth != "" {
+		GlobalObject.TLSClientAuth = config.TLSClientAuth
+	}
+	if config.TLSMinVersion != "" {
+		GlobalObject.TLSMinVersion = config.TLSMinVersion
+	}
+	if len(config.TLSCipherSuites) > 0 {
+		GlobalObject.TLSCipherSuites = config.TLSCipherSuites
+	}
 
+	if config.UnixSocketPath != "" {
+		GlobalObject.UnixSocketPath = config.UnixSocketPath
+	}
+	if config.UnixSocketPerm != "" {
+		GlobalObject.UnixSocketPerm = config.UnixSocketPerm
+	}
 	if config.Mode != "" {
 		GlobalObject.Mode = config.Mode
 	}
+	if config.EpollLoops != 0 {
+		GlobalObject.EpollLoops = config.EpollLoops
+	}
 	if config.WsPort != 0 {
 		GlobalObject.WsPort = config.WsPort
 	}
@@ -149,4 +197,84 @@ func UserConfToGlobal(config *Config) {
 		GlobalObject.KcpFecParityShards = config.KcpFecParityShards
 	}
 
+	// Metrics
+	if config.PrometheusMetricsEnable {
+		GlobalObject.PrometheusMetricsEnable = config.PrometheusMetricsEnable
+	}
+	if config.PrometheusServer {
+		GlobalObject.PrometheusServer = config.PrometheusServer
+	}
+	if config.PrometheusListen != "" {
+		GlobalObject.PrometheusListen = config.PrometheusListen
+	}
+	if config.PrometheusPath != "" {
+		GlobalObject.PrometheusPath = config.PrometheusPath
+	}
+
+	// RateLimit
+	if config.RateLimitConnRate > 0 {
+		GlobalObject.RateLimitConnRate = config.RateLimitConnRate
+	}
+	if config.RateLimitConnBurst > 0 {
+		GlobalObject.RateLimitConnBurst = config.RateLimitConnBurst
+	}
+	if config.RateLimitIPRate > 0 {
+		GlobalObject.RateLimitIPRate = config.RateLimitIPRate
+	}
+	if config.RateLimitIPBurst > 0 {
+		GlobalObject.RateLimitIPBurst = config.RateLimitIPBurst
+	}
+	if config.RateLimitMsgRate > 0 {
+		GlobalObject.RateLimitMsgRate = config.RateLimitMsgRate
+	}
+	if config.RateLimitMsgBurst > 0 {
+		GlobalObject.RateLimitMsgBurst = config.RateLimitMsgBurst
+	}
+	if config.RateLimitAction != "" {
+		GlobalObject.RateLimitAction = config.RateLimitAction
+	}
+	if config.RateLimitReplyMsgID != 0 {
+		GlobalObject.RateLimitReplyMsgID = config.RateLimitReplyMsgID
+	}
+
+	// SendQueue
+	if config.SendQueueLowSize != 0 {
+		GlobalObject.SendQueueLowSize = config.SendQueueLowSize
+	}
+	if config.SendQueueNormalSize != 0 {
+		GlobalObject.SendQueueNormalSize = config.SendQueueNormalSize
+	}
+	if config.SendQueueHighSize != 0 {
+		GlobalObject.SendQueueHighSize = config.SendQueueHighSize
+	}
+	if config.SendQueueLowPolicy != "" {
+		GlobalObject.SendQueueLowPolicy = config.SendQueueLowPolicy
+	}
+	if config.SendQueueNormalPolicy != "" {
+		GlobalObject.SendQueueNormalPolicy = config.SendQueueNormalPolicy
+	}
+	if config.SendQueueHighPolicy != "" {
+		GlobalObject.SendQueueHighPolicy = config.SendQueueHighPolicy
+	}
+}
+
+// UserServerConf synchronizes config to GlobalObject with UserConfToGlobal and returns the config of a server
+// created with it: a copy of GlobalObject whose rate limits and idle timeouts are the ones of config itself,
+// so a zero turns them off for that server whatever GlobalObject says
+// (通过UserConfToGlobal同步config至GlobalObject, 并返回以其创建的Server的配置: GlobalObject的副本,
+// 其中限流速率和空闲超时取config自身的值, 因此为0时该Server不开启, 与GlobalObject无关)
+func UserServerConf(config *Config) *Config {
+	UserConfToGlobal(config)
+
+	hotLock.RLock()
+	conf := *GlobalObject
+	hotLock.RUnlock()
+
+	conf.RateLimitConnRate = config.RateLimitConnRate
+	conf.RateLimitIPRate = config.RateLimitIPRate
+	conf.RateLimitMsgRate = config.RateLimitMsgRate
+	conf.ReaderIdleTime = config.ReaderIdleTime
+	conf.WriterIdleTime = config.WriterIdleTime
+	conf.AllIdleTime = config.AllIdleTime
+	return &conf
 }
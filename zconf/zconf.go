@@ -10,6 +10,7 @@ import (
 	"fmt"
 	"os"
 	"reflect"
+	"strconv"
 	"testing"
 	"time"
 
@@ -20,6 +21,8 @@ const (
 	ServerModeTcp       = "tcp"
 	ServerModeWebsocket = "websocket"
 	ServerModeKcp       = "kcp"
+	ServerModeEpoll     = "epoll" // TCP served by a few epoll event loops instead of a reader goroutine per connection, Linux only (由少量epoll事件循环代替每连接一个读协程的TCP模式, 仅支持Linux)
+	ServerModeUnix      = "unix"  // Unix domain socket at UnixSocketPath, for clients on the same host (监听UnixSocketPath处的Unix域套接字, 用于同一主机上的客户端)
 )
 
 const (
@@ -33,6 +36,32 @@ const (
 	//跟WorkerModeHash的区别是，如果业务层回调有阻塞操作的话，也不影响其他连接的业务层处理。
 	//跟WorkerModeBind的区别是，不需要像Bind模式那样一开始就创建很多worker,而是根据连接数动态创建worker，这样可以避免闲置worker数量过多导致的资源浪费。
 	WorkerModeDynamicBind = "DynamicBind" // Dynamic binding of a worker to each connection when there is no worker in worker pool.(临时动态创建一个worker绑定到每个连接)
+
+	// WorkerModeKey 按业务key(房间ID、玩家ID、订单ID等)分配worker, 同一个key的请求无论来自哪个连接都由同一个worker按顺序处理,
+	// key由IServer.SetDispatchKey设置的函数给出, 没有key的请求按ConnID分配, 与Hash模式相同。
+	WorkerModeKey = "Key" // Requests with the same dispatch key are handled in order by one worker, across connections.(相同分发key的请求跨连接由同一个worker按顺序处理)
+)
+
+const (
+	WorkerOverflowBlock  = "block"  // Wait until the worker queue has room, the reader of the connection stalls.(等待worker队列有空位, 连接的读协程会被阻塞)
+	WorkerOverflowReject = "reject" // Drop the request and reply an error message to the client.(丢弃请求并向客户端回复错误信息)
+	WorkerOverflowShed   = "shed"   // Drop the request silently.(直接丢弃请求)
+	WorkerOverflowSpill  = "spill"  // Put the request into a spill lane of its worker, keeping its order, and reject it when the lane is full too.(放入该worker的溢出队列并保持顺序, 溢出队列也满时拒绝)
+)
+
+const (
+	RateLimitActionDrop  = "drop"  // Drop the message silently.(直接丢弃消息)
+	RateLimitActionDelay = "delay" // Hold the message until a token is available.(等待令牌后再处理消息)
+	RateLimitActionReply = "reply" // Drop the message and send RateLimitReplyMsgID back.(丢弃消息并回复RateLimitReplyMsgID)
+	RateLimitActionClose = "close" // Drop the message and close the connection.(丢弃消息并关闭连接)
+)
+
+const (
+	SendQueueDropNewest = "drop-newest" // Refuse the new message with an error.(拒绝新消息并返回错误)
+	SendQueueDropOldest = "drop-oldest" // Drop the oldest message of the queue to make room.(丢弃队列中最早的消息腾出空间)
+	SendQueueBlock      = "block"       // Wait for room up to the send timeout.(在发送超时时间内等待空位)
+	SendQueueDisconnect = "disconnect"  // Refuse the new message and close the slow connection.(拒绝新消息并关闭发送缓慢的连接)
+	SendQueueCoalesce   = "coalesce"    // Replace the queued message with the same MsgID, for state that only the latest value matters.(替换队列中MsgID相同的消息, 用于只关心最新值的状态)
 )
 
 /*
@@ -52,6 +81,11 @@ type Config struct {
 	Name    string // The name of the current server.(当前服务器名称)
 	KcpPort int    // he port number on which the server listens for KCP connections.(当前服务器主机监听端口号)
 
+	UnixSocketPath string // The socket file the server listens on in "unix" mode.("unix"模式下监听的套接字文件路径)
+	// The permission of the socket file in octal, such as "0660", empty leaves it to the umask.
+	// (套接字文件的权限, 八进制字符串, 例如"0660", 为空时由umask决定)
+	UnixSocketPerm string
+
 	/*
 		ServerConfig
 	*/
@@ -74,19 +108,37 @@ type Config struct {
 	MaxConn          int    // The maximum number of connections that the server can handle.(当前服务器主机允许的最大连接个数)
 	WorkerPoolSize   uint32 // The number of worker pools in the business logic.(业务工作Worker池的数量)
 	MaxWorkerTaskLen uint32 // The maximum number of tasks that a worker pool can handle.(业务工作Worker对应负责的任务队列最大任务存储数量)
-	WorkerMode       string // The way to assign workers to connections.(为连接分配worker的方式)
+	WorkerMode       string // The way to assign workers to connections or dispatch keys.(为连接或分发key分配worker的方式)
 	MaxMsgChanLen    uint32 // The maximum length of the send buffer message queue.(SendBuffMsg发送消息的缓冲最大长度)
 	IOReadBuffSize   uint32 // The maximum size of the read buffer for each IO operation.(每次IO最大的读取长度)
 
-	//The server mode, which can be "tcp" or "websocket". If it is empty, both modes are enabled.
-	//"tcp":tcp监听, "websocket":websocket 监听 为空时同时开启
+	// In "Hash" mode the pool grows up to WorkerPoolMaxSize workers when the queues fill up or the handling gets slow,
+	// and shrinks back to WorkerPoolSize when idle. A connection, new or not, moves to the least loaded worker
+	// whenever none of its requests is queued, so its requests keep their order.
+	// (在"Hash"模式下, 任务队列积压或处理变慢时worker池最多扩容到WorkerPoolMaxSize个, 空闲时缩容回WorkerPoolSize个;
+	// 无论新旧连接, 在没有排队中的请求时移到负载最低的worker上, 因此其请求保持顺序)
+	WorkerPoolMaxSize    uint32 // Upper bound of the pool, not above WorkerPoolSize keeps the pool fixed.(worker池上限, 不大于WorkerPoolSize时池大小固定)
+	WorkerScaleInterval  int    // Milliseconds between two scaling checks, default 1000.(两次扩缩容检查的间隔毫秒数)
+	WorkerScaleLatency   int    // Average handling milliseconds above which the pool grows, 0 only looks at the queue depth.(平均处理耗时超过该毫秒数时扩容, 为0时只看队列长度)
+	WorkerOverflowAction string // What to do with a request whose worker queue is full: "block"(default), "reject", "shed" or "spill".(worker任务队列满时的处理方式)
+	WorkerRejectMsgID    uint32 // The MsgID a rejected request is answered with, RPC calls get an RPC error instead.(被拒绝的请求所回复的MsgID, RPC调用则回复RPC错误)
+
+	//The server mode, which can be "tcp", "websocket", "kcp", "epoll" or "unix". If it is empty, both tcp and websocket are enabled.
+	//"tcp":tcp监听, "websocket":websocket 监听, "epoll":基于epoll事件循环的tcp监听, "unix":Unix域套接字监听 为空时同时开启tcp和websocket
 	Mode string
 
+	// The number of epoll event loops in "epoll" mode, 0 means one per CPU.
+	// ("epoll"模式下epoll事件循环的数量, 0表示每个CPU一个)
+	EpollLoops int
+
 	// A boolean value that indicates whether the new or old version of the router is used. The default value is false.
 	// 路由模式 false为旧版本路由，true为启用新版本的路由 默认使用旧版本
 	RouterSlicesMode bool
 
 	// 是否开启 Request 对象池模式
+	// Whether the Request pool mode is on, the frame buffer of a request also goes back to zbuffer once the request is done,
+	// so the request data must be copied (or the request passed through Copy) before it is used in another goroutine.
+	// (开启后请求处理完成时帧缓冲也会归还zbuffer, 在其他协程中使用请求数据前需要拷贝(或使用Copy))
 	RequestPoolMode bool
 	/*
 		logger
@@ -112,13 +164,94 @@ type Config struct {
 	// 最长心跳检测间隔时间(单位：秒),超过改时间间隔，则认为超时，从配置文件读取
 	HeartbeatMax int
 
+	// Idle timeouts in seconds, all connections of the server are checked on one shared time wheel and 0 turns
+	// a timeout off. When one fires IServer.SetOnIdle is called, without it the connection is closed.
+	// (空闲超时秒数, 服务器的全部连接在同一个时间轮上检查, 为0时关闭该检查; 超时时调用IServer.SetOnIdle设置的函数, 未设置时关闭连接)
+	ReaderIdleTime int // Nothing read from the connection for this long.(连接超过该时长没有读到数据)
+	WriterIdleTime int // Nothing written to the connection for this long.(连接超过该时长没有写出数据)
+	AllIdleTime    int // Nothing read or written for this long.(连接超过该时长既没有读也没有写)
+
 	/*
 		TLS
 	*/
 	CertFile       string // The name of the certificate file. If it is empty, TLS encryption is not enabled.(证书文件名称 默认"")
 	PrivateKeyFile string // The name of the private key file. If it is empty, TLS encryption is not enabled.(私钥文件名称 默认"" --如果没有设置证书和私钥文件，则不启用TLS加密)
+
+	// More certificates, the one matching the SNI of the client is used, CertFile is the default.
+	// Certificate files are reloaded when they change.
+	// (更多的证书, 按客户端的SNI选择匹配的证书, CertFile为默认证书; 证书文件修改后自动重新加载)
+	TLSCertificates []TLSCertificate
+
+	// The CA bundle verifying client certificates, setting it turns on mutual TLS.
+	// (校验客户端证书的CA证书文件, 设置后开启双向TLS认证)
+	TLSClientCAFile string
+
+	// How client certificates are checked: "none", "request", "require", "verify" or "require_and_verify",
+	// "require_and_verify" by default when TLSClientCAFile is set.
+	// (客户端证书的校验方式, 设置了TLSClientCAFile时默认为"require_and_verify")
+	TLSClientAuth string
+
+	TLSMinVersion string // The minimum TLS version: "1.0", "1.1", "1.2"(default) or "1.3".(最低TLS版本 默认"1.2")
+
+	// Cipher suites by their crypto/tls names, such as "TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256", empty uses the Go defaults.
+	// (加密套件, 使用crypto/tls中的名称, 为空时使用Go的默认值)
+	TLSCipherSuites []string
+
+	/*
+		Metrics
+	*/
+	PrometheusMetricsEnable bool   // Whether to collect the built-in metrics.(是否开启内置指标统计 默认false)
+	PrometheusServer        bool   // Whether to start the built-in metrics HTTP server.(是否启动内置的指标HTTP服务 默认false)
+	PrometheusListen        string // The address the metrics HTTP server listens on.(指标HTTP服务监听地址 默认"0.0.0.0:20004")
+	PrometheusPath          string // The HTTP path metrics are exported on.(指标导出路径 默认"/metrics")
+
+	/*
+		RateLimit
+		Token bucket limits used by zinterceptor.RateLimiter, a rate of 0 disables that limit.
+		(zinterceptor.RateLimiter使用的令牌桶限流参数, 速率为0表示不开启该项限流)
+	*/
+	RateLimitConnRate   float64 // Messages per second allowed for each connection.(每个连接每秒允许的消息数)
+	RateLimitConnBurst  int     // Burst size of each connection.(每个连接的突发上限)
+	RateLimitIPRate     float64 // Messages per second allowed for each remote IP.(每个远程IP每秒允许的消息数)
+	RateLimitIPBurst    int     // Burst size of each remote IP.(每个远程IP的突发上限)
+	RateLimitMsgRate    float64 // Messages per second allowed for each MsgID, shared by all connections.(每个MsgID每秒允许的消息数，所有连接共享)
+	RateLimitMsgBurst   int     // Burst size of each MsgID.(每个MsgID的突发上限)
+	RateLimitAction     string  // What to do with a message over the limit: "drop"(default), "delay", "reply" or "close".(超限处理方式)
+	RateLimitReplyMsgID uint32  // The MsgID sent back when RateLimitAction is "reply".(RateLimitAction为"reply"时回复的MsgID)
+
+	/*
+		SendQueue
+		Outbound queues of SendBuffMsg, one per ziface.MsgPriority, a size of 0 uses MaxMsgChanLen.
+		A sender given ziface.WithSendMsgTimeout waits up to that long for room, then the policy decides what happens
+		when the queue is still full: "drop-newest", "drop-oldest", "block", "disconnect" or "coalesce",
+		empty keeps the default of the connection type ("drop-newest" for TCP, "block" for WebSocket and KCP).
+		(SendBuffMsg的发送队列, 每个优先级一个, 长度为0时使用MaxMsgChanLen;
+		指定了ziface.WithSendMsgTimeout的发送方最多等待该时长, 之后队列仍满时由策略决定处理方式,
+		为空时使用连接类型的默认方式: TCP为"drop-newest", WebSocket和KCP为"block")
+	*/
+	SendQueueLowSize      uint32 // Size of the low priority queue.(低优先级队列长度)
+	SendQueueNormalSize   uint32 // Size of the normal priority queue.(普通优先级队列长度)
+	SendQueueHighSize     uint32 // Size of the high priority queue.(高优先级队列长度)
+	SendQueueLowPolicy    string // Policy of the low priority queue when full.(低优先级队列满时的策略)
+	SendQueueNormalPolicy string // Policy of the normal priority queue when full.(普通优先级队列满时的策略)
+	SendQueueHighPolicy   string // Policy of the high priority queue when full.(高优先级队列满时的策略)
 }
 
+// TLSCertificate is a certificate and its private key (证书及其私钥)
+type TLSCertificate struct {
+	CertFile       string
+	PrivateKeyFile string
+}
+
+// TLS client authentication modes (客户端证书校验方式)
+const (
+	TLSClientAuthNone             = "none"               // Client certificates are not requested.(不请求客户端证书)
+	TLSClientAuthRequest          = "request"            // Requested but not required or verified.(请求但不要求、不校验)
+	TLSClientAuthRequire          = "require"            // Required but not verified.(要求但不校验)
+	TLSClientAuthVerify           = "verify"             // Verified when given.(提供时校验)
+	TLSClientAuthRequireAndVerify = "require_and_verify" // Required and verified.(要求并校验)
+)
+
 // GlobalObject Define a global object.(定义一个全局的对象)
 var GlobalObject *Config
 
@@ -181,9 +314,34 @@ func (g *Config) Show() {
 }
 
 func (g *Config) HeartbeatMaxDuration() time.Duration {
+	hotLock.RLock()
+	defer hotLock.RUnlock()
+
 	return time.Duration(g.HeartbeatMax) * time.Second
 }
 
+// GetMaxConn returns MaxConn, it is safe to call while the config is hot reloaded
+// (获取MaxConn, 可以在热加载的同时调用)
+func (g *Config) GetMaxConn() int {
+	hotLock.RLock()
+	defer hotLock.RUnlock()
+
+	return g.MaxConn
+}
+
+// UnixSocketFileMode parses UnixSocketPerm, 0 means it is not set
+// (解析UnixSocketPerm, 未设置时返回0)
+func (g *Config) UnixSocketFileMode() (os.FileMode, error) {
+	if g.UnixSocketPerm == "" {
+		return 0, nil
+	}
+	perm, err := strconv.ParseUint(g.UnixSocketPerm, 8, 32)
+	if err != nil || perm > 0777 {
+		return 0, fmt.Errorf("invalid unix socket permission %q", g.UnixSocketPerm)
+	}
+	return os.FileMode(perm), nil
+}
+
 func (g *Config) InitLogConfig() {
 	if g.LogFile != "" {
 		zlog.SetLogFile(g.LogDir, g.LogFile)
@@ -222,6 +380,7 @@ func init() {
 		WsPort:            9000,
 		WsPath:            "/",
 		KcpPort:           9001,
+		UnixSocketPath:    "/tmp/zinx.sock",
 		Host:              "0.0.0.0",
 		MaxConn:           12000,
 		MaxPacketSize:     4096,
@@ -251,6 +410,16 @@ func init() {
 		KcpSendWindow:      32,
 		KcpFecDataShards:   0,
 		KcpFecParityShards: 0,
+
+		PrometheusMetricsEnable: false,
+		PrometheusServer:        false,
+		PrometheusListen:        "0.0.0.0:20004",
+		PrometheusPath:          "/metrics",
+
+		RateLimitAction: RateLimitActionDrop,
+
+		WorkerScaleInterval:  1000,
+		WorkerOverflowAction: WorkerOverflowBlock,
 	}
 
 	// Note: Load some user-configured parameters from the configuration file.
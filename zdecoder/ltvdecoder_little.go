@@ -49,7 +49,6 @@
 package zdecoder
 
 import (
-	"bytes"
 	"encoding/binary"
 	"math"
 
@@ -102,11 +101,9 @@ func (ltv *LTV_Little_Decoder) decode(data []byte) *LTV_Little_Decoder {
 	ltvData.Length = binary.LittleEndian.Uint32(data[0:4])
 	//Get T
 	ltvData.Tag = binary.LittleEndian.Uint32(data[4:8])
-	//Determine the length of V. (确定V的长度)
-	ltvData.Value = make([]byte, ltvData.Length)
-
-	//5. Get V
-	binary.Read(bytes.NewBuffer(data[8:8+ltvData.Length]), binary.LittleEndian, ltvData.Value)
+	//Get V, V shares the memory of the frame instead of being copied
+	// (V直接引用帧的内存, 不再拷贝)
+	ltvData.Value = data[8 : 8+ltvData.Length]
 
 	return &ltvData
 }
@@ -50,7 +50,6 @@
 package zdecoder
 
 import (
-	"bytes"
 	"encoding/binary"
 	"math"
 
@@ -100,11 +99,9 @@ func (tlv *TLVDecoder) decode(data []byte) *TLVDecoder {
 	tlvData.Tag = binary.BigEndian.Uint32(data[0:4])
 	//Get L
 	tlvData.Length = binary.BigEndian.Uint32(data[4:8])
-	//Determine the length of V. (确定V的长度)
-	tlvData.Value = make([]byte, tlvData.Length)
-
-	//Get V
-	binary.Read(bytes.NewBuffer(data[8:8+tlvData.Length]), binary.BigEndian, tlvData.Value)
+	//Get V, V shares the memory of the frame instead of being copied
+	// (V直接引用帧的内存, 不再拷贝)
+	tlvData.Value = data[8 : 8+tlvData.Length]
 
 	//zlog.Ins().DebugF("TLV-DecodeData size:%d data:%+v\n", unsafe.Sizeof(data), tlvData)
 	return &tlvData
@@ -0,0 +1,35 @@
+package ziface
+
+import "time"
+
+// PropertyIdentity is the connection property holding the identity accepted by the AuthHandler
+// (保存AuthHandler认证通过的身份的连接属性)
+const PropertyIdentity = "zinx.identity"
+
+// AuthHandler checks a handshake message of a connection that has not been authenticated yet, it may reply to it.
+// Return accepted with the identity of the peer to finish the handshake, an error to reject and close the connection,
+// or neither to wait for the next handshake message.
+// (检查未认证连接的握手消息, 可以对其进行应答。返回accepted及对端身份表示握手完成, 返回error表示拒绝并关闭连接,
+// 两者都不返回表示继续等待下一条握手消息)
+type AuthHandler func(request IRequest) (identity interface{}, accepted bool, err error)
+
+// OnAuthReject is called before a connection failing the handshake is closed
+// (握手失败的连接被关闭前调用)
+type OnAuthReject func(conn IConnection, err error)
+
+// OnAuthAccept is called once a connection passed the handshake, with the identity accepted
+// (连接通过握手后以认证得到的身份调用)
+type OnAuthAccept func(conn IConnection, identity interface{})
+
+type AuthOption struct {
+	MaxMessages int           // The most handshake messages a connection may send, 1 by default(连接最多可以发送的握手消息数, 默认为1)
+	Timeout     time.Duration // Connections not authenticated in time are closed, 10s by default(超时未完成认证的连接会被关闭, 默认10秒)
+	AllowMsgIDs []uint32      // MsgIDs routed before authentication, such as the heartbeat(认证前即可路由的MsgID, 例如心跳)
+	OnReject    OnAuthReject  // Called before a rejected connection is closed(被拒绝的连接关闭前调用)
+	OnAccept    OnAuthAccept  // Called after a connection is accepted, see ISessionManager.OnAuthAccept(连接认证通过后调用, 参见ISessionManager.OnAuthAccept)
+}
+
+const (
+	AuthDefaultMaxMessages = 1
+	AuthDefaultTimeout     = 10 * time.Second
+)
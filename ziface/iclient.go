@@ -17,6 +17,11 @@ type IClient interface {
 	AddRouter(msgID uint32, router IRouter)
 	Conn() IConnection
 
+	// SendBuffMsg Send a message through the current connection with buffering,
+	// the message is kept and sent after reconnecting if the client is reconnecting with replay enabled
+	// (通过当前连接发送有缓冲的消息, 若开启了断线重放且正在重连, 消息会在重连成功后发送)
+	SendBuffMsg(msgID uint32, data []byte, opts ...MsgSendOption) error
+
 	// SetOnConnStart Set the Hook function to be called when a connection is created for this Client
 	// (设置该Client的连接创建时Hook函数)
 	SetOnConnStart(func(IConnection))
@@ -41,6 +46,14 @@ type IClient interface {
 	// (设置Client绑定的数据协议封包方式)
 	SetPacket(IDataPack)
 
+	// GetCodec Get the codec used by typed routers and IConnection.SendTyped
+	// (获取类型化路由和IConnection.SendTyped使用的编解码器)
+	GetCodec() ICodec
+
+	// SetCodec Set the codec used by typed routers and IConnection.SendTyped
+	// (设置类型化路由和IConnection.SendTyped使用的编解码器)
+	SetCodec(ICodec)
+
 	// GetMsgHandler Get the message handling module bound to this Client
 	// (获取Client绑定的消息处理模块)
 	GetMsgHandler() IMsgHandle
@@ -60,6 +73,10 @@ type IClient interface {
 	// AddInterceptor Add an interceptor for this Client 添加拦截器
 	AddInterceptor(IInterceptor)
 
+	// GetReliableSession Get the reliable session resumed on every connection, nil without WithReliableClient
+	// (获取在每个连接上恢复的可靠会话, 未设置WithReliableClient时为nil)
+	GetReliableSession() IReliableSession
+
 	// Get the error channel for this Client 获取客户端错误管道
 	GetErrChan() <-chan error
 
@@ -0,0 +1,18 @@
+// @Title icodec.go
+// @Description Serialization codec used by typed routers and IConnection.SendTyped
+package ziface
+
+// ICodec Serializes the messages exchanged by typed routers
+// (类型化路由使用的消息序列化方式)
+type ICodec interface {
+	Name() string                               // Name of the codec(序列化方式名称)
+	Marshal(v interface{}) ([]byte, error)      // Serialize v(序列化)
+	Unmarshal(data []byte, v interface{}) error // Deserialize data into v(反序列化)
+}
+
+const (
+	// Zinx built-in codecs(Zinx内置的序列化方式)
+	ZinxCodecJSON     string = "json"
+	ZinxCodecProtobuf string = "protobuf"
+	ZinxCodecMsgpack  string = "msgpack"
+)
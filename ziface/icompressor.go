@@ -0,0 +1,43 @@
+// @Title icompressor.go
+// @Description Message compression negotiated per connection
+package ziface
+
+// ICompressor Compresses the data of the messages exchanged by a connection
+// (压缩连接收发的消息数据)
+type ICompressor interface {
+	Name() string                           // Name used in the negotiation(协商时使用的名称)
+	ID() uint8                              // Algorithm ID carried by the compressed messages, not 0(压缩消息中携带的算法ID, 不能为0)
+	Compress(data []byte) ([]byte, error)   // Compress data(压缩)
+	Decompress(data []byte) ([]byte, error) // Decompress data(解压)
+}
+
+const (
+	// Zinx compression algorithms, all built in, others can be registered with zcompress.Register
+	// (Zinx的压缩算法, 均已内置, 其他算法可以通过zcompress.Register注册)
+	ZinxCompressGzip    string = "gzip"
+	ZinxCompressDeflate string = "deflate"
+	ZinxCompressZstd    string = "zstd"
+	ZinxCompressSnappy  string = "snappy"
+
+	// Algorithm IDs of the Zinx compression algorithms(Zinx压缩算法的算法ID)
+	ZinxCompressGzipID    uint8 = 1
+	ZinxCompressDeflateID uint8 = 2
+	ZinxCompressZstdID    uint8 = 3
+	ZinxCompressSnappyID  uint8 = 4
+)
+
+const (
+	// CompressNegotiateMsgID is the message a client offers its compression algorithms with,
+	// the server answers with the one chosen, or nothing to disable compression
+	// (客户端提供压缩算法的协商消息ID, 服务端应答选中的算法, 应答为空表示不压缩)
+	CompressNegotiateMsgID uint32 = 99998
+
+	// CompressMsgID is the MsgID of every compressed message, the MsgID compressed is carried in the
+	// compression header of the data, so the data of other messages is never taken for compressed
+	// (所有压缩消息都使用该MsgID, 被压缩的MsgID放在数据的压缩报头中, 因此其他消息的数据不会被误认为已压缩)
+	CompressMsgID uint32 = 99993
+
+	// CompressDefaultThreshold is the smallest message data compressed by default
+	// (默认压缩的最小消息数据长度)
+	CompressDefaultThreshold = 1024
+)
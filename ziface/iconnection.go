@@ -5,11 +5,18 @@ package ziface
 
 import (
 	"context"
+	"crypto/x509"
 	"net"
 
 	"github.com/gorilla/websocket"
 )
 
+// RPCMsgID is the MsgID of every frame of IConnection.Call and of its reply, the MsgID called is carried
+// in the RPC header of the data, so the data of other messages is never taken for an RPC frame
+// (IConnection.Call及其应答的所有帧都使用该MsgID, 被调用的MsgID放在数据的RPC报头中,
+// 因此其他消息的数据不会被误认为RPC帧)
+const RPCMsgID uint32 = 99994
+
 // IConnection Define connection interface
 type IConnection interface {
 	// Start the connection, make the current connection start working
@@ -48,6 +55,28 @@ type IConnection interface {
 	// 直接将Message数据发送给远程的TCP客户端(有缓冲)
 	SendBuffMsg(msgID uint32, data []byte, opts ...MsgSendOption) error
 
+	// Send an RPC request tagged with a correlation ID and wait for the reply sent by IRequest.Reply,
+	// returns ctx.Err() when ctx is done first
+	// (发送带有关联ID的RPC请求并等待IRequest.Reply发回的应答, ctx先结束则返回ctx.Err())
+	Call(ctx context.Context, msgID uint32, data []byte) (IMessage, error)
+
+	// Marshal v with the codec of the connection and send it directly (without buffering)
+	// (使用连接的编解码器序列化v并直接发送(无缓冲))
+	SendTyped(msgID uint32, v interface{}) error
+	GetCodec() ICodec // Get the codec inherited from the Server or Client (获取从Server或Client继承的编解码器)
+
+	// Get the identity accepted by the AuthHandler of the Server, nil before authentication
+	// (获取Server的AuthHandler认证通过的身份, 认证前为nil)
+	GetIdentity() interface{}
+
+	// Get the certificates presented by the peer over TLS, nil without TLS or when the peer presented none
+	// (获取对端通过TLS提供的证书, 未使用TLS或对端未提供证书时为nil)
+	GetPeerCertificates() []*x509.Certificate
+
+	// Get the compressor negotiated for the messages sent, nil while they are sent uncompressed
+	// (获取发送消息时协商好的压缩算法, 不压缩时为nil)
+	GetCompressor() ICompressor
+
 	SetProperty(key string, value interface{})   // Set connection property
 	GetProperty(key string) (interface{}, error) // Get connection property
 	RemoveProperty(key string)                   // Remove connection property
@@ -15,4 +15,14 @@ type IConnManager interface {
 	GetAllConnIdStr() []string                                              // Get all string connection IDs
 	Range(func(uint64, IConnection, interface{}) error, interface{}) error  // Traverse all connections
 	Range2(func(string, IConnection, interface{}) error, interface{}) error // Traverse all connections 2
+
+	// Groups (rooms) of connections keyed by name, a member leaves its groups automatically when it is closed
+	// (按名称管理的连接分组(房间), 连接关闭时自动退出所在的分组)
+	CreateGroup(name string) error                                                         // Create a group, fails if it already exists (创建分组)
+	DissolveGroup(name string)                                                             // Remove a group and all its members (解散分组)
+	JoinGroup(name string, conn IConnection) error                                         // Add a connection to a group (加入分组)
+	LeaveGroup(name string, conn IConnection)                                              // Remove a connection from a group (退出分组)
+	GetGroupMembers(name string) []IConnection                                             // Get the members of a group (获取分组成员)
+	GroupLen(name string) int                                                              // Get the number of members of a group (获取分组成员数量)
+	BroadcastGroup(name string, msgID uint32, data []byte, excludeConnIDs ...uint64) error // Send the message to every member except excludeConnIDs, packed once for the members without compression, encryption or a reliable session (将消息发送给除excludeConnIDs外的所有成员, 未使用压缩、加密或可靠会话的成员共用一次打包)
 }
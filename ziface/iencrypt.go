@@ -0,0 +1,38 @@
+// @Title iencrypt.go
+// @Description Application level encryption of the messages, for transports that can not use TLS
+package ziface
+
+import "time"
+
+const (
+	// Zinx ciphers sealing the message data(Zinx加密消息数据的算法)
+	ZinxCipherAESGCM           string = "aes-256-gcm"
+	ZinxCipherChaCha20Poly1305 string = "chacha20-poly1305"
+)
+
+const (
+	// EncryptExchangeMsgID is the message the client starts the X25519 key exchange with,
+	// it is the only message sent in plaintext on an encrypted connection
+	// (客户端发起X25519密钥交换的消息ID, 是加密连接上唯一的明文消息)
+	EncryptExchangeMsgID uint32 = 99997
+
+	EncryptDefaultTimeout = 10 * time.Second
+)
+
+// EncryptOption is the encryption setting of a Server or Client, messages sent before the key exchange
+// finishes are held and sent encrypted afterwards
+// (Server或Client的加密设置, 密钥交换完成前发送的消息会被暂存, 完成后加密发送)
+type EncryptOption struct {
+	// Ciphers accepted in order of preference, all Zinx ciphers by default
+	// (按优先级排序的可接受加密算法, 默认为全部Zinx加密算法)
+	Ciphers []string
+
+	// Optional key shared by both sides and mixed into the session keys, without it the key exchange
+	// is not authenticated and a man in the middle can not be detected
+	// (可选的双方共享密钥, 会参与会话密钥的生成。不设置时密钥交换没有身份认证, 无法发现中间人攻击)
+	PSK []byte
+
+	// The server closes connections not finishing the key exchange in time, 10s by default
+	// (服务端会关闭超时未完成密钥交换的连接, 默认10秒)
+	Timeout time.Duration
+}
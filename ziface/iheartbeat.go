@@ -39,3 +39,29 @@ type HeartBeatOption struct {
 const (
 	HeartBeatDefaultMsgID uint32 = 99999
 )
+
+// IdleState tells which idle timeout of a connection fired, see zconf.ReaderIdleTime
+// (连接触发的空闲超时类型, 见zconf.ReaderIdleTime)
+type IdleState uint8
+
+const (
+	ReaderIdle IdleState = iota + 1 // Nothing read for ReaderIdleTime.(超过ReaderIdleTime没有读到数据)
+	WriterIdle                      // Nothing written for WriterIdleTime.(超过WriterIdleTime没有写出数据)
+	AllIdle                         // Nothing read or written for AllIdleTime.(超过AllIdleTime既没有读也没有写)
+)
+
+func (s IdleState) String() string {
+	switch s {
+	case ReaderIdle:
+		return "reader idle"
+	case WriterIdle:
+		return "writer idle"
+	case AllIdle:
+		return "all idle"
+	}
+	return "unknown idle"
+}
+
+// OnIdle is called when an idle timeout of a connection fires
+// (连接空闲超时时调用的函数)
+type OnIdle func(IConnection, IdleState)
@@ -0,0 +1,50 @@
+// @Title ilistener.go
+// @Description Listener settings of a Server serving several transports at the same time
+package ziface
+
+import (
+	"crypto/tls"
+	"os"
+)
+
+// Networks a listener can serve (监听器支持的网络类型)
+const (
+	ListenerTCP       = "tcp"       // Plain TCP (TCP)
+	ListenerTLS       = "tls"       // TCP over TLS, ListenerConfig.TLSConfig is required (TLS加密的TCP, 需要设置TLSConfig)
+	ListenerWebsocket = "websocket" // WebSocket (WebSocket)
+	ListenerWSS       = "wss"       // WebSocket over TLS, ListenerConfig.TLSConfig is required (TLS加密的WebSocket, 需要设置TLSConfig)
+	ListenerKCP       = "kcp"       // KCP over UDP (基于UDP的KCP)
+	ListenerUnix      = "unix"      // Unix domain socket, Addr is the socket file path (Unix域套接字, Addr为套接字文件路径)
+)
+
+// ListenerConfig describes one listener of a Server, all the listeners share the routers and the ConnManager of the Server
+// (描述Server的一个监听器, 所有监听器共享Server的路由和连接管理器)
+type ListenerConfig struct {
+	// Name shown in the logs, "<Network>://<Addr>" by default (日志中显示的名称, 默认为"<Network>://<Addr>")
+	Name string
+
+	// One of the Listener* constants (Listener*常量之一)
+	Network string
+
+	// The address to listen on, "host:port" or the socket file path for ListenerUnix
+	// (监听地址, "host:port", ListenerUnix为套接字文件路径)
+	Addr string
+
+	// The WebSocket path, "/" by default (WebSocket路径, 默认为"/")
+	WsPath string
+
+	// Permission of the socket file of ListenerUnix, 0 leaves it to the umask
+	// (ListenerUnix套接字文件的权限, 为0时由umask决定)
+	FileMode os.FileMode
+
+	// TLS settings used by ListenerTLS and ListenerWSS (ListenerTLS和ListenerWSS使用的TLS配置)
+	TLSConfig *tls.Config
+
+	// Decoder of the connections accepted by this listener, nil uses the decoder of the Server
+	// (该监听器接入连接的解码器, 为nil时使用Server的解码器)
+	Decoder IDecoder
+
+	// Maximum number of connections accepted by this listener, 0 means only the MaxConn of the Server applies
+	// (该监听器允许的最大连接数, 0表示只受Server的MaxConn限制)
+	MaxConn int
+}
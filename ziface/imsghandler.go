@@ -3,6 +3,32 @@
 // @Author Aceld - Thu Mar 11 10:32:29 CST 2019
 package ziface
 
+// DispatchKeyFunc returns the business key (room ID, player ID, order ID...) of a request in zconf.WorkerModeKey,
+// requests with the same key are handled in order by one worker, an empty key falls back to the worker of the connection
+// (返回zconf.WorkerModeKey模式下请求的业务key(房间ID、玩家ID、订单ID等), 相同key的请求由同一个worker按顺序处理, 空key使用连接所属的worker)
+type DispatchKeyFunc func(request IRequest) string
+
+// WorkerStat is the state of one worker of the pool
+// (worker池中一个worker的状态)
+type WorkerStat struct {
+	WorkerID int    // ID of the worker, the index of its TaskQueue.(worker的ID, 即其任务队列的下标)
+	QueueLen int    // Requests waiting in its TaskQueue.(任务队列中等待的请求数)
+	QueueCap int    // Capacity of its TaskQueue.(任务队列的容量)
+	Running  bool   // Whether its goroutine is running.(worker协程是否在运行)
+	Handled  uint64 // Requests handled since start.(启动以来处理的请求数)
+}
+
+// WorkerStats is a snapshot of the worker pool
+// (worker池的快照)
+type WorkerStats struct {
+	Workers  []WorkerStat // Every worker that has a TaskQueue.(所有拥有任务队列的worker)
+	Active   int          // Workers new connections are assigned to.(新连接可分配到的worker数)
+	SpillLen int          // Requests waiting in the spill lanes of the workers.(各worker溢出队列中等待的请求数)
+	Rejected uint64       // Requests answered with an error because the queue was full.(因队列满被回复错误的请求数)
+	Shed     uint64       // Requests dropped because the queue was full.(因队列满被丢弃的请求数)
+	Spilled  uint64       // Requests put into a spill lane because the queue was full.(因队列满被放入溢出队列的请求数)
+}
+
 // IMsgHandle Abstract layer of message management(消息管理抽象层)
 type IMsgHandle interface {
 	// Add specific handling logic for messages, msgID supports int and string types
@@ -14,13 +40,16 @@ type IMsgHandle interface {
 
 	StartWorkerPool()                    //  Start the worker pool
 	SendMsgToTaskQueue(request IRequest) // Pass the message to the TaskQueue for processing by the worker(将消息交给TaskQueue,由worker进行处理)
+	GetWorkerStats() WorkerStats         // Get the queue depth and counters of every worker(获取每个worker的队列长度和计数)
 
 	Execute(request IRequest) // Execute interceptor methods on the responsibility chain(执行责任链上的拦截器方法)
 
 	// Register the entry point of the responsibility chain. After each interceptor is processed,
 	// the data is passed to the next interceptor, so that the message can be handled and passed layer by layer,
-	// the order depends on the registration order
-	// (注册责任链任务入口，每个拦截器处理完后，数据都会传递至下一个拦截器，使得消息可以层层处理层层传递，顺序取决于注册顺序)
+	// the order depends on the registration order. They run after the transport layer, so they see the messages of the
+	// routers decrypted, decompressed and under their own MsgID
+	// (注册责任链任务入口，每个拦截器处理完后，数据都会传递至下一个拦截器，使得消息可以层层处理层层传递，顺序取决于注册顺序;
+	// 拦截器位于传输层之后, 看到的是交给路由的、已解密解压并使用原本MsgID的消息)
 	AddInterceptor(interceptor IInterceptor)
 
 	// SetHeadInterceptor sets the head interceptor of the responsibility chain, which is the first interceptor to be executed
@@ -0,0 +1,59 @@
+// @Title ireliable.go
+// @Description Reliable in-order delivery of the messages of a logical session, which survives reconnections
+package ziface
+
+import "time"
+
+const (
+	// ReliableHelloMsgID is the message the client binds a new connection to its reliable session with
+	// (客户端将新连接绑定到其可靠会话的消息ID)
+	ReliableHelloMsgID uint32 = 99996
+
+	// ReliableAckMsgID acknowledges the messages of a reliable session received in order so far
+	// (确认可靠会话中目前为止按序收到的消息)
+	ReliableAckMsgID uint32 = 99995
+
+	// ReliableMsgID is the MsgID of every message sent through a reliable session, the MsgID sent is carried
+	// in the reliable header of the data, so the data of other messages is never taken for a session message
+	// (通过可靠会话发送的所有消息都使用该MsgID, 发送的MsgID放在数据的可靠传输报头中,
+	// 因此其他消息的数据不会被误认为会话消息)
+	ReliableMsgID uint32 = 99992
+
+	ReliableDefaultWindow        = 1024
+	ReliableDefaultResumeTimeout = 60 * time.Second
+)
+
+// ReliableOption is the reliable session setting of a Server or Client
+// (Server或Client的可靠会话设置)
+type ReliableOption struct {
+	// Messages sent and not acknowledged yet, at most, 1024 by default
+	// (最多允许的已发送未确认消息数, 默认1024)
+	Window int
+
+	// How long the server keeps a session whose connection closed, waiting for the client to resume it,
+	// 60s by default, the client keeps its session until it is stopped
+	// (服务端在会话的连接断开后保留会话等待客户端恢复的时长, 默认60秒; 客户端的会话一直保留到客户端停止)
+	ResumeTimeout time.Duration
+}
+
+// IReliableSession is a logical session keyed by a token instead of a connection. Messages sent through it
+// are numbered, kept until the peer acknowledges them and retransmitted when the client reconnects and
+// resumes the session, the peer routes them once and in order. With an AuthHandler, only a connection
+// authenticated as the identity that opened the session can resume it.
+// (以token而非连接标识的逻辑会话。通过会话发送的消息会被编号并保留到对端确认, 客户端重连并恢复会话时重新发送,
+// 对端按顺序且只路由一次; 设置了AuthHandler时, 只有认证为开启会话的同一身份的连接才能恢复会话)
+type IReliableSession interface {
+	// Token identifying the session across connections (跨连接标识会话的token)
+	Token() string
+
+	// Send a message through the session, while the session has no connection it is kept and sent on resume
+	// (通过会话发送消息, 会话没有连接时消息被保留, 恢复后发送)
+	Send(msgID uint32, data []byte) error
+
+	// The connection the session is bound to, nil while the client is away
+	// (会话当前绑定的连接, 客户端断开期间为nil)
+	GetConnection() IConnection
+
+	// Number of the messages sent and not acknowledged yet (已发送未确认的消息数)
+	Unacked() int
+}
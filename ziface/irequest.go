@@ -55,6 +55,14 @@ type IRequest interface {
 	Set(key string, value interface{})
 	//Get 从 Request 中获取一个上下文信息
 	Get(key string) (value interface{}, exists bool)
+
+	// Reply answers the request, the reply of an RPC call is routed back to the waiting IConnection.Call
+	// (应答请求, RPC调用的应答会投递给等待中的IConnection.Call)
+	Reply(data []byte) error
+
+	// ReplyError answers the request with an error, IConnection.Call on the peer returns it
+	// (以错误应答请求, 对端的IConnection.Call会返回该错误)
+	ReplyError(err error) error
 }
 
 type BaseRequest struct{}
@@ -72,6 +80,8 @@ func (br *BaseRequest) Goto(HandleStep)                  {}
 func (br *BaseRequest) BindRouterSlices([]RouterHandler) {}
 func (br *BaseRequest) RouterSlicesNext()                {}
 func (br *BaseRequest) Copy() IRequest                   { return nil }
+func (br *BaseRequest) Reply(data []byte) error          { return nil }
+func (br *BaseRequest) ReplyError(err error) error       { return nil }
 
 func (br *BaseRequest) Set(key string, value interface{}) {}
 
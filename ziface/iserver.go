@@ -4,6 +4,7 @@
 package ziface
 
 import (
+	"context"
 	"net/http"
 	"time"
 )
@@ -14,6 +15,11 @@ type IServer interface {
 	Stop()  // Stop the server method (停止服务器方法)
 	Serve() // Start the business service method(开启业务服务方法)
 
+	// Gracefully stop the server: stop accepting, finish queued requests, flush every connection,
+	// then close the sockets or give up when ctx is done
+	// (优雅关闭服务器：停止接收连接，处理完排队的请求，刷新每个连接的发送缓冲后关闭连接，ctx到期则放弃等待)
+	Shutdown(ctx context.Context) error
+
 	// Routing feature: register a routing business method for the current service for client link processing use
 	//(路由功能：给当前服务注册一个路由业务方法，供客户端连接处理使用)
 	AddRouter(msgID uint32, router IRouter)
@@ -45,6 +51,24 @@ type IServer interface {
 	// (得到该Server的连接断开时的Hook函数)
 	GetOnConnStop() func(IConnection)
 
+	// Set the function called when the send queue of a connection overflows, once until that queue drains again,
+	// it runs on the sending goroutine so it can kick the lagging client with IConnection.Stop
+	// (设置连接发送队列溢出时调用的函数, 队列排空前只调用一次; 在发送协程中执行, 可以用IConnection.Stop踢掉落后的客户端)
+	SetOnSlowConsumer(func(IConnection, SlowConsumerEvent))
+
+	// Get the function called when the send queue of a connection overflows
+	// (得到连接发送队列溢出时调用的函数)
+	GetOnSlowConsumer() func(IConnection, SlowConsumerEvent)
+
+	// Set the function called when an idle timeout of a connection fires, see zconf.ReaderIdleTime.
+	// Without it the idle connection is closed.
+	// (设置连接空闲超时时调用的函数, 见zconf.ReaderIdleTime; 未设置时关闭空闲的连接)
+	SetOnIdle(OnIdle)
+
+	// Get the function called when an idle timeout of a connection fires
+	// (得到连接空闲超时时调用的函数)
+	GetOnIdle() OnIdle
+
 	// Get the data protocol packet binding method for the Server
 	// (获取Server绑定的数据协议封包方式)
 	GetPacket() IDataPack
@@ -57,6 +81,14 @@ type IServer interface {
 	// (设置Server绑定的数据协议封包方式)
 	SetPacket(IDataPack)
 
+	// Get the codec used by typed routers and IConnection.SendTyped
+	// (获取类型化路由和IConnection.SendTyped使用的编解码器)
+	GetCodec() ICodec
+
+	// Set the codec used by typed routers and IConnection.SendTyped
+	// (设置类型化路由和IConnection.SendTyped使用的编解码器)
+	SetCodec(ICodec)
+
 	// Start the heartbeat check
 	// (启动心跳检测)
 	StartHeartBeat(time.Duration)
@@ -73,10 +105,46 @@ type IServer interface {
 	SetDecoder(IDecoder)
 	AddInterceptor(IInterceptor)
 
+	// Add a listener, once any listener is added Start serves the listeners instead of the ones chosen by zconf.GlobalObject.Mode,
+	// listeners can only be added before Start
+	// (添加监听器, 添加了监听器后Start只开启这些监听器, 不再根据zconf.GlobalObject.Mode选择, 只能在Start之前添加)
+	AddListener(ListenerConfig) error
+
+	// Set the handshake every new connection must pass before its messages are routed
+	// (设置新连接的握手认证, 认证通过前连接的消息不会被路由)
+	SetAuthenticator(handler AuthHandler, option *AuthOption)
+
+	// Set the compression algorithms accepted when clients negotiate, in order of preference,
+	// message data shorter than threshold is never compressed
+	// (设置客户端协商时按优先级接受的压缩算法, 长度小于threshold的消息数据不压缩)
+	SetCompression(threshold int, names ...string)
+
+	// Encrypt the messages of every new connection with the keys exchanged with the client, for transports without TLS
+	// (使用与客户端交换的密钥加密每个新连接的消息, 用于无法使用TLS的传输方式)
+	SetEncryption(option *EncryptOption)
+
+	// Let clients open reliable sessions, the messages sent through a session are delivered in order
+	// and retransmitted after the client reconnects
+	// (允许客户端开启可靠会话, 通过会话发送的消息按顺序投递, 客户端重连后重新发送)
+	SetReliable(option *ReliableOption)
+
+	// Get the reliable session the connection is bound to, nil before the client resumed one
+	// (获取连接绑定的可靠会话, 客户端恢复会话之前为nil)
+	GetReliableSession(conn IConnection) IReliableSession
+
+	// Set the function choosing the dispatch key of each request in zconf.WorkerModeKey,
+	// it runs on the reader goroutine of the connection before the request is queued
+	// (设置zconf.WorkerModeKey模式下为每个请求选择分发key的函数, 在请求入队前于连接的读协程中执行)
+	SetDispatchKey(fn DispatchKeyFunc)
+
 	// Add WebSocket authentication method
 	// (添加websocket认证方法)
 	SetWebsocketAuth(func(r *http.Request) error)
 
 	// Get the server name (获取服务器名称)
 	ServerName() string
+
+	// Whether the routers are added with AddRouterSlices instead of AddRouter
+	// (是否使用AddRouterSlices而不是AddRouter添加路由)
+	IsRouterSlicesMode() bool
 }
@@ -0,0 +1,75 @@
+// @Title isession.go
+// @Description User sessions kept across connections, with a pluggable store
+package ziface
+
+import "time"
+
+// SessionData is what a session store keeps of a session (会话存储中保存的会话数据)
+type SessionData struct {
+	ID     string            `json:"id"`
+	UserID string            `json:"user_id"`
+	Values map[string]string `json:"values,omitempty"`
+
+	CreatedAt time.Time `json:"created_at"`
+	// Zero while the user is online, otherwise when the session expires (用户在线时为零值, 否则为会话的过期时间)
+	ExpiresAt time.Time `json:"expires_at,omitempty"`
+}
+
+// ISessionStore keeps the sessions by user ID, each user has at most one session
+// (按用户ID保存会话, 每个用户最多一个会话)
+type ISessionStore interface {
+	// Load returns the session of the user, nil without an error when the user has none
+	// (返回用户的会话, 用户没有会话时返回nil且不返回错误)
+	Load(userID string) (*SessionData, error)
+
+	Save(data *SessionData) error
+
+	Delete(userID string) error
+
+	// List returns every session kept, used to restore the expiry timers after a restart
+	// (返回保存的全部会话, 用于重启后恢复过期定时器)
+	List() ([]*SessionData, error)
+}
+
+// ISession is the session of a user, it outlives the connections of the user
+// (用户的会话, 生命周期长于用户的连接)
+type ISession interface {
+	ID() string
+	UserID() string
+
+	// The current connection of the user, nil while the user is offline (用户当前的连接, 离线时为nil)
+	GetConnection() IConnection
+
+	// Values are written through to the store (写入的值会同步保存到存储中)
+	Get(key string) (string, bool)
+	Set(key, value string) error
+	Remove(key string) error
+
+	// Zero while the user is online, otherwise when the session expires (用户在线时为零值, 否则为会话的过期时间)
+	ExpiresAt() time.Time
+}
+
+// ISessionManager creates the session of a user when a connection is authenticated and binds the session
+// to the newest connection of the user, the session expires a TTL after the user went offline
+// (连接认证通过时创建用户的会话并将其绑定到用户最新的连接, 用户离线TTL时长后会话过期)
+type ISessionManager interface {
+	// OnAuthAccept binds the session of the user identified to conn, set it as AuthOption.OnAccept
+	// (将认证得到的用户的会话绑定到conn, 设置为AuthOption.OnAccept使用)
+	OnAuthAccept(conn IConnection, identity interface{})
+
+	// Get returns the session of the user, online or not (返回用户的会话, 无论是否在线)
+	Get(userID string) (ISession, error)
+
+	// GetByConn returns the session conn is bound to, nil when it is not bound to any
+	// (返回conn绑定的会话, 未绑定时为nil)
+	GetByConn(conn IConnection) ISession
+
+	// GetConnection returns the current connection of the user (返回用户当前的连接)
+	GetConnection(userID string) (IConnection, error)
+
+	// Remove deletes the session of the user, the connection is left open (删除用户的会话, 不关闭连接)
+	Remove(userID string) error
+
+	// Number of the sessions online (在线的会话数)
+	OnlineCount() int
+}
@@ -2,14 +2,45 @@ package ziface
 
 import "time"
 
+// MsgPriority is the priority of a message queued by SendBuffMsg, higher priorities are written first
+// (SendBuffMsg入队消息的优先级, 优先级高的消息先写出)
+type MsgPriority uint8
+
+const (
+	MsgPriorityLow    MsgPriority = iota // Broadcasts and other traffic that may wait.(广播等可以延后的消息)
+	MsgPriorityNormal                    // The default priority.(默认优先级)
+	MsgPriorityHigh                      // Kick, state-sync and other critical messages.(踢人、状态同步等关键消息)
+
+	MsgPriorityLevels = 3 // Number of priorities.(优先级数量)
+)
+
+// SlowConsumerEvent reports a connection whose send queue is full, see IServer.SetOnSlowConsumer
+// (发送队列已满的连接事件, 见IServer.SetOnSlowConsumer)
+type SlowConsumerEvent struct {
+	Priority MsgPriority // The priority whose queue is full.(已满队列的优先级)
+	Queued   int         // Messages waiting in that queue.(该队列中等待发送的消息数)
+	Policy   string      // The overflow policy applied, one of the zconf.SendQueue values.(采取的溢出策略)
+}
+
 type MsgSendOptionObj struct {
-	Timeout time.Duration
+	Timeout  time.Duration
+	Priority MsgPriority
 }
 
 type MsgSendOption func(opt *MsgSendOptionObj)
 
+// WithSendMsgTimeout sets how long SendBuffMsg waits for room in a full queue before the overflow policy applies
+// (设置队列满时SendBuffMsg等待空位的时长, 超时后执行溢出策略)
 func WithSendMsgTimeout(timeout time.Duration) MsgSendOption {
 	return func(opt *MsgSendOptionObj) {
 		opt.Timeout = timeout
 	}
 }
+
+// WithSendMsgPriority sets the queue a buffered message goes into, MsgPriorityNormal by default
+// (设置缓冲消息进入的优先级队列, 默认为MsgPriorityNormal)
+func WithSendMsgPriority(priority MsgPriority) MsgSendOption {
+	return func(opt *MsgSendOptionObj) {
+		opt.Priority = priority
+	}
+}
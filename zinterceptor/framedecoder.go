@@ -10,6 +10,7 @@ import (
 	"bytes"
 	"encoding/binary"
 	"fmt"
+	"github.com/aceld/zinx/zbuffer"
 	"github.com/aceld/zinx/ziface"
 	"math"
 )
@@ -568,20 +569,24 @@ func (d *FrameDecoder) decode(buf []byte) []byte {
 	// Get the real data length after skipping (获取跳过后的真实数据长度)
 	actualFrameLength := frameLengthInt - d.InitialBytesToStrip
 
-	// Extract the real data (提取真实的数据)
-	buff := make([]byte, actualFrameLength)
+	// Extract the real data into a pooled buffer, the owner of the frame returns it with zbuffer.Put
+	// (将真实的数据提取到池化的缓冲中, 由帧的持有者通过zbuffer.Put归还)
+	buff := zbuffer.Get(actualFrameLength)
 	_, _ = in.Read(buff)
 
 	return buff
 }
 
+// Decode returns the complete frames found so far, every frame is taken from zbuffer
+// (返回目前为止解析出的完整帧, 每一帧都取自zbuffer)
 func (d *FrameDecoder) Decode(buff []byte) [][]byte {
 
 	d.in = append(d.in, buff...)
 	resp := make([][]byte, 0)
+	offset := 0
 
 	for {
-		arr := d.decode(d.in)
+		arr := d.decode(d.in[offset:])
 
 		if arr != nil {
 			// Indicates that a complete packet has been parsed
@@ -589,9 +594,12 @@ func (d *FrameDecoder) Decode(buff []byte) [][]byte {
 			resp = append(resp, arr)
 			_size := len(arr) + d.InitialBytesToStrip
 			if _size > 0 {
-				d.in = d.in[_size:]
+				offset += _size
 			}
 		} else {
+			// Move the half package to the front so that the memory of d.in is reused
+			// (将剩余的半包移到最前面, 复用d.in的内存)
+			d.in = append(d.in[:0], d.in[offset:]...)
 			return resp
 		}
 	}
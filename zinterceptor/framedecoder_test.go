@@ -0,0 +1,45 @@
+package zinterceptor
+
+import (
+	"bytes"
+	"math"
+	"testing"
+
+	"github.com/aceld/zinx/ziface"
+	"github.com/aceld/zinx/zpack"
+)
+
+func TestFrameDecoderSplitFrames(t *testing.T) {
+	decoder := NewFrameDecoder(ziface.LengthField{
+		MaxFrameLength:    math.MaxUint32 + 4 + 4,
+		LengthFieldOffset: 4,
+		LengthFieldLength: 4,
+	})
+
+	dp := zpack.NewDataPack()
+	var stream []byte
+	for i, data := range []string{"hello", "zinx", "", "frame decoder"} {
+		msg, err := dp.Pack(zpack.NewMsgPackage(uint32(i), []byte(data)))
+		if err != nil {
+			t.Fatalf("pack err: %v", err)
+		}
+		stream = append(stream, msg...)
+	}
+
+	// Feed the stream 3 bytes at a time, so that frames and headers are split (每次3字节, 使包头和数据被拆开)
+	var frames [][]byte
+	for i := 0; i < len(stream); i += 3 {
+		end := i + 3
+		if end > len(stream) {
+			end = len(stream)
+		}
+		frames = append(frames, decoder.Decode(stream[i:end])...)
+	}
+
+	if len(frames) != 4 {
+		t.Fatalf("got %d frames, want 4", len(frames))
+	}
+	if got := bytes.Join(frames, nil); !bytes.Equal(got, stream) {
+		t.Fatalf("frames = %v, want %v", got, stream)
+	}
+}
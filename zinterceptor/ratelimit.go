@@ -0,0 +1,307 @@
+/**
+ * @description 令牌桶限流拦截器
+ **/
+
+package zinterceptor
+
+import (
+	"fmt"
+	"math"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/aceld/zinx/zconf"
+	"github.com/aceld/zinx/ziface"
+	"github.com/aceld/zinx/zlog"
+)
+
+// Which limit a request went over (请求超出了哪一项限制)
+const (
+	RateLimitScopeConn = "conn" // per connection (单个连接)
+	RateLimitScopeIP   = "ip"   // per remote IP (单个远程IP)
+	RateLimitScopeMsg  = "msg"  // per MsgID (单个MsgID)
+)
+
+// Idle buckets are released after this long (空闲超过该时长的令牌桶会被回收)
+const rateLimitIdleTimeout = time.Minute
+
+// RateLimitHandler decides what happens to a request over the limit.
+// scope is one of the RateLimitScope* constants, wait is how long until a token is available
+// (only set when the action is zconf.RateLimitActionDelay).
+// (请求超限时的处理函数, scope为RateLimitScope*常量, wait为等待令牌的时长, 仅在delay模式下有值)
+type RateLimitHandler func(chain ziface.IChain, request ziface.IRequest, scope string, wait time.Duration) ziface.IcResp
+
+// RateLimiter is an interceptor that limits the message rate per connection, per remote IP and per MsgID
+// with token buckets, add it with AddInterceptor so that it runs after the transport layer and before the MsgHandle.
+// (令牌桶限流拦截器, 分别限制每个连接、每个远程IP、每个MsgID的消息速率,
+// 通过AddInterceptor添加, 位于传输层之后、MsgHandle之前)
+type RateLimiter struct {
+	enabled int32 // 1 when any RateLimit*Rate is set (设置了任一RateLimit*Rate时为1)
+
+	connRate, ipRate, msgRate    float64
+	connBurst, ipBurst, msgBurst int
+	action                       string
+	replyMsgID                   uint32
+	onLimit                      RateLimitHandler
+
+	lock      sync.Mutex
+	conns     map[ziface.IConnection]*tokenBucket
+	ips       map[string]*tokenBucket
+	msgs      map[uint32]*tokenBucket
+	lastPrune time.Time
+}
+
+// NewRateLimiter creates a RateLimiter from the RateLimit* fields of conf.
+// Every server adds one by itself, which lets all requests through while no RateLimit*Rate is set.
+// (根据conf中的RateLimit*参数创建限流拦截器, 每个Server都会自动添加, 未设置任何RateLimit*Rate时放行所有请求)
+func NewRateLimiter(conf *zconf.Config) *RateLimiter {
+	r := &RateLimiter{
+		connRate:   conf.RateLimitConnRate,
+		connBurst:  conf.RateLimitConnBurst,
+		ipRate:     conf.RateLimitIPRate,
+		ipBurst:    conf.RateLimitIPBurst,
+		msgRate:    conf.RateLimitMsgRate,
+		msgBurst:   conf.RateLimitMsgBurst,
+		action:     conf.RateLimitAction,
+		replyMsgID: conf.RateLimitReplyMsgID,
+		conns:      make(map[ziface.IConnection]*tokenBucket),
+		ips:        make(map[string]*tokenBucket),
+		msgs:       make(map[uint32]*tokenBucket),
+		lastPrune:  time.Now(),
+	}
+	r.setEnabled(conf)
+	return r
+}
+
+// setEnabled turns the limiter on when any rate of conf is set (conf中设置了任一速率时开启限流)
+func (r *RateLimiter) setEnabled(conf *zconf.Config) {
+	var enabled int32
+	if conf.RateLimitConnRate > 0 || conf.RateLimitIPRate > 0 || conf.RateLimitMsgRate > 0 {
+		enabled = 1
+	}
+	atomic.StoreInt32(&r.enabled, enabled)
+}
+
+// Update applies the RateLimit* fields of conf, the existing buckets are dropped and refilled with the new limits.
+// A server whose limiter comes from zconf.GlobalObject calls it after every hot reload of the rate limits.
+// (应用conf中的RateLimit*参数, 丢弃已有的令牌桶并按新参数重新计算, 限流参数来自zconf.GlobalObject的Server在热加载后自动调用)
+func (r *RateLimiter) Update(conf *zconf.Config) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	r.connRate, r.connBurst = conf.RateLimitConnRate, conf.RateLimitConnBurst
+	r.ipRate, r.ipBurst = conf.RateLimitIPRate, conf.RateLimitIPBurst
+	r.msgRate, r.msgBurst = conf.RateLimitMsgRate, conf.RateLimitMsgBurst
+	r.action, r.replyMsgID = conf.RateLimitAction, conf.RateLimitReplyMsgID
+
+	r.conns = make(map[ziface.IConnection]*tokenBucket)
+	r.ips = make(map[string]*tokenBucket)
+	r.msgs = make(map[uint32]*tokenBucket)
+	r.setEnabled(conf)
+}
+
+// SetOnLimit replaces the built-in action configured by RateLimitAction
+// (自定义超限处理方式, 替换RateLimitAction配置的内置处理方式)
+func (r *RateLimiter) SetOnLimit(handler RateLimitHandler) {
+	r.onLimit = handler
+}
+
+func (r *RateLimiter) Intercept(chain ziface.IChain) ziface.IcResp {
+	if atomic.LoadInt32(&r.enabled) == 0 {
+		return chain.Proceed(chain.Request())
+	}
+
+	iRequest, ok := chain.Request().(ziface.IRequest)
+	if !ok {
+		return chain.Proceed(chain.Request())
+	}
+	conn := iRequest.GetConnection()
+	if conn == nil || reservedMsgID(iRequest.GetMsgID()) {
+		return chain.Proceed(chain.Request())
+	}
+
+	scope, wait := r.take(conn, iRequest.GetMsgID())
+	if scope == "" {
+		return chain.Proceed(chain.Request())
+	}
+
+	if r.onLimit != nil {
+		return r.onLimit(chain, iRequest, scope, wait)
+	}
+	return r.doAction(chain, iRequest, scope, wait)
+}
+
+// reservedMsgID tells whether msgID is reserved by zinx, from ziface.ReliableMsgID to ziface.HeartBeatDefaultMsgID.
+// They carry transport traffic such as heartbeats and are never limited
+// (判断msgID是否为zinx保留的MsgID, 即ziface.ReliableMsgID至ziface.HeartBeatDefaultMsgID, 这些消息承载心跳等传输层流量, 不做限流)
+func reservedMsgID(msgID uint32) bool {
+	return msgID >= ziface.ReliableMsgID && msgID <= ziface.HeartBeatDefaultMsgID
+}
+
+// doAction runs the built-in action configured by RateLimitAction
+// (执行RateLimitAction配置的内置处理方式)
+func (r *RateLimiter) doAction(chain ziface.IChain, request ziface.IRequest, scope string, wait time.Duration) ziface.IcResp {
+	conn := request.GetConnection()
+
+	r.lock.Lock()
+	action, replyMsgID := r.action, r.replyMsgID
+	r.lock.Unlock()
+
+	switch action {
+	case zconf.RateLimitActionDelay:
+		// Blocks the reader of this connection, which also slows the client down
+		// (阻塞该连接的读协程, 同时也起到了让客户端减速的作用)
+		time.Sleep(wait)
+		return chain.Proceed(chain.Request())
+	case zconf.RateLimitActionReply:
+		if err := conn.SendMsg(replyMsgID, []byte(fmt.Sprintf("rate limit exceeded (%s)", scope))); err != nil {
+			zlog.Ins().ErrorF("rate limit reply to ConnID = %d err: %v", conn.GetConnID(), err)
+		}
+	case zconf.RateLimitActionClose:
+		zlog.Ins().InfoF("ConnID = %d %s over the %s rate limit, close it", conn.GetConnID(), conn.RemoteAddrString(), scope)
+		conn.Stop()
+		return nil
+	}
+
+	zlog.Ins().DebugF("ConnID = %d over the %s rate limit, drop msgID = %d", conn.GetConnID(), scope, request.GetMsgID())
+	return nil
+}
+
+// take consumes one token from every bucket the request belongs to and returns the scope that ran out,
+// an empty scope means the request is allowed.
+// With the delay action, tokens are borrowed from the future and wait tells how long the request must be held.
+// (从请求对应的每个令牌桶中取一个令牌, 返回令牌不足的scope, 为空表示放行。
+// delay模式下预支令牌, wait为请求需要等待的时长)
+func (r *RateLimiter) take(conn ziface.IConnection, msgID uint32) (scope string, wait time.Duration) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	reserve := r.action == zconf.RateLimitActionDelay
+
+	now := time.Now()
+	r.prune(now)
+
+	if r.connRate > 0 {
+		b, ok := r.conns[conn]
+		if !ok {
+			b = newTokenBucket(r.connRate, r.connBurst, now)
+			r.conns[conn] = b
+			conn.AddCloseCallback(r, RateLimitScopeConn, func() {
+				r.lock.Lock()
+				delete(r.conns, conn)
+				r.lock.Unlock()
+			})
+		}
+		if w, limited := b.take(now, reserve); limited && w >= wait {
+			scope, wait = RateLimitScopeConn, w
+		}
+	}
+
+	if r.ipRate > 0 {
+		ip := remoteIP(conn)
+		b, ok := r.ips[ip]
+		if !ok {
+			b = newTokenBucket(r.ipRate, r.ipBurst, now)
+			r.ips[ip] = b
+		}
+		if w, limited := b.take(now, reserve); limited && w >= wait {
+			scope, wait = RateLimitScopeIP, w
+		}
+	}
+
+	if r.msgRate > 0 {
+		b, ok := r.msgs[msgID]
+		if !ok {
+			b = newTokenBucket(r.msgRate, r.msgBurst, now)
+			r.msgs[msgID] = b
+		}
+		if w, limited := b.take(now, reserve); limited && w >= wait {
+			scope, wait = RateLimitScopeMsg, w
+		}
+	}
+
+	return scope, wait
+}
+
+// prune releases the buckets that have been idle long enough to be full again
+// (回收空闲已久、令牌已满的令牌桶)
+func (r *RateLimiter) prune(now time.Time) {
+	if now.Sub(r.lastPrune) < rateLimitIdleTimeout {
+		return
+	}
+	r.lastPrune = now
+
+	for conn, b := range r.conns {
+		if b.idle(now) {
+			delete(r.conns, conn)
+		}
+	}
+	for ip, b := range r.ips {
+		if b.idle(now) {
+			delete(r.ips, ip)
+		}
+	}
+	for msgID, b := range r.msgs {
+		if b.idle(now) {
+			delete(r.msgs, msgID)
+		}
+	}
+}
+
+func remoteIP(conn ziface.IConnection) string {
+	addr := conn.RemoteAddrString()
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		return host
+	}
+	return addr
+}
+
+// tokenBucket refills rate tokens per second up to burst (令牌桶, 每秒补充rate个令牌, 最多burst个)
+type tokenBucket struct {
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(rate float64, burst int, now time.Time) *tokenBucket {
+	b := &tokenBucket{rate: rate, burst: float64(burst), last: now}
+	if b.burst <= 0 {
+		b.burst = math.Max(1, math.Ceil(rate))
+	}
+	b.tokens = b.burst
+	return b
+}
+
+func (b *tokenBucket) refill(now time.Time) {
+	b.tokens = math.Min(b.burst, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+	b.last = now
+}
+
+// take consumes one token. Without reserve nothing is consumed when the bucket is empty;
+// with reserve the token is borrowed and wait is how long until it is paid back.
+// (取一个令牌。非预支模式下令牌不足时不消耗; 预支模式下欠下令牌, wait为还清所需时长)
+func (b *tokenBucket) take(now time.Time, reserve bool) (wait time.Duration, limited bool) {
+	b.refill(now)
+
+	if b.tokens >= 1 {
+		b.tokens--
+		return 0, false
+	}
+	if !reserve {
+		return 0, true
+	}
+
+	b.tokens--
+	return time.Duration(-b.tokens / b.rate * float64(time.Second)), true
+}
+
+func (b *tokenBucket) idle(now time.Time) bool {
+	if now.Sub(b.last) < rateLimitIdleTimeout {
+		return false
+	}
+	b.refill(now)
+	return b.tokens >= b.burst
+}
@@ -0,0 +1,158 @@
+package zinterceptor
+
+import (
+	"testing"
+	"time"
+
+	"github.com/aceld/zinx/zconf"
+	"github.com/aceld/zinx/ziface"
+)
+
+// run in terminal:
+// go test -v ./zinterceptor -run=TestRateLimiter
+
+type limitTestConn struct {
+	ziface.IConnection
+	id      uint64
+	addr    string
+	stopped bool
+}
+
+func (c *limitTestConn) GetConnID() uint64                                   { return c.id }
+func (c *limitTestConn) RemoteAddrString() string                            { return c.addr }
+func (c *limitTestConn) AddCloseCallback(handler, key interface{}, f func()) {}
+func (c *limitTestConn) Stop()                                               { c.stopped = true }
+
+type limitTestRequest struct {
+	ziface.BaseRequest
+	conn  ziface.IConnection
+	msgID uint32
+}
+
+func (r *limitTestRequest) GetConnection() ziface.IConnection { return r.conn }
+func (r *limitTestRequest) GetMsgID() uint32                  { return r.msgID }
+
+// countInterceptor stands for the MsgHandle at the tail of the chain (代替责任链末尾的MsgHandle)
+type countInterceptor struct {
+	count int
+}
+
+func (c *countInterceptor) Intercept(chain ziface.IChain) ziface.IcResp {
+	c.count++
+	return chain.Proceed(chain.Request())
+}
+
+func sendThrough(limiter *RateLimiter, tail *countInterceptor, conn ziface.IConnection, msgID uint32, n int) {
+	for i := 0; i < n; i++ {
+		req := &limitTestRequest{conn: conn, msgID: msgID}
+		NewChain([]ziface.IInterceptor{limiter, tail}, 0, req).Proceed(req)
+	}
+}
+
+func TestRateLimiterConn(t *testing.T) {
+	conf := &zconf.Config{RateLimitConnRate: 1, RateLimitConnBurst: 3, RateLimitAction: zconf.RateLimitActionDrop}
+	limiter, tail := NewRateLimiter(conf), &countInterceptor{}
+
+	c1 := &limitTestConn{id: 1, addr: "10.0.0.1:1000"}
+	c2 := &limitTestConn{id: 2, addr: "10.0.0.1:1001"}
+	sendThrough(limiter, tail, c1, 1, 10)
+	sendThrough(limiter, tail, c2, 1, 10)
+
+	// Every connection has its own bucket (每个连接有独立的令牌桶)
+	if tail.count != 6 {
+		t.Fatalf("passed %d requests, want 6", tail.count)
+	}
+}
+
+func TestRateLimiterIPAndClose(t *testing.T) {
+	conf := &zconf.Config{RateLimitIPRate: 1, RateLimitIPBurst: 2, RateLimitAction: zconf.RateLimitActionClose}
+	limiter, tail := NewRateLimiter(conf), &countInterceptor{}
+
+	c1 := &limitTestConn{id: 1, addr: "10.0.0.1:1000"}
+	c2 := &limitTestConn{id: 2, addr: "10.0.0.1:1001"}
+	sendThrough(limiter, tail, c1, 1, 2)
+	sendThrough(limiter, tail, c2, 1, 1)
+
+	// Both connections share the bucket of their IP (同一IP的连接共享令牌桶)
+	if tail.count != 2 || c1.stopped || !c2.stopped {
+		t.Fatalf("passed = %d c1.stopped = %v c2.stopped = %v, want 2 false true", tail.count, c1.stopped, c2.stopped)
+	}
+}
+
+func TestRateLimiterMsgDelay(t *testing.T) {
+	conf := &zconf.Config{RateLimitMsgRate: 20, RateLimitMsgBurst: 1, RateLimitAction: zconf.RateLimitActionDelay}
+	limiter, tail := NewRateLimiter(conf), &countInterceptor{}
+	conn := &limitTestConn{id: 1, addr: "10.0.0.1:1000"}
+
+	start := time.Now()
+	sendThrough(limiter, tail, conn, 7, 3)
+	cost := time.Since(start)
+
+	// The 2nd and 3rd messages wait 50ms each (第2、3条消息各等待50ms)
+	if tail.count != 3 || cost < 90*time.Millisecond {
+		t.Fatalf("passed = %d cost = %v, want 3 and >= 100ms", tail.count, cost)
+	}
+}
+
+func TestRateLimiterCustomAction(t *testing.T) {
+	conf := &zconf.Config{RateLimitConnRate: 1, RateLimitConnBurst: 1}
+	limiter, tail := NewRateLimiter(conf), &countInterceptor{}
+
+	var scopes []string
+	limiter.SetOnLimit(func(chain ziface.IChain, request ziface.IRequest, scope string, wait time.Duration) ziface.IcResp {
+		scopes = append(scopes, scope)
+		return nil
+	})
+	sendThrough(limiter, tail, &limitTestConn{id: 1, addr: "10.0.0.1:1000"}, 1, 3)
+
+	if tail.count != 1 || len(scopes) != 2 || scopes[0] != RateLimitScopeConn {
+		t.Fatalf("passed = %d scopes = %v, want 1 [conn conn]", tail.count, scopes)
+	}
+}
+
+func TestRateLimiterUpdate(t *testing.T) {
+	conf := &zconf.Config{RateLimitMsgRate: 1, RateLimitMsgBurst: 1, RateLimitAction: zconf.RateLimitActionDrop}
+	limiter, tail := NewRateLimiter(conf), &countInterceptor{}
+
+	c1 := &limitTestConn{id: 1, addr: "10.0.0.1:1000"}
+	sendThrough(limiter, tail, c1, 1, 5)
+
+	// New limits start from full buckets (新的限流参数从满令牌桶开始计算)
+	conf.RateLimitMsgBurst = 4
+	limiter.Update(conf)
+	sendThrough(limiter, tail, c1, 1, 5)
+
+	if tail.count != 5 {
+		t.Fatalf("passed %d requests, want 5", tail.count)
+	}
+}
+
+func TestRateLimiterEnable(t *testing.T) {
+	conf := &zconf.Config{RateLimitAction: zconf.RateLimitActionDrop}
+	limiter, tail := NewRateLimiter(conf), &countInterceptor{}
+
+	// Without any rate everything passes (未设置速率时全部放行)
+	c1 := &limitTestConn{id: 1, addr: "10.0.0.1:1000"}
+	sendThrough(limiter, tail, c1, 1, 5)
+
+	// A reload from 0 turns the limit on (热加载从0开启限流)
+	conf.RateLimitConnRate, conf.RateLimitConnBurst = 1, 2
+	limiter.Update(conf)
+	sendThrough(limiter, tail, c1, 1, 5)
+
+	if tail.count != 7 {
+		t.Fatalf("passed %d requests, want 7", tail.count)
+	}
+}
+
+func TestRateLimiterReserved(t *testing.T) {
+	conf := &zconf.Config{RateLimitConnRate: 1, RateLimitConnBurst: 1, RateLimitAction: zconf.RateLimitActionClose}
+	limiter, tail := NewRateLimiter(conf), &countInterceptor{}
+
+	// Heartbeats are transport traffic and never close the connection (心跳属于传输层流量, 不会导致连接被关闭)
+	c1 := &limitTestConn{id: 1, addr: "10.0.0.1:1000"}
+	sendThrough(limiter, tail, c1, ziface.HeartBeatDefaultMsgID, 5)
+	if c1.stopped || tail.count != 5 {
+		t.Fatalf("heartbeats limited, passed %d stopped %v", tail.count, c1.stopped)
+	}
+}
@@ -22,6 +22,7 @@ import (
 	"path/filepath"
 	"runtime"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/aceld/zinx/zutils"
@@ -79,9 +80,9 @@ type ZinxLoggerCore struct {
 	// the output buffer (输出的缓冲区)
 	buf bytes.Buffer
 
-	// log isolation level
-	// (日志隔离级别)
-	isolationLevel int
+	// log isolation level, changed at runtime by hot reloads
+	// (日志隔离级别, 热加载时会在运行中修改)
+	isolationLevel atomic.Int64
 
 	// call stack depth of the function that gets the log file name and code using runtime.Call
 	// (获取日志文件名和代码上述的runtime.Call 的函数调用层数)
@@ -103,7 +104,7 @@ func NewZinxLog(prefix string, flag int) *ZinxLoggerCore {
 
 	// By default, debug is turned on, the depth is 2, and the ZinxLogger object calling the log print method can call up to two levels to reach the output function
 	// (默认 debug打开， calledDepth深度为2,ZinxLogger对象调用日志打印方法最多调用两层到达output函数)
-	zlog := &ZinxLoggerCore{prefix: prefix, flag: flag, isolationLevel: 0, calldDepth: 2}
+	zlog := &ZinxLoggerCore{prefix: prefix, flag: flag, calldDepth: 2}
 
 	// Set the log object's resource cleanup destructor method (this is not necessary, as go's Gc will automatically collect, but for the sake of neatness)
 	// (设置log对象 回收资源 析构方法(不设置也可以，go的Gc会自动回收，强迫症没办法))
@@ -240,7 +241,7 @@ func (log *ZinxLoggerCore) OutPut(level int, s string) error {
 }
 
 func (log *ZinxLoggerCore) verifyLogIsolation(logLevel int) bool {
-	return log.isolationLevel > logLevel
+	return int(log.isolationLevel.Load()) > logLevel
 }
 
 func (log *ZinxLoggerCore) Debugf(format string, v ...interface{}) {
@@ -423,7 +424,7 @@ func (log *ZinxLoggerCore) closeFile() {
 }
 
 func (log *ZinxLoggerCore) SetLogLevel(logLevel int) {
-	log.isolationLevel = logLevel
+	log.isolationLevel.Store(int64(logLevel))
 }
 
 // Convert an integer to a fixed-length string, where the width of the string should be greater than 0
@@ -0,0 +1,357 @@
+// @Title collector.go
+// @Description Minimal Prometheus collectors (counter, gauge, histogram) rendered in the text exposition format
+package zmetrics
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// labelSep separates label values in the series key, it can never appear in a valid UTF-8 label value
+// (序列key中各label值的分隔符)
+const labelSep = "\xff"
+
+// DefBuckets are the default histogram buckets in seconds, the same as the Prometheus client library
+// (默认的直方图分桶，单位秒，与Prometheus官方客户端一致)
+var DefBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// collector is implemented by every metric family that can be exported
+// (所有可导出指标族需要实现的接口)
+type collector interface {
+	write(w io.Writer)
+}
+
+// metricDesc holds the common description of a metric family
+// (指标族的公共描述信息)
+type metricDesc struct {
+	name       string
+	help       string
+	kind       string
+	labelNames []string
+}
+
+func (d *metricDesc) writeHeader(w io.Writer) {
+	fmt.Fprintf(w, "# HELP %s %s\n", d.name, d.help)
+	fmt.Fprintf(w, "# TYPE %s %s\n", d.name, d.kind)
+}
+
+func (d *metricDesc) key(labelValues []string) string {
+	if len(labelValues) != len(d.labelNames) {
+		panic(fmt.Sprintf("zmetrics: %s expects %d label values, got %d", d.name, len(d.labelNames), len(labelValues)))
+	}
+	return strings.Join(labelValues, labelSep)
+}
+
+// labels renders the label set of one series, extra is appended as-is (used by the histogram "le" label)
+// (渲染一个序列的label集合)
+func (d *metricDesc) labels(key string, extra string) string {
+	if len(d.labelNames) == 0 && extra == "" {
+		return ""
+	}
+
+	var sb strings.Builder
+	sb.WriteByte('{')
+	if len(d.labelNames) > 0 {
+		values := strings.Split(key, labelSep)
+		for i, name := range d.labelNames {
+			if i > 0 {
+				sb.WriteByte(',')
+			}
+			sb.WriteString(name)
+			sb.WriteString(`="`)
+			sb.WriteString(escapeLabelValue(values[i]))
+			sb.WriteByte('"')
+		}
+	}
+	if extra != "" {
+		if len(d.labelNames) > 0 {
+			sb.WriteByte(',')
+		}
+		sb.WriteString(extra)
+	}
+	sb.WriteByte('}')
+	return sb.String()
+}
+
+func escapeLabelValue(v string) string {
+	v = strings.ReplaceAll(v, `\`, `\\`)
+	v = strings.ReplaceAll(v, "\n", `\n`)
+	return strings.ReplaceAll(v, `"`, `\"`)
+}
+
+func formatFloat(f float64) string {
+	switch {
+	case math.IsInf(f, 1):
+		return "+Inf"
+	case math.IsInf(f, -1):
+		return "-Inf"
+	case math.IsNaN(f):
+		return "NaN"
+	}
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
+
+// sortedKeys returns the keys of a series map in a stable order
+func sortedKeys[T any](m map[string]T) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// CounterVec is a monotonically increasing counter partitioned by labels
+// (按label划分的单调递增计数器)
+type CounterVec struct {
+	metricDesc
+	mu     sync.RWMutex
+	series map[string]*uint64
+}
+
+// NewCounterVec creates a counter family
+func NewCounterVec(name, help string, labelNames ...string) *CounterVec {
+	return &CounterVec{
+		metricDesc: metricDesc{name: name, help: help, kind: "counter", labelNames: labelNames},
+		series:     make(map[string]*uint64),
+	}
+}
+
+func (c *CounterVec) get(labelValues []string) *uint64 {
+	key := c.key(labelValues)
+
+	c.mu.RLock()
+	v, ok := c.series[key]
+	c.mu.RUnlock()
+	if ok {
+		return v
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if v, ok = c.series[key]; !ok {
+		v = new(uint64)
+		c.series[key] = v
+	}
+	return v
+}
+
+// Add increases the counter of the given label values by delta
+// (给指定label的计数器增加delta)
+func (c *CounterVec) Add(delta uint64, labelValues ...string) {
+	atomic.AddUint64(c.get(labelValues), delta)
+}
+
+// Inc increases the counter of the given label values by one
+func (c *CounterVec) Inc(labelValues ...string) {
+	c.Add(1, labelValues...)
+}
+
+// Value returns the current value of the given label values
+func (c *CounterVec) Value(labelValues ...string) uint64 {
+	return atomic.LoadUint64(c.get(labelValues))
+}
+
+func (c *CounterVec) write(w io.Writer) {
+	c.writeHeader(w)
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	for _, key := range sortedKeys(c.series) {
+		fmt.Fprintf(w, "%s%s %d\n", c.name, c.labels(key, ""), atomic.LoadUint64(c.series[key]))
+	}
+}
+
+// GaugeVec is a value that can go up and down, partitioned by labels
+// (按label划分的可增可减的仪表盘)
+type GaugeVec struct {
+	metricDesc
+	mu     sync.RWMutex
+	series map[string]*int64
+}
+
+// NewGaugeVec creates a gauge family
+func NewGaugeVec(name, help string, labelNames ...string) *GaugeVec {
+	return &GaugeVec{
+		metricDesc: metricDesc{name: name, help: help, kind: "gauge", labelNames: labelNames},
+		series:     make(map[string]*int64),
+	}
+}
+
+func (g *GaugeVec) get(labelValues []string) *int64 {
+	key := g.key(labelValues)
+
+	g.mu.RLock()
+	v, ok := g.series[key]
+	g.mu.RUnlock()
+	if ok {
+		return v
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if v, ok = g.series[key]; !ok {
+		v = new(int64)
+		g.series[key] = v
+	}
+	return v
+}
+
+// Add adds delta (which may be negative) to the gauge of the given label values
+func (g *GaugeVec) Add(delta int64, labelValues ...string) {
+	atomic.AddInt64(g.get(labelValues), delta)
+}
+
+// Set sets the gauge of the given label values
+func (g *GaugeVec) Set(value int64, labelValues ...string) {
+	atomic.StoreInt64(g.get(labelValues), value)
+}
+
+// Value returns the current value of the given label values
+func (g *GaugeVec) Value(labelValues ...string) int64 {
+	return atomic.LoadInt64(g.get(labelValues))
+}
+
+func (g *GaugeVec) write(w io.Writer) {
+	g.writeHeader(w)
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+	for _, key := range sortedKeys(g.series) {
+		fmt.Fprintf(w, "%s%s %d\n", g.name, g.labels(key, ""), atomic.LoadInt64(g.series[key]))
+	}
+}
+
+// GaugeFunc is a gauge family whose values are sampled by a callback at scrape time
+// (采集时由回调函数取值的仪表盘)
+type GaugeFunc struct {
+	metricDesc
+	mu    sync.RWMutex
+	funcs map[string]func() int64
+}
+
+// NewGaugeFunc creates a gauge family sampled at scrape time
+func NewGaugeFunc(name, help string, labelNames ...string) *GaugeFunc {
+	return &GaugeFunc{
+		metricDesc: metricDesc{name: name, help: help, kind: "gauge", labelNames: labelNames},
+		funcs:      make(map[string]func() int64),
+	}
+}
+
+// Register binds a sampling function to the given label values, a nil f removes the series
+// (为指定label绑定取值函数，f为nil时删除该序列)
+func (g *GaugeFunc) Register(f func() int64, labelValues ...string) {
+	key := g.key(labelValues)
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if f == nil {
+		delete(g.funcs, key)
+		return
+	}
+	g.funcs[key] = f
+}
+
+func (g *GaugeFunc) write(w io.Writer) {
+	g.writeHeader(w)
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+	for _, key := range sortedKeys(g.funcs) {
+		fmt.Fprintf(w, "%s%s %d\n", g.name, g.labels(key, ""), g.funcs[key]())
+	}
+}
+
+// histogram is one series of a HistogramVec
+type histogram struct {
+	counts  []uint64 // per bucket, not cumulative
+	count   uint64
+	sumBits uint64 // float64 bits of the sum
+}
+
+func (h *histogram) observe(buckets []float64, v float64) {
+	i := sort.SearchFloat64s(buckets, v)
+	if i < len(buckets) {
+		atomic.AddUint64(&h.counts[i], 1)
+	}
+	atomic.AddUint64(&h.count, 1)
+	for {
+		old := atomic.LoadUint64(&h.sumBits)
+		sum := math.Float64bits(math.Float64frombits(old) + v)
+		if atomic.CompareAndSwapUint64(&h.sumBits, old, sum) {
+			return
+		}
+	}
+}
+
+// HistogramVec samples observations into configurable buckets, partitioned by labels
+// (按label划分的直方图)
+type HistogramVec struct {
+	metricDesc
+	buckets []float64
+	mu      sync.RWMutex
+	series  map[string]*histogram
+}
+
+// NewHistogramVec creates a histogram family, buckets must be sorted in increasing order,
+// DefBuckets is used when buckets is empty
+func NewHistogramVec(name, help string, buckets []float64, labelNames ...string) *HistogramVec {
+	if len(buckets) == 0 {
+		buckets = DefBuckets
+	}
+	return &HistogramVec{
+		metricDesc: metricDesc{name: name, help: help, kind: "histogram", labelNames: labelNames},
+		buckets:    buckets,
+		series:     make(map[string]*histogram),
+	}
+}
+
+func (h *HistogramVec) get(labelValues []string) *histogram {
+	key := h.key(labelValues)
+
+	h.mu.RLock()
+	v, ok := h.series[key]
+	h.mu.RUnlock()
+	if ok {
+		return v
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if v, ok = h.series[key]; !ok {
+		v = &histogram{counts: make([]uint64, len(h.buckets))}
+		h.series[key] = v
+	}
+	return v
+}
+
+// Observe adds a single observation to the histogram of the given label values
+// (为指定label的直方图添加一次观测值)
+func (h *HistogramVec) Observe(v float64, labelValues ...string) {
+	h.get(labelValues).observe(h.buckets, v)
+}
+
+// Count returns how many observations were made for the given label values
+func (h *HistogramVec) Count(labelValues ...string) uint64 {
+	return atomic.LoadUint64(&h.get(labelValues).count)
+}
+
+func (h *HistogramVec) write(w io.Writer) {
+	h.writeHeader(w)
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	for _, key := range sortedKeys(h.series) {
+		s := h.series[key]
+		var cumulative uint64
+		for i, upper := range h.buckets {
+			cumulative += atomic.LoadUint64(&s.counts[i])
+			fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, h.labels(key, `le="`+formatFloat(upper)+`"`), cumulative)
+		}
+		count := atomic.LoadUint64(&s.count)
+		fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, h.labels(key, `le="+Inf"`), count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", h.name, h.labels(key, ""), formatFloat(math.Float64frombits(atomic.LoadUint64(&s.sumBits))))
+		fmt.Fprintf(w, "%s_count%s %d\n", h.name, h.labels(key, ""), count)
+	}
+}
@@ -0,0 +1,164 @@
+// @Title metrics.go
+// @Description Built-in Zinx metrics: connections, traffic, requests, handler latency and worker queue depth
+package zmetrics
+
+import (
+	"bytes"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/aceld/zinx/zconf"
+	"github.com/aceld/zinx/zlog"
+)
+
+const (
+	// DefaultMetricsPath is the HTTP path metrics are exported on when zconf.Config.PrometheusPath is empty
+	// (默认的指标导出路径)
+	DefaultMetricsPath = "/metrics"
+)
+
+// ZinxMetrics holds every metric family exported by the framework
+// (Zinx框架导出的全部指标)
+type ZinxMetrics struct {
+	// Number of alive connections per server (每个Server当前存活的连接数)
+	ConnCount *GaugeVec
+	// Accept errors per server (每个Server Accept失败的次数)
+	AcceptErrors *CounterVec
+	// Bytes read from the sockets per server (每个Server读取的字节数)
+	BytesIn *CounterVec
+	// Bytes written to the sockets per server (每个Server写出的字节数)
+	BytesOut *CounterVec
+	// Routed requests per server and MsgID (每个Server每个MsgID的请求数)
+	Requests *CounterVec
+	// Router handling latency per server and MsgID (每个Server每个MsgID的处理耗时)
+	HandleDuration *HistogramVec
+	// Pending tasks per server and worker (每个Server每个Worker的待处理任务数)
+	TaskQueueLen *GaugeFunc
+
+	collectors []collector
+}
+
+var (
+	metricsOnce     sync.Once
+	metricsInstance *ZinxMetrics
+	serveOnce       sync.Once
+)
+
+// Metrics returns the process-wide metrics instance, singleton
+// (获取全局指标对象，单例)
+func Metrics() *ZinxMetrics {
+	metricsOnce.Do(func() {
+		metricsInstance = newZinxMetrics()
+	})
+	return metricsInstance
+}
+
+func newZinxMetrics() *ZinxMetrics {
+	m := &ZinxMetrics{
+		ConnCount:      NewGaugeVec("zinx_connections", "Number of alive connections.", "server"),
+		AcceptErrors:   NewCounterVec("zinx_accept_errors_total", "Number of failed Accept calls.", "server"),
+		BytesIn:        NewCounterVec("zinx_received_bytes_total", "Bytes read from connections.", "server"),
+		BytesOut:       NewCounterVec("zinx_sent_bytes_total", "Bytes written to connections.", "server"),
+		Requests:       NewCounterVec("zinx_requests_total", "Number of routed requests.", "server", "msg_id"),
+		HandleDuration: NewHistogramVec("zinx_handle_duration_seconds", "Router handling latency in seconds.", nil, "server", "msg_id"),
+		TaskQueueLen:   NewGaugeFunc("zinx_task_queue_length", "Number of requests waiting in a worker TaskQueue.", "server", "worker_id"),
+	}
+	m.collectors = []collector{
+		m.ConnCount, m.AcceptErrors, m.BytesIn, m.BytesOut, m.Requests, m.HandleDuration, m.TaskQueueLen,
+	}
+	return m
+}
+
+// Enabled reports whether metrics collection is switched on in the global configuration
+// (是否开启了指标统计)
+func Enabled() bool {
+	return zconf.GlobalObject.PrometheusMetricsEnable
+}
+
+// ConnAdd records a connection joining the given server
+func (m *ZinxMetrics) ConnAdd(server string) {
+	m.ConnCount.Add(1, server)
+}
+
+// ConnRemove records a connection leaving the given server
+func (m *ZinxMetrics) ConnRemove(server string) {
+	m.ConnCount.Add(-1, server)
+}
+
+// AcceptError records a failed Accept on the given server
+func (m *ZinxMetrics) AcceptError(server string) {
+	m.AcceptErrors.Inc(server)
+}
+
+// ReceivedBytes records n bytes read by the given server
+func (m *ZinxMetrics) ReceivedBytes(server string, n int) {
+	if n > 0 {
+		m.BytesIn.Add(uint64(n), server)
+	}
+}
+
+// SentBytes records n bytes written by the given server
+func (m *ZinxMetrics) SentBytes(server string, n int) {
+	if n > 0 {
+		m.BytesOut.Add(uint64(n), server)
+	}
+}
+
+// Handled records one routed request and how long its router took
+// (记录一次路由处理及其耗时)
+func (m *ZinxMetrics) Handled(server string, msgID uint32, cost time.Duration) {
+	id := strconv.FormatUint(uint64(msgID), 10)
+	m.Requests.Inc(server, id)
+	m.HandleDuration.Observe(cost.Seconds(), server, id)
+}
+
+// RegisterTaskQueue samples the depth of one worker's TaskQueue at scrape time
+// (注册某个Worker任务队列长度的取值函数)
+func (m *ZinxMetrics) RegisterTaskQueue(server string, workerID int, f func() int64) {
+	m.TaskQueueLen.Register(f, server, strconv.Itoa(workerID))
+}
+
+// Bytes renders every metric in the Prometheus text exposition format
+// (以Prometheus文本格式输出全部指标)
+func (m *ZinxMetrics) Bytes() []byte {
+	var buf bytes.Buffer
+	for _, c := range m.collectors {
+		c.write(&buf)
+	}
+	return buf.Bytes()
+}
+
+// ServeHTTP makes ZinxMetrics an http.Handler, so it can be mounted on any user mux
+// (实现http.Handler，可以挂载到用户自己的路由上)
+func (m *ZinxMetrics) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	_, _ = w.Write(m.Bytes())
+}
+
+// RunMetricsService starts the built-in metrics HTTP server described by conf.
+// It is started at most once per process, no matter how many servers call it.
+// (启动内置的指标HTTP服务，一个进程只会启动一次)
+func RunMetricsService(conf *zconf.Config) {
+	if !conf.PrometheusMetricsEnable || !conf.PrometheusServer {
+		return
+	}
+
+	serveOnce.Do(func() {
+		path := conf.PrometheusPath
+		if path == "" {
+			path = DefaultMetricsPath
+		}
+
+		mux := http.NewServeMux()
+		mux.Handle(path, Metrics())
+
+		go func() {
+			zlog.Ins().InfoF("[START] Zinx metrics service listening at %s%s", conf.PrometheusListen, path)
+			if err := http.ListenAndServe(conf.PrometheusListen, mux); err != nil {
+				zlog.Ins().ErrorF("Zinx metrics service err: %v", err)
+			}
+		}()
+	})
+}
@@ -0,0 +1,102 @@
+package zmetrics
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// run in terminal:
+// go test -v ./zmetrics
+
+func TestCounterAndGauge(t *testing.T) {
+	c := NewCounterVec("test_total", "test counter.", "server")
+	c.Inc("s1")
+	c.Add(2, "s1")
+	c.Inc("s2")
+	if v := c.Value("s1"); v != 3 {
+		t.Fatalf("counter s1 = %d, want 3", v)
+	}
+
+	g := NewGaugeVec("test_gauge", "test gauge.", "server")
+	g.Add(5, "s1")
+	g.Add(-2, "s1")
+	if v := g.Value("s1"); v != 3 {
+		t.Fatalf("gauge s1 = %d, want 3", v)
+	}
+
+	var sb strings.Builder
+	c.write(&sb)
+	out := sb.String()
+	for _, want := range []string{
+		"# TYPE test_total counter\n",
+		`test_total{server="s1"} 3` + "\n",
+		`test_total{server="s2"} 1` + "\n",
+	} {
+		if !strings.Contains(out, want) {
+			t.Fatalf("output missing %q:\n%s", want, out)
+		}
+	}
+}
+
+func TestHistogram(t *testing.T) {
+	h := NewHistogramVec("test_seconds", "test histogram.", []float64{0.1, 1}, "msg_id")
+	h.Observe(0.05, "1")
+	h.Observe(0.5, "1")
+	h.Observe(5, "1")
+
+	var sb strings.Builder
+	h.write(&sb)
+	out := sb.String()
+	for _, want := range []string{
+		`test_seconds_bucket{msg_id="1",le="0.1"} 1`,
+		`test_seconds_bucket{msg_id="1",le="1"} 2`,
+		`test_seconds_bucket{msg_id="1",le="+Inf"} 3`,
+		`test_seconds_sum{msg_id="1"} 5.55`,
+		`test_seconds_count{msg_id="1"} 3`,
+	} {
+		if !strings.Contains(out, want) {
+			t.Fatalf("output missing %q:\n%s", want, out)
+		}
+	}
+}
+
+func TestLabelEscape(t *testing.T) {
+	g := NewGaugeVec("test_escape", "test escape.", "server")
+	g.Set(1, "a\"b\\c\nd")
+
+	var sb strings.Builder
+	g.write(&sb)
+	if want := `test_escape{server="a\"b\\c\nd"} 1`; !strings.Contains(sb.String(), want) {
+		t.Fatalf("output missing %q:\n%s", want, sb.String())
+	}
+}
+
+func TestMetricsHandler(t *testing.T) {
+	m := newZinxMetrics()
+	m.ConnAdd("srv")
+	m.ReceivedBytes("srv", 128)
+	m.SentBytes("srv", 64)
+	m.AcceptError("srv")
+	m.Handled("srv", 100, 3*time.Millisecond)
+	m.RegisterTaskQueue("srv", 0, func() int64 { return 7 })
+
+	rec := httptest.NewRecorder()
+	m.ServeHTTP(rec, httptest.NewRequest("GET", DefaultMetricsPath, nil))
+	out := rec.Body.String()
+
+	for _, want := range []string{
+		`zinx_connections{server="srv"} 1`,
+		`zinx_accept_errors_total{server="srv"} 1`,
+		`zinx_received_bytes_total{server="srv"} 128`,
+		`zinx_sent_bytes_total{server="srv"} 64`,
+		`zinx_requests_total{server="srv",msg_id="100"} 1`,
+		`zinx_handle_duration_seconds_count{server="srv",msg_id="100"} 1`,
+		`zinx_task_queue_length{server="srv",worker_id="0"} 7`,
+	} {
+		if !strings.Contains(out, want) {
+			t.Fatalf("output missing %q:\n%s", want, out)
+		}
+	}
+}
@@ -1,6 +1,7 @@
 package znet
 
 import (
+	"sync/atomic"
 	"time"
 )
 
@@ -8,14 +9,17 @@ const (
 	maxDelay = 1 * time.Second
 )
 
+// AcceptDelay is kept for compatibility, every listener of a Server backs off with its own acceptDelay
+// (为兼容保留, Server的每个监听都使用自己的acceptDelay进行退避)
 var AcceptDelay *acceptDelay
 
 func init() {
 	AcceptDelay = &acceptDelay{duration: 0}
 }
 
+// acceptDelay is the backoff of an accept loop, safe for concurrent use (accept循环的退避, 并发安全)
 type acceptDelay struct {
-	duration time.Duration
+	duration int64 // time.Duration, accessed atomically (原子访问)
 }
 
 func (d *acceptDelay) Delay() {
@@ -24,22 +28,32 @@ func (d *acceptDelay) Delay() {
 }
 
 func (d *acceptDelay) Reset() {
-	d.duration = 0
+	atomic.StoreInt64(&d.duration, 0)
 }
 
 func (d *acceptDelay) Up() {
-	if d.duration == 0 {
-		d.duration = 5 * time.Millisecond
-		return
-	}
-	d.duration = 2 * d.duration
-	if d.duration > maxDelay {
-		d.duration = maxDelay
+	for {
+		old := atomic.LoadInt64(&d.duration)
+		next := 2 * old
+		if old == 0 {
+			next = int64(5 * time.Millisecond)
+		}
+		if next > int64(maxDelay) {
+			next = int64(maxDelay)
+		}
+		if atomic.CompareAndSwapInt64(&d.duration, old, next) {
+			return
+		}
 	}
 }
 
+// Duration returns the current backoff (返回当前的退避时长)
+func (d *acceptDelay) Duration() time.Duration {
+	return time.Duration(atomic.LoadInt64(&d.duration))
+}
+
 func (d *acceptDelay) do() {
-	if d.duration > 0 {
-		time.Sleep(d.duration)
+	if duration := d.Duration(); duration > 0 {
+		time.Sleep(duration)
 	}
 }
@@ -19,16 +19,25 @@ func teardown() {
 }
 
 func TestDelay(t *testing.T) {
-	assert.Equal(t, time.Duration(0), AcceptDelay.duration)
+	assert.Equal(t, time.Duration(0), AcceptDelay.Duration())
 	AcceptDelay.Up()
-	assert.Equal(t, 5*time.Millisecond, AcceptDelay.duration)
+	assert.Equal(t, 5*time.Millisecond, AcceptDelay.Duration())
 	AcceptDelay.Reset()
-	assert.Equal(t, time.Duration(0), AcceptDelay.duration)
+	assert.Equal(t, time.Duration(0), AcceptDelay.Duration())
 
 	for i := 0; i < 600; i++ {
 		AcceptDelay.Up()
 	}
-	assert.Equal(t, 1*time.Second, AcceptDelay.duration)
+	assert.Equal(t, 1*time.Second, AcceptDelay.Duration())
+}
+
+func TestListenerDelay(t *testing.T) {
+	// A busy listener does not throttle the others (繁忙的监听器不影响其他监听器)
+	busy, idle := &listener{}, &listener{}
+	busy.delay.Up()
+	busy.delay.Up()
+	assert.Equal(t, 10*time.Millisecond, busy.delay.Duration())
+	assert.Equal(t, time.Duration(0), idle.delay.Duration())
 }
 
 func TestMain(m *testing.M) {
@@ -0,0 +1,211 @@
+package znet
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/aceld/zinx/ziface"
+	"github.com/aceld/zinx/zlog"
+	"github.com/aceld/zinx/zpack"
+)
+
+var errAuthTimeout = errors.New("authentication timeout")
+
+// maxAuthHeldMsgs is how many transport messages a connection holds before it is accepted, at most
+// (连接认证通过前最多暂存的传输层消息数)
+const maxAuthHeldMsgs = 4
+
+// authenticator runs the handshake of the new connections of a Server, the messages of a connection
+// go to the AuthHandler until it is accepted, and only then to the routers
+// (执行Server新连接的握手, 连接认证通过前的消息交给AuthHandler处理, 通过后才交给路由)
+type authenticator struct {
+	handler ziface.AuthHandler
+	option  ziface.AuthOption
+	allow   map[uint32]bool
+	states  sync.Map // ziface.IConnection -> *authState
+
+	// Handles the transport messages held until a connection is accepted (处理连接认证通过前暂存的传输层消息)
+	transport func(request ziface.IRequest) bool
+}
+
+// authState is the handshake progress of one connection (一个连接的握手进度)
+type authState struct {
+	lock     sync.Mutex
+	count    int
+	accepted bool
+	rejected bool
+	timer    *time.Timer
+	held     []ziface.IRequest // Transport messages waiting for the acceptance (等待认证通过的传输层消息)
+}
+
+func newAuthenticator(handler ziface.AuthHandler, option *ziface.AuthOption) *authenticator {
+	a := &authenticator{handler: handler, allow: make(map[uint32]bool)}
+	if option != nil {
+		a.option = *option
+	}
+	if a.option.MaxMessages <= 0 {
+		a.option.MaxMessages = ziface.AuthDefaultMaxMessages
+	}
+	if a.option.Timeout <= 0 {
+		a.option.Timeout = ziface.AuthDefaultTimeout
+	}
+	for _, msgID := range a.option.AllowMsgIDs {
+		a.allow[msgID] = true
+	}
+	return a
+}
+
+// begin starts the handshake of a new connection, it is closed if not accepted before the timeout
+// (开始新连接的握手, 超时未通过认证则关闭连接)
+func (a *authenticator) begin(conn ziface.IConnection) {
+	state := &authState{}
+	state.timer = time.AfterFunc(a.option.Timeout, func() {
+		state.lock.Lock()
+		timeout := !state.accepted && !state.rejected
+		if timeout {
+			state.rejected = true
+		}
+		state.lock.Unlock()
+
+		if timeout {
+			a.reject(conn, errAuthTimeout)
+		}
+	})
+	a.states.Store(conn, state)
+
+	// The handler may stop the connection while holding state.lock. Stop only cancels the connection,
+	// the close callbacks run later on the goroutine that closes it, so taking state.lock here is safe
+	// (handler可能在持有state.lock时停止连接; Stop只取消连接, 关闭回调随后在关闭连接的协程中执行, 因此这里可以加锁)
+	conn.AddCloseCallback(a, nil, func() {
+		a.states.Delete(conn)
+		state.timer.Stop()
+
+		state.lock.Lock()
+		held := state.held
+		state.held = nil
+		state.lock.Unlock()
+		for _, request := range held {
+			PutRequest(request)
+		}
+	})
+}
+
+// holdTransport tells whether a message of a connection not authenticated yet is a transport message,
+// which neither goes to the AuthHandler nor is handled before the connection is accepted
+// (判断未认证连接的消息是否为传输层消息, 这类消息既不交给AuthHandler, 也不会在认证通过前处理)
+func holdTransport(request ziface.IRequest) bool {
+	switch request.GetMsgID() {
+	case ziface.ReliableHelloMsgID, ziface.CompressNegotiateMsgID:
+		return true
+	}
+	return false
+}
+
+// check reports whether the request can go to the routers, handshake messages are handled here.
+// The reliable session hello and the compression negotiation of a connection not authenticated yet are held,
+// held tells that the request is kept and handled once the connection is accepted. The other reserved
+// messages, such as RPC replies and acknowledgements, are dropped until then.
+// (判断请求能否交给路由处理, 握手消息在此处理。未认证连接的可靠会话握手和压缩协商消息会被暂存,
+// held表示请求已被保留, 将在连接认证通过后处理; 此前RPC应答、确认消息等其他保留消息会被丢弃)
+func (a *authenticator) check(request ziface.IRequest) (pass, held bool) {
+	if a.allow[request.GetMsgID()] {
+		return true, false
+	}
+	value, ok := a.states.Load(request.GetConnection())
+	if !ok {
+		return true, false
+	}
+
+	state := value.(*authState)
+	state.lock.Lock()
+	if state.accepted {
+		state.lock.Unlock()
+		return true, false
+	}
+	if state.rejected {
+		state.lock.Unlock()
+		return false, false
+	}
+	if holdTransport(request) {
+		if len(state.held) >= maxAuthHeldMsgs {
+			state.lock.Unlock()
+			return false, false
+		}
+		// The data outlives the request frame (数据的生命周期长于请求帧)
+		detachBuffer(request)
+		state.held = append(state.held, request)
+		state.lock.Unlock()
+		return false, true
+	}
+	if req, ok := request.(*Request); (ok && req.rpcFlag != 0 && req.rpcFlag != zpack.RPCFlagRequest) ||
+		request.GetMsgID() == ziface.ReliableAckMsgID {
+		state.lock.Unlock()
+		return false, false
+	}
+
+	state.count++
+	conn := request.GetConnection()
+	identity, accepted, err := a.callHandler(request)
+	var replay []ziface.IRequest
+	switch {
+	case err != nil:
+		state.rejected = true
+	case accepted:
+		state.accepted = true
+		state.timer.Stop()
+		conn.SetProperty(ziface.PropertyIdentity, identity)
+		replay, state.held = state.held, nil
+	case state.count >= a.option.MaxMessages:
+		state.rejected = true
+		err = fmt.Errorf("not authenticated after %d messages", state.count)
+	}
+	state.lock.Unlock()
+
+	if err != nil {
+		a.reject(conn, err)
+	} else if accepted {
+		zlog.Ins().DebugF("ConnID = %d authenticated as %v", conn.GetConnID(), identity)
+		if a.option.OnAccept != nil {
+			a.option.OnAccept(conn, identity)
+		}
+		for _, request := range replay {
+			if a.transport != nil {
+				a.transport(request)
+			}
+			PutRequest(request)
+		}
+	}
+	return false, false
+}
+
+func (a *authenticator) callHandler(request ziface.IRequest) (identity interface{}, accepted bool, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("auth handler panic: %v", r)
+		}
+	}()
+	return a.handler(request)
+}
+
+func (a *authenticator) reject(conn ziface.IConnection, err error) {
+	zlog.Ins().InfoF("ConnID = %d %s rejected by authentication: %v", conn.GetConnID(), conn.RemoteAddrString(), err)
+	if a.option.OnReject != nil {
+		a.option.OnReject(conn, err)
+	}
+	conn.Stop()
+}
+
+// SetAuthenticator makes every new connection pass handler before its messages are routed,
+// messages other than option.AllowMsgIDs are blocked until then. Only the key exchange is handled before,
+// the reliable session hello and the compression negotiation wait for the acceptance.
+// (每个新连接需要先通过handler认证, 认证通过前除option.AllowMsgIDs外的消息都不会被路由。
+// 此前只处理密钥交换, 可靠会话握手和压缩协商等待认证通过后处理)
+func (s *Server) SetAuthenticator(handler ziface.AuthHandler, option *ziface.AuthOption) {
+	s.auth = newAuthenticator(handler, option)
+	if mh, ok := s.msgHandler.(*MsgHandle); ok {
+		s.auth.transport = mh.handleTransport
+		mh.auth = s.auth
+	}
+}
@@ -0,0 +1,207 @@
+package znet
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/aceld/zinx/zconf"
+	"github.com/aceld/zinx/ziface"
+)
+
+// run in terminal:
+// go test -v ./znet -run=TestAuthenticator
+
+const authLoginMsgID = 100
+
+// IdentityRouter replies the identity of the connection (应答连接的身份)
+type IdentityRouter struct {
+	BaseRouter
+}
+
+func (r *IdentityRouter) Handle(req ziface.IRequest) {
+	_ = req.Reply([]byte(fmt.Sprint(req.GetConnection().GetIdentity())))
+}
+
+func TestAuthenticator(t *testing.T) {
+	conf := *zconf.GlobalObject
+	conf.Name = "AuthTest"
+	conf.Host = "127.0.0.1"
+	conf.TCPPort = 19010
+
+	rejected := make(chan error, 3)
+	accepted := make(chan interface{}, 1)
+	s := newServerWithConfig(&conf, "tcp")
+	s.SetAuthenticator(func(req ziface.IRequest) (interface{}, bool, error) {
+		if req.GetMsgID() != authLoginMsgID || string(req.GetData()) != "token-ok" {
+			return nil, false, errors.New("bad login")
+		}
+		_ = req.Reply([]byte("welcome"))
+		return "user-1", true, nil
+	}, &ziface.AuthOption{
+		Timeout:  time.Millisecond * 500,
+		OnReject: func(conn ziface.IConnection, err error) { rejected <- err },
+		OnAccept: func(conn ziface.IConnection, identity interface{}) { accepted <- identity },
+	})
+	s.AddRouter(1, &IdentityRouter{})
+	s.Start()
+	defer s.Stop()
+	time.Sleep(time.Second * 1)
+
+	dial := func() *Client {
+		client := NewClient("127.0.0.1", 19010).(*Client)
+		client.Start()
+		time.Sleep(time.Millisecond * 300)
+		if client.Conn() == nil {
+			t.Fatal("client not connected")
+		}
+		return client
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+	defer cancel()
+
+	// Logged in first, then routed with the identity attached (先登录, 之后的消息携带身份进行路由)
+	good := dial()
+	defer good.Stop()
+	reply, err := good.Conn().Call(ctx, authLoginMsgID, []byte("token-ok"))
+	if err != nil || string(reply.GetData()) != "welcome" {
+		t.Fatalf("login reply = %v err = %v", reply, err)
+	}
+	if identity := <-accepted; identity != "user-1" {
+		t.Fatalf("accepted identity = %v, want user-1", identity)
+	}
+	reply, err = good.Conn().Call(ctx, 1, nil)
+	if err != nil || string(reply.GetData()) != "user-1" {
+		t.Fatalf("identity reply = %v err = %v", reply, err)
+	}
+
+	// Other messages before login are rejected (登录前发送其他消息会被拒绝)
+	bad := dial()
+	defer bad.Stop()
+	if _, err := bad.Conn().Call(ctx, 1, nil); err == nil {
+		t.Fatal("message routed before authentication")
+	}
+	if err := <-rejected; err == nil || err.Error() != "bad login" {
+		t.Fatalf("reject err = %v, want bad login", err)
+	}
+
+	// Silent connections are closed after the timeout (超时未认证的连接被关闭)
+	silent := dial()
+	defer silent.Stop()
+	select {
+	case err := <-rejected:
+		if err != errAuthTimeout {
+			t.Fatalf("reject err = %v, want timeout", err)
+		}
+	case <-time.After(time.Second * 2):
+		t.Fatal("silent connection not rejected")
+	}
+}
+
+func TestAuthBeforeResume(t *testing.T) {
+	conf := *zconf.GlobalObject
+	conf.Name = "AuthResumeTest"
+	conf.Host = "127.0.0.1"
+	conf.TCPPort = 19025
+
+	s := newServerWithConfig(&conf, "tcp", WithReliable(nil))
+	s.SetAuthenticator(func(req ziface.IRequest) (interface{}, bool, error) {
+		if req.GetMsgID() != authLoginMsgID || string(req.GetData()) != "token-ok" {
+			return nil, false, errors.New("bad login")
+		}
+		_ = req.Reply([]byte("welcome"))
+		return "user-1", true, nil
+	}, &ziface.AuthOption{Timeout: time.Second * 2})
+	s.Start()
+	defer s.Stop()
+	time.Sleep(time.Second * 1)
+
+	client := NewClient("127.0.0.1", 19025, WithReliableClient(nil)).(*Client)
+	client.Start()
+	defer client.Stop()
+	time.Sleep(time.Millisecond * 300)
+
+	var serverConn ziface.IConnection
+	_ = s.GetConnMgr().Range(func(_ uint64, c ziface.IConnection, _ interface{}) error {
+		serverConn = c
+		return nil
+	}, nil)
+	if serverConn == nil {
+		t.Fatal("client not connected")
+	}
+
+	// The hello sent on connect does not resume a session before the login (登录前连接时发送的握手不会恢复会话)
+	if session := s.GetReliableSession(serverConn); session != nil {
+		t.Fatalf("reliable session %s resumed before authentication", session.Token())
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+	defer cancel()
+	if _, err := client.Conn().Call(ctx, authLoginMsgID, []byte("token-ok")); err != nil {
+		t.Fatalf("login err: %v", err)
+	}
+	deadline := time.Now().Add(time.Second * 2)
+	for s.GetReliableSession(serverConn) == nil && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond * 20)
+	}
+	session := s.GetReliableSession(serverConn)
+	if session == nil || session.Token() != client.GetReliableSession().Token() {
+		t.Fatal("reliable session not resumed after authentication")
+	}
+}
+
+func TestResumeOtherIdentity(t *testing.T) {
+	conf := *zconf.GlobalObject
+	conf.Name = "AuthIdentityTest"
+	conf.Host = "127.0.0.1"
+	conf.TCPPort = 19029
+
+	// The login data is the identity (登录数据即身份)
+	s := newServerWithConfig(&conf, "tcp", WithReliable(nil))
+	s.SetAuthenticator(func(req ziface.IRequest) (interface{}, bool, error) {
+		_ = req.Reply([]byte("welcome"))
+		return string(req.GetData()), true, nil
+	}, nil)
+	s.Start()
+	defer s.Stop()
+	time.Sleep(time.Second * 1)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+	defer cancel()
+
+	victim := NewClient("127.0.0.1", 19029, WithReliableClient(nil)).(*Client)
+	victim.Start()
+	defer victim.Stop()
+	time.Sleep(time.Millisecond * 300)
+	if _, err := victim.Conn().Call(ctx, authLoginMsgID, []byte("user-1")); err != nil {
+		t.Fatalf("login err: %v", err)
+	}
+	deadline := time.Now().Add(time.Second * 2)
+	for victim.GetReliableSession().Token() == "" && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond * 20)
+	}
+	token := victim.GetReliableSession().Token()
+	if token == "" {
+		t.Fatal("reliable session not opened")
+	}
+
+	thief := NewClient("127.0.0.1", 19029).(*Client)
+	thief.Start()
+	defer thief.Stop()
+	time.Sleep(time.Millisecond * 300)
+	if _, err := thief.Conn().Call(ctx, authLoginMsgID, []byte("user-2")); err != nil {
+		t.Fatalf("login err: %v", err)
+	}
+
+	// The token of user-1 does not resume its session for user-2 (user-2不能凭user-1的token恢复其会话)
+	hello := append(make([]byte, 8), token...)
+	if _, err := thief.Conn().Call(ctx, ziface.ReliableHelloMsgID, hello); err == nil {
+		t.Fatal("reliable session resumed by another identity")
+	}
+	time.Sleep(time.Millisecond * 200)
+	if victim.Conn() == nil || victim.Conn().Context().Err() != nil {
+		t.Fatal("connection of the session owner closed")
+	}
+}
@@ -16,6 +16,7 @@ import (
 type chainBuilder struct {
 	body       []ziface.IInterceptor
 	head, tail ziface.IInterceptor
+	transport  ziface.IInterceptor
 }
 
 // newChainBuilder creates a new instance of chainBuilder.
@@ -30,6 +31,11 @@ func (ic *chainBuilder) Head(interceptor ziface.IInterceptor) {
 	ic.head = interceptor
 }
 
+// Transport adds the interceptor run right after the head, before the body of the chain.
+func (ic *chainBuilder) Transport(interceptor ziface.IInterceptor) {
+	ic.transport = interceptor
+}
+
 // Tail adds an interceptor to the tail of the chain.
 func (ic *chainBuilder) Tail(interceptor ziface.IInterceptor) {
 	ic.tail = interceptor
@@ -48,6 +54,9 @@ func (ic *chainBuilder) Execute(req ziface.IcReq) ziface.IcResp {
 	if ic.head != nil {
 		interceptors = append(interceptors, ic.head)
 	}
+	if ic.transport != nil {
+		interceptors = append(interceptors, ic.transport)
+	}
 	if len(ic.body) > 0 {
 		interceptors = append(interceptors, ic.body...)
 	}
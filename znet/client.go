@@ -10,6 +10,8 @@ import (
 	"sync"
 	"time"
 
+	"github.com/aceld/zinx/zcodec"
+	"github.com/aceld/zinx/zconf"
 	"github.com/aceld/zinx/zdecoder"
 	"github.com/aceld/zinx/ziface"
 	"github.com/aceld/zinx/zlog"
@@ -29,7 +31,9 @@ type Client struct {
 	Ip string
 	// Port of the target server to connect 目标连接服务器的端口
 	Port int
-	Url  *url.URL // 扩展，连接时带上其他参数
+	// Socket file of the target server for a unix client 目标服务器的Unix域套接字文件路径
+	UnixPath string
+	Url      *url.URL // 扩展，连接时带上其他参数
 	// Custom headers for WebSocket connection WebSocket连接的自定义头信息
 	WsHeader http.Header
 	// Client version tcp,websocket,客户端版本 tcp,websocket
@@ -44,6 +48,8 @@ type Client struct {
 	onConnStop func(conn ziface.IConnection)
 	// Data packet packer 数据报文封包方式
 	packet ziface.IDataPack
+	// Codec of the typed messages 类型化消息的编解码器
+	codec ziface.ICodec
 	// Asynchronous channel for capturing connection close status 异步捕获连接关闭状态
 	// exitChan chan struct{}
 	// Message management module 消息管理模块
@@ -53,12 +59,34 @@ type Client struct {
 	decoder ziface.IDecoder
 	// Heartbeat checker 心跳检测器
 	hc ziface.IHeartbeatChecker
+	// Unregisters the hot reload handler, called by Stop (取消热加载回调, 由Stop调用)
+	stopReload func()
 	// Use TLS 使用TLS
 	useTLS bool
+	// TLS settings, nil verifies the server certificate against the system pool TLS配置, 为nil时使用系统证书池校验服务端证书
+	tlsConfig *tls.Config
+	// Skip verifying the server certificate when no tlsConfig is set (未设置tlsConfig时跳过服务端证书校验)
+	insecureSkipVerify bool
 	// For websocket connections
 	dialer *websocket.Dialer
 	// Error channel
 	errChan chan error
+	// Automatic reconnection policy, nil means never reconnect (自动重连策略, nil表示不重连)
+	reconnect *ReconnectPolicy
+	// Hook function called before every reconnect attempt (每次尝试重连前的Hook函数)
+	onReconnecting func(attempt int, err error)
+	// Hook function called after reconnected (重连成功后的Hook函数)
+	onReconnected func(conn ziface.IConnection)
+	// Messages sent by SendBuffMsg while disconnected (断线期间SendBuffMsg缓存的消息)
+	replayMsgs   []*zpack.Message
+	disconnected bool
+	replayMux    sync.Mutex
+	// Compression offered to the server, nil sends uncompressed (向服务端提供的压缩设置, nil表示不压缩)
+	compress *compressOption
+	// Encryption of the connection, nil sends plaintext (连接的加密设置, nil表示不加密)
+	encrypt *encryptOption
+	// Reliable session resumed on every connection, nil sends the messages directly (每个连接上恢复的可靠会话, nil表示直接发送消息)
+	reliable *reliableManager
 }
 
 func NewClient(ip string, port int, opts ...ClientOption) ziface.IClient {
@@ -72,6 +100,7 @@ func NewClient(ip string, port int, opts ...ClientOption) ziface.IClient {
 
 		msgHandler: newCliMsgHandle(),
 		packet:     zpack.Factory().NewPack(ziface.ZinxDataPack), // Default to using Zinx's TLV packet format(默认使用zinx的TLV封包方式)
+		codec:      zcodec.Default(),                             // Default to using JSON (默认使用JSON编解码)
 		decoder:    zdecoder.NewTLVDecoder(),                     // Default to using Zinx's TLV decoder(默认使用zinx的TLV解码器)
 		version:    "tcp",
 		errChan:    make(chan error, 1),
@@ -96,6 +125,7 @@ func NewWsClient(ip string, port int, opts ...ClientOption) ziface.IClient {
 
 		msgHandler: newCliMsgHandle(),
 		packet:     zpack.Factory().NewPack(ziface.ZinxDataPack), // Default to using Zinx's TLV packet format(默认使用zinx的TLV封包方式)
+		codec:      zcodec.Default(),                             // Default to using JSON (默认使用JSON编解码)
 		decoder:    zdecoder.NewTLVDecoder(),                     // Default to using Zinx's TLV decoder(默认使用zinx的TLV解码器)
 		version:    "websocket",
 		dialer:     &websocket.Dialer{},
@@ -110,6 +140,32 @@ func NewWsClient(ip string, port int, opts ...ClientOption) ziface.IClient {
 	return c
 }
 
+// NewUnixClient creates a client connecting to the Unix domain socket at path
+// (创建连接path处Unix域套接字的客户端)
+func NewUnixClient(path string, opts ...ClientOption) ziface.IClient {
+
+	c := &Client{
+		// Default name, can be modified using the WithNameClient Option
+		// (默认名称，可以使用WithNameClient的Option修改)
+		Name:     "ZinxClientUnix",
+		UnixPath: path,
+
+		msgHandler: newCliMsgHandle(),
+		packet:     zpack.Factory().NewPack(ziface.ZinxDataPack), // Default to using Zinx's TLV packet format(默认使用zinx的TLV封包方式)
+		codec:      zcodec.Default(),                             // Default to using JSON (默认使用JSON编解码)
+		decoder:    zdecoder.NewTLVDecoder(),                     // Default to using Zinx's TLV decoder(默认使用zinx的TLV解码器)
+		version:    "unix",
+		errChan:    make(chan error, 1),
+	}
+
+	// Apply Option settings (应用Option设置)
+	for _, opt := range opts {
+		opt(c)
+	}
+
+	return c
+}
+
 func NewTLSClient(ip string, port int, opts ...ClientOption) ziface.IClient {
 
 	c, _ := NewClient(ip, port, opts...).(*Client)
@@ -142,94 +198,194 @@ func (c *Client) Restart() {
 	}
 	c.started = true
 	c.ctx, c.cancel = context.WithCancel(context.Background())
+	c.stopReload = zconf.OnReload(func(old, conf *zconf.Config, report *zconf.ReloadReport) {
+		followHeartbeatMax(c.hc, old, conf, report)
+	})
 	c.Add(1)
 	c.Unlock()
 
-	zlog.Ins().InfoF("[START] Zinx Client dial RemoteAddr: %s:%d\n", c.Ip, c.Port)
+	if c.version == "unix" {
+		zlog.Ins().InfoF("[START] Zinx Client dial RemoteAddr: %s\n", c.UnixPath)
+	} else {
+		zlog.Ins().InfoF("[START] Zinx Client dial RemoteAddr: %s:%d\n", c.Ip, c.Port)
+	}
 	go func() {
 		defer c.Done()
 
-		// Create a raw socket and get net.Conn (创建原始Socket，得到net.Conn)
-		var connect ziface.IConnection
-		switch c.version {
-		case "websocket":
-			wsAddr := fmt.Sprintf("ws://%s:%d", c.Ip, c.Port)
-			if c.Url != nil {
-				wsAddr = c.Url.String()
+		connect, closed, err := c.dial(c.ctx, false)
+		if err != nil {
+			if c.reconnect == nil {
+				c.notifyErr(err)
+				return
 			}
+			if connect, closed = c.redial(err); connect == nil {
+				return
+			}
+		}
 
-			// Create a raw socket and get net.Conn (创建原始Socket，得到net.Conn)
-			wsConn, _, err := c.dialer.DialContext(c.ctx, wsAddr, c.WsHeader)
-			if err != nil {
-				// connection failed
-				zlog.Ins().ErrorF("WsClient connect to server failed, err:%v", err)
-				c.notifyErr(err)
+		hc := c.hc
+		for {
+			c.serve(connect, hc)
+
+			// closed is nil without a reconnect policy, so only the client exit is waited for
+			// (未设置重连策略时closed为nil, 只等待客户端退出)
+			select {
+			case <-c.ctx.Done():
+				zlog.Ins().InfoF("client exit.")
 				return
+			case <-closed:
 			}
-			// Create Connection object
-			connect = newWsClientConn(c, wsConn)
-
-		default:
-			var conn net.Conn
-			var err error
-			if c.useTLS {
-				// TLS encryption
-				config := &tls.Config{
-					// Skip certificate verification here because the CA certificate of the certificate issuer is not authenticated
-					// (这里是跳过证书验证，因为证书签发机构的CA证书是不被认证的)
-					InsecureSkipVerify: true,
-				}
-				d := &tls.Dialer{
-					Config: config,
-				}
-				//conn, err = tls.Dial("tcp", fmt.Sprintf("%v:%v", net.ParseIP(c.Ip), c.Port), config)
-				conn, err = d.DialContext(c.ctx, "tcp", fmt.Sprintf("%v:%v", net.ParseIP(c.Ip), c.Port))
-				if err != nil {
-					zlog.Ins().ErrorF("tls client connect to server failed, err:%v", err)
-					c.notifyErr(err)
-					return
-				}
-			} else {
-				//conn, err = net.DialTCP("tcp", nil, addr)
-				d := &net.Dialer{}
-				conn, err = d.DialContext(c.ctx, "tcp", fmt.Sprintf("%v:%v", net.ParseIP(c.Ip), c.Port))
-				if err != nil {
-					// connection failed
-					zlog.Ins().ErrorF("client connect to server failed, err:%v", err)
-					c.notifyErr(err)
-					return
+
+			if connect, closed = c.redial(nil); connect == nil {
+				return
+			}
+			// Every new connection gets its own heartbeat checker (每个新连接绑定一个新的心跳检测器)
+			if c.hc != nil {
+				hc = c.hc.Clone()
+			}
+		}
+	}()
+}
+
+// dial creates the raw socket and the Connection object on top of it
+// (创建原始Socket及对应的Connection对象)
+func (c *Client) dial(ctx context.Context, reconnected bool) (ziface.IConnection, chan struct{}, error) {
+	// The connections of a reconnecting client report their start and stop to the client
+	// (开启自动重连时, 连接的创建和断开需要通知到客户端)
+	var owner ziface.IClient = c
+	var closed chan struct{}
+	if c.reconnect != nil {
+		hooks := &reconnectHooks{Client: c, reconnected: reconnected, closed: make(chan struct{})}
+		owner, closed = hooks, hooks.closed
+	}
+	if c.compress != nil || c.encrypt != nil || c.reliable != nil {
+		owner = &negotiateHooks{IClient: owner, client: c}
+	}
+
+	switch c.version {
+	case "websocket":
+		wsAddr := fmt.Sprintf("ws://%s:%d", c.Ip, c.Port)
+		if c.Url != nil {
+			wsAddr = c.Url.String()
+		}
+
+		// Create a raw socket and get net.Conn (创建原始Socket，得到net.Conn)
+		wsConn, _, err := c.dialer.DialContext(ctx, wsAddr, c.WsHeader)
+		if err != nil {
+			// connection failed
+			zlog.Ins().ErrorF("WsClient connect to server failed, err:%v", err)
+			return nil, nil, err
+		}
+		// Create Connection object
+		return newWsClientConn(owner, wsConn), closed, nil
+
+	case "unix":
+		d := &net.Dialer{}
+		conn, err := d.DialContext(ctx, "unix", c.UnixPath)
+		if err != nil {
+			zlog.Ins().ErrorF("unix client connect to server failed, err:%v", err)
+			return nil, nil, err
+		}
+		return newClientConn(owner, conn), closed, nil
+
+	default:
+		var conn net.Conn
+		var err error
+		if c.useTLS {
+			// TLS encryption
+			config := c.tlsConfig
+			if config == nil {
+				// Verified against the system pool unless skipping is asked for by WithInsecureSkipVerifyClient
+				// (使用系统证书池校验服务端证书, 除非通过WithInsecureSkipVerifyClient要求跳过校验)
+				config = &tls.Config{ServerName: c.Ip, InsecureSkipVerify: c.insecureSkipVerify}
+				if c.insecureSkipVerify {
+					zlog.Ins().InfoF("tls client does not verify the server certificate")
 				}
 			}
-			// Create Connection object
-			connect = newClientConn(c, conn)
+			d := &tls.Dialer{
+				Config: config,
+			}
+			//conn, err = tls.Dial("tcp", fmt.Sprintf("%v:%v", net.ParseIP(c.Ip), c.Port), config)
+			conn, err = d.DialContext(ctx, "tcp", fmt.Sprintf("%v:%v", net.ParseIP(c.Ip), c.Port))
+			if err != nil {
+				zlog.Ins().ErrorF("tls client connect to server failed, err:%v", err)
+				return nil, nil, err
+			}
+		} else {
+			//conn, err = net.DialTCP("tcp", nil, addr)
+			d := &net.Dialer{}
+			conn, err = d.DialContext(ctx, "tcp", fmt.Sprintf("%v:%v", net.ParseIP(c.Ip), c.Port))
+			if err != nil {
+				// connection failed
+				zlog.Ins().ErrorF("client connect to server failed, err:%v", err)
+				return nil, nil, err
+			}
 		}
+		// Create Connection object
+		return newClientConn(owner, conn), closed, nil
+	}
+}
 
-		// Set connection to the client
-		c.setConn(connect)
+// negotiateHooks is the IClient handed to the connections of a Client that encrypts, compresses or sends its messages
+// through a reliable session, the key exchange, the compression negotiation and then the session resume start
+// once the connection has started
+// (开启加密、压缩或可靠会话时传给连接的IClient, 连接启动后依次进行密钥交换、压缩协商和会话恢复)
+type negotiateHooks struct {
+	ziface.IClient
+	client *Client
+}
 
-		zlog.Ins().InfoF("[START] Zinx Client LocalAddr: %s, RemoteAddr: %s\n", connect.LocalAddr(), connect.RemoteAddr())
-		// HeartBeat detection
-		if c.hc != nil {
-			// Bind connection and heartbeat detector after connection is successfully established
-			// (创建连接成功，绑定连接与心跳检测器)
-			c.hc.BindConn(connect)
+func (h *negotiateHooks) GetOnConnStart() func(ziface.IConnection) {
+	onConnStart := h.IClient.GetOnConnStart()
+	return func(conn ziface.IConnection) {
+		go func() {
+			if h.client.encrypt != nil && !h.client.exchangeKeys(conn) {
+				return
+			}
+			if h.client.compress != nil {
+				h.client.negotiateCompression(conn)
+			}
+			if h.client.reliable != nil {
+				h.client.resumeReliable(conn)
+			}
+		}()
+		if onConnStart != nil {
+			onConnStart(conn)
 		}
+	}
+}
 
-		// Start connection
-		go connect.Start()
+// serve binds the new connection to the client and starts it
+// (将新连接绑定到客户端并启动)
+func (c *Client) serve(connect ziface.IConnection, hc ziface.IHeartbeatChecker) {
+	// Set connection to the client
+	c.setConn(connect)
+
+	zlog.Ins().InfoF("[START] Zinx Client LocalAddr: %s, RemoteAddr: %s\n", connect.LocalAddr(), connect.RemoteAddr())
+	// HeartBeat detection
+	if hc != nil {
+		// Bind connection and heartbeat detector after connection is successfully established
+		// (创建连接成功，绑定连接与心跳检测器)
+		hc.BindConn(connect)
+	}
 
-		<-c.ctx.Done()
-		zlog.Ins().InfoF("client exit.")
-	}()
+	// Messages are held until the keys are exchanged (密钥交换完成前暂存消息)
+	if ec, ok := connect.(encryptConn); ok && c.encrypt != nil {
+		ec.getEncryption().enable()
+	}
+
+	// Start connection
+	go connect.Start()
 }
 
 // Start starts the client, sends requests and establishes a connection.
 // (启动客户端，发送请求且建立连接)
 func (c *Client) Start() {
 
-	// Add the decoder to the interceptor list (将解码器添加到拦截器)
+	// Add the decoder to the interceptors head, the transport layer is handled right after it
+	// (将解码器添加到拦截器最前面, 紧接着处理传输层)
 	if c.decoder != nil {
-		c.msgHandler.AddInterceptor(c.decoder)
+		c.msgHandler.SetHeadInterceptor(c.decoder)
 	}
 
 	c.Restart()
@@ -280,6 +436,7 @@ func (c *Client) Stop() {
 		return
 	}
 	c.started = false
+	c.stopReload()
 
 	con := c.Conn()
 	if con != nil {
@@ -336,6 +493,14 @@ func (c *Client) SetPacket(packet ziface.IDataPack) {
 	c.packet = packet
 }
 
+func (c *Client) GetCodec() ziface.ICodec {
+	return c.codec
+}
+
+func (c *Client) SetCodec(codec ziface.ICodec) {
+	c.codec = codec
+}
+
 func (c *Client) GetMsgHandler() ziface.IMsgHandle {
 	return c.msgHandler
 }
@@ -0,0 +1,235 @@
+package znet
+
+import (
+	"context"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/aceld/zinx/zcompress"
+	"github.com/aceld/zinx/ziface"
+	"github.com/aceld/zinx/zlog"
+	"github.com/aceld/zinx/zpack"
+)
+
+// compressNegotiateTimeout is how long a client waits for the server to choose the compression
+// (客户端等待服务端选择压缩算法的时长)
+const compressNegotiateTimeout = 5 * time.Second
+
+// compressOption is the compression setting of a Server or Client
+// (Server或Client的压缩设置)
+type compressOption struct {
+	threshold int
+	names     []string // Algorithms accepted, in order of preference (可接受的压缩算法, 按优先级排序)
+}
+
+func newCompressOption(threshold int, names []string) *compressOption {
+	if len(names) == 0 {
+		names = []string{ziface.ZinxCompressGzip}
+	}
+	for _, name := range names {
+		if zcompress.Get(name) == nil {
+			panic("zinx: unknown compressor " + name)
+		}
+	}
+	return &compressOption{threshold: threshold, names: names}
+}
+
+// choose returns the first accepted algorithm the peer offers, or nil
+// (返回对端提供的算法中优先级最高的可接受算法, 没有时返回nil)
+func (o *compressOption) choose(offers []string) ziface.ICompressor {
+	for _, name := range o.names {
+		for _, offer := range offers {
+			if offer == name {
+				return zcompress.Get(name)
+			}
+		}
+	}
+	return nil
+}
+
+// connCompression is the compression negotiated by a connection, messages are sent uncompressed until then,
+// and received ones are only decompressed with the algorithms negotiated
+// (连接协商好的压缩方式, 协商完成前消息不压缩发送; 收到的消息只按协商的算法解压)
+type connCompression struct {
+	value    atomic.Value // *compressState
+	accepted atomic.Value // []uint8, IDs of the algorithms the peer may compress with (对端可以使用的压缩算法ID)
+}
+
+type compressState struct {
+	compressor ziface.ICompressor
+	threshold  int
+}
+
+// compressConn is implemented by connections that can compress the messages they exchange
+// (可以压缩收发消息的连接)
+type compressConn interface {
+	getCompression() *connCompression
+}
+
+func (c *connCompression) set(compressor ziface.ICompressor, threshold int) {
+	c.value.Store(&compressState{compressor: compressor, threshold: threshold})
+}
+
+// accept lets the peer compress with the algorithms of ids, nothing is decompressed before
+// (允许对端使用ids中的算法压缩, 此前收到的消息一律不解压)
+func (c *connCompression) accept(ids ...uint8) {
+	c.accepted.Store(ids)
+}
+
+func (c *connCompression) accepts(id uint8) bool {
+	ids, _ := c.accepted.Load().([]uint8)
+	for _, accepted := range ids {
+		if accepted == id {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *connCompression) compressor() ziface.ICompressor {
+	state, _ := c.value.Load().(*compressState)
+	if state == nil {
+		return nil
+	}
+	return state.compressor
+}
+
+// compress returns the MsgID and data to send for a message. Data at least the threshold that gets smaller
+// is sent compressed under ziface.CompressMsgID with the compression header, otherwise msgID and data themselves.
+// (返回消息实际发送的MsgID和数据, 长度达到阈值且压缩后变小时以ziface.CompressMsgID发送带压缩报头的数据,
+// 否则为msgID和data本身)
+func (c *connCompression) compress(msgID uint32, data []byte) (uint32, []byte) {
+	state, _ := c.value.Load().(*compressState)
+	if state == nil || state.compressor == nil || len(data) < state.threshold {
+		return msgID, data
+	}
+
+	compressed, err := state.compressor.Compress(data)
+	if err != nil {
+		zlog.Ins().ErrorF("%s compress err: %v, send uncompressed", state.compressor.Name(), err)
+		return msgID, data
+	}
+	if len(compressed)+zpack.CompressHeaderLen >= len(data) {
+		return msgID, data
+	}
+	return ziface.CompressMsgID, zpack.PackCompressed(state.compressor.ID(), msgID, compressed)
+}
+
+// decompressRequest restores the MsgID and data of an ziface.CompressMsgID request before it is routed,
+// other requests are left alone. false means the request is malformed, compressed with an algorithm its
+// connection did not negotiate, can not be decompressed or expands beyond zcompress.MaxDecompressedSize,
+// and should be dropped.
+// (在路由前还原ziface.CompressMsgID请求的MsgID和数据, 其他请求保持不变; 返回false表示请求格式错误、
+// 使用了连接未协商的压缩算法、无法解压或解压后超过zcompress.MaxDecompressedSize, 应丢弃该请求)
+func decompressRequest(request ziface.IRequest) bool {
+	msg := request.GetMessage()
+	if msg == nil || msg.GetMsgID() != ziface.CompressMsgID {
+		return true
+	}
+
+	connID := request.GetConnection().GetConnID()
+	algorithm, msgID, payload, ok := zpack.UnpackCompressed(msg.GetData())
+	if !ok {
+		zlog.Ins().ErrorF("ConnID = %d malformed compressed frame, drop it", connID)
+		return false
+	}
+	conn, ok := request.GetConnection().(compressConn)
+	if !ok || !conn.getCompression().accepts(algorithm) {
+		zlog.Ins().ErrorF("ConnID = %d msgID = %d compressed with algorithm %d not negotiated, drop it", connID, msgID, algorithm)
+		return false
+	}
+	compressor := zcompress.GetByID(algorithm)
+	if compressor == nil {
+		zlog.Ins().ErrorF("unknown compression algorithm %d of msgID = %d, drop it", algorithm, msgID)
+		return false
+	}
+	data, err := compressor.Decompress(payload)
+	if err == nil && len(data) > zcompress.MaxDecompressedSize {
+		err = zcompress.ErrTooLarge
+	}
+	if err != nil {
+		zlog.Ins().ErrorF("%s decompress msgID = %d err: %v, drop it", compressor.Name(), msgID, err)
+		return false
+	}
+
+	msg.SetMsgID(msgID)
+	msg.SetData(data)
+	msg.SetDataLen(uint32(len(data)))
+	return true
+}
+
+// negotiateCompression answers the compression offer of a client with the algorithm chosen,
+// the connection compresses its messages from then on
+// (应答客户端的压缩协商, 回复选中的算法, 此后连接发送的消息开始压缩)
+func (mh *MsgHandle) negotiateCompression(request ziface.IRequest) {
+	var compressor ziface.ICompressor
+	if mh.compress != nil {
+		compressor = mh.compress.choose(strings.Split(string(request.GetData()), ","))
+	}
+
+	var name string
+	if compressor != nil {
+		name = compressor.Name()
+	}
+	conn, ok := request.GetConnection().(compressConn)
+	if ok && compressor != nil {
+		// In the reader, before the client may send a message compressed with it (在读协程中, 先于客户端发送压缩消息)
+		conn.getCompression().accept(compressor.ID())
+	}
+	if err := request.Reply([]byte(name)); err != nil {
+		return
+	}
+
+	if ok && compressor != nil {
+		conn.getCompression().set(compressor, mh.compress.threshold)
+		zlog.Ins().DebugF("ConnID = %d compresses messages with %s", request.GetConnection().GetConnID(), name)
+	}
+}
+
+// negotiateCompression offers the algorithms of the client to the server and compresses the messages
+// of the connection with the one chosen
+// (向服务端提供客户端的压缩算法, 并使用服务端选中的算法压缩连接的消息)
+func (c *Client) negotiateCompression(conn ziface.IConnection) {
+	cc, ok := conn.(compressConn)
+	if !ok {
+		return
+	}
+	ctx, cancel := context.WithTimeout(conn.Context(), compressNegotiateTimeout)
+	defer cancel()
+
+	// The server compresses with the algorithm chosen right after its reply, which may be read before Call returns
+	// (服务端应答后立即使用选中的算法压缩, 这些消息可能在Call返回前就被读取)
+	offered := make([]uint8, 0, len(c.compress.names))
+	for _, name := range c.compress.names {
+		offered = append(offered, zcompress.Get(name).ID())
+	}
+	cc.getCompression().accept(offered...)
+
+	reply, err := conn.Call(ctx, ziface.CompressNegotiateMsgID, []byte(strings.Join(c.compress.names, ",")))
+	if err != nil {
+		zlog.Ins().ErrorF("compression negotiation err: %v, send uncompressed", err)
+		return
+	}
+
+	name := string(reply.GetData())
+	compressor := zcompress.Get(name)
+	if compressor == nil {
+		cc.getCompression().accept()
+		zlog.Ins().InfoF("server accepts none of the compressors %v, send uncompressed", c.compress.names)
+		return
+	}
+	cc.getCompression().accept(compressor.ID())
+	cc.getCompression().set(compressor, c.compress.threshold)
+	zlog.Ins().DebugF("client compresses messages with %s", name)
+}
+
+// SetCompression accepts the compression algorithms in names, in order of preference, when clients negotiate,
+// message data shorter than threshold is never compressed
+// (客户端协商时按优先级接受names中的压缩算法, 长度小于threshold的消息数据不压缩)
+func (s *Server) SetCompression(threshold int, names ...string) {
+	s.compress = newCompressOption(threshold, names)
+	if mh, ok := s.msgHandler.(*MsgHandle); ok {
+		mh.compress = s.compress
+	}
+}
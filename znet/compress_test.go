@@ -0,0 +1,153 @@
+package znet
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aceld/zinx/zcompress"
+	"github.com/aceld/zinx/zconf"
+	"github.com/aceld/zinx/ziface"
+	"github.com/aceld/zinx/zpack"
+)
+
+// run in terminal:
+// go test -v ./znet -run=TestCompression
+
+// recordInterceptor keeps the MsgID and data of every request an interceptor sees (记录拦截器看到的每个请求的MsgID和数据)
+type recordInterceptor struct {
+	lock  sync.Mutex
+	msgs  []uint32
+	datas [][]byte
+}
+
+func (r *recordInterceptor) Intercept(chain ziface.IChain) ziface.IcResp {
+	if request, ok := chain.Request().(ziface.IRequest); ok {
+		r.lock.Lock()
+		r.msgs = append(r.msgs, request.GetMsgID())
+		r.datas = append(r.datas, append([]byte(nil), request.GetData()...))
+		r.lock.Unlock()
+	}
+	return chain.Proceed(chain.Request())
+}
+
+func TestCompression(t *testing.T) {
+	conf := *zconf.GlobalObject
+	conf.Name = "CompressionTest"
+	conf.Host = "127.0.0.1"
+	conf.TCPPort = 19011
+
+	s := newServerWithConfig(&conf, "tcp", WithCompression(64, ziface.ZinxCompressSnappy, ziface.ZinxCompressGzip))
+	s.AddRouter(1, &RPCEchoRouter{})
+	record := &recordInterceptor{}
+	s.AddInterceptor(record)
+	s.Start()
+	defer s.Stop()
+	time.Sleep(time.Second * 1)
+
+	client := NewClient("127.0.0.1", 19011, WithCompressionClient(64, ziface.ZinxCompressGzip, ziface.ZinxCompressSnappy)).(*Client)
+	client.Start()
+	defer client.Stop()
+	time.Sleep(time.Second * 1)
+
+	conn := client.Conn()
+	if conn == nil {
+		t.Fatal("client not connected")
+	}
+	// The server preference wins (以服务端的优先级为准)
+	if compressor := conn.GetCompressor(); compressor == nil || compressor.Name() != ziface.ZinxCompressSnappy {
+		t.Fatalf("client compressor = %v, want snappy", compressor)
+	}
+	var serverConn ziface.IConnection
+	_ = s.GetConnMgr().Range(func(_ uint64, c ziface.IConnection, _ interface{}) error {
+		serverConn = c
+		return nil
+	}, nil)
+	if serverConn == nil || serverConn.GetCompressor() == nil {
+		t.Fatal("server connection does not compress")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+	defer cancel()
+	for _, data := range [][]byte{[]byte("short"), []byte(strings.Repeat("state sync ", 500))} {
+		reply, err := conn.Call(ctx, 1, data)
+		if err != nil {
+			t.Fatalf("call err: %v", err)
+		}
+		if !bytes.Equal(reply.GetData(), append([]byte("echo:"), data...)) {
+			t.Fatalf("reply of %d bytes changed", len(data))
+		}
+	}
+
+	// The interceptors see the calls decompressed under their own MsgID (拦截器看到的是解压后、使用原本MsgID的调用)
+	record.lock.Lock()
+	defer record.lock.Unlock()
+	if len(record.msgs) != 2 {
+		t.Fatalf("interceptor saw msgIDs %v, want the 2 calls only", record.msgs)
+	}
+	for i, msgID := range record.msgs {
+		if msgID != 1 || !bytes.HasPrefix(record.datas[i], []byte("s")) {
+			t.Fatalf("interceptor saw msgID = %d data = %.20q", msgID, record.datas[i])
+		}
+	}
+}
+
+func TestConnCompression(t *testing.T) {
+	var c connCompression
+	data := []byte(strings.Repeat("chat ", 100))
+	if msgID, sent := c.compress(1, data); msgID != 1 || !bytes.Equal(sent, data) {
+		t.Fatal("compressed before negotiation")
+	}
+
+	c.set(newCompressOption(0, []string{ziface.ZinxCompressGzip}).choose([]string{"zstd", "gzip"}), 64)
+	short := []byte("below threshold")
+	if msgID, sent := c.compress(1, short); msgID != 1 || !bytes.Equal(sent, short) {
+		t.Fatal("compressed below the threshold")
+	}
+
+	msgID, compressed := c.compress(1, data)
+	algorithm, inner, _, ok := zpack.UnpackCompressed(compressed)
+	if msgID != ziface.CompressMsgID || !ok || algorithm != ziface.ZinxCompressGzipID || inner != 1 || len(compressed) >= len(data) {
+		t.Fatalf("data not compressed with gzip, msgID = %d, %d bytes", msgID, len(compressed))
+	}
+
+	// Nothing is decompressed before the algorithm is negotiated (协商前不解压任何数据)
+	conn := &Connection{}
+	request := &Request{conn: conn, msg: zpack.NewMsgPackage(ziface.CompressMsgID, compressed)}
+	if decompressRequest(request) {
+		t.Fatal("data decompressed without negotiation")
+	}
+
+	conn.getCompression().accept(ziface.ZinxCompressGzipID)
+	request = &Request{conn: conn, msg: zpack.NewMsgPackage(ziface.CompressMsgID, compressed)}
+	if !decompressRequest(request) || request.GetMsgID() != 1 || !bytes.Equal(request.GetData(), data) {
+		t.Fatal("message not restored")
+	}
+
+	// Uncompressed data is never taken for compressed, whatever it starts with
+	// (无论以什么开头, 未压缩的数据都不会被误认为已压缩)
+	plain := zpack.PackCompressed(ziface.ZinxCompressGzipID, 2, []byte("plain"))
+	request = &Request{conn: conn, msg: zpack.NewMsgPackage(1, plain)}
+	if !decompressRequest(request) || request.GetMsgID() != 1 || !bytes.Equal(request.GetData(), plain) {
+		t.Fatal("uncompressed data rewritten")
+	}
+
+	// Algorithms not negotiated are dropped (未协商的算法被丢弃)
+	snappy := zpack.PackCompressed(ziface.ZinxCompressSnappyID, 1, []byte("snappy"))
+	request = &Request{conn: conn, msg: zpack.NewMsgPackage(ziface.CompressMsgID, snappy)}
+	if decompressRequest(request) {
+		t.Fatal("data of an algorithm not negotiated accepted")
+	}
+
+	// Data expanding beyond the limit is dropped (解压后超过上限的数据被丢弃)
+	limit := zcompress.MaxDecompressedSize
+	zcompress.MaxDecompressedSize = len(data) - 1
+	defer func() { zcompress.MaxDecompressedSize = limit }()
+	request = &Request{conn: conn, msg: zpack.NewMsgPackage(ziface.CompressMsgID, compressed)}
+	if decompressRequest(request) {
+		t.Fatal("data beyond the limit accepted")
+	}
+}
@@ -3,18 +3,23 @@ package znet
 import (
 	"bufio"
 	"context"
+	"crypto/x509"
 	"encoding/hex"
 	"errors"
 	"net"
 	"strconv"
 	"sync"
 	"sync/atomic"
+	"syscall"
 	"time"
 
+	"github.com/aceld/zinx/zbuffer"
+	"github.com/aceld/zinx/zcodec"
 	"github.com/aceld/zinx/zconf"
 	"github.com/aceld/zinx/ziface"
 	"github.com/aceld/zinx/zinterceptor"
 	"github.com/aceld/zinx/zlog"
+	"github.com/aceld/zinx/zmetrics"
 	"github.com/aceld/zinx/zpack"
 
 	"github.com/gorilla/websocket"
@@ -24,6 +29,18 @@ import (
 // (定义回调函数类型)
 type CallBackFunc func()
 
+// sendItem is an entry of the send queue, pooled marks data taken from zbuffer,
+// msgID is kept for the "coalesce" policy when the item comes from SendBuffMsg
+// (发送队列中的一项, pooled表示数据取自zbuffer, 来自SendBuffMsg时记录msgID供"coalesce"策略使用)
+type sendItem struct {
+	data   []byte
+	pooled bool
+	msgID  uint32 // MsgID on the wire, ziface.CompressMsgID when compressed (实际发送的MsgID, 压缩后为ziface.CompressMsgID)
+	key    uint32 // MsgID sent by the caller, the item is coalesced by (调用方发送的MsgID, 按其合并)
+	keyed  bool   // key is set, the item can be coalesced (设置了key, 可以被合并)
+	seal   bool   // data is the payload of msgID, sealed and packed by the writer (data为msgID的消息数据, 由写协程加密封包)
+}
+
 // Connection TCP connection module
 // Used to handle the read and write business of TCP connections, one Connection corresponds to one connection
 // (用于处理Tcp连接的读写业务 一个连接对应一个Connection)
@@ -31,7 +48,8 @@ type Connection struct {
 	// // The socket TCP socket of the current connection(当前连接的socket TCP套接字)
 	conn net.Conn
 
-	// The buffer writer of the current connection(当前连接的写缓冲)
+	// The buffer writer of the current connection, created with the writer goroutine
+	// (当前连接的写缓冲, 随写协程一起创建)
 	bufWriter *bufio.Writer
 
 	// The ID of the current connection, also known as SessionID, globally unique, used by server Connection
@@ -54,21 +72,24 @@ type Connection struct {
 	// (消息管理MsgID和对应处理方法的消息管理模块)
 	msgHandler ziface.IMsgHandle
 
-	// Channel to notify that the connection has exited/stopped
-	// (告知该连接已经退出/停止的channel)
+	// Channel to notify that the connection has exited/stopped, created with the connection
+	// so that other goroutines may read it before Start
+	// (告知该连接已经退出/停止的channel, 随连接一起创建, 因此其他协程可以在Start之前读取)
 	ctx    context.Context
 	cancel context.CancelFunc
 
-	// Buffered channel used for message communication between the read and write goroutines
-	// (有缓冲管道，用于读、写两个goroutine之间的消息通信)
-	msgBuffChan chan []byte
-
-	closeOnce sync.Once
+	// Priority queues of the messages waiting for the writer goroutine
+	// (等待写协程发送的消息的优先级队列)
+	sendQueue *sendQueue
 
 	// Go StartWriter Flag
 	// (开始初始化写协程标志)
 	startWriterFlag int32
 
+	// Number of queued messages not yet flushed to the socket
+	// (已入队但尚未刷新到socket的消息数)
+	pendingSend int64
+
 	// Connection properties
 	// (连接属性)
 	property map[string]interface{}
@@ -89,10 +110,18 @@ type Connection struct {
 	// (当前连接断开时的Hook函数)
 	onConnStop func(conn ziface.IConnection)
 
+	// Hook function when the send queue overflows, inherited from the Server
+	// (发送队列溢出时的Hook函数, 继承自Server)
+	onSlowConsumer func(conn ziface.IConnection, event ziface.SlowConsumerEvent)
+
 	// Data packet packaging method
 	// (数据报文封包方式)
 	packet ziface.IDataPack
 
+	// Codec of the typed messages
+	// (类型化消息的编解码器)
+	codec ziface.ICodec
+
 	// Last activity time
 	// (最后一次活动时间)
 	lastActivityTime time.Time
@@ -105,6 +134,10 @@ type Connection struct {
 	// (心跳检测器)
 	hc ziface.IHeartbeatChecker
 
+	// Idle timeouts checked on the shared time wheel, nil when none is configured
+	// (在共享时间轮上检查的空闲超时, 未配置时为nil)
+	idle *idleWatch
+
 	// Connection name, default to be the same as the name of the Server/Client that created the connection
 	// (连接名称，默认与创建连接的Server/Client的Name一致)
 	name string
@@ -122,6 +155,24 @@ type Connection struct {
 
 	// Close callback mutex
 	closeCallbackMutex sync.RWMutex
+
+	// RPC calls waiting for their reply
+	// (等待应答的RPC调用)
+	rpc rpcCalls
+
+	// Compression negotiated for the messages sent
+	// (发送消息时使用的协商好的压缩方式)
+	compression connCompression
+
+	// Session keys and sequence numbers of the encrypted messages
+	// (加密消息的会话密钥及序号)
+	encryption connEncryption
+
+	// The epoll event loop reading this connection in "epoll" mode, nil means a reader goroutine is used
+	// ("epoll"模式下负责读取该连接的事件循环, 为nil时使用读协程)
+	loop    *eventLoop
+	rawConn syscall.RawConn
+	inbox   loopInbox
 }
 
 // newServerConn :for Server, method to create a Server-side connection with Server-specific properties
@@ -131,16 +182,16 @@ func newServerConn(server ziface.IServer, conn net.Conn, connID uint64) ziface.I
 	// Initialize Conn properties
 	c := &Connection{
 		conn:            conn,
-		bufWriter:       bufio.NewWriterSize(conn, 16*1024),
 		connID:          connID,
 		connIdStr:       strconv.FormatUint(connID, 10),
 		startWriterFlag: 0,
-		msgBuffChan:     nil,
+		sendQueue:       newSendQueue(zconf.SendQueueDropNewest),
 		property:        nil,
 		name:            server.ServerName(),
 		localAddr:       conn.LocalAddr().String(),
 		remoteAddr:      conn.RemoteAddr().String(),
 	}
+	c.ctx, c.cancel = context.WithCancel(context.Background())
 
 	lengthField := server.GetLengthField()
 	if lengthField != nil {
@@ -149,8 +200,11 @@ func newServerConn(server ziface.IServer, conn net.Conn, connID uint64) ziface.I
 
 	// Inherited properties from server (从server继承过来的属性)
 	c.packet = server.GetPacket()
+	c.codec = server.GetCodec()
 	c.onConnStart = server.GetOnConnStart()
 	c.onConnStop = server.GetOnConnStop()
+	c.onSlowConsumer = server.GetOnSlowConsumer()
+	c.idle = newIdleWatch(c, server)
 	c.msgHandler = server.GetMsgHandler()
 
 	// Bind the current Connection with the Server's ConnManager
@@ -169,16 +223,16 @@ func newServerConn(server ziface.IServer, conn net.Conn, connID uint64) ziface.I
 func newClientConn(client ziface.IClient, conn net.Conn) ziface.IConnection {
 	c := &Connection{
 		conn:            conn,
-		bufWriter:       bufio.NewWriterSize(conn, 16*1024),
 		connID:          0,  // client ignore
 		connIdStr:       "", // client ignore
 		startWriterFlag: 0,
-		msgBuffChan:     nil,
+		sendQueue:       newSendQueue(zconf.SendQueueDropNewest),
 		property:        nil,
 		name:            client.GetName(),
 		localAddr:       conn.LocalAddr().String(),
 		remoteAddr:      conn.RemoteAddr().String(),
 	}
+	c.ctx, c.cancel = context.WithCancel(context.Background())
 
 	lengthField := client.GetLengthField()
 	if lengthField != nil {
@@ -187,6 +241,7 @@ func newClientConn(client ziface.IClient, conn net.Conn) ziface.IConnection {
 
 	// Inherited properties from server (从client继承过来的属性)
 	c.packet = client.GetPacket()
+	c.codec = client.GetCodec()
 	c.onConnStart = client.GetOnConnStart()
 	c.onConnStop = client.GetOnConnStop()
 	c.msgHandler = client.GetMsgHandler()
@@ -201,38 +256,29 @@ func (c *Connection) StartWriter() {
 	defer func() {
 		zlog.Ins().InfoF("%s [conn Writer exit!]", c.RemoteAddr().String())
 		c.Flush()
+		c.releaseSendQueue()
 	}()
 	for {
 		select {
-		case data, ok := <-c.msgBuffChan:
-			if !ok {
-				zlog.Ins().ErrorF("msgBuffChan is Closed")
-				return
-			}
-
-			if err := c.SendBuf(data); err != nil {
-				zlog.Ins().ErrorF("Send Buff Data error:, %s Conn Writer exit", err)
-				return
-			}
-			// 一次性循环读出 msgBuffChan 中所有剩余数据
-		drainLoop:
+		case <-c.sendQueue.notify:
+			// 按优先级一次性读出队列中所有数据
+			batch := int64(0)
 			for {
-				select {
-				case extra, ok2 := <-c.msgBuffChan:
-					if !ok2 {
-						zlog.Ins().ErrorF("msgBuffChan is Closed")
-						return
-					}
-					if err := c.SendBuf(extra); err != nil {
-						zlog.Ins().ErrorF("Send Buff Data error:, %s Conn Writer exit", err)
-						return
-					}
-				default:
-					break drainLoop
+				item, ok := c.sendQueue.pop()
+				if !ok {
+					break
+				}
+				batch++
+				if err := c.sendItem(item); err != nil {
+					atomic.AddInt64(&c.pendingSend, -batch)
+					zlog.Ins().ErrorF("Send Buff Data error:, %s Conn Writer exit", err)
+					return
 				}
 			}
 			// 批量写入完成后一次性 flush
-			if err := c.Flush(); err != nil {
+			err := c.Flush()
+			atomic.AddInt64(&c.pendingSend, -batch)
+			if err != nil {
 				zlog.Ins().ErrorF("Flush Buff Data error: %v Conn Writer exit", err)
 				return
 			}
@@ -275,41 +321,54 @@ func (c *Connection) StartReader() {
 				return
 			}
 			zlog.Ins().DebugF("read buffer %s \n", hex.EncodeToString(buffer[0:n]))
-
-			// If normal data is read from the peer, update the heartbeat detection Active state
-			// (正常读取到对端数据，更新心跳检测Active状态)
-			if n > 0 && c.hc != nil {
-				c.updateActivity()
+			if zmetrics.Enabled() {
+				zmetrics.Metrics().ReceivedBytes(c.name, n)
 			}
 
-			// Deal with the custom protocol fragmentation problem, added by uuxia 2023-03-21
-			// (处理自定义协议断粘包问题)
-			if c.frameDecoder != nil {
-				// Decode the 0-n bytes of data read
-				// (为读取到的0-n个字节的数据进行解码)
-				bufArrays := c.frameDecoder.Decode(buffer[0:n])
-				if bufArrays == nil {
-					continue
-				}
-				for _, bytes := range bufArrays {
-					// zlog.Ins().DebugF("read buffer %s \n", hex.EncodeToString(bytes))
-					msg := zpack.NewMessage(uint32(len(bytes)), bytes)
-					// Get the current client's Request data
-					// (得到当前客户端请求的Request数据)
-					req := GetRequest(c, msg)
-					c.msgHandler.Execute(req)
-				}
-			} else {
-				msg := zpack.NewMessage(uint32(n), buffer[0:n])
-				// Get the current client's Request data
-				// (得到当前客户端请求的Request数据)
-				req := GetRequest(c, msg)
-				c.msgHandler.Execute(req)
-			}
+			c.handleData(buffer[0:n])
 		}
 	}
 }
 
+// handleData hands the bytes read from the socket to the frame decoder and the message handler
+// (将从socket读取到的数据交给断粘包解码器和消息处理模块)
+func (c *Connection) handleData(data []byte) {
+	c.decodeData(data, c.msgHandler.Execute)
+}
+
+// decodeData records the activity of the connection and passes the requests decoded from data to handle
+// (记录连接的活跃状态, 并将data解码出的请求交给handle)
+func (c *Connection) decodeData(data []byte, handle func(request ziface.IRequest)) {
+	// If normal data is read from the peer, update the heartbeat detection Active state
+	// (正常读取到对端数据，更新心跳检测Active状态)
+	if len(data) > 0 && c.hc != nil {
+		c.updateActivity()
+	}
+	c.idle.read()
+
+	// Deal with the custom protocol fragmentation problem, added by uuxia 2023-03-21
+	// (处理自定义协议断粘包问题)
+	if c.frameDecoder != nil {
+		// Decode the 0-n bytes of data read
+		// (为读取到的0-n个字节的数据进行解码)
+		bufArrays := c.frameDecoder.Decode(data)
+		for _, bytes := range bufArrays {
+			// zlog.Ins().DebugF("read buffer %s \n", hex.EncodeToString(bytes))
+			msg := zpack.NewMessage(uint32(len(bytes)), bytes)
+			// Get the current client's Request data
+			// (得到当前客户端请求的Request数据)
+			req := getRequestWithBuffer(c, msg, bytes)
+			handle(req)
+		}
+	} else {
+		msg := zpack.NewMessage(uint32(len(data)), data)
+		// Get the current client's Request data
+		// (得到当前客户端请求的Request数据)
+		req := GetRequest(c, msg)
+		handle(req)
+	}
+}
+
 // Start starts the connection and makes the current connection work.
 // (启动连接，让当前连接开始工作)
 func (c *Connection) Start() {
@@ -318,7 +377,6 @@ func (c *Connection) Start() {
 			zlog.Ins().ErrorF("Connection Start() error: %v", err)
 		}
 	}()
-	c.ctx, c.cancel = context.WithCancel(context.Background())
 
 	// Execute the hook method for processing business logic when creating a connection
 	// (按照用户传递进来的创建连接时需要处理的业务，执行钩子方法)
@@ -329,10 +387,25 @@ func (c *Connection) Start() {
 		c.hc.Start()
 		c.updateActivity()
 	}
+	c.idle.start()
 
 	// 占用workerid
 	c.workerID = useWorker(c)
 
+	// In "epoll" mode the event loop reads the connection, and the connection is closed once ctx is done
+	// ("epoll"模式下由事件循环读取数据, ctx结束时关闭连接)
+	if c.loop != nil {
+		err := c.loop.add(c)
+		if err == nil {
+			context.AfterFunc(c.ctx, func() {
+				c.loop.remove(c)
+				c.doClose()
+			})
+			return
+		}
+		zlog.Ins().ErrorF("connID=%d add to epoll err: %v, use a reader goroutine instead", c.connID, err)
+	}
+
 	// Start the Goroutine for reading data from the client
 	// (开启用户从客户端读取数据流程的Goroutine)
 	go c.StartReader()
@@ -345,6 +418,11 @@ func (c *Connection) Start() {
 // (停止连接，结束当前连接状态)
 func (c *Connection) Stop() {
 	c.cancel()
+	// Wake up the reader waiting on a silent peer, so a kicked connection is closed right away
+	// (唤醒等待对端数据的读协程, 使被踢掉的连接立即关闭)
+	if c.loop == nil {
+		_ = c.conn.SetReadDeadline(time.Now())
+	}
 }
 
 func (c *Connection) GetConnection() net.Conn {
@@ -384,6 +462,9 @@ func (c *Connection) Flush() error {
 	if c.isClosed() == true {
 		return errors.New("connection closed when flush data")
 	}
+	if c.bufWriter == nil {
+		return nil
+	}
 	return c.bufWriter.Flush()
 }
 
@@ -391,11 +472,15 @@ func (c *Connection) Send(data []byte) error {
 	if c.isClosed() == true {
 		return errors.New("connection closed when send msg")
 	}
-	_, err := c.conn.Write(data)
+	n, err := c.conn.Write(data)
+	if zmetrics.Enabled() {
+		zmetrics.Metrics().SentBytes(c.name, n)
+	}
 	if err != nil {
 		zlog.Ins().ErrorF("SendMsg err data = %+v, err = %+v", data, err)
 		return err
 	}
+	c.idle.wrote()
 	return nil
 }
 
@@ -403,22 +488,58 @@ func (c *Connection) SendBuf(data []byte) error {
 	if c.isClosed() == true {
 		return errors.New("connection closed when send msg")
 	}
-	_, err := c.bufWriter.Write(data)
+	if c.bufWriter == nil {
+		c.bufWriter = bufio.NewWriterSize(c.conn, 16*1024)
+	}
+	n, err := c.bufWriter.Write(data)
+	if zmetrics.Enabled() {
+		zmetrics.Metrics().SentBytes(c.name, n)
+	}
 	if err != nil {
 		zlog.Ins().ErrorF("SendMsg err data = %+v, err = %+v", data, err)
 		return err
 	}
+	c.idle.wrote()
 	return nil
 }
 
+// sendItem writes a queued item into the buffered writer and returns a pooled buffer to zbuffer
+// (将队列中的数据写入缓冲写入器, 池化的缓冲写入后归还zbuffer)
+func (c *Connection) sendItem(item sendItem) error {
+	if item.seal {
+		var err error
+		if item, err = c.encryption.packSealed(c.packet, item); err != nil {
+			zlog.Ins().ErrorF("Pack error msg ID = %d, drop it", item.msgID)
+			return nil
+		}
+	}
+	err := c.SendBuf(item.data)
+	if item.pooled {
+		zbuffer.Put(item.data)
+	}
+	return err
+}
+
+// putPacked returns a buffer packed by packet to zbuffer, unless packet did not take it from there
+// (归还packet封包的缓冲, packet的缓冲不是取自zbuffer时不归还)
+func putPacked(packet ziface.IDataPack, msg []byte) {
+	if zpack.IsPooled(packet) {
+		zbuffer.Put(msg)
+	}
+}
+
 func (c *Connection) SendToQueue(data []byte, opts ...ziface.MsgSendOption) error {
+	return c.queue(sendItem{data: data}, opts...)
+}
 
-	if c.msgBuffChan == nil && c.setStartWriterFlag() {
-		c.msgBuffChan = make(chan []byte, zconf.GlobalObject.MaxMsgChanLen)
+func (c *Connection) queue(item sendItem, opts ...ziface.MsgSendOption) error {
+
+	if atomic.LoadInt32(&c.startWriterFlag) == 0 && c.setStartWriterFlag() {
+		c.bufWriter = bufio.NewWriterSize(c.conn, 16*1024)
 		// Start a Goroutine to write data back to the client
-		// This method only reads data from the MsgBuffChan without allocating memory or starting a Goroutine
+		// This method only reads data from the send queue without allocating memory or starting a Goroutine
 		// (开启用于写回客户端数据流程的Goroutine
-		// 此方法只读取MsgBuffChan中的数据没调用SendBuffMsg可以分配内存和启用协程)
+		// 此方法只读取发送队列中的数据没调用SendBuffMsg可以分配内存和启用协程)
 		go c.StartWriter()
 	}
 
@@ -426,37 +547,68 @@ func (c *Connection) SendToQueue(data []byte, opts ...ziface.MsgSendOption) erro
 		return errors.New("Connection closed when send buff msg")
 	}
 
-	if data == nil {
+	if item.data == nil {
 		zlog.Ins().ErrorF("Pack data is nil")
 		return errors.New("Pack data is nil")
 	}
 
-	// Send timeout
-	select {
-	case <-c.ctx.Done():
-		// Close all channels associated with the connection
-		// Close Once to avoid repeated closure
-		c.closeOnce.Do(func() {
-			close(c.msgBuffChan)
-		})
-		return errors.New("connection closed when send buff msg")
-	case c.msgBuffChan <- data:
-		return nil
-	default:
-		zlog.Ins().ErrorF("send buff msg channel is full")
-		return errors.New("send buff msg channel is full")
+	opt := ziface.MsgSendOptionObj{
+		Priority: ziface.MsgPriorityNormal,
+	}
+	for _, o := range opts {
+		o(&opt)
+	}
+
+	atomic.AddInt64(&c.pendingSend, 1)
+	dropped, event, err := c.sendQueue.push(item, opt, c.ctx.Done())
+	if event != nil {
+		c.callOnSlowConsumer(*event)
+	}
+	if err != nil {
+		atomic.AddInt64(&c.pendingSend, -1)
+		if err == errSlowConsumer {
+			c.Stop()
+		}
+		return err
+	}
+	if dropped != nil {
+		// A queued message made room for this one (队列中的一条消息为本条消息让出了位置)
+		atomic.AddInt64(&c.pendingSend, -1)
+		if dropped.pooled {
+			zbuffer.Put(dropped.data)
+		}
+	}
+	return nil
+}
+
+// releaseSendQueue closes the send queue and returns the pooled buffers left in it
+// (关闭发送队列并归还其中剩余的池化缓冲)
+func (c *Connection) releaseSendQueue() {
+	for _, item := range c.sendQueue.close() {
+		if item.pooled {
+			zbuffer.Put(item.data)
+		}
 	}
 }
 
 // SendMsg directly sends Message data to the remote TCP client.
 // (直接将Message数据发送数据给远程的TCP客户端)
 func (c *Connection) SendMsg(msgID uint32, data []byte) error {
+	if held, err := c.encryption.hold(msgID, data, false, nil); held {
+		return err
+	}
+	return c.sendMsg(msgID, data)
+}
+
+// sendMsg packs and sends a message without holding it for the key exchange (封包并发送消息, 不会等待密钥交换)
+func (c *Connection) sendMsg(msgID uint32, data []byte) error {
 
 	if c.isClosed() == true {
 		return errors.New("connection closed when send msg")
 	}
 	// Pack data and send it
-	msg, err := c.packet.Pack(zpack.NewMsgPackage(msgID, data))
+	wireID, data := c.compression.compress(msgID, data)
+	msg, err := c.packet.Pack(zpack.NewMsgPackage(wireID, c.encryption.seal(wireID, data)))
 	if err != nil {
 		zlog.Ins().ErrorF("Pack error msg ID = %d", msgID)
 		return errors.New("Pack error msg ")
@@ -465,20 +617,40 @@ func (c *Connection) SendMsg(msgID uint32, data []byte) error {
 	err = c.Send(msg)
 	if err != nil {
 		zlog.Ins().ErrorF("SendMsg err msg ID = %d, data = %+v, err = %+v", msgID, string(msg), err)
-		return err
 	}
+	putPacked(c.packet, msg)
 
-	return nil
+	return err
 }
 
 func (c *Connection) SendBuffMsg(msgID uint32, data []byte, opts ...ziface.MsgSendOption) error {
-	msg, err := c.packet.Pack(zpack.NewMsgPackage(msgID, data))
+	if held, err := c.encryption.hold(msgID, data, true, opts); held {
+		return err
+	}
+	return c.sendBuffMsg(msgID, data, opts...)
+}
+
+func (c *Connection) sendBuffMsg(msgID uint32, data []byte, opts ...ziface.MsgSendOption) error {
+	wireID, data := c.compression.compress(msgID, data)
+	if c.encryption.sealing(wireID, data) {
+		return c.queue(sendItem{data: append([]byte(nil), data...), msgID: wireID, key: msgID, keyed: true, seal: true}, opts...)
+	}
+	msg, err := c.packet.Pack(zpack.NewMsgPackage(wireID, data))
 	if err != nil {
 		zlog.Ins().ErrorF("Pack error msg ID = %d", msgID)
 		return errors.New("Pack error msg ")
 	}
-	return c.SendToQueue(msg, opts...)
 
+	// A buffer packed from zbuffer belongs to this connection, the writer returns it after writing
+	// (取自zbuffer的封包缓冲只属于该连接, 由写协程写出后归还)
+	pooled := zpack.IsPooled(c.packet)
+	if err := c.queue(sendItem{data: msg, pooled: pooled, msgID: wireID, key: msgID, keyed: true}, opts...); err != nil {
+		if pooled {
+			zbuffer.Put(msg)
+		}
+		return err
+	}
+	return nil
 }
 
 func (c *Connection) SetProperty(key string, value interface{}) {
@@ -522,6 +694,7 @@ func (c *Connection) finalizer() {
 	if c.hc != nil {
 		c.hc.Stop()
 	}
+	c.idle.stop()
 
 	// Close the socket connection
 	_ = c.conn.Close()
@@ -558,6 +731,15 @@ func (c *Connection) callOnConnStop() {
 	}
 }
 
+// callOnSlowConsumer reports an overflowing send queue to the log and the Server hook
+// (将发送队列溢出记录日志并通知Server的Hook函数)
+func (c *Connection) callOnSlowConsumer(event ziface.SlowConsumerEvent) {
+	zlog.Ins().ErrorF("ZINX slow consumer ConnID = %d, priority = %d, queued = %d, policy = %s", c.connID, event.Priority, event.Queued, event.Policy)
+	if c.onSlowConsumer != nil {
+		c.onSlowConsumer(c, event)
+	}
+}
+
 func (c *Connection) IsAlive() bool {
 	if c.isClosed() {
 		return false
@@ -596,6 +778,14 @@ func (c *Connection) isClosed() bool {
 	return c.ctx == nil || c.ctx.Err() != nil
 }
 
+// drainSendQueue blocks until every message queued by SendToQueue has been flushed to the socket
+// (等待SendToQueue入队的消息全部刷新到socket)
+func (c *Connection) drainSendQueue(ctx context.Context) error {
+	return waitUntil(ctx, func() bool {
+		return c.isClosed() || atomic.LoadInt64(&c.pendingSend) == 0
+	})
+}
+
 func (c *Connection) setStartWriterFlag() bool {
 	return atomic.CompareAndSwapInt32(&c.startWriterFlag, 0, 1)
 }
@@ -629,3 +819,49 @@ func (s *Connection) doClose() {
 	// 归还workerid
 	freeWorker(s)
 }
+
+// Call sends an RPC request and waits for the reply, see ziface.IConnection
+// (发送RPC请求并等待应答)
+func (c *Connection) Call(ctx context.Context, msgID uint32, data []byte) (ziface.IMessage, error) {
+	return c.rpc.call(ctx, c, msgID, data)
+}
+
+func (c *Connection) deliverReply(callID uint64, reply rpcReply) bool {
+	return c.rpc.deliver(callID, reply)
+}
+
+func (c *Connection) GetCodec() ziface.ICodec {
+	if c.codec == nil {
+		return zcodec.Default()
+	}
+	return c.codec
+}
+
+func (c *Connection) GetIdentity() interface{} {
+	identity, _ := c.GetProperty(ziface.PropertyIdentity)
+	return identity
+}
+
+func (c *Connection) GetCompressor() ziface.ICompressor {
+	return c.compression.compressor()
+}
+
+func (c *Connection) getEncryption() *connEncryption {
+	return &c.encryption
+}
+
+func (c *Connection) getCompression() *connCompression {
+	return &c.compression
+}
+
+func (c *Connection) GetPeerCertificates() []*x509.Certificate {
+	return peerCertificates(c.conn)
+}
+
+func (c *Connection) SendTyped(msgID uint32, v interface{}) error {
+	data, err := c.GetCodec().Marshal(v)
+	if err != nil {
+		return err
+	}
+	return c.SendMsg(msgID, data)
+}
@@ -0,0 +1,252 @@
+package znet
+
+import (
+	"errors"
+	"sync"
+
+	"github.com/aceld/zinx/ziface"
+	"github.com/aceld/zinx/zlog"
+	"github.com/aceld/zinx/zpack"
+)
+
+// connGroup is a named set of connections, such as a room or an AOI grid
+// (按名称组织的一组连接, 例如房间或AOI格子)
+type connGroup struct {
+	lock    sync.RWMutex
+	members map[uint64]ziface.IConnection
+}
+
+// snapshot copies the members so that they can be used without holding the lock
+// (复制成员列表, 使用时无需持有锁)
+func (g *connGroup) snapshot() []ziface.IConnection {
+	g.lock.RLock()
+	defer g.lock.RUnlock()
+
+	members := make([]ziface.IConnection, 0, len(g.members))
+	for _, conn := range g.members {
+		members = append(members, conn)
+	}
+	return members
+}
+
+// connGroupKey is the close callback key of a group membership
+// (分组成员关系对应的连接关闭回调key)
+type connGroupKey string
+
+// connGroups holds the groups of a ConnManager
+// (ConnManager的所有分组)
+type connGroups struct {
+	lock     sync.RWMutex
+	groups   map[string]*connGroup
+	packet   ziface.IDataPack
+	reliable *reliableManager
+}
+
+func (connMgr *ConnManager) getGroup(name string) (*connGroup, bool) {
+	connMgr.groups.lock.RLock()
+	defer connMgr.groups.lock.RUnlock()
+
+	group, ok := connMgr.groups.groups[name]
+	return group, ok
+}
+
+// setPacket sets the packer used by BroadcastGroup, the Server keeps it the same as its own
+// (设置BroadcastGroup使用的封包方式, 由Server保持与自身一致)
+func (connMgr *ConnManager) setPacket(packet ziface.IDataPack) {
+	connMgr.groups.lock.Lock()
+	defer connMgr.groups.lock.Unlock()
+
+	connMgr.groups.packet = packet
+}
+
+// setReliable sets the reliable sessions BroadcastGroup sends through, see Server.SetReliable
+// (设置BroadcastGroup发送时使用的可靠会话, 参见Server.SetReliable)
+func (connMgr *ConnManager) setReliable(reliable *reliableManager) {
+	connMgr.groups.lock.Lock()
+	defer connMgr.groups.lock.Unlock()
+
+	connMgr.groups.reliable = reliable
+}
+
+func (connMgr *ConnManager) CreateGroup(name string) error {
+	connMgr.groups.lock.Lock()
+	defer connMgr.groups.lock.Unlock()
+
+	if _, ok := connMgr.groups.groups[name]; ok {
+		return errors.New("group already exists")
+	}
+	connMgr.groups.groups[name] = &connGroup{members: make(map[uint64]ziface.IConnection)}
+
+	zlog.Ins().DebugF("group %s created", name)
+	return nil
+}
+
+func (connMgr *ConnManager) DissolveGroup(name string) {
+	connMgr.groups.lock.Lock()
+	group, ok := connMgr.groups.groups[name]
+	delete(connMgr.groups.groups, name)
+	connMgr.groups.lock.Unlock()
+
+	if !ok {
+		return
+	}
+
+	group.lock.Lock()
+	members := group.members
+	group.members = make(map[uint64]ziface.IConnection)
+	group.lock.Unlock()
+
+	for _, conn := range members {
+		conn.RemoveCloseCallback(connMgr, connGroupKey(name))
+	}
+
+	zlog.Ins().DebugF("group %s dissolved, %d members removed", name, len(members))
+}
+
+func (connMgr *ConnManager) JoinGroup(name string, conn ziface.IConnection) error {
+	if !conn.IsAlive() {
+		return errors.New("connection closed when join group")
+	}
+
+	group, ok := connMgr.getGroup(name)
+	if !ok {
+		return errors.New("group not found")
+	}
+
+	group.lock.Lock()
+	_, joined := group.members[conn.GetConnID()]
+	group.members[conn.GetConnID()] = conn
+	group.lock.Unlock()
+
+	if !joined {
+		// Leave the group when the connection is closed
+		// (连接关闭时自动退出分组)
+		conn.AddCloseCallback(connMgr, connGroupKey(name), func() {
+			group.lock.Lock()
+			if group.members[conn.GetConnID()] == conn {
+				delete(group.members, conn.GetConnID())
+			}
+			group.lock.Unlock()
+		})
+	}
+
+	return nil
+}
+
+func (connMgr *ConnManager) LeaveGroup(name string, conn ziface.IConnection) {
+	group, ok := connMgr.getGroup(name)
+	if !ok {
+		return
+	}
+
+	group.lock.Lock()
+	_, joined := group.members[conn.GetConnID()]
+	delete(group.members, conn.GetConnID())
+	group.lock.Unlock()
+
+	if joined {
+		conn.RemoveCloseCallback(connMgr, connGroupKey(name))
+	}
+}
+
+func (connMgr *ConnManager) GetGroupMembers(name string) []ziface.IConnection {
+	group, ok := connMgr.getGroup(name)
+	if !ok {
+		return nil
+	}
+	return group.snapshot()
+}
+
+func (connMgr *ConnManager) GroupLen(name string) int {
+	group, ok := connMgr.getGroup(name)
+	if !ok {
+		return 0
+	}
+
+	group.lock.RLock()
+	defer group.lock.RUnlock()
+
+	return len(group.members)
+}
+
+// BroadcastGroup packs the message once and puts it into the send queue of every member,
+// so a slow member does not hold up the others. Members bound to a reliable session, or with negotiated
+// compression or encryption, get the message through their own send path instead. Send errors of single
+// members are only logged.
+// (只打包一次消息, 放入每个成员的发送队列, 慢速成员不会阻塞其他成员; 绑定了可靠会话或协商了压缩、加密的成员
+// 通过各自的发送流程接收消息。单个成员的发送错误只记录日志)
+func (connMgr *ConnManager) BroadcastGroup(name string, msgID uint32, data []byte, excludeConnIDs ...uint64) error {
+	connMgr.groups.lock.RLock()
+	group, ok := connMgr.groups.groups[name]
+	packet := connMgr.groups.packet
+	reliable := connMgr.groups.reliable
+	connMgr.groups.lock.RUnlock()
+
+	if !ok {
+		return errors.New("group not found")
+	}
+	if packet == nil {
+		packet = zpack.Factory().NewPack(ziface.ZinxDataPack)
+	}
+
+	var msg []byte
+	for _, conn := range group.snapshot() {
+		if excluded(conn.GetConnID(), excludeConnIDs) {
+			continue
+		}
+
+		var session *reliableSession
+		if reliable != nil {
+			session = reliable.get(conn)
+		}
+
+		var err error
+		switch {
+		case session != nil:
+			err = session.Send(msgID, data)
+		case transformed(conn):
+			err = conn.SendBuffMsg(msgID, data)
+		default:
+			if msg == nil {
+				if msg, err = packet.Pack(zpack.NewMsgPackage(msgID, data)); err != nil {
+					return err
+				}
+			}
+			// Keyed by msgID like SendBuffMsg, so the "coalesce" policy applies (与SendBuffMsg一样按msgID标记, 使"coalesce"策略生效)
+			if c, ok := conn.(queueConn); ok {
+				err = c.queue(sendItem{data: msg, msgID: msgID, key: msgID, keyed: true})
+			} else {
+				err = conn.SendToQueue(msg)
+			}
+		}
+		if err != nil {
+			zlog.Ins().ErrorF("broadcast group %s msgID = %d to ConnID = %d err: %v", name, msgID, conn.GetConnID(), err)
+		}
+	}
+
+	return nil
+}
+
+// queueConn is implemented by connections that put sendItem into their send queue
+// (可以将sendItem放入发送队列的连接)
+type queueConn interface {
+	queue(item sendItem, opts ...ziface.MsgSendOption) error
+}
+
+// transformed tells whether conn compresses or encrypts the messages it sends
+// (判断连接是否会压缩或加密发送的消息)
+func transformed(conn ziface.IConnection) bool {
+	if c, ok := conn.(encryptConn); ok && c.getEncryption().isEnabled() {
+		return true
+	}
+	return conn.GetCompressor() != nil
+}
+
+func excluded(connID uint64, excludeConnIDs []uint64) bool {
+	for _, id := range excludeConnIDs {
+		if id == connID {
+			return true
+		}
+	}
+	return false
+}
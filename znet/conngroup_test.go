@@ -0,0 +1,188 @@
+package znet
+
+import (
+	"context"
+	"net"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/aceld/zinx/zconf"
+	"github.com/aceld/zinx/ziface"
+	"github.com/aceld/zinx/zpack"
+)
+
+// run in terminal:
+// go test -v ./znet -run=TestConnGroup
+
+type groupTestConn struct {
+	ziface.IConnection
+	id         uint64
+	lock       sync.Mutex
+	sent       [][]byte
+	buffered   [][]byte
+	callbacks  map[interface{}]func()
+	encryption connEncryption
+}
+
+func newGroupTestConn(id uint64) *groupTestConn {
+	return &groupTestConn{id: id, callbacks: make(map[interface{}]func())}
+}
+
+func (c *groupTestConn) GetConnID() uint64 { return c.id }
+func (c *groupTestConn) IsAlive() bool     { return true }
+
+func (c *groupTestConn) SendToQueue(data []byte, opts ...ziface.MsgSendOption) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.sent = append(c.sent, data)
+	return nil
+}
+
+func (c *groupTestConn) SendBuffMsg(msgID uint32, data []byte, opts ...ziface.MsgSendOption) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.buffered = append(c.buffered, data)
+	return nil
+}
+
+func (c *groupTestConn) GetCompressor() ziface.ICompressor       { return nil }
+func (c *groupTestConn) getEncryption() *connEncryption          { return &c.encryption }
+func (c *groupTestConn) sendMsg(msgID uint32, data []byte) error { return nil }
+func (c *groupTestConn) sendBuffMsg(msgID uint32, data []byte, opts ...ziface.MsgSendOption) error {
+	return nil
+}
+
+func (c *groupTestConn) AddCloseCallback(handler, key interface{}, callback func()) {
+	c.callbacks[key] = callback
+}
+
+func (c *groupTestConn) RemoveCloseCallback(handler, key interface{}) {
+	delete(c.callbacks, key)
+}
+
+func (c *groupTestConn) close() {
+	for _, callback := range c.callbacks {
+		callback()
+	}
+}
+
+func TestConnGroup(t *testing.T) {
+	connMgr := newConnManager()
+	if err := connMgr.CreateGroup("room1"); err != nil {
+		t.Fatalf("create group err: %v", err)
+	}
+	if err := connMgr.CreateGroup("room1"); err == nil {
+		t.Fatal("create an existing group should fail")
+	}
+	if err := connMgr.JoinGroup("room2", newGroupTestConn(9)); err == nil {
+		t.Fatal("join a missing group should fail")
+	}
+
+	conns := []*groupTestConn{newGroupTestConn(1), newGroupTestConn(2), newGroupTestConn(3)}
+	for _, conn := range conns {
+		if err := connMgr.JoinGroup("room1", conn); err != nil {
+			t.Fatalf("join group err: %v", err)
+		}
+	}
+	if n := connMgr.GroupLen("room1"); n != 3 {
+		t.Fatalf("group len = %d, want 3", n)
+	}
+
+	// The sender is excluded and the others get the same packed message
+	// (排除发送者, 其他成员收到同一份打包好的消息)
+	if err := connMgr.BroadcastGroup("room1", 7, []byte("hello"), 1); err != nil {
+		t.Fatalf("broadcast err: %v", err)
+	}
+	if len(conns[0].sent) != 0 || len(conns[1].sent) != 1 || len(conns[2].sent) != 1 {
+		t.Fatalf("sent = %d %d %d, want 0 1 1", len(conns[0].sent), len(conns[1].sent), len(conns[2].sent))
+	}
+	if &conns[1].sent[0][0] != &conns[2].sent[0][0] {
+		t.Fatal("message should be packed only once")
+	}
+	msg, err := zpack.Factory().NewPack(ziface.ZinxDataPack).Unpack(conns[1].sent[0])
+	if err != nil || msg.GetMsgID() != 7 {
+		t.Fatalf("unpack msgID = %v err = %v", msg, err)
+	}
+
+	// An encrypted member gets the message through its own send path (加密的成员通过自身的发送流程接收消息)
+	encrypted := newGroupTestConn(4)
+	encrypted.encryption.enable()
+	if err := connMgr.JoinGroup("room1", encrypted); err != nil {
+		t.Fatalf("join group err: %v", err)
+	}
+	if err := connMgr.BroadcastGroup("room1", 7, []byte("secret"), 1, 2, 3); err != nil {
+		t.Fatalf("broadcast err: %v", err)
+	}
+	if len(encrypted.sent) != 0 || len(encrypted.buffered) != 1 || string(encrypted.buffered[0]) != "secret" {
+		t.Fatalf("encrypted member sent = %d buffered = %q", len(encrypted.sent), encrypted.buffered)
+	}
+	connMgr.LeaveGroup("room1", encrypted)
+
+	// Closed connections leave automatically (连接关闭后自动退出分组)
+	conns[1].close()
+	if n := connMgr.GroupLen("room1"); n != 2 {
+		t.Fatalf("group len after close = %d, want 2", n)
+	}
+
+	connMgr.LeaveGroup("room1", conns[0])
+	if n := connMgr.GroupLen("room1"); n != 1 || len(conns[0].callbacks) != 0 {
+		t.Fatalf("group len after leave = %d callbacks = %d, want 1 0", n, len(conns[0].callbacks))
+	}
+
+	connMgr.DissolveGroup("room1")
+	if members := connMgr.GetGroupMembers("room1"); members != nil || len(conns[2].callbacks) != 0 {
+		t.Fatalf("members after dissolve = %v callbacks = %d", members, len(conns[2].callbacks))
+	}
+	if err := connMgr.BroadcastGroup("room1", 7, nil); err == nil {
+		t.Fatal("broadcast to a dissolved group should fail")
+	}
+}
+
+func TestBroadcastGroupCoalesce(t *testing.T) {
+	withSendQueueConf(t, 3, zconf.SendQueueCoalesce)
+
+	local, remote := net.Pipe()
+	defer local.Close()
+	defer remote.Close()
+	c := newServerConn(NewServer(), local, 1).(*Connection)
+	c.ctx, c.cancel = context.WithCancel(context.Background())
+	c.lastActivityTime = time.Now()
+	defer c.cancel()
+	// No writer, the queue only fills up (不启动写协程, 队列只会被填满)
+	atomic.StoreInt32(&c.startWriterFlag, 1)
+
+	connMgr := newConnManager()
+	if err := connMgr.CreateGroup("room1"); err != nil {
+		t.Fatalf("create group err: %v", err)
+	}
+	if err := connMgr.JoinGroup("room1", c); err != nil {
+		t.Fatalf("join group err: %v", err)
+	}
+	for _, msg := range []struct {
+		msgID uint32
+		data  string
+	}{{1, "a1"}, {2, "b1"}, {1, "a2"}, {1, "a3"}} {
+		if err := connMgr.BroadcastGroup("room1", msg.msgID, []byte(msg.data)); err != nil {
+			t.Fatalf("broadcast err: %v", err)
+		}
+	}
+
+	// The newest broadcast of msgID 1 takes the place of the one queued (msgID 1的最新广播替换队列中的那一条)
+	var got []string
+	for {
+		item, ok := c.sendQueue.pop()
+		if !ok {
+			break
+		}
+		msg, err := zpack.Factory().NewPack(ziface.ZinxDataPack).Unpack(item.data)
+		if err != nil {
+			t.Fatalf("unpack err: %v", err)
+		}
+		got = append(got, string(item.data[len(item.data)-int(msg.GetDataLen()):]))
+	}
+	if len(got) != 3 || got[0] != "a1" || got[1] != "b1" || got[2] != "a3" {
+		t.Fatalf("queued %v, want [a1 b1 a3]", got)
+	}
+}
@@ -6,16 +6,19 @@ import (
 
 	"github.com/aceld/zinx/ziface"
 	"github.com/aceld/zinx/zlog"
+	"github.com/aceld/zinx/zmetrics"
 	"github.com/aceld/zinx/zutils"
 )
 
 type ConnManager struct {
 	connections zutils.ShardLockMaps
+	groups      connGroups
 }
 
 func newConnManager() *ConnManager {
 	return &ConnManager{
 		connections: zutils.NewShardLockMaps(),
+		groups:      connGroups{groups: make(map[string]*connGroup)},
 	}
 }
 
@@ -23,12 +26,20 @@ func (connMgr *ConnManager) Add(conn ziface.IConnection) {
 
 	connMgr.connections.Set(conn.GetConnIdStr(), conn) // 将conn连接添加到ConnManager中
 
+	if zmetrics.Enabled() {
+		zmetrics.Metrics().ConnAdd(conn.GetName())
+	}
+
 	zlog.Ins().DebugF("connection add to ConnManager successfully: conn num = %d", connMgr.Len())
 }
 
 func (connMgr *ConnManager) Remove(conn ziface.IConnection) {
 
-	connMgr.connections.Remove(conn.GetConnIdStr()) // 删除连接信息
+	_, exists := connMgr.connections.Pop(conn.GetConnIdStr()) // 删除连接信息
+
+	if exists && zmetrics.Enabled() {
+		zmetrics.Metrics().ConnRemove(conn.GetName())
+	}
 
 	zlog.Ins().DebugF("connection Remove ConnID=%d successfully: conn num = %d", conn.GetConnID(), connMgr.Len())
 }
@@ -0,0 +1,42 @@
+package znet
+
+import (
+	"hash/fnv"
+
+	"github.com/aceld/zinx/zconf"
+	"github.com/aceld/zinx/ziface"
+)
+
+// SetDispatchKey sets the function choosing the dispatch key of each request in zconf.WorkerModeKey
+// (设置zconf.WorkerModeKey模式下为每个请求选择分发key的函数)
+func (s *Server) SetDispatchKey(fn ziface.DispatchKeyFunc) {
+	if mh, ok := s.msgHandler.(*MsgHandle); ok {
+		mh.dispatchKey = fn
+	}
+}
+
+// taskWorkerID returns the worker whose TaskQueue takes the request. In zconf.WorkerModeKey a request with
+// a dispatch key goes to the worker of the key, every other request goes to the worker of its connection.
+// (返回处理该请求的worker; zconf.WorkerModeKey模式下有分发key的请求交给key所属的worker, 其余请求交给连接所属的worker)
+func (mh *MsgHandle) taskWorkerID(request ziface.IRequest) uint32 {
+	if zconf.GlobalObject.WorkerMode == zconf.WorkerModeKey && mh.WorkerPoolSize > 0 {
+		var key string
+		if req, ok := request.(*RequestFunc); ok {
+			key = req.key
+		} else if mh.dispatchKey != nil {
+			key = mh.dispatchKey(request)
+		}
+		if key != "" {
+			return keyWorkerID(key, mh.WorkerPoolSize)
+		}
+	}
+	return request.GetConnection().GetWorkerID()
+}
+
+// keyWorkerID maps a dispatch key to one of size workers
+// (将分发key映射到size个worker中的一个)
+func keyWorkerID(key string, size uint32) uint32 {
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(key))
+	return h.Sum32() % size
+}
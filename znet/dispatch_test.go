@@ -0,0 +1,106 @@
+package znet
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/aceld/zinx/zconf"
+	"github.com/aceld/zinx/ziface"
+	"github.com/aceld/zinx/zpack"
+)
+
+func newKeyModeMsgHandle(t *testing.T, poolSize uint32) *MsgHandle {
+	mode, size := zconf.GlobalObject.WorkerMode, zconf.GlobalObject.WorkerPoolSize
+	t.Cleanup(func() {
+		zconf.GlobalObject.WorkerMode, zconf.GlobalObject.WorkerPoolSize = mode, size
+	})
+	zconf.GlobalObject.WorkerMode, zconf.GlobalObject.WorkerPoolSize = zconf.WorkerModeKey, poolSize
+	return newMsgHandle()
+}
+
+func TestDispatchKeyWorker(t *testing.T) {
+	mh := newKeyModeMsgHandle(t, 8)
+	mh.dispatchKey = func(request ziface.IRequest) string {
+		return string(request.GetData())
+	}
+
+	conn := &Connection{workerID: 5}
+	room := keyWorkerID("room-1", 8)
+	if got := mh.taskWorkerID(NewRequest(conn, zpack.NewMsgPackage(1, []byte("room-1")))); got != room {
+		t.Fatalf("keyed request on worker %d, want %d", got, room)
+	}
+	if got := mh.taskWorkerID(NewKeyFuncRequest(&Connection{workerID: 2}, "room-1", nil)); got != room {
+		t.Fatalf("keyed func request on worker %d, want %d", got, room)
+	}
+	// No key, the worker of the connection (没有key时使用连接所属的worker)
+	if got := mh.taskWorkerID(NewRequest(conn, zpack.NewMsgPackage(1, nil))); got != 5 {
+		t.Fatalf("request without key on worker %d, want 5", got)
+	}
+	if got := mh.taskWorkerID(NewFuncRequest(conn, nil)); got != 5 {
+		t.Fatalf("func request without key on worker %d, want 5", got)
+	}
+}
+
+func TestDispatchKeyOrder(t *testing.T) {
+	mh := newKeyModeMsgHandle(t, 4)
+	mh.StartWorkerPool()
+	defer func() {
+		for i := range mh.TaskQueue {
+			mh.StopOneWorker(i)
+		}
+	}()
+
+	const keys, conns, perConn = 6, 5, 200
+	var (
+		lock    sync.Mutex
+		last    = make(map[string]map[int]int) // key -> conn -> last seq
+		running = make(map[string]*int32)
+		wg      sync.WaitGroup
+	)
+	for k := 0; k < keys; k++ {
+		key := fmt.Sprintf("room-%d", k)
+		last[key] = make(map[int]int)
+		running[key] = new(int32)
+	}
+
+	// Several connections send to every key at the same time, each key must run single-threaded and in order
+	// (多个连接同时向每个key发送, 每个key必须单线程按顺序执行)
+	wg.Add(conns * keys * perConn)
+	for c := 0; c < conns; c++ {
+		go func(c int) {
+			conn := &Connection{workerID: uint32(c % 4)}
+			for seq := 1; seq <= perConn; seq++ {
+				for k := 0; k < keys; k++ {
+					key, seq := fmt.Sprintf("room-%d", k), seq
+					mh.SendMsgToTaskQueue(NewKeyFuncRequest(conn, key, func() {
+						defer wg.Done()
+						if atomic.AddInt32(running[key], 1) != 1 {
+							t.Errorf("%s runs on two workers", key)
+						}
+						lock.Lock()
+						if last[key][c] != seq-1 {
+							t.Errorf("%s conn %d got seq %d after %d", key, c, seq, last[key][c])
+						}
+						last[key][c] = seq
+						lock.Unlock()
+						atomic.AddInt32(running[key], -1)
+					}))
+				}
+			}
+		}(c)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+		t.Fatal("requests not handled")
+	}
+}
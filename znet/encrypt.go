@@ -0,0 +1,539 @@
+package znet
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/hkdf"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aceld/zinx/ziface"
+	"github.com/aceld/zinx/zlog"
+	"github.com/aceld/zinx/zpack"
+	"golang.org/x/crypto/chacha20poly1305"
+)
+
+const (
+	// maxHeldMsgs is how many messages a connection holds before its key exchange finishes, at most
+	// (密钥交换完成前连接最多暂存的消息数)
+	maxHeldMsgs = 1024
+
+	// replayWindowSize is how far behind the newest sequence number a message may arrive. Buffered messages are
+	// sealed when written, so only concurrent SendMsg calls and unflushed writes reorder them
+	// (消息序号最多可以落后最新序号的距离。缓冲消息在写出时加密, 只有并发的SendMsg和未刷新的写入会导致乱序)
+	replayWindowSize = 1024
+
+	x25519KeyLen = 32
+)
+
+var (
+	errPlaintext   = errors.New("plaintext message on an encrypted connection")
+	errTooManyHeld = errors.New("too many messages sent before the key exchange")
+)
+
+var ciphers = map[string]func(key []byte) (cipher.AEAD, error){
+	ziface.ZinxCipherAESGCM: func(key []byte) (cipher.AEAD, error) {
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			return nil, err
+		}
+		return cipher.NewGCM(block)
+	},
+	ziface.ZinxCipherChaCha20Poly1305: chacha20poly1305.New,
+}
+
+// encryptOption is the encryption setting of a Server or Client
+// (Server或Client的加密设置)
+type encryptOption struct {
+	ciphers []string
+	psk     []byte
+	timeout time.Duration
+}
+
+func newEncryptOption(option *ziface.EncryptOption) *encryptOption {
+	o := &encryptOption{timeout: ziface.EncryptDefaultTimeout}
+	if option != nil {
+		o.ciphers, o.psk = option.Ciphers, option.PSK
+		if option.Timeout > 0 {
+			o.timeout = option.Timeout
+		}
+	}
+	if len(o.ciphers) == 0 {
+		o.ciphers = []string{ziface.ZinxCipherAESGCM, ziface.ZinxCipherChaCha20Poly1305}
+	}
+	for _, name := range o.ciphers {
+		if ciphers[name] == nil {
+			panic("zinx: unknown cipher " + name)
+		}
+	}
+	return o
+}
+
+// choose returns the first accepted cipher the peer offers, or ""
+// (返回对端提供的算法中优先级最高的可接受算法, 没有时返回"")
+func (o *encryptOption) choose(offers []string) string {
+	for _, name := range o.ciphers {
+		for _, offer := range offers {
+			if offer == name {
+				return name
+			}
+		}
+	}
+	return ""
+}
+
+// deriveKeys derives the keys of both directions from the X25519 shared secret and the pre-shared key
+// (根据X25519共享密钥和预共享密钥生成双向的会话密钥)
+func (o *encryptOption) deriveKeys(name string, shared, clientPub, serverPub []byte) (c2s, s2c cipher.AEAD, err error) {
+	secret := append(append([]byte{}, shared...), o.psk...)
+	salt := append(append([]byte{}, clientPub...), serverPub...)
+
+	aeads := make([]cipher.AEAD, 2)
+	for i, info := range []string{"zinx c2s ", "zinx s2c "} {
+		key, err := hkdf.Key(sha256.New, secret, salt, info+name, 32)
+		if err != nil {
+			return nil, nil, err
+		}
+		if aeads[i], err = ciphers[name](key); err != nil {
+			return nil, nil, err
+		}
+	}
+	return aeads[0], aeads[1], nil
+}
+
+// heldMsg is a message sent before the key exchange finished (密钥交换完成前发送的消息)
+type heldMsg struct {
+	msgID    uint32
+	data     []byte
+	buffered bool
+	opts     []ziface.MsgSendOption
+}
+
+// encryptConn is implemented by connections that can encrypt their messages
+// (可以加密消息的连接)
+type encryptConn interface {
+	getEncryption() *connEncryption
+	sendMsg(msgID uint32, data []byte) error
+	sendBuffMsg(msgID uint32, data []byte, opts ...ziface.MsgSendOption) error
+}
+
+// connEncryption is the encryption state of a connection, once enabled plaintext messages other than
+// the key exchange are refused, and the messages sent are held until the session keys are ready
+// (连接的加密状态, 开启后除密钥交换外的明文消息都会被拒绝, 发送的消息暂存到会话密钥就绪)
+type connEncryption struct {
+	lock    sync.Mutex
+	enabled bool
+	ready   bool // The held messages have been sent, messages are sealed directly (暂存的消息已发出, 消息直接加密发送)
+
+	sendAEAD cipher.AEAD
+	sendSeq  uint64
+	held     []heldMsg
+
+	recvAEAD cipher.AEAD
+	window   replayWindow
+
+	// The client's key and setting waiting for the reply of the server (客户端等待服务端应答时的密钥和设置)
+	private *ecdh.PrivateKey
+	offer   *encryptOption
+}
+
+func (e *connEncryption) enable() {
+	e.lock.Lock()
+	e.enabled = true
+	e.lock.Unlock()
+}
+
+func (e *connEncryption) isEnabled() bool {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	return e.enabled
+}
+
+func (e *connEncryption) isReady() bool {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	return e.ready
+}
+
+// hold keeps a message sent before the session keys are ready, it reports whether the message was held
+// (暂存会话密钥就绪前发送的消息, 返回消息是否被暂存)
+func (e *connEncryption) hold(msgID uint32, data []byte, buffered bool, opts []ziface.MsgSendOption) (bool, error) {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	if !e.enabled || e.ready || isExchange(msgID, data) {
+		return false, nil
+	}
+	if len(e.held) >= maxHeldMsgs {
+		return true, errTooManyHeld
+	}
+	e.held = append(e.held, heldMsg{msgID: msgID, data: append([]byte{}, data...), buffered: buffered, opts: opts})
+	return true, nil
+}
+
+// flush sends the held messages and then lets the messages be sealed directly
+// (发出暂存的消息, 之后消息直接加密发送)
+func (e *connEncryption) flush(conn encryptConn) {
+	for {
+		e.lock.Lock()
+		held := e.held
+		e.held = nil
+		if len(held) == 0 {
+			e.ready = true
+			e.lock.Unlock()
+			return
+		}
+		e.lock.Unlock()
+
+		for _, msg := range held {
+			var err error
+			if msg.buffered {
+				err = conn.sendBuffMsg(msg.msgID, msg.data, msg.opts...)
+			} else {
+				err = conn.sendMsg(msg.msgID, msg.data)
+			}
+			if err != nil {
+				zlog.Ins().ErrorF("send held msgID = %d err: %v", msg.msgID, err)
+			}
+		}
+	}
+}
+
+// seal returns the data to send for a message, encrypted with the extended header once the keys are ready
+// (返回消息实际发送的数据, 会话密钥就绪后为带扩展报头的加密数据)
+func (e *connEncryption) seal(msgID uint32, data []byte) []byte {
+	if isExchange(msgID, data) {
+		return data
+	}
+
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	if e.sendAEAD == nil {
+		return data
+	}
+	e.sendSeq++
+	header := zpack.PackEncrypted(e.sendSeq, nil)
+	return e.sendAEAD.Seal(header, encryptNonce(e.sendSeq), data, encryptAAD(msgID, header))
+}
+
+// sealing tells whether a message is sealed by seal. SendBuffMsg leaves such messages to the writer,
+// so that the sequence numbers follow the order the messages are written in, whatever their priority
+// (判断消息是否需要seal加密。SendBuffMsg将这类消息交给写协程加密, 使序号与写出顺序一致而不受优先级影响)
+func (e *connEncryption) sealing(msgID uint32, data []byte) bool {
+	if isExchange(msgID, data) {
+		return false
+	}
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	return e.sendAEAD != nil
+}
+
+// packSealed seals and packs an item queued by SendBuffMsg when the writer takes it
+// (写协程取出SendBuffMsg入队的数据时将其加密封包)
+func (e *connEncryption) packSealed(packet ziface.IDataPack, item sendItem) (sendItem, error) {
+	msg, err := packet.Pack(zpack.NewMsgPackage(item.msgID, e.seal(item.msgID, item.data)))
+	if err != nil {
+		return item, err
+	}
+	return sendItem{data: msg, pooled: zpack.IsPooled(packet), msgID: item.msgID, key: item.key}, nil
+}
+
+// open returns the plaintext of the data of a message received
+// (返回收到的消息数据的明文)
+func (e *connEncryption) open(msgID uint32, data []byte) ([]byte, error) {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	seq, sealed, ok := zpack.UnpackEncrypted(data)
+	if !ok || (!e.enabled && e.recvAEAD == nil) {
+		if e.enabled && !isExchange(msgID, data) {
+			return nil, errPlaintext
+		}
+		return data, nil
+	}
+
+	if e.recvAEAD == nil {
+		return nil, errors.New("encrypted message before the key exchange")
+	}
+	if !e.window.fresh(seq) {
+		return nil, fmt.Errorf("replayed message seq = %d", seq)
+	}
+	plain, err := e.recvAEAD.Open(nil, encryptNonce(seq), sealed, encryptAAD(msgID, data[:zpack.EncryptHeaderLen]))
+	if err != nil {
+		return nil, err
+	}
+	e.window.record(seq)
+	return plain, nil
+}
+
+// isExchange tells whether a message is a call of the key exchange or its reply, which are not encrypted
+// (判断消息是否为密钥交换的调用或其应答, 这些消息不加密)
+func isExchange(msgID uint32, data []byte) bool {
+	return msgID == ziface.EncryptExchangeMsgID ||
+		msgID == ziface.RPCMsgID && zpack.RPCCalledMsgID(data) == ziface.EncryptExchangeMsgID
+}
+
+// finishExchange derives the session keys of the client from the reply of the server,
+// in the reader so that the encrypted messages following the reply can be opened
+// (根据服务端的应答生成客户端的会话密钥, 在读协程中执行, 以便解密紧随应答之后的加密消息)
+func (e *connEncryption) finishExchange(data []byte) error {
+	header, payload, ok := zpack.UnpackRPC(data)
+	if !ok || header.Flag != zpack.RPCFlagReply || header.MsgID != ziface.EncryptExchangeMsgID {
+		return nil
+	}
+
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	if e.private == nil {
+		return nil
+	}
+	private, offer := e.private, e.offer
+	e.private = nil
+
+	if len(payload) <= x25519KeyLen {
+		return errors.New("invalid key exchange reply")
+	}
+	serverPub, err := ecdh.X25519().NewPublicKey(payload[:x25519KeyLen])
+	if err != nil {
+		return err
+	}
+	name := string(payload[x25519KeyLen:])
+	if offer.choose([]string{name}) == "" {
+		return fmt.Errorf("server chose cipher %q not offered", name)
+	}
+	shared, err := private.ECDH(serverPub)
+	if err != nil {
+		return err
+	}
+	c2s, s2c, err := offer.deriveKeys(name, shared, private.PublicKey().Bytes(), serverPub.Bytes())
+	if err != nil {
+		return err
+	}
+	e.sendAEAD, e.recvAEAD = c2s, s2c
+	return nil
+}
+
+func encryptNonce(seq uint64) []byte {
+	nonce := make([]byte, 12)
+	binary.BigEndian.PutUint64(nonce[4:], seq)
+	return nonce
+}
+
+func encryptAAD(msgID uint32, header []byte) []byte {
+	aad := make([]byte, 4, 4+len(header))
+	binary.BigEndian.PutUint32(aad, msgID)
+	return append(aad, header...)
+}
+
+// replayWindow remembers the sequence numbers received recently, messages may arrive out of order
+// within the window, a sequence number received twice or older than the window is refused
+// (记录最近收到的序号, 窗口内的消息可以乱序到达, 重复或早于窗口的序号会被拒绝)
+type replayWindow struct {
+	top  uint64
+	bits [replayWindowSize / 64]uint64
+}
+
+func (w *replayWindow) fresh(seq uint64) bool {
+	if seq == 0 {
+		return false
+	}
+	if seq > w.top {
+		return true
+	}
+	if w.top-seq >= replayWindowSize {
+		return false
+	}
+	i := seq % replayWindowSize
+	return w.bits[i/64]&(1<<(i%64)) == 0
+}
+
+func (w *replayWindow) record(seq uint64) {
+	if seq > w.top {
+		if seq-w.top >= replayWindowSize {
+			w.bits = [replayWindowSize / 64]uint64{}
+		} else {
+			for s := w.top + 1; s < seq; s++ {
+				i := s % replayWindowSize
+				w.bits[i/64] &^= 1 << (i % 64)
+			}
+		}
+		w.top = seq
+	}
+	i := seq % replayWindowSize
+	w.bits[i/64] |= 1 << (i % 64)
+}
+
+// decryptRequest restores the data of an encrypted request before it is routed, the connection is
+// closed when the data can not be opened or a plaintext message arrives on an encrypted connection
+// (在路由前还原加密请求的数据, 数据无法解密或加密连接上收到明文消息时关闭连接)
+func decryptRequest(request ziface.IRequest) bool {
+	msg := request.GetMessage()
+	conn, ok := request.GetConnection().(encryptConn)
+	if msg == nil || !ok {
+		return true
+	}
+
+	e := conn.getEncryption()
+	data, err := e.open(msg.GetMsgID(), msg.GetData())
+	if err == nil && msg.GetMsgID() == ziface.RPCMsgID {
+		err = e.finishExchange(data)
+	}
+	if err != nil {
+		zlog.Ins().ErrorF("ConnID = %d msgID = %d decrypt err: %v, close it", request.GetConnection().GetConnID(), msg.GetMsgID(), err)
+		request.GetConnection().Stop()
+		return false
+	}
+
+	msg.SetData(data)
+	msg.SetDataLen(uint32(len(data)))
+	return true
+}
+
+// begin enables the encryption of a new connection, it is closed if the key exchange does not finish in time
+// (开启新连接的加密, 超时未完成密钥交换则关闭连接)
+func (o *encryptOption) begin(conn ziface.IConnection) {
+	ec, ok := conn.(encryptConn)
+	if !ok {
+		return
+	}
+	e := ec.getEncryption()
+	e.enable()
+
+	timer := time.AfterFunc(o.timeout, func() {
+		if !e.isReady() {
+			zlog.Ins().InfoF("ConnID = %d %s key exchange timeout", conn.GetConnID(), conn.RemoteAddrString())
+			conn.Stop()
+		}
+	})
+	conn.AddCloseCallback(o, nil, func() {
+		timer.Stop()
+	})
+}
+
+// exchangeKeys answers the key exchange of a client with the public key of the server and the cipher chosen,
+// the messages of the connection are encrypted from then on
+// (以服务端公钥和选中的算法应答客户端的密钥交换, 此后连接的消息都会加密)
+func (mh *MsgHandle) exchangeKeys(request ziface.IRequest) {
+	conn, ok := request.GetConnection().(encryptConn)
+	if mh.encrypt == nil || !ok {
+		_ = request.ReplyError(errors.New("encryption not enabled"))
+		return
+	}
+	e := conn.getEncryption()
+
+	data := request.GetData()
+	if len(data) <= x25519KeyLen {
+		_ = request.ReplyError(errors.New("invalid key exchange"))
+		return
+	}
+	clientPub, err := ecdh.X25519().NewPublicKey(data[:x25519KeyLen])
+	if err != nil {
+		_ = request.ReplyError(err)
+		return
+	}
+	name := mh.encrypt.choose(strings.Split(string(data[x25519KeyLen:]), ","))
+	if name == "" {
+		_ = request.ReplyError(fmt.Errorf("none of the ciphers %s accepted", data[x25519KeyLen:]))
+		return
+	}
+
+	private, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		_ = request.ReplyError(err)
+		return
+	}
+	shared, err := private.ECDH(clientPub)
+	if err != nil {
+		_ = request.ReplyError(err)
+		return
+	}
+	c2s, s2c, err := mh.encrypt.deriveKeys(name, shared, clientPub.Bytes(), private.PublicKey().Bytes())
+	if err != nil {
+		_ = request.ReplyError(err)
+		return
+	}
+
+	e.lock.Lock()
+	exchanged := e.recvAEAD != nil
+	if !exchanged {
+		e.recvAEAD = c2s
+	}
+	e.lock.Unlock()
+	if exchanged {
+		_ = request.ReplyError(errors.New("keys already exchanged"))
+		return
+	}
+
+	// The client opens the messages following the reply with the new keys (客户端使用新密钥解密应答之后的消息)
+	if err := request.Reply(append(private.PublicKey().Bytes(), name...)); err != nil {
+		return
+	}
+	e.lock.Lock()
+	e.sendAEAD = s2c
+	e.lock.Unlock()
+	e.flush(conn)
+	zlog.Ins().DebugF("ConnID = %d encrypts messages with %s", request.GetConnection().GetConnID(), name)
+}
+
+// exchangeKeys runs the X25519 key exchange with the server and sends the held messages encrypted,
+// the connection is closed if it fails
+// (与服务端进行X25519密钥交换并加密发送暂存的消息, 失败时关闭连接)
+func (c *Client) exchangeKeys(conn ziface.IConnection) bool {
+	ec, ok := conn.(encryptConn)
+	if !ok {
+		return false
+	}
+	e := ec.getEncryption()
+
+	private, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		zlog.Ins().ErrorF("key exchange err: %v", err)
+		conn.Stop()
+		return false
+	}
+	e.lock.Lock()
+	e.private, e.offer = private, c.encrypt
+	e.lock.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), c.encrypt.timeout)
+	defer cancel()
+
+	data := append(private.PublicKey().Bytes(), strings.Join(c.encrypt.ciphers, ",")...)
+	if _, err := conn.Call(ctx, ziface.EncryptExchangeMsgID, data); err != nil {
+		zlog.Ins().ErrorF("key exchange err: %v, close the connection", err)
+		conn.Stop()
+		return false
+	}
+
+	// The keys were derived by the reader when the reply arrived (应答到达时读协程已生成会话密钥)
+	e.lock.Lock()
+	derived := e.sendAEAD != nil
+	e.lock.Unlock()
+	if !derived {
+		zlog.Ins().ErrorF("key exchange failed, close the connection")
+		conn.Stop()
+		return false
+	}
+	e.flush(ec)
+	return true
+}
+
+// SetEncryption encrypts the messages of every new connection with the keys exchanged with the client,
+// plaintext messages are refused
+// (使用与客户端交换的密钥加密每个新连接的消息, 明文消息会被拒绝)
+func (s *Server) SetEncryption(option *ziface.EncryptOption) {
+	s.encrypt = newEncryptOption(option)
+	if mh, ok := s.msgHandler.(*MsgHandle); ok {
+		mh.encrypt = s.encrypt
+	}
+}
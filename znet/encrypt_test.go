@@ -0,0 +1,172 @@
+package znet
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/aceld/zinx/zconf"
+	"github.com/aceld/zinx/ziface"
+	"github.com/aceld/zinx/zpack"
+)
+
+// run in terminal:
+// go test -v ./znet -run=TestEncryption
+
+// NotifyRouter hands the data of the requests to a channel (将请求数据交给channel)
+type NotifyRouter struct {
+	BaseRouter
+	data chan string
+}
+
+func (r *NotifyRouter) Handle(req ziface.IRequest) {
+	r.data <- string(req.GetData())
+}
+
+func TestEncryption(t *testing.T) {
+	conf := *zconf.GlobalObject
+	conf.Name = "EncryptionTest"
+	conf.Host = "127.0.0.1"
+	conf.TCPPort = 19012
+
+	psk := []byte("shared-secret")
+	notify := &NotifyRouter{data: make(chan string, 1)}
+	s := newServerWithConfig(&conf, "tcp", WithEncryption(&ziface.EncryptOption{PSK: psk, Timeout: time.Second}))
+	s.AddRouter(1, &RPCEchoRouter{})
+	s.AddRouter(2, notify)
+	s.Start()
+	defer s.Stop()
+	time.Sleep(time.Second * 1)
+
+	client := NewClient("127.0.0.1", 19012, WithEncryptionClient(&ziface.EncryptOption{
+		Ciphers: []string{ziface.ZinxCipherChaCha20Poly1305},
+		PSK:     psk,
+	})).(*Client)
+	// Sent before the key exchange, held and then sent encrypted (在密钥交换前发送, 暂存后加密发送)
+	client.SetOnConnStart(func(conn ziface.IConnection) {
+		_ = conn.SendMsg(2, []byte("hello"))
+	})
+	client.Start()
+	defer client.Stop()
+
+	select {
+	case data := <-notify.data:
+		if data != "hello" {
+			t.Fatalf("held message = %q", data)
+		}
+	case <-time.After(time.Second * 3):
+		t.Fatal("held message not received")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+	defer cancel()
+	reply, err := client.Conn().Call(ctx, 1, []byte("secret"))
+	if err != nil {
+		t.Fatalf("call err: %v", err)
+	}
+	if string(reply.GetData()) != "echo:secret" {
+		t.Fatalf("reply = %q", reply.GetData())
+	}
+
+	// A client with another pre-shared key is closed (预共享密钥不同的客户端被关闭)
+	other := NewClient("127.0.0.1", 19012, WithEncryptionClient(&ziface.EncryptOption{PSK: []byte("wrong")})).(*Client)
+	other.Start()
+	defer other.Stop()
+	time.Sleep(time.Millisecond * 300)
+	if _, err := other.Conn().Call(ctx, 1, []byte("secret")); err == nil {
+		t.Fatal("client with another pre-shared key answered")
+	}
+
+	// Plaintext messages close the connection (明文消息会导致连接被关闭)
+	raw, err := net.Dial("tcp", "127.0.0.1:19012")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer raw.Close()
+	msg, _ := zpack.Factory().NewPack(ziface.ZinxDataPack).Pack(zpack.NewMsgPackage(1, []byte("plain")))
+	_, _ = raw.Write(msg)
+	_ = raw.SetReadDeadline(time.Now().Add(time.Second * 2))
+	if n, err := raw.Read(make([]byte, 64)); err == nil {
+		t.Fatalf("plaintext message answered with %d bytes", n)
+	}
+}
+
+func TestConnEncryptionReplay(t *testing.T) {
+	option := newEncryptOption(nil)
+	c2s, _, err := option.deriveKeys(ziface.ZinxCipherAESGCM, []byte("shared"), []byte("client"), []byte("server"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sender := &connEncryption{sendAEAD: c2s}
+	receiver := &connEncryption{enabled: true, recvAEAD: c2s}
+
+	first, second := sender.seal(1, []byte("first")), sender.seal(1, []byte("second"))
+
+	// Out of order within the window is fine (窗口内可以乱序)
+	if data, err := receiver.open(1, second); err != nil || string(data) != "second" {
+		t.Fatalf("open second: %q %v", data, err)
+	}
+	if data, err := receiver.open(1, first); err != nil || string(data) != "first" {
+		t.Fatalf("open first: %q %v", data, err)
+	}
+	// Replays, tampering and plaintext are refused (拒绝重放、篡改和明文)
+	if _, err := receiver.open(1, second); err == nil {
+		t.Fatal("replay accepted")
+	}
+	third := sender.seal(1, []byte("third"))
+	if _, err := receiver.open(2, third); err == nil {
+		t.Fatal("message with another msgID accepted")
+	}
+	tampered := bytes.Clone(third)
+	tampered[len(tampered)-1] ^= 1
+	if _, err := receiver.open(1, tampered); err == nil {
+		t.Fatal("tampered message accepted")
+	}
+	if _, err := receiver.open(1, []byte("plain")); err != errPlaintext {
+		t.Fatalf("plaintext err = %v", err)
+	}
+}
+
+func TestConnEncryptionPriorities(t *testing.T) {
+	option := newEncryptOption(nil)
+	c2s, _, err := option.deriveKeys(ziface.ZinxCipherAESGCM, []byte("shared"), []byte("client"), []byte("server"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sender := &connEncryption{enabled: true, ready: true, sendAEAD: c2s}
+	receiver := &connEncryption{enabled: true, recvAEAD: c2s}
+	packet := zpack.Factory().NewPack(ziface.ZinxDataPack)
+
+	// High priority messages overtake a full low priority queue, further than the replay window
+	// (高优先级消息越过已满的低优先级队列, 距离超过重放窗口)
+	q := newSendQueue(zconf.SendQueueDropNewest)
+	n := replayWindowSize + 100
+	q.queues[ziface.MsgPriorityLow].limit, q.queues[ziface.MsgPriorityHigh].limit = n, n
+	for _, priority := range []ziface.MsgPriority{ziface.MsgPriorityLow, ziface.MsgPriorityHigh} {
+		for i := 0; i < n; i++ {
+			if !sender.sealing(1, []byte("data")) {
+				t.Fatal("message not sealed by the writer")
+			}
+			item := sendItem{data: []byte("data"), msgID: 1, key: 1, keyed: true, seal: true}
+			if _, _, err := q.push(item, ziface.MsgSendOptionObj{Priority: priority}, nil); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	for i := 0; i < 2*n; i++ {
+		item, ok := q.pop()
+		if !ok {
+			t.Fatalf("queue empty after %d messages", i)
+		}
+		if item, err = sender.packSealed(packet, item); err != nil {
+			t.Fatal(err)
+		}
+		data := item.data[packet.GetHeadLen():]
+		if plain, err := receiver.open(1, data); err != nil || string(plain) != "data" {
+			t.Fatalf("open message %d: %q %v", i, plain, err)
+		}
+	}
+}
@@ -0,0 +1,332 @@
+package znet
+
+import (
+	"errors"
+	"net"
+	"runtime"
+	"sync"
+	"syscall"
+
+	"github.com/aceld/zinx/zconf"
+	"github.com/aceld/zinx/ziface"
+	"github.com/aceld/zinx/zlog"
+	"github.com/aceld/zinx/zmetrics"
+)
+
+// epollEvents are the events every connection is registered with, level triggered
+// (每个连接注册的事件, 水平触发)
+const epollEvents = syscall.EPOLLIN | syscall.EPOLLRDHUP
+
+// loopInboxLen is how many requests of a connection may wait for its interceptors before the loop stops reading it
+// (连接等待拦截器处理的请求达到该数量时, 事件循环暂停读取该连接)
+const loopInboxLen = 1024
+
+// poller spreads the connections of a Server over a few epoll event loops,
+// so that an idle connection costs neither a reader goroutine nor a read buffer.
+// (将Server的连接分散到少量的epoll事件循环中, 空闲连接不再占用读协程和读缓冲)
+type poller struct {
+	loops []*eventLoop
+	next  uint32
+	lock  sync.Mutex
+}
+
+// eventLoop is one epoll instance served by one goroutine, all its connections share one read buffer
+// (一个epoll实例及其事件循环协程, 循环内的所有连接共用一个读缓冲)
+type eventLoop struct {
+	epfd int
+	// Pipe used to wake up EpollWait when the loop is closed (关闭时用于唤醒EpollWait的管道)
+	wakeR, wakeW int
+
+	lock  sync.Mutex
+	conns map[int]*Connection
+	fds   map[*Connection]int
+}
+
+// loopInbox hands the requests an event loop decodes to a goroutine of their connection, started on demand,
+// which runs the interceptors in order. Interceptors may block, such as a rate limit or a full worker queue,
+// this holds up their connection only and never the other connections of the loop.
+// (将事件循环解码出的请求交给按需启动的连接协程, 由其按顺序执行拦截器。拦截器可以阻塞, 例如限流或worker队列已满,
+// 只会影响该连接, 不会影响事件循环中的其他连接)
+type loopInbox struct {
+	lock     sync.Mutex
+	requests []ziface.IRequest
+	running  bool
+	paused   bool // The loop stopped reading the connection until the inbox drains (事件循环暂停读取, 直到请求处理完)
+}
+
+func newPoller(loops int) (*poller, error) {
+	if loops <= 0 {
+		loops = runtime.NumCPU()
+	}
+
+	p := &poller{}
+	for i := 0; i < loops; i++ {
+		l, err := newEventLoop()
+		if err != nil {
+			p.close()
+			return nil, err
+		}
+		p.loops = append(p.loops, l)
+		go l.run()
+	}
+
+	zlog.Ins().InfoF("[START] epoll poller with %d event loops", loops)
+	return p, nil
+}
+
+// attach binds conn to one of the event loops in turn, TLS connections keep their reader goroutine
+// since the bytes on the socket are not the plain frames
+// (轮流为conn分配事件循环, TLS连接的数据需要解密, 仍然使用读协程)
+func (p *poller) attach(conn *Connection) {
+	if _, ok := conn.conn.(*net.TCPConn); !ok {
+		return
+	}
+
+	p.lock.Lock()
+	conn.loop = p.loops[p.next%uint32(len(p.loops))]
+	p.next++
+	p.lock.Unlock()
+}
+
+func (p *poller) close() {
+	for _, l := range p.loops {
+		l.close()
+	}
+}
+
+func newEventLoop() (*eventLoop, error) {
+	epfd, err := syscall.EpollCreate1(syscall.EPOLL_CLOEXEC)
+	if err != nil {
+		return nil, err
+	}
+
+	var pipe [2]int
+	if err := syscall.Pipe2(pipe[:], syscall.O_NONBLOCK|syscall.O_CLOEXEC); err != nil {
+		_ = syscall.Close(epfd)
+		return nil, err
+	}
+	if err := syscall.EpollCtl(epfd, syscall.EPOLL_CTL_ADD, pipe[0], &syscall.EpollEvent{Events: syscall.EPOLLIN, Fd: int32(pipe[0])}); err != nil {
+		_ = syscall.Close(epfd)
+		_ = syscall.Close(pipe[0])
+		_ = syscall.Close(pipe[1])
+		return nil, err
+	}
+
+	return &eventLoop{
+		epfd:  epfd,
+		wakeR: pipe[0],
+		wakeW: pipe[1],
+		conns: make(map[int]*Connection),
+		fds:   make(map[*Connection]int),
+	}, nil
+}
+
+// add registers the socket of conn to the loop, from now on the loop reads it
+// (将conn的socket注册到事件循环, 此后由事件循环负责读取)
+func (l *eventLoop) add(conn *Connection) error {
+	raw, err := conn.conn.(*net.TCPConn).SyscallConn()
+	if err != nil {
+		return err
+	}
+	conn.rawConn = raw
+
+	var ctlErr error
+	err = raw.Control(func(fd uintptr) {
+		l.lock.Lock()
+		defer l.lock.Unlock()
+
+		if l.epfd < 0 {
+			ctlErr = errors.New("event loop closed")
+			return
+		}
+		ctlErr = syscall.EpollCtl(l.epfd, syscall.EPOLL_CTL_ADD, int(fd), &syscall.EpollEvent{Events: epollEvents, Fd: int32(fd)})
+		if ctlErr == nil {
+			l.conns[int(fd)] = conn
+			l.fds[conn] = int(fd)
+		}
+	})
+	if err != nil {
+		return err
+	}
+	return ctlErr
+}
+
+// remove unregisters conn, it is safe to call more than once
+// (注销conn, 可重复调用)
+func (l *eventLoop) remove(conn *Connection) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	fd, ok := l.fds[conn]
+	if !ok || l.epfd < 0 {
+		return
+	}
+	delete(l.fds, conn)
+	if l.conns[fd] == conn {
+		delete(l.conns, fd)
+	}
+	// Fails with EBADF when the socket is already closed, the kernel has removed it by then
+	// (socket已关闭时返回EBADF, 此时内核已自动将其移除)
+	_ = syscall.EpollCtl(l.epfd, syscall.EPOLL_CTL_DEL, fd, nil)
+}
+
+func (l *eventLoop) close() {
+	_, _ = syscall.Write(l.wakeW, []byte{0})
+}
+
+func (l *eventLoop) run() {
+	defer func() {
+		l.lock.Lock()
+		defer l.lock.Unlock()
+
+		_ = syscall.Close(l.epfd)
+		_ = syscall.Close(l.wakeR)
+		_ = syscall.Close(l.wakeW)
+		l.epfd = -1
+	}()
+
+	events := make([]syscall.EpollEvent, 256)
+	buffer := make([]byte, zconf.GlobalObject.IOReadBuffSize)
+
+	for {
+		n, err := syscall.EpollWait(l.epfd, events, -1)
+		if err != nil {
+			if errors.Is(err, syscall.EINTR) {
+				continue
+			}
+			zlog.Ins().ErrorF("epoll wait err: %v, event loop exit", err)
+			return
+		}
+
+		for i := 0; i < n; i++ {
+			fd := int(events[i].Fd)
+			if fd == l.wakeR {
+				return
+			}
+
+			l.lock.Lock()
+			conn := l.conns[fd]
+			l.lock.Unlock()
+			if conn == nil {
+				continue
+			}
+
+			l.read(conn, buffer)
+		}
+	}
+}
+
+// read reads what is available on the socket of conn once, the rest is reported again by the level triggered epoll
+// (读取一次conn的socket上可读的数据, 剩余的数据由水平触发的epoll再次通知)
+func (l *eventLoop) read(conn *Connection, buffer []byte) {
+	defer func() {
+		if err := recover(); err != nil {
+			zlog.Ins().ErrorF("connID=%d, panic err=%v", conn.GetConnID(), err)
+			l.remove(conn)
+			conn.Stop()
+		}
+	}()
+
+	var n int
+	var readErr error
+	err := conn.rawConn.Read(func(fd uintptr) bool {
+		n, readErr = syscall.Read(int(fd), buffer)
+		return true
+	})
+	if err == nil {
+		err = readErr
+	}
+	if errors.Is(err, syscall.EAGAIN) {
+		return
+	}
+	if err != nil || n <= 0 {
+		// n == 0 means the peer closed the connection (n为0表示对端关闭了连接)
+		zlog.Ins().ErrorF("read msg head [read datalen=%d], error = %v", n, err)
+		l.remove(conn)
+		conn.Stop()
+		return
+	}
+
+	if zmetrics.Enabled() {
+		zmetrics.Metrics().ReceivedBytes(conn.name, n)
+	}
+
+	// The buffer is shared by the loop, so data not split by a frame decoder is copied
+	// (读缓冲由整个事件循环共用, 未经断粘包解码器拆分的数据需要拷贝)
+	data := buffer[:n]
+	if conn.frameDecoder == nil {
+		data = append([]byte(nil), data...)
+	}
+	var requests []ziface.IRequest
+	conn.decodeData(data, func(request ziface.IRequest) {
+		requests = append(requests, request)
+	})
+	if len(requests) > 0 {
+		l.deliver(conn, requests)
+	}
+}
+
+// deliver queues the requests of conn for its goroutine, a connection whose requests pile up is no longer read
+// (将请求放入conn的队列交给连接协程处理, 请求堆积的连接暂停读取)
+func (l *eventLoop) deliver(conn *Connection, requests []ziface.IRequest) {
+	in := &conn.inbox
+	in.lock.Lock()
+	in.requests = append(in.requests, requests...)
+	start := !in.running
+	in.running = true
+	pause := !in.paused && len(in.requests) >= loopInboxLen
+	if pause {
+		in.paused = true
+	}
+	in.lock.Unlock()
+
+	if pause {
+		l.watch(conn, 0)
+	}
+	if start {
+		go l.serve(conn)
+	}
+}
+
+// serve runs the interceptors of the queued requests of conn until none is left
+// (为conn队列中的请求执行拦截器, 直到队列为空)
+func (l *eventLoop) serve(conn *Connection) {
+	defer func() {
+		if err := recover(); err != nil {
+			zlog.Ins().ErrorF("connID=%d, panic err=%v", conn.GetConnID(), err)
+			l.remove(conn)
+			conn.Stop()
+		}
+	}()
+
+	in := &conn.inbox
+	for {
+		in.lock.Lock()
+		if len(in.requests) == 0 {
+			resume := in.paused
+			in.running, in.paused, in.requests = false, false, nil
+			in.lock.Unlock()
+			if resume {
+				l.watch(conn, epollEvents)
+			}
+			return
+		}
+		request := in.requests[0]
+		in.requests[0] = nil
+		in.requests = in.requests[1:]
+		in.lock.Unlock()
+
+		conn.msgHandler.Execute(request)
+	}
+}
+
+// watch changes the events conn is registered with, 0 stops reading it
+// (修改conn注册的事件, 为0时暂停读取)
+func (l *eventLoop) watch(conn *Connection, events uint32) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	if fd, ok := l.fds[conn]; ok && l.epfd >= 0 {
+		_ = syscall.EpollCtl(l.epfd, syscall.EPOLL_CTL_MOD, fd, &syscall.EpollEvent{Events: events, Fd: int32(fd)})
+	}
+}
@@ -0,0 +1,134 @@
+package znet
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/aceld/zinx/zconf"
+	"github.com/aceld/zinx/ziface"
+)
+
+// run in terminal:
+// go test -v ./znet -run=TestEpollServer
+
+func TestEpollServer(t *testing.T) {
+	mode := zconf.GlobalObject.Mode
+	zconf.GlobalObject.Mode = zconf.ServerModeEpoll
+	defer func() { zconf.GlobalObject.Mode = mode }()
+
+	conf := *zconf.GlobalObject
+	conf.Name = "EpollTest"
+	conf.Host = "127.0.0.1"
+	conf.TCPPort = 19005
+
+	s := newServerWithConfig(&conf, "tcp")
+	s.AddRouter(1, &RPCEchoRouter{})
+	s.Start()
+	defer s.Stop()
+	time.Sleep(time.Second * 1)
+
+	if s.(*Server).poller == nil {
+		t.Fatal("epoll poller not started")
+	}
+
+	clients := make([]*Client, 3)
+	for i := range clients {
+		clients[i] = NewClient("127.0.0.1", 19005).(*Client)
+		clients[i].Start()
+	}
+	time.Sleep(time.Second * 1)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+	defer cancel()
+	for _, client := range clients {
+		conn := client.Conn()
+		if conn == nil {
+			t.Fatal("client not connected")
+		}
+		for _, data := range []string{"a", "bb", "ccc"} {
+			reply, err := conn.Call(ctx, 1, []byte(data))
+			if err != nil {
+				t.Fatalf("call err: %v", err)
+			}
+			if string(reply.GetData()) != "echo:"+data {
+				t.Fatalf("reply = %q, want %q", reply.GetData(), "echo:"+data)
+			}
+		}
+	}
+	if n := s.GetConnMgr().Len(); n != len(clients) {
+		t.Fatalf("conn num = %d, want %d", n, len(clients))
+	}
+
+	// Connections closed by the peer are removed by the event loop (对端关闭的连接由事件循环移除)
+	for _, client := range clients {
+		_ = client.Conn().GetConnection().Close()
+		client.Stop()
+	}
+	deadline := time.Now().Add(time.Second * 3)
+	for s.GetConnMgr().Len() != 0 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond * 50)
+	}
+	if n := s.GetConnMgr().Len(); n != 0 {
+		t.Fatalf("conn num after close = %d, want 0", n)
+	}
+}
+
+// blockInterceptor holds the requests of msgID 2 until release is closed (阻塞msgID为2的请求, 直到release被关闭)
+type blockInterceptor struct {
+	release chan struct{}
+}
+
+func (i *blockInterceptor) Intercept(chain ziface.IChain) ziface.IcResp {
+	if request, ok := chain.Request().(ziface.IRequest); ok && request.GetMsgID() == 2 {
+		<-i.release
+	}
+	return chain.Proceed(chain.Request())
+}
+
+func TestEpollBlockingInterceptor(t *testing.T) {
+	mode, loops := zconf.GlobalObject.Mode, zconf.GlobalObject.EpollLoops
+	zconf.GlobalObject.Mode, zconf.GlobalObject.EpollLoops = zconf.ServerModeEpoll, 1
+	defer func() { zconf.GlobalObject.Mode, zconf.GlobalObject.EpollLoops = mode, loops }()
+
+	conf := *zconf.GlobalObject
+	conf.Name = "EpollBlockTest"
+	conf.Host = "127.0.0.1"
+	conf.TCPPort = 19024
+
+	blocker := &blockInterceptor{release: make(chan struct{})}
+	s := newServerWithConfig(&conf, "tcp")
+	s.AddInterceptor(blocker)
+	s.AddRouter(1, &RPCEchoRouter{})
+	s.AddRouter(2, &RPCEchoRouter{})
+	s.Start()
+	defer s.Stop()
+	time.Sleep(time.Second * 1)
+
+	blocked, other := NewClient("127.0.0.1", 19024).(*Client), NewClient("127.0.0.1", 19024).(*Client)
+	blocked.Start()
+	defer blocked.Stop()
+	other.Start()
+	defer other.Stop()
+	time.Sleep(time.Second * 1)
+
+	// Both connections share the only event loop, the blocked interceptor holds up its own connection only
+	// (两个连接共用唯一的事件循环, 阻塞的拦截器只影响自身的连接)
+	if err := blocked.Conn().SendMsg(2, []byte("wait")); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(time.Millisecond * 100)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+	defer cancel()
+	reply, err := other.Conn().Call(ctx, 1, []byte("free"))
+	if err != nil || string(reply.GetData()) != "echo:free" {
+		t.Fatalf("call beside a blocked interceptor: %v %v", reply, err)
+	}
+
+	close(blocker.release)
+	reply, err = blocked.Conn().Call(ctx, 1, []byte("after"))
+	if err != nil || string(reply.GetData()) != "echo:after" {
+		t.Fatalf("call after release: %v %v", reply, err)
+	}
+}
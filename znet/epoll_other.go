@@ -0,0 +1,28 @@
+//go:build !linux
+
+package znet
+
+import (
+	"errors"
+)
+
+// eventLoop is only implemented on Linux (仅在Linux下实现)
+type eventLoop struct{}
+
+type poller struct{}
+
+type loopInbox struct{}
+
+func newPoller(loops int) (*poller, error) {
+	return nil, errors.New("epoll mode is only supported on linux")
+}
+
+func (p *poller) attach(conn *Connection) {}
+
+func (p *poller) close() {}
+
+func (l *eventLoop) add(conn *Connection) error {
+	return errors.New("epoll mode is only supported on linux")
+}
+
+func (l *eventLoop) remove(conn *Connection) {}
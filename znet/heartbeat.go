@@ -2,15 +2,17 @@ package znet
 
 import (
 	"fmt"
+	"sync"
 	"time"
 
+	"github.com/aceld/zinx/zconf"
 	"github.com/aceld/zinx/ziface"
 	"github.com/aceld/zinx/zlog"
 )
 
 type HeartbeatChecker struct {
-	interval time.Duration //  Heartbeat detection interval(心跳检测时间间隔)
-	quitChan chan bool     // Quit signal(退出信号)
+	interval *heartbeatInterval //  Heartbeat detection interval, shared with the clones(心跳检测时间间隔, 与克隆出的检测器共享)
+	quitChan chan bool          // Quit signal(退出信号)
 
 	makeMsg ziface.HeartBeatMsgFunc //User-defined heartbeat message processing method(用户自定义的心跳检测消息处理方法)
 
@@ -24,6 +26,56 @@ type HeartbeatChecker struct {
 	beatFunc ziface.HeartBeatFunc // // User-defined heartbeat sending function(用户自定义心跳发送函数)
 }
 
+// heartbeatInterval is the interval shared by a checker and its clones, running checkers follow its changes
+// (检测器与其克隆共享的检测间隔, 运行中的检测器跟随其变化)
+type heartbeatInterval struct {
+	lock     sync.Mutex
+	interval time.Duration
+	changed  chan struct{} // Closed when the interval changes(间隔变化时关闭)
+}
+
+func newHeartbeatInterval(interval time.Duration) *heartbeatInterval {
+	return &heartbeatInterval{interval: interval, changed: make(chan struct{})}
+}
+
+func (i *heartbeatInterval) get() (time.Duration, <-chan struct{}) {
+	i.lock.Lock()
+	defer i.lock.Unlock()
+
+	return i.interval, i.changed
+}
+
+// scale keeps the ratio of the interval to HeartbeatMax when HeartbeatMax changes from oldMax to newMax
+// (HeartbeatMax从oldMax变为newMax时, 保持检测间隔与HeartbeatMax的比例)
+func (i *heartbeatInterval) scale(oldMax, newMax int) {
+	if oldMax <= 0 || newMax <= 0 || oldMax == newMax {
+		return
+	}
+
+	i.lock.Lock()
+	defer i.lock.Unlock()
+
+	interval := i.interval * time.Duration(newMax) / time.Duration(oldMax)
+	if interval <= 0 {
+		return
+	}
+	i.interval = interval
+	close(i.changed)
+	i.changed = make(chan struct{})
+}
+
+// followHeartbeatMax scales the interval of checker and its running clones after HeartbeatMax of zconf.GlobalObject is hot reloaded,
+// the connections check HeartbeatMax of zconf.GlobalObject
+// (zconf.GlobalObject的HeartbeatMax热加载后调整checker及其运行中克隆的检测间隔, 连接根据zconf.GlobalObject的HeartbeatMax判断存活)
+func followHeartbeatMax(checker ziface.IHeartbeatChecker, old, conf *zconf.Config, report *zconf.ReloadReport) {
+	if conf != zconf.GlobalObject || !report.Changed("HeartbeatMax") {
+		return
+	}
+	if hc, ok := checker.(*HeartbeatChecker); ok {
+		hc.interval.scale(old.HeartbeatMax, conf.HeartbeatMax)
+	}
+}
+
 /*
 Default callback routing business for receiving remote heartbeat messages
 (收到remote心跳消息的默认回调路由业务)
@@ -54,7 +106,7 @@ func notAliveDefaultFunc(conn ziface.IConnection) {
 
 func NewHeartbeatChecker(interval time.Duration) ziface.IHeartbeatChecker {
 	heartbeat := &HeartbeatChecker{
-		interval: interval,
+		interval: newHeartbeatInterval(interval),
 		quitChan: make(chan bool),
 
 		// Use default heartbeat message generation function and remote connection not alive handling method
@@ -103,11 +155,15 @@ func (h *HeartbeatChecker) BindRouterSlices(msgID uint32, handlers ...ziface.Rou
 }
 
 func (h *HeartbeatChecker) start() {
-	ticker := time.NewTicker(h.interval)
+	interval, changed := h.interval.get()
+	ticker := time.NewTicker(interval)
 	for {
 		select {
 		case <-ticker.C:
 			h.check()
+		case <-changed:
+			interval, changed = h.interval.get()
+			ticker.Reset(interval)
 		case <-h.quitChan:
 			ticker.Stop()
 			return
@@ -0,0 +1,45 @@
+package znet
+
+import (
+	"testing"
+	"time"
+
+	"github.com/aceld/zinx/zconf"
+	"github.com/aceld/zinx/ziface"
+)
+
+// run in terminal:
+// go test -v ./znet -run=TestHeartbeatFollowsReload
+
+func TestHeartbeatFollowsReload(t *testing.T) {
+	beats := make(chan struct{}, 1)
+	checker := NewHeartbeatChecker(time.Hour)
+	checker.SetHeartbeatFunc(func(conn ziface.IConnection) error {
+		select {
+		case beats <- struct{}{}:
+		default:
+		}
+		return nil
+	})
+
+	// A running clone follows the interval of the checker it was cloned from (运行中的克隆跟随原检测器的间隔)
+	running := checker.Clone().(*HeartbeatChecker)
+	running.conn = newGroupTestConn(1)
+	running.Start()
+	defer running.Stop()
+
+	// HeartbeatMax going from 360000s to its current value shrinks the hour to 100ms at the default 10s
+	// (HeartbeatMax从360000秒变为当前值, 默认10秒时一小时缩短为100毫秒)
+	old := &zconf.Config{HeartbeatMax: 360000}
+	followHeartbeatMax(checker, old, zconf.GlobalObject, &zconf.ReloadReport{Applied: []string{"HeartbeatMax"}})
+
+	want := time.Hour * time.Duration(zconf.GlobalObject.HeartbeatMax) / 360000
+	if interval, _ := running.interval.get(); interval != want {
+		t.Fatalf("interval = %v, want %v", interval, want)
+	}
+	select {
+	case <-beats:
+	case <-time.After(want + 2*time.Second):
+		t.Fatal("running checker kept the old interval")
+	}
+}
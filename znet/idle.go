@@ -0,0 +1,173 @@
+package znet
+
+import (
+	"math"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/aceld/zinx/zconf"
+	"github.com/aceld/zinx/ziface"
+	"github.com/aceld/zinx/zlog"
+	"github.com/aceld/zinx/ztimer"
+)
+
+var (
+	// The time wheel shared by the idle checks of every connection (所有连接的空闲检查共用的时间轮)
+	idleScheduler     *ztimer.TimerScheduler
+	idleSchedulerOnce sync.Once
+)
+
+func getIdleScheduler() *ztimer.TimerScheduler {
+	idleSchedulerOnce.Do(func() {
+		idleScheduler = ztimer.NewAutoExecTimerScheduler()
+	})
+	return idleScheduler
+}
+
+// idleCheck is one of the idle timeouts of a connection (连接的一种空闲超时)
+type idleCheck struct {
+	state   ziface.IdleState
+	timeout int64 // ns
+	fired   int64 // Unix time in ns it last fired (上次触发的unix时间, 单位ns)
+}
+
+// idleWatch fires the idle timeouts of a connection in the style of Netty's IdleStateHandler.
+// A connection has at most one timer on the shared time wheel, set to its nearest deadline,
+// so reads and writes only store a timestamp.
+// (按Netty IdleStateHandler的方式触发连接的空闲超时; 每个连接在共享时间轮上最多只有一个定时器, 设置为最近的超时时间, 读写时只记录时间戳)
+type idleWatch struct {
+	conn   ziface.IConnection
+	onIdle ziface.OnIdle
+
+	// Unix time in ns of the last read and write (最近一次读、写的unix时间, 单位ns)
+	lastRead  int64
+	lastWrite int64
+
+	lock    sync.Mutex
+	checks  []idleCheck
+	timerID uint32
+	stopped bool
+}
+
+// newIdleWatch returns nil when the config of the server has no idle timeout (Server的配置中没有空闲超时时返回nil)
+func newIdleWatch(conn ziface.IConnection, server ziface.IServer) *idleWatch {
+	conf := zconf.GlobalObject
+	if ls, ok := server.(*listenerServer); ok {
+		server = ls.Server
+	}
+	if s, ok := server.(*Server); ok && s.config != nil {
+		conf = s.config
+	}
+	w := &idleWatch{conn: conn, onIdle: server.GetOnIdle()}
+	for _, c := range []idleCheck{
+		{state: ziface.ReaderIdle, timeout: int64(conf.ReaderIdleTime) * int64(time.Second)},
+		{state: ziface.WriterIdle, timeout: int64(conf.WriterIdleTime) * int64(time.Second)},
+		{state: ziface.AllIdle, timeout: int64(conf.AllIdleTime) * int64(time.Second)},
+	} {
+		if c.timeout > 0 {
+			w.checks = append(w.checks, c)
+		}
+	}
+	if len(w.checks) == 0 {
+		return nil
+	}
+	if w.onIdle == nil {
+		w.onIdle = closeIdleConn
+	}
+	return w
+}
+
+func closeIdleConn(conn ziface.IConnection, state ziface.IdleState) {
+	zlog.Ins().InfoF("Connection %d %s is %s, stop it", conn.GetConnID(), conn.RemoteAddr(), state)
+	conn.Stop()
+}
+
+func (w *idleWatch) start() {
+	if w == nil {
+		return
+	}
+	now := time.Now().UnixNano()
+	atomic.StoreInt64(&w.lastRead, now)
+	atomic.StoreInt64(&w.lastWrite, now)
+	w.check()
+}
+
+func (w *idleWatch) stop() {
+	if w == nil {
+		return
+	}
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	w.stopped = true
+	getIdleScheduler().CancelTimer(w.timerID)
+}
+
+func (w *idleWatch) read() {
+	if w != nil {
+		atomic.StoreInt64(&w.lastRead, time.Now().UnixNano())
+	}
+}
+
+func (w *idleWatch) wrote() {
+	if w != nil {
+		atomic.StoreInt64(&w.lastWrite, time.Now().UnixNano())
+	}
+}
+
+// check fires the timeouts that passed and sets the timer to the nearest deadline. After firing,
+// a timeout fires again only when the connection stays idle for another full period.
+// (触发已经超时的检查并将定时器设置为最近的超时时间; 触发后连接需要再空闲一个完整周期才会再次触发)
+func (w *idleWatch) check() {
+	now := time.Now().UnixNano()
+	lastRead, lastWrite := atomic.LoadInt64(&w.lastRead), atomic.LoadInt64(&w.lastWrite)
+
+	var idle []ziface.IdleState
+	w.lock.Lock()
+	if w.stopped {
+		w.lock.Unlock()
+		return
+	}
+	next := int64(math.MaxInt64)
+	for i := range w.checks {
+		c := &w.checks[i]
+		since := lastRead
+		switch c.state {
+		case ziface.WriterIdle:
+			since = lastWrite
+		case ziface.AllIdle:
+			if lastWrite > since {
+				since = lastWrite
+			}
+		}
+		if c.fired > since {
+			since = c.fired
+		}
+		deadline := since + c.timeout
+		if deadline <= now {
+			idle = append(idle, c.state)
+			c.fired = now
+			deadline = now + c.timeout
+		}
+		if deadline < next {
+			next = deadline
+		}
+	}
+	df := ztimer.NewDelayFunc(func(v ...interface{}) {
+		w.check()
+	}, nil)
+	timerID, err := getIdleScheduler().CreateTimerAfter(df, time.Duration(next-now))
+	if err == nil {
+		w.timerID = timerID
+	}
+	w.lock.Unlock()
+
+	for _, state := range idle {
+		w.onIdle(w.conn, state)
+	}
+	if err != nil {
+		// Without the timer the idle timeouts would never fire again (没有定时器, 空闲超时将不再触发)
+		zlog.Ins().ErrorF("Connection %d idle timer err: %v, stop it", w.conn.GetConnID(), err)
+		w.conn.Stop()
+	}
+}
@@ -0,0 +1,153 @@
+package znet
+
+import (
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/aceld/zinx/zconf"
+	"github.com/aceld/zinx/zdecoder"
+	"github.com/aceld/zinx/ziface"
+	"github.com/aceld/zinx/zpack"
+)
+
+func withIdleConf(t *testing.T, reader, writer, all int) {
+	conf := zconf.GlobalObject
+	r, w, a := conf.ReaderIdleTime, conf.WriterIdleTime, conf.AllIdleTime
+	t.Cleanup(func() {
+		conf.ReaderIdleTime, conf.WriterIdleTime, conf.AllIdleTime = r, w, a
+	})
+	conf.ReaderIdleTime, conf.WriterIdleTime, conf.AllIdleTime = reader, writer, all
+}
+
+// run in terminal:
+// go test -v ./znet -run=TestIdle
+
+func TestIdleTimeout(t *testing.T) {
+	withIdleConf(t, 1, 1, 0)
+
+	conf := *zconf.GlobalObject
+	conf.Name = "IdleTest"
+	conf.Host = "127.0.0.1"
+	conf.TCPPort = 19014
+
+	states := make(chan ziface.IdleState, 10)
+	s := newServerWithConfig(&conf, "tcp")
+	s.SetOnIdle(func(conn ziface.IConnection, state ziface.IdleState) {
+		states <- state
+	})
+	s.Start()
+	defer s.Stop()
+	time.Sleep(time.Second * 1)
+
+	conn, err := net.Dial("tcp", "127.0.0.1:19014")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	// The client keeps sending, the server never writes (客户端持续发送, 服务端从不写出)
+	dp := zpack.Factory().NewPack(ziface.ZinxDataPack)
+	pack, _ := dp.Pack(zpack.NewMsgPackage(1, []byte("ping")))
+	deadline := time.After(2500 * time.Millisecond)
+	ticker := time.NewTicker(300 * time.Millisecond)
+	defer ticker.Stop()
+	var writerIdle int
+	for done := false; !done; {
+		select {
+		case <-ticker.C:
+			if _, err := conn.Write(pack); err != nil {
+				t.Fatal(err)
+			}
+		case state := <-states:
+			if state != ziface.WriterIdle {
+				t.Fatalf("got %s while the client is sending", state)
+			}
+			writerIdle++
+		case <-deadline:
+			done = true
+		}
+	}
+	if writerIdle == 0 || writerIdle > 2 {
+		t.Fatalf("writer idle fired %d times in 2.5s, want 1 or 2", writerIdle)
+	}
+}
+
+func TestIdleClose(t *testing.T) {
+	withIdleConf(t, 0, 0, 1)
+
+	conf := *zconf.GlobalObject
+	conf.Name = "IdleCloseTest"
+	conf.Host = "127.0.0.1"
+	conf.TCPPort = 19015
+
+	s := newServerWithConfig(&conf, "tcp")
+	s.Start()
+	defer s.Stop()
+	time.Sleep(time.Second * 1)
+
+	conn, err := net.Dial("tcp", "127.0.0.1:19015")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	// Without SetOnIdle the idle connection is closed (未设置SetOnIdle时关闭空闲连接)
+	start := time.Now()
+	_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, err := conn.Read(make([]byte, 1)); err != io.EOF {
+		t.Fatalf("expected EOF, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed < 800*time.Millisecond {
+		t.Fatalf("closed after %v, before the idle timeout", elapsed)
+	}
+}
+
+func TestUserConfServerIdle(t *testing.T) {
+	withIdleConf(t, 0, 0, 1)
+	rate := zconf.GlobalObject.RateLimitConnRate
+	zconf.GlobalObject.RateLimitConnRate = 10
+	defer func() { zconf.GlobalObject.RateLimitConnRate = rate }()
+
+	g := NewServer()
+	defer g.Stop()
+	if newIdleWatch(nil, g) == nil {
+		t.Fatal("server of GlobalObject has no idle watch")
+	}
+
+	// The idle timeouts and rate limits of the user config are not taken from GlobalObject
+	// (用户配置的空闲超时和限流不取自GlobalObject)
+	s := NewUserConfServer(&zconf.Config{})
+	defer s.Stop()
+	if newIdleWatch(nil, s) != nil {
+		t.Fatal("server of a user config without idle timeouts has an idle watch")
+	}
+	if s.(*Server).config.RateLimitConnRate != 0 {
+		t.Fatal("server of a user config without rate limits is rate limited")
+	}
+}
+
+func TestListenerDecoderIdle(t *testing.T) {
+	withIdleConf(t, 0, 0, 0)
+
+	s := NewUserConfServer(&zconf.Config{Name: "ListenerIdleTest", AllIdleTime: 1},
+		WithListener(ziface.ListenerConfig{Network: ziface.ListenerTCP, Addr: "127.0.0.1:19026", Decoder: zdecoder.NewTLVDecoder()}),
+	)
+	// The idle timeout is left only in the user config (空闲超时只保留在用户配置中)
+	zconf.GlobalObject.AllIdleTime = 0
+	s.Start()
+	defer s.Stop()
+	time.Sleep(time.Second * 1)
+
+	conn, err := net.Dial("tcp", "127.0.0.1:19026")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, err := conn.Read(make([]byte, 1)); err != io.EOF {
+		t.Fatalf("expected EOF, got %v", err)
+	}
+}
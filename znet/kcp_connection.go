@@ -2,6 +2,7 @@ package znet
 
 import (
 	"context"
+	"crypto/x509"
 	"encoding/hex"
 	"errors"
 	"net"
@@ -12,9 +13,12 @@ import (
 
 	"github.com/aceld/zinx/ziface"
 
+	"github.com/aceld/zinx/zbuffer"
+	"github.com/aceld/zinx/zcodec"
 	"github.com/aceld/zinx/zconf"
 	"github.com/aceld/zinx/zinterceptor"
 	"github.com/aceld/zinx/zlog"
+	"github.com/aceld/zinx/zmetrics"
 	"github.com/aceld/zinx/zpack"
 	"github.com/gorilla/websocket"
 	"github.com/xtaci/kcp-go"
@@ -47,19 +51,28 @@ type KcpConnection struct {
 	// (消息管理MsgID和对应处理方法的消息管理模块)
 	msgHandler ziface.IMsgHandle
 
-	// Channel to notify that the connection has exited/stopped
-	// (告知该连接已经退出/停止的channel)
+	// Channel to notify that the connection has exited/stopped, created with the connection
+	// so that other goroutines may read it before Start
+	// (告知该连接已经退出/停止的channel, 随连接一起创建, 因此其他协程可以在Start之前读取)
 	ctx    context.Context
 	cancel context.CancelFunc
 
-	// Buffered channel used for message communication between the read and write goroutines
-	// (有缓冲管道，用于读、写两个goroutine之间的消息通信)
-	msgBuffChan chan []byte
+	// sendQueue holds the messages waiting for the writer goroutine, one queue per priority.
+	// (等待写协程发送的消息, 每个优先级一个队列)
+	sendQueue *sendQueue
+
+	// startWriterFlag marks that the writer goroutine has been started.
+	// (写协程已启动标志)
+	startWriterFlag int32
 
 	// Lock for user message reception and transmission
 	// (用户收发消息的Lock)
 	msgLock sync.RWMutex
 
+	// Number of queued messages not yet written to the socket
+	// (已入队但尚未写入socket的消息数)
+	pendingSend int64
+
 	// Connection properties
 	// (连接属性)
 	property map[string]interface{}
@@ -84,10 +97,18 @@ type KcpConnection struct {
 	// (当前连接断开时的Hook函数)
 	onConnStop func(conn ziface.IConnection)
 
+	// Hook function when the send queue overflows, inherited from the Server
+	// (发送队列溢出时的Hook函数, 继承自Server)
+	onSlowConsumer func(conn ziface.IConnection, event ziface.SlowConsumerEvent)
+
 	// Data packet packaging method
 	// (数据报文封包方式)
 	packet ziface.IDataPack
 
+	// Codec of the typed messages
+	// (类型化消息的编解码器)
+	codec ziface.ICodec
+
 	// Last activity time
 	// (最后一次活动时间)
 	lastActivityTime time.Time
@@ -100,6 +121,10 @@ type KcpConnection struct {
 	// (心跳检测器)
 	hc ziface.IHeartbeatChecker
 
+	// Idle timeouts checked on the shared time wheel, nil when none is configured
+	// (在共享时间轮上检查的空闲超时, 未配置时为nil)
+	idle *idleWatch
+
 	// Connection name, default to be the same as the name of the Server/Client that created the connection
 	// (连接名称，默认与创建连接的Server/Client的Name一致)
 	name string
@@ -117,6 +142,18 @@ type KcpConnection struct {
 
 	// Close callback mutex
 	closeCallbackMutex sync.RWMutex
+
+	// RPC calls waiting for their reply
+	// (等待应答的RPC调用)
+	rpc rpcCalls
+
+	// Compression negotiated for the messages sent
+	// (发送消息时使用的协商好的压缩方式)
+	compression connCompression
+
+	// Session keys and sequence numbers of the encrypted messages
+	// (加密消息的会话密钥及序号)
+	encryption connEncryption
 }
 
 // newKcpServerConn :for Server, method to create a Server-side connection with Server-specific properties
@@ -124,15 +161,16 @@ type KcpConnection struct {
 func newKcpServerConn(server ziface.IServer, conn *kcp.UDPSession, connID uint64) ziface.IConnection {
 	// Initialize Conn properties
 	c := &KcpConnection{
-		conn:        conn,
-		connID:      connID,
-		connIdStr:   strconv.FormatUint(connID, 10),
-		msgBuffChan: nil,
-		property:    nil,
-		name:        server.ServerName(),
-		localAddr:   conn.LocalAddr().String(),
-		remoteAddr:  conn.RemoteAddr().String(),
+		conn:       conn,
+		connID:     connID,
+		connIdStr:  strconv.FormatUint(connID, 10),
+		sendQueue:  newSendQueue(zconf.SendQueueBlock),
+		property:   nil,
+		name:       server.ServerName(),
+		localAddr:  conn.LocalAddr().String(),
+		remoteAddr: conn.RemoteAddr().String(),
 	}
+	c.ctx, c.cancel = context.WithCancel(context.Background())
 
 	lengthField := server.GetLengthField()
 	if lengthField != nil {
@@ -141,8 +179,11 @@ func newKcpServerConn(server ziface.IServer, conn *kcp.UDPSession, connID uint64
 
 	// Inherited properties from server (从server继承过来的属性)
 	c.packet = server.GetPacket()
+	c.codec = server.GetCodec()
 	c.onConnStart = server.GetOnConnStart()
 	c.onConnStop = server.GetOnConnStop()
+	c.onSlowConsumer = server.GetOnSlowConsumer()
+	c.idle = newIdleWatch(c, server)
 	c.msgHandler = server.GetMsgHandler()
 
 	// Bind the current Connection with the Server's ConnManager
@@ -160,15 +201,16 @@ func newKcpServerConn(server ziface.IServer, conn *kcp.UDPSession, connID uint64
 // (创建一个Client服务端特性的连接的方法)
 func newKcpClientConn(client ziface.IClient, conn *kcp.UDPSession) ziface.IConnection {
 	c := &KcpConnection{
-		conn:        conn,
-		connID:      0,  // client ignore
-		connIdStr:   "", // client ignore
-		msgBuffChan: nil,
-		property:    nil,
-		name:        client.GetName(),
-		localAddr:   conn.LocalAddr().String(),
-		remoteAddr:  conn.RemoteAddr().String(),
+		conn:       conn,
+		connID:     0,  // client ignore
+		connIdStr:  "", // client ignore
+		sendQueue:  newSendQueue(zconf.SendQueueBlock),
+		property:   nil,
+		name:       client.GetName(),
+		localAddr:  conn.LocalAddr().String(),
+		remoteAddr: conn.RemoteAddr().String(),
 	}
+	c.ctx, c.cancel = context.WithCancel(context.Background())
 
 	lengthField := client.GetLengthField()
 	if lengthField != nil {
@@ -177,6 +219,7 @@ func newKcpClientConn(client ziface.IClient, conn *kcp.UDPSession) ziface.IConne
 
 	// Inherited properties from server (从client继承过来的属性)
 	c.packet = client.GetPacket()
+	c.codec = client.GetCodec()
 	c.onConnStart = client.GetOnConnStart()
 	c.onConnStop = client.GetOnConnStop()
 	c.msgHandler = client.GetMsgHandler()
@@ -192,16 +235,18 @@ func (c *KcpConnection) StartWriter() {
 
 	for {
 		select {
-		case data, ok := <-c.msgBuffChan:
-			if ok {
-				if err := c.Send(data); err != nil {
-					zlog.Ins().ErrorF("Send Buff Data error:, %s Conn Writer exit", err)
+		case <-c.sendQueue.notify:
+			for {
+				item, ok := c.sendQueue.pop()
+				if !ok {
 					break
 				}
-
-			} else {
-				zlog.Ins().ErrorF("msgBuffChan is Closed")
-				break
+				err := c.sendItem(item)
+				atomic.AddInt64(&c.pendingSend, -1)
+				if err != nil {
+					zlog.Ins().ErrorF("Send Buff Data error:, %s Conn Writer exit", err)
+					return
+				}
 			}
 		case <-c.ctx.Done():
 			return
@@ -237,12 +282,16 @@ func (c *KcpConnection) StartReader() {
 				return
 			}
 			zlog.Ins().DebugF("read buffer %s \n", hex.EncodeToString(buffer[0:n]))
+			if zmetrics.Enabled() {
+				zmetrics.Metrics().ReceivedBytes(c.name, n)
+			}
 
 			// If normal data is read from the peer, update the heartbeat detection Active state
 			// (正常读取到对端数据，更新心跳检测Active状态)
 			if n > 0 && c.hc != nil {
 				c.updateActivity()
 			}
+			c.idle.read()
 
 			// Deal with the custom protocol fragmentation problem, added by uuxia 2023-03-21
 			// (处理自定义协议断粘包问题)
@@ -258,7 +307,7 @@ func (c *KcpConnection) StartReader() {
 					msg := zpack.NewMessage(uint32(len(bytes)), bytes)
 					// Get the current client's Request data
 					// (得到当前客户端请求的Request数据)
-					req := GetRequest(c, msg)
+					req := getRequestWithBuffer(c, msg, bytes)
 					c.msgHandler.Execute(req)
 				}
 			} else {
@@ -280,7 +329,6 @@ func (c *KcpConnection) Start() {
 			zlog.Ins().ErrorF("Connection Start() error: %v", err)
 		}
 	}()
-	c.ctx, c.cancel = context.WithCancel(context.Background())
 
 	// Execute the hook method for processing business logic when creating a connection
 	// (按照用户传递进来的创建连接时需要处理的业务，执行钩子方法)
@@ -291,6 +339,7 @@ func (c *KcpConnection) Start() {
 		c.hc.Start()
 		c.updateActivity()
 	}
+	c.idle.start()
 
 	// 占用workerid
 	c.workerID = useWorker(c)
@@ -355,65 +404,87 @@ func (c *KcpConnection) Send(data []byte) error {
 		return errors.New("connection closed when send msg")
 	}
 
-	_, err := c.conn.Write(data)
+	n, err := c.conn.Write(data)
+	if zmetrics.Enabled() {
+		zmetrics.Metrics().SentBytes(c.name, n)
+	}
 	if err != nil {
 		zlog.Ins().ErrorF("SendMsg err data = %+v, err = %+v", data, err)
 		return err
 	}
+	c.idle.wrote()
 
 	return nil
 }
 
 func (c *KcpConnection) SendToQueue(data []byte, opts ...ziface.MsgSendOption) error {
-	c.msgLock.RLock()
-	defer c.msgLock.RUnlock()
+	if c.isClosed() {
+		return errors.New("Connection closed when send buff msg")
+	}
 
-	if c.msgBuffChan == nil {
-		c.msgBuffChan = make(chan []byte, zconf.GlobalObject.MaxMsgChanLen)
+	if data == nil {
+		zlog.Ins().ErrorF("Pack data is nil")
+		return errors.New("Pack data is nil")
+	}
+
+	return c.queue(sendItem{data: data}, opts...)
+}
+
+// queue puts data into the send queue of its priority, starting the writer goroutine on first use
+// (将数据放入对应优先级的发送队列, 首次使用时启动写协程)
+func (c *KcpConnection) queue(item sendItem, opts ...ziface.MsgSendOption) error {
+	if atomic.LoadInt32(&c.startWriterFlag) == 0 && atomic.CompareAndSwapInt32(&c.startWriterFlag, 0, 1) {
 		// Start a Goroutine to write data back to the client
-		// This method only reads data from the MsgBuffChan without allocating memory or starting a Goroutine
+		// This method only reads data from the send queue without allocating memory or starting a Goroutine
 		// (开启用于写回客户端数据流程的Goroutine
-		// 此方法只读取MsgBuffChan中的数据没调用SendBuffMsg可以分配内存和启用协程)
+		// 此方法只读取发送队列中的数据没调用SendBuffMsg可以分配内存和启用协程)
 		go c.StartWriter()
 	}
 
 	opt := ziface.MsgSendOptionObj{
-		Timeout: 5 * time.Millisecond,
+		Priority: ziface.MsgPriorityNormal,
 	}
 
 	for _, o := range opts {
 		o(&opt)
 	}
 
-	idleTimeout := time.NewTimer(opt.Timeout)
-	defer idleTimeout.Stop()
-
-	if c.isClosed() {
-		return errors.New("Connection closed when send buff msg")
+	var done <-chan struct{}
+	if c.ctx != nil {
+		done = c.ctx.Done()
 	}
 
-	if data == nil {
-		zlog.Ins().ErrorF("Pack data is nil")
-		return errors.New("Pack data is nil")
+	atomic.AddInt64(&c.pendingSend, 1)
+	dropped, event, err := c.sendQueue.push(item, opt, done)
+	if event != nil {
+		c.callOnSlowConsumer(*event)
 	}
-
-	// Send timeout
-	select {
-	case <-idleTimeout.C:
-		return errors.New("send buff msg timeout")
-	case c.msgBuffChan <- data:
-		return nil
+	if err != nil || dropped != nil {
+		atomic.AddInt64(&c.pendingSend, -1)
+	}
+	if err == errSlowConsumer {
+		c.Stop()
 	}
+	return err
 }
 
 // SendMsg directly sends Message data to the remote KCP client.
 // (直接将Message数据发送数据给远程的KCP客户端)
 func (c *KcpConnection) SendMsg(msgID uint32, data []byte) error {
+	if held, err := c.encryption.hold(msgID, data, false, nil); held {
+		return err
+	}
+	return c.sendMsg(msgID, data)
+}
+
+// sendMsg packs and sends a message without holding it for the key exchange (封包并发送消息, 不会等待密钥交换)
+func (c *KcpConnection) sendMsg(msgID uint32, data []byte) error {
 	if c.isClosed() {
 		return errors.New("connection closed when send msg")
 	}
 	// Pack data and send it
-	msg, err := c.packet.Pack(zpack.NewMsgPackage(msgID, data))
+	wireID, data := c.compression.compress(msgID, data)
+	msg, err := c.packet.Pack(zpack.NewMsgPackage(wireID, c.encryption.seal(wireID, data)))
 	if err != nil {
 		zlog.Ins().ErrorF("Pack error msg ID = %d", msgID)
 		return errors.New("Pack error msg ")
@@ -422,49 +493,54 @@ func (c *KcpConnection) SendMsg(msgID uint32, data []byte) error {
 	err = c.Send(msg)
 	if err != nil {
 		zlog.Ins().ErrorF("SendMsg err msg ID = %d, data = %+v, err = %+v", msgID, string(msg), err)
-		return err
 	}
+	putPacked(c.packet, msg)
 
-	return nil
+	return err
 }
 
-func (c *KcpConnection) SendBuffMsg(msgID uint32, data []byte, opts ...ziface.MsgSendOption) error {
-	if c.isClosed() {
-		return errors.New("connection closed when send buff msg")
+// sendItem writes a queued item, sealing and packing it first when it comes from an encrypted SendBuffMsg
+// (写出队列中的数据, 来自加密连接SendBuffMsg的数据先加密封包)
+func (c *KcpConnection) sendItem(item sendItem) error {
+	if item.seal {
+		var err error
+		if item, err = c.encryption.packSealed(c.packet, item); err != nil {
+			zlog.Ins().ErrorF("Pack error msg ID = %d, drop it", item.msgID)
+			return nil
+		}
 	}
-	if c.msgBuffChan == nil {
-		c.msgBuffChan = make(chan []byte, zconf.GlobalObject.MaxMsgChanLen)
-		// Start a Goroutine to write data back to the client
-		// This method only reads data from the MsgBuffChan without allocating memory or starting a Goroutine
-		// (开启用于写回客户端数据流程的Goroutine
-		// 此方法只读取MsgBuffChan中的数据没调用SendBuffMsg可以分配内存和启用协程)
-		go c.StartWriter()
+	err := c.Send(item.data)
+	if item.pooled {
+		zbuffer.Put(item.data)
 	}
+	return err
+}
 
-	opt := ziface.MsgSendOptionObj{
-		Timeout: 5 * time.Millisecond,
+func (c *KcpConnection) SendBuffMsg(msgID uint32, data []byte, opts ...ziface.MsgSendOption) error {
+	if held, err := c.encryption.hold(msgID, data, true, opts); held {
+		return err
 	}
+	return c.sendBuffMsg(msgID, data, opts...)
+}
 
-	for _, o := range opts {
-		o(&opt)
+func (c *KcpConnection) sendBuffMsg(msgID uint32, data []byte, opts ...ziface.MsgSendOption) error {
+	if c.isClosed() {
+		return errors.New("connection closed when send buff msg")
 	}
 
-	idleTimeout := time.NewTimer(opt.Timeout)
-	defer idleTimeout.Stop()
-
-	msg, err := c.packet.Pack(zpack.NewMsgPackage(msgID, data))
+	// Package data and send
+	// (将data封包，并且发送)
+	wireID, data := c.compression.compress(msgID, data)
+	if c.encryption.sealing(wireID, data) {
+		return c.queue(sendItem{data: append([]byte(nil), data...), msgID: wireID, key: msgID, keyed: true, seal: true}, opts...)
+	}
+	msg, err := c.packet.Pack(zpack.NewMsgPackage(wireID, data))
 	if err != nil {
 		zlog.Ins().ErrorF("Pack error msg ID = %d", msgID)
 		return errors.New("Pack error msg ")
 	}
 
-	// send timeout
-	select {
-	case <-idleTimeout.C:
-		return errors.New("send buff msg timeout")
-	case c.msgBuffChan <- msg:
-		return nil
-	}
+	return c.queue(sendItem{data: msg, msgID: wireID, key: msgID, keyed: true}, opts...)
 }
 
 func (c *KcpConnection) SetProperty(key string, value interface{}) {
@@ -521,6 +597,7 @@ func (c *KcpConnection) finalizer() {
 	if c.hc != nil {
 		c.hc.Stop()
 	}
+	c.idle.stop()
 
 	// Close the socket connection
 	_ = c.conn.Close()
@@ -531,9 +608,7 @@ func (c *KcpConnection) finalizer() {
 	}
 
 	// Close all channels associated with the connection
-	if c.msgBuffChan != nil {
-		close(c.msgBuffChan)
-	}
+	c.sendQueue.close()
 
 	go func() {
 		defer func() {
@@ -562,6 +637,15 @@ func (c *KcpConnection) callOnConnStop() {
 	}
 }
 
+// callOnSlowConsumer reports an overflowing send queue to the log and the Server hook
+// (将发送队列溢出记录日志并通知Server的Hook函数)
+func (c *KcpConnection) callOnSlowConsumer(event ziface.SlowConsumerEvent) {
+	zlog.Ins().ErrorF("ZINX slow consumer ConnID = %d, priority = %d, queued = %d, policy = %s", c.connID, event.Priority, event.Queued, event.Policy)
+	if c.onSlowConsumer != nil {
+		c.onSlowConsumer(c, event)
+	}
+}
+
 func (c *KcpConnection) IsAlive() bool {
 	if c.isClosed() {
 		return false
@@ -572,6 +656,14 @@ func (c *KcpConnection) IsAlive() bool {
 	return time.Now().Sub(c.lastActivityTime) < zconf.GlobalObject.HeartbeatMaxDuration()
 }
 
+// drainSendQueue blocks until every queued message has been written to the socket
+// (等待入队的消息全部写入socket)
+func (c *KcpConnection) drainSendQueue(ctx context.Context) error {
+	return waitUntil(ctx, func() bool {
+		return c.isClosed() || atomic.LoadInt64(&c.pendingSend) == 0
+	})
+}
+
 func (c *KcpConnection) updateActivity() {
 	c.lastActivityTime = time.Now()
 }
@@ -639,7 +731,7 @@ func (s *KcpConnection) InvokeCloseCallbacks() {
 //     conn:        conn,
 //     connID:      0, // client ignore
 //     isClosed:    false,
-//     msgBuffChan: nil,
+//     sendQueue:   newSendQueue(zconf.SendQueueBlock),
 //     property:    nil,
 //     name:        client.GetName(),
 //     localAddr:   conn.LocalAddr().String(),
@@ -661,7 +753,7 @@ func (s *KcpConnection) InvokeCloseCallbacks() {
 //     conn:        conn,
 //     connID:      connID,
 //     isClosed:    false,
-//     msgBuffChan: nil,
+//     sendQueue:   newSendQueue(zconf.SendQueueBlock),
 //     property:    nil,
 //     name:        server.ServerName(),
 //     localAddr:   conn.LocalAddr().String(),
@@ -678,3 +770,50 @@ func (s *KcpConnection) InvokeCloseCallbacks() {
 
 //   return c
 // }
+
+// Call sends an RPC request and waits for the reply, see ziface.IConnection
+// (发送RPC请求并等待应答)
+func (c *KcpConnection) Call(ctx context.Context, msgID uint32, data []byte) (ziface.IMessage, error) {
+	return c.rpc.call(ctx, c, msgID, data)
+}
+
+func (c *KcpConnection) deliverReply(callID uint64, reply rpcReply) bool {
+	return c.rpc.deliver(callID, reply)
+}
+
+func (c *KcpConnection) GetCodec() ziface.ICodec {
+	if c.codec == nil {
+		return zcodec.Default()
+	}
+	return c.codec
+}
+
+func (c *KcpConnection) GetIdentity() interface{} {
+	identity, _ := c.GetProperty(ziface.PropertyIdentity)
+	return identity
+}
+
+func (c *KcpConnection) GetCompressor() ziface.ICompressor {
+	return c.compression.compressor()
+}
+
+func (c *KcpConnection) getEncryption() *connEncryption {
+	return &c.encryption
+}
+
+func (c *KcpConnection) getCompression() *connCompression {
+	return &c.compression
+}
+
+// GetPeerCertificates returns nil, KCP connections do not use TLS (KCP连接不使用TLS)
+func (c *KcpConnection) GetPeerCertificates() []*x509.Certificate {
+	return nil
+}
+
+func (c *KcpConnection) SendTyped(msgID uint32, v interface{}) error {
+	data, err := c.GetCodec().Marshal(v)
+	if err != nil {
+		return err
+	}
+	return c.SendMsg(msgID, data)
+}
@@ -0,0 +1,300 @@
+package znet
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"sync"
+	"sync/atomic"
+
+	"github.com/xtaci/kcp-go"
+
+	"github.com/aceld/zinx/zconf"
+	"github.com/aceld/zinx/ziface"
+	"github.com/aceld/zinx/zlog"
+	"github.com/aceld/zinx/zmetrics"
+)
+
+// listener serves one ListenerConfig of a Server
+// (服务Server的一个ListenerConfig)
+type listener struct {
+	conf   ziface.ListenerConfig
+	server *Server
+
+	// Number of live connections accepted by this listener (该监听器接入的存活连接数)
+	conns int64
+
+	// Backoff of the accept loop, a busy listener does not throttle the others (accept循环的退避, 繁忙的监听器不影响其他监听器)
+	delay acceptDelay
+}
+
+// listenerServer is the Server seen by the connections of a listener, so that they pick up the decoder of the listener
+// (监听器接入的连接所看到的Server, 使连接使用监听器的解码器)
+type listenerServer struct {
+	*Server
+	l *listener
+}
+
+func (ls *listenerServer) GetLengthField() *ziface.LengthField {
+	if ls.l.conf.Decoder != nil {
+		return ls.l.conf.Decoder.GetLengthField()
+	}
+	return ls.Server.GetLengthField()
+}
+
+// connDecoder is the head interceptor when some listeners have their own decoder,
+// it decodes every request with the decoder of the listener that accepted the connection
+// (部分监听器设置了自己的解码器时作为头拦截器, 按连接所属监听器的解码器解码请求)
+type connDecoder struct {
+	decoder  ziface.IDecoder
+	decoders sync.Map // ziface.IConnection -> ziface.IDecoder
+}
+
+func (d *connDecoder) Intercept(chain ziface.IChain) ziface.IcResp {
+	if request, ok := chain.Request().(ziface.IRequest); ok {
+		if decoder, ok := d.decoders.Load(request.GetConnection()); ok {
+			return decoder.(ziface.IDecoder).Intercept(chain)
+		}
+	}
+	if d.decoder != nil {
+		return d.decoder.Intercept(chain)
+	}
+	return chain.Proceed(chain.Request())
+}
+
+func (s *Server) AddListener(conf ziface.ListenerConfig) error {
+	switch conf.Network {
+	case ziface.ListenerTCP, ziface.ListenerWebsocket, ziface.ListenerKCP, ziface.ListenerUnix:
+	case ziface.ListenerTLS, ziface.ListenerWSS:
+		if conf.TLSConfig == nil {
+			return fmt.Errorf("listener %s requires a TLSConfig", conf.Network)
+		}
+		if err := checkClientCAs(conf.TLSConfig); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("unknown listener network %q", conf.Network)
+	}
+	if conf.Addr == "" {
+		return errors.New("listener address is empty")
+	}
+	if conf.Name == "" {
+		conf.Name = conf.Network + "://" + conf.Addr
+	}
+	if conf.WsPath == "" {
+		conf.WsPath = "/"
+	}
+
+	s.exitLock.Lock()
+	defer s.exitLock.Unlock()
+
+	if s.exitChan != nil {
+		return errors.New("listeners must be added before the server starts")
+	}
+	s.listeners = append(s.listeners, &listener{conf: conf, server: s})
+	return nil
+}
+
+// headInterceptor returns the decoder put at the head of the interceptors
+// (返回放在拦截器最前面的解码器)
+func (s *Server) headInterceptor() ziface.IInterceptor {
+	for _, l := range s.listeners {
+		if l.conf.Decoder != nil {
+			s.connDecoder = &connDecoder{decoder: s.decoder}
+			return s.connDecoder
+		}
+	}
+	if s.decoder != nil {
+		return s.decoder
+	}
+	return nil
+}
+
+// full reports whether the listener or the server has reached its maximum number of connections
+// (判断监听器或Server的连接数是否已达上限)
+func (l *listener) full() bool {
+	if l.server.ConnMgr.Len() >= zconf.GlobalObject.GetMaxConn() {
+		return true
+	}
+	return l.conf.MaxConn > 0 && atomic.LoadInt64(&l.conns) >= int64(l.conf.MaxConn)
+}
+
+// connServer returns the Server the connections of this listener are created with
+// (返回创建该监听器连接时使用的Server)
+func (l *listener) connServer() ziface.IServer {
+	if l.conf.Decoder == nil {
+		return l.server
+	}
+	return &listenerServer{Server: l.server, l: l}
+}
+
+// track counts conn until it is closed, and registers its decoder
+// (统计连接数直到连接关闭, 并登记连接的解码器)
+func (l *listener) track(conn ziface.IConnection) {
+	atomic.AddInt64(&l.conns, 1)
+	if l.conf.Decoder != nil && l.server.connDecoder != nil {
+		l.server.connDecoder.decoders.Store(conn, l.conf.Decoder)
+	}
+
+	conn.AddCloseCallback(l, nil, func() {
+		atomic.AddInt64(&l.conns, -1)
+		if l.server.connDecoder != nil {
+			l.server.connDecoder.decoders.Delete(conn)
+		}
+	})
+}
+
+// serve listens and accepts connections until exitChan is closed
+// (开启监听并接收连接, 直到exitChan关闭)
+func (l *listener) serve(exitChan chan struct{}) {
+	zlog.Ins().InfoF("[START] Server name: %s, listener %s is starting", l.server.Name, l.conf.Name)
+
+	var err error
+	switch l.conf.Network {
+	case ziface.ListenerWebsocket, ziface.ListenerWSS:
+		err = l.serveWebsocket(exitChan)
+	case ziface.ListenerKCP:
+		err = l.serveKcp(exitChan)
+	default:
+		err = l.serveStream(exitChan)
+	}
+
+	if err != nil {
+		zlog.Ins().ErrorF("[START] listener %s err: %v", l.conf.Name, err)
+	}
+}
+
+func (l *listener) listen() (net.Listener, error) {
+	switch l.conf.Network {
+	case ziface.ListenerTLS:
+		return tls.Listen("tcp", l.conf.Addr, l.conf.TLSConfig)
+	case ziface.ListenerUnix:
+		return listenUnix(l.conf.Addr, l.conf.FileMode)
+	default:
+		return net.Listen("tcp", l.conf.Addr)
+	}
+}
+
+// serveStream serves TCP, TLS and Unix domain socket listeners
+// (服务TCP、TLS和Unix域套接字监听器)
+func (l *listener) serveStream(exitChan chan struct{}) error {
+	ln, err := l.listen()
+	if err != nil {
+		return err
+	}
+
+	go func() {
+		<-exitChan
+		if err := ln.Close(); err != nil {
+			zlog.Ins().ErrorF("listener %s close err: %v", l.conf.Name, err)
+		}
+	}()
+
+	s := l.server
+	for {
+		// Wait while the server or this listener is full (Server或该监听器连接数已满时等待)
+		if l.full() {
+			zlog.Ins().InfoF("listener %s exceeded the maxConnNum, Wait:%d", l.conf.Name, l.delay.Duration())
+			l.delay.Delay()
+			continue
+		}
+
+		conn, err := ln.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				zlog.Ins().InfoF("listener %s closed", l.conf.Name)
+				return nil
+			}
+			zlog.Ins().ErrorF("listener %s accept err: %v", l.conf.Name, err)
+			if zmetrics.Enabled() {
+				zmetrics.Metrics().AcceptError(s.Name)
+			}
+			l.delay.Delay()
+			continue
+		}
+
+		l.delay.Reset()
+
+		dealConn := newServerConn(l.connServer(), conn, atomic.AddUint64(&s.cID, 1))
+		l.track(dealConn)
+
+		go s.StartConn(dealConn)
+	}
+}
+
+// serveWebsocket serves WebSocket listeners with an http server of their own
+// (使用独立的http服务处理WebSocket监听器)
+func (l *listener) serveWebsocket(exitChan chan struct{}) error {
+	mux := http.NewServeMux()
+	mux.HandleFunc(l.conf.WsPath, func(w http.ResponseWriter, r *http.Request) {
+		l.server.serveWebsocket(l, w, r)
+	})
+
+	httpServer := &http.Server{Addr: l.conf.Addr, Handler: mux, TLSConfig: l.conf.TLSConfig}
+	go func() {
+		<-exitChan
+		_ = httpServer.Close()
+	}()
+
+	var err error
+	if l.conf.Network == ziface.ListenerWSS {
+		err = httpServer.ListenAndServeTLS("", "")
+	} else {
+		err = httpServer.ListenAndServe()
+	}
+	if errors.Is(err, http.ErrServerClosed) {
+		return nil
+	}
+	return err
+}
+
+// serveKcp serves KCP listeners with the KCP settings of the Server
+// (使用Server的KCP配置服务KCP监听器)
+func (l *listener) serveKcp(exitChan chan struct{}) error {
+	s := l.server
+	ln, err := kcp.ListenWithOptions(l.conf.Addr, nil, s.kcpConfig.KcpFecDataShards, s.kcpConfig.KcpFecParityShards)
+	if err != nil {
+		return err
+	}
+
+	go func() {
+		<-exitChan
+		if err := ln.Close(); err != nil {
+			zlog.Ins().ErrorF("listener %s close err: %v", l.conf.Name, err)
+		}
+	}()
+
+	for {
+		if l.full() {
+			zlog.Ins().InfoF("listener %s exceeded the maxConnNum, Wait:%d", l.conf.Name, l.delay.Duration())
+			l.delay.Delay()
+			continue
+		}
+
+		conn, err := ln.AcceptKCP()
+		if err != nil {
+			select {
+			case <-exitChan:
+				zlog.Ins().InfoF("listener %s closed", l.conf.Name)
+				return nil
+			default:
+			}
+			zlog.Ins().ErrorF("listener %s accept err: %v", l.conf.Name, err)
+			if zmetrics.Enabled() {
+				zmetrics.Metrics().AcceptError(s.Name)
+			}
+			l.delay.Delay()
+			continue
+		}
+
+		l.delay.Reset()
+
+		s.setupKcpSession(conn)
+		dealConn := newKcpServerConn(l.connServer(), conn, atomic.AddUint64(&s.cID, 1))
+		l.track(dealConn)
+
+		go s.StartConn(dealConn)
+	}
+}
@@ -0,0 +1,69 @@
+package znet
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/aceld/zinx/zconf"
+	"github.com/aceld/zinx/ziface"
+)
+
+// run in terminal:
+// go test -v ./znet -run=TestServerListeners
+
+func TestServerListeners(t *testing.T) {
+	conf := *zconf.GlobalObject
+	conf.Name = "ListenersTest"
+
+	s := newServerWithConfig(&conf, "tcp",
+		WithListener(ziface.ListenerConfig{Network: ziface.ListenerTCP, Addr: "127.0.0.1:19006", MaxConn: 1}),
+		WithListener(ziface.ListenerConfig{Network: ziface.ListenerWebsocket, Addr: "127.0.0.1:19007"}),
+	)
+	s.AddRouter(1, &RPCEchoRouter{})
+	s.Start()
+	defer s.Stop()
+	time.Sleep(time.Second * 1)
+
+	if err := s.AddListener(ziface.ListenerConfig{Network: ziface.ListenerTCP, Addr: "127.0.0.1:19008"}); err == nil {
+		t.Fatal("listener added after start")
+	}
+
+	clients := []*Client{
+		NewClient("127.0.0.1", 19006).(*Client),
+		NewWsClient("127.0.0.1", 19007).(*Client),
+	}
+	for _, client := range clients {
+		client.Start()
+	}
+	time.Sleep(time.Second * 1)
+
+	// Both transports share the routers (两种传输方式共享路由)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+	defer cancel()
+	for _, client := range clients {
+		conn := client.Conn()
+		if conn == nil {
+			t.Fatal("client not connected")
+		}
+		reply, err := conn.Call(ctx, 1, []byte("hi"))
+		if err != nil {
+			t.Fatalf("call err: %v", err)
+		}
+		if string(reply.GetData()) != "echo:hi" {
+			t.Fatalf("reply = %q, want %q", reply.GetData(), "echo:hi")
+		}
+	}
+
+	// The TCP listener accepts one connection only (TCP监听器只接入一个连接)
+	extra := NewClient("127.0.0.1", 19006).(*Client)
+	extra.Start()
+	time.Sleep(time.Second * 1)
+	if n := s.GetConnMgr().Len(); n != 2 {
+		t.Fatalf("conn num = %d, want 2", n)
+	}
+
+	for _, client := range append(clients, extra) {
+		client.Stop()
+	}
+}
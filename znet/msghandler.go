@@ -1,13 +1,17 @@
 package znet
 
 import (
+	"context"
 	"encoding/hex"
 	"fmt"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/aceld/zinx/zconf"
 	"github.com/aceld/zinx/ziface"
 	"github.com/aceld/zinx/zlog"
+	"github.com/aceld/zinx/zmetrics"
 )
 
 const (
@@ -43,10 +47,61 @@ type MsgHandle struct {
 	extraFreeWorkers  map[uint32]struct{}
 	extraFreeWorkerMu sync.Mutex
 
+	// Handshake of the new connections, nil when the server has no authenticator
+	// (新连接的握手认证, Server未设置认证时为nil)
+	auth *authenticator
+
+	// Compression accepted when clients negotiate, nil answers every offer with no compression
+	// (客户端协商时可接受的压缩设置, 为nil时不接受任何压缩)
+	compress *compressOption
+
+	// Encryption accepted when clients exchange keys, nil refuses every key exchange
+	// (客户端交换密钥时可接受的加密设置, 为nil时拒绝任何密钥交换)
+	encrypt *encryptOption
+
+	// Reliable sessions of the clients, nil when the messages are not sent through reliable sessions
+	// (客户端的可靠会话, 为nil时不使用可靠会话)
+	reliable *reliableManager
+
+	// Chooses the dispatch key of a request in zconf.WorkerModeKey
+	// (zconf.WorkerModeKey模式下选择请求的分发key)
+	dispatchKey ziface.DispatchKeyFunc
+
 	// Chain builder for the responsibility chain
 	// (责任链构造器)
 	builder      *chainBuilder
 	RouterSlices *RouterSlices
+
+	// Number of requests dispatched but not finished yet, used by graceful shutdown
+	// (已分发但尚未处理完成的请求数，用于优雅关闭)
+	inFlight int64
+
+	// Whether new client requests are refused because the server is shutting down
+	// (服务器正在关闭，不再接收新的客户端请求)
+	draining int32
+
+	// Grows and shrinks the pool of zconf.WorkerModeHash, nil when WorkerPoolMaxSize is not above WorkerPoolSize
+	// (对zconf.WorkerModeHash的worker池扩缩容, WorkerPoolMaxSize不大于WorkerPoolSize时为nil)
+	scale *workerScaler
+
+	// What to do with a client request whose worker queue is full, one of the zconf.WorkerOverflow values
+	// (worker任务队列满时对客户端请求的处理方式)
+	overflow string
+
+	// The MsgID a rejected client request is answered with, RPC calls get an RPC error instead
+	// (被拒绝的客户端请求所回复的MsgID, RPC调用则回复RPC错误)
+	rejectMsgID uint32
+
+	// Overflow lane of every worker for zconf.WorkerOverflowSpill, one per TaskQueue
+	// (zconf.WorkerOverflowSpill使用的每个worker的溢出队列, 与TaskQueue一一对应)
+	spill []spillLane
+
+	// Requests handled by each worker and client requests dropped on overflow
+	// (每个worker处理的请求数及溢出时丢弃的客户端请求数)
+	handled  []uint64
+	rejected uint64
+	shed     uint64
+	spilled  uint64
 }
 
 // newMsgHandle creates MsgHandle
@@ -60,7 +115,7 @@ func newMsgHandle() *MsgHandle {
 		// MaxWorkerTaskLen can also be reduced, for example, 50
 		// 为每个连接分配一个workder，避免同一worker处理多个连接时的互相影响
 		// 同时可以减小MaxWorkerTaskLen，比如50，因为每个worker的负担减轻了
-		zconf.GlobalObject.WorkerPoolSize = uint32(zconf.GlobalObject.MaxConn)
+		zconf.GlobalObject.WorkerPoolSize = uint32(zconf.GlobalObject.GetMaxConn())
 		freeWorkers = make(map[uint32]struct{}, zconf.GlobalObject.WorkerPoolSize)
 		for i := uint32(0); i < zconf.GlobalObject.WorkerPoolSize; i++ {
 			freeWorkers[i] = struct{}{}
@@ -76,11 +131,20 @@ func newMsgHandle() *MsgHandle {
 			freeWorkers[i] = struct{}{}
 		}
 
-		extraFreeWorkers = make(map[uint32]struct{}, zconf.GlobalObject.MaxConn-int(zconf.GlobalObject.WorkerPoolSize))
-		for i := zconf.GlobalObject.WorkerPoolSize; i < uint32(zconf.GlobalObject.MaxConn); i++ {
+		extraFreeWorkers = make(map[uint32]struct{}, zconf.GlobalObject.GetMaxConn()-int(zconf.GlobalObject.WorkerPoolSize))
+		for i := zconf.GlobalObject.WorkerPoolSize; i < uint32(zconf.GlobalObject.GetMaxConn()); i++ {
 			extraFreeWorkers[i] = struct{}{}
 		}
-		TaskQueueLen = uint32(zconf.GlobalObject.MaxConn)
+		TaskQueueLen = uint32(zconf.GlobalObject.GetMaxConn())
+	}
+
+	// Only the hash mode can move connections between workers, WorkerPoolSize is then the minimum
+	// (只有Hash模式可以在worker之间调整连接, 此时WorkerPoolSize为最小值)
+	var scale *workerScaler
+	if mode := zconf.GlobalObject.WorkerMode; (mode == "" || mode == zconf.WorkerModeHash) &&
+		zconf.GlobalObject.WorkerPoolSize > 0 && zconf.GlobalObject.WorkerPoolMaxSize > zconf.GlobalObject.WorkerPoolSize {
+		scale = newWorkerScaler(zconf.GlobalObject.WorkerPoolSize, zconf.GlobalObject.WorkerPoolMaxSize)
+		TaskQueueLen = zconf.GlobalObject.WorkerPoolMaxSize
 	}
 
 	handle := &MsgHandle{
@@ -90,6 +154,16 @@ func newMsgHandle() *MsgHandle {
 		builder:      newChainBuilder(),
 		// 可额外临时分配的workerID集合
 		extraFreeWorkers: extraFreeWorkers,
+		scale:            scale,
+		overflow:         zconf.GlobalObject.WorkerOverflowAction,
+		rejectMsgID:      zconf.GlobalObject.WorkerRejectMsgID,
+		handled:          make([]uint64, TaskQueueLen),
+	}
+	if handle.overflow == "" {
+		handle.overflow = zconf.WorkerOverflowBlock
+	}
+	if handle.overflow == zconf.WorkerOverflowSpill {
+		handle.spill = make([]spillLane, TaskQueueLen)
 	}
 
 	// server
@@ -99,6 +173,7 @@ func newMsgHandle() *MsgHandle {
 
 	// It is necessary to add the MsgHandle to the responsibility chain here, and it is the last link in the responsibility chain. After decoding in the MsgHandle, data distribution is done by router
 	// (此处必须把 msghandler 添加到责任链中，并且是责任链最后一环，在msghandler中进行解码后由router做数据分发)
+	handle.builder.Transport(&transportInterceptor{mh: handle})
 	handle.builder.Tail(handle)
 	return handle
 }
@@ -114,7 +189,7 @@ func newCliMsgHandle() *MsgHandle {
 		// MaxWorkerTaskLen can also be reduced, for example, 50
 		// 为每个连接分配一个workder，避免同一worker处理多个连接时的互相影响
 		// 同时可以减小MaxWorkerTaskLen，比如50，因为每个worker的负担减轻了
-		zconf.GlobalObject.WorkerPoolSize = uint32(zconf.GlobalObject.MaxConn)
+		zconf.GlobalObject.WorkerPoolSize = uint32(zconf.GlobalObject.GetMaxConn())
 		freeWorkers = make(map[uint32]struct{}, zconf.GlobalObject.WorkerPoolSize)
 		for i := uint32(0); i < zconf.GlobalObject.WorkerPoolSize; i++ {
 			freeWorkers[i] = struct{}{}
@@ -130,11 +205,11 @@ func newCliMsgHandle() *MsgHandle {
 			freeWorkers[i] = struct{}{}
 		}
 
-		extraFreeWorkers = make(map[uint32]struct{}, zconf.GlobalObject.MaxConn-int(zconf.GlobalObject.WorkerPoolSize))
-		for i := zconf.GlobalObject.WorkerPoolSize; i < uint32(zconf.GlobalObject.MaxConn); i++ {
+		extraFreeWorkers = make(map[uint32]struct{}, zconf.GlobalObject.GetMaxConn()-int(zconf.GlobalObject.WorkerPoolSize))
+		for i := zconf.GlobalObject.WorkerPoolSize; i < uint32(zconf.GlobalObject.GetMaxConn()); i++ {
 			extraFreeWorkers[i] = struct{}{}
 		}
-		TaskQueueLen = uint32(zconf.GlobalObject.MaxConn)
+		TaskQueueLen = uint32(zconf.GlobalObject.GetMaxConn())
 	}
 
 	handle := &MsgHandle{
@@ -144,6 +219,8 @@ func newCliMsgHandle() *MsgHandle {
 		builder:      newChainBuilder(),
 		// 可额外临时分配的workerID集合
 		extraFreeWorkers: extraFreeWorkers,
+		overflow:         zconf.WorkerOverflowBlock,
+		handled:          make([]uint64, TaskQueueLen),
 	}
 
 	// client: Set worker pool size to 0 to turn off the worker pool in the client (客户端将协程池关闭)
@@ -153,6 +230,7 @@ func newCliMsgHandle() *MsgHandle {
 
 	// It is necessary to add the MsgHandle to the responsibility chain here, and it is the last link in the responsibility chain. After decoding in the MsgHandle, data distribution is done by router
 	// (此处必须把 msghandler 添加到责任链中，并且是责任链最后一环，在msghandler中进行解码后由router做数据分发)
+	handle.builder.Transport(&transportInterceptor{mh: handle})
 	handle.builder.Tail(handle)
 	return handle
 }
@@ -204,6 +282,9 @@ func useWorker(conn ziface.IConnection) uint32 {
 	//(兼容client没有worker情况，解决除0的情况)
 	if mh.WorkerPoolSize == 0 {
 		workerId = 0
+	} else if mh.scale != nil {
+		// Only the active workers of an adaptive pool take new connections (可伸缩的池只由活跃的worker接收新连接)
+		workerId = mh.scale.assign(conn.GetConnID())
 	} else {
 		// Assign the worker responsible for processing the current connection based on the ConnID
 		// Using a round-robin average allocation rule to get the workerID that needs to process this connection
@@ -248,6 +329,84 @@ func freeWorker(conn ziface.IConnection) {
 			mh.extraFreeWorkerMu.Unlock()
 		}
 	}
+
+	if mh.scale != nil {
+		mh.scale.release(conn)
+	}
+}
+
+// handleTransport answers the reliable session hello and the compression negotiation, it reports whether
+// the request was one of them
+// (应答可靠会话握手和压缩协商, 返回请求是否为其中之一)
+func (mh *MsgHandle) handleTransport(request ziface.IRequest) bool {
+	switch request.GetMsgID() {
+	case ziface.ReliableHelloMsgID:
+		mh.resumeReliable(request)
+	case ziface.CompressNegotiateMsgID:
+		mh.negotiateCompression(request)
+	default:
+		return false
+	}
+	return true
+}
+
+// transport handles the transport layer of a request: decryption, decompression, the reserved MsgIDs, the handshake
+// and the reliable sessions. It reports whether the request goes on to the routers, otherwise it was consumed here
+// and put back, unless the authenticator holds it
+// (处理请求的传输层: 解密、解压、保留MsgID、握手认证及可靠会话; 返回请求是否继续交给路由, 否则请求已在此处理并归还, 被认证暂存的除外)
+func (mh *MsgHandle) transport(request ziface.IRequest) bool {
+	if !decryptRequest(request) || !decompressRequest(request) || !unwrapRPC(request) {
+		PutRequest(request)
+		return false
+	}
+	if request.GetMsgID() == ziface.EncryptExchangeMsgID {
+		// The key exchange is the only transport message before authentication, so the handshake can be encrypted,
+		// the reply of a client goes back to its caller
+		// (密钥交换是认证前唯一处理的传输层消息, 以便握手消息可以加密; 客户端收到的应答交给调用方)
+		if !handleRPC(request) {
+			mh.exchangeKeys(request)
+		}
+		PutRequest(request)
+		return false
+	}
+	if mh.auth != nil {
+		if pass, held := mh.auth.check(request); !pass {
+			// Handshake messages and messages of connections not authenticated yet are not routed,
+			// held transport messages are handled once the connection is accepted
+			// (握手消息及未认证连接的消息不进行路由, 暂存的传输层消息在连接认证通过后处理)
+			if !held {
+				PutRequest(request)
+			}
+			return false
+		}
+	}
+	if mh.reliable != nil && !mh.reliable.receive(request) {
+		// Acknowledgements, duplicates and messages after a gap are not routed
+		// (确认消息、重复的消息及缺口之后的消息不进行路由)
+		PutRequest(request)
+		return false
+	}
+	if handleRPC(request) || mh.handleTransport(request) {
+		// RPC replies go straight back to the caller, transport messages are answered here
+		// (RPC应答直接交给调用方, 传输层消息在此应答)
+		PutRequest(request)
+		return false
+	}
+	return true
+}
+
+// transportInterceptor runs MsgHandle.transport right after the decoder, so the interceptors added with
+// AddInterceptor only see the messages of the routers, decrypted, decompressed and under their own MsgID
+// (紧接在解码器之后执行MsgHandle.transport, 使AddInterceptor添加的拦截器只看到交给路由的消息, 且已解密、解压并使用其原本的MsgID)
+type transportInterceptor struct {
+	mh *MsgHandle
+}
+
+func (t *transportInterceptor) Intercept(chain ziface.IChain) ziface.IcResp {
+	if iRequest, ok := chain.Request().(ziface.IRequest); ok && !t.mh.transport(iRequest) {
+		return nil
+	}
+	return chain.Proceed(chain.Request())
 }
 
 // Data processing interceptor that is necessary by default in Zinx
@@ -258,6 +417,13 @@ func (mh *MsgHandle) Intercept(chain ziface.IChain) ziface.IcResp {
 		switch request.(type) {
 		case ziface.IRequest:
 			iRequest := request.(ziface.IRequest)
+			if atomic.LoadInt32(&mh.draining) == 1 {
+				// The server is shutting down, new requests are dropped
+				// (服务器正在关闭，丢弃新的请求)
+				zlog.Ins().DebugF("server is draining, drop msgID = %d", iRequest.GetMsgID())
+				PutRequest(iRequest)
+				return chain.Proceed(chain.Request())
+			}
 			if mh.WorkerPoolSize > 0 {
 				// If the worker pool mechanism has been started, hand over the message to the worker for processing
 				// (已经启动工作池机制，将消息交给Worker处理)
@@ -266,10 +432,17 @@ func (mh *MsgHandle) Intercept(chain ziface.IChain) ziface.IcResp {
 
 				// Execute the corresponding Handle method from the bound message and its corresponding processing method
 				// (从绑定好的消息和对应的处理方法中执行对应的Handle方法)
+				atomic.AddInt64(&mh.inFlight, 1)
 				if !zconf.GlobalObject.RouterSlicesMode {
-					go mh.doMsgHandler(iRequest, WorkerIDWithoutWorkerPool)
+					go func() {
+						defer atomic.AddInt64(&mh.inFlight, -1)
+						mh.doMsgHandler(iRequest, WorkerIDWithoutWorkerPool)
+					}()
 				} else if zconf.GlobalObject.RouterSlicesMode {
-					go mh.doMsgHandlerSlices(iRequest, WorkerIDWithoutWorkerPool)
+					go func() {
+						defer atomic.AddInt64(&mh.inFlight, -1)
+						mh.doMsgHandlerSlices(iRequest, WorkerIDWithoutWorkerPool)
+					}()
 				}
 
 			}
@@ -297,10 +470,38 @@ func (mh *MsgHandle) AddInterceptor(interceptor ziface.IInterceptor) {
 // SendMsgToTaskQueue sends the message to the TaskQueue for processing by the worker
 // (将消息交给TaskQueue,由worker进行处理)
 func (mh *MsgHandle) SendMsgToTaskQueue(request ziface.IRequest) {
-	workerID := request.GetConnection().GetWorkerID()
+	workerID := mh.taskWorkerID(request)
 	// zlog.Ins().DebugF("Add ConnID=%d request msgID=%d to workerID=%d", request.GetConnection().GetConnID(), request.GetMsgID(), workerID)
 	// Send the request message to the task queue
-	mh.TaskQueue[workerID] <- request
+	atomic.AddInt64(&mh.inFlight, 1)
+	if mh.scale != nil {
+		workerID = mh.scale.enter(request.GetConnection(), workerID)
+		defer mh.scale.leave(workerID)
+	}
+
+	// Internal function requests such as zasync_op completions always wait for room
+	// (zasync_op完成回调等内部函数请求总是等待队列空位)
+	_, isFunc := request.(ziface.IFuncRequest)
+	if mh.spill != nil {
+		// Behind the requests already spilled, function requests are never dropped (排在已溢出的请求之后, 函数请求不会被丢弃)
+		spilled, ok := mh.spill[workerID].send(request, mh.TaskQueue[workerID], isFunc)
+		if !ok {
+			mh.overflowed(request, workerID)
+			return
+		}
+		if spilled {
+			atomic.AddUint64(&mh.spilled, 1)
+		}
+	} else if isFunc || mh.overflow == zconf.WorkerOverflowBlock {
+		mh.TaskQueue[workerID] <- request
+	} else {
+		select {
+		case mh.TaskQueue[workerID] <- request:
+		default:
+			mh.overflowed(request, workerID)
+			return
+		}
+	}
 	zlog.Ins().DebugF("SendMsgToTaskQueue-->%s", hex.EncodeToString(request.GetData()))
 }
 
@@ -337,7 +538,9 @@ func (mh *MsgHandle) doMsgHandler(request ziface.IRequest, workerID int) {
 	request.BindRouter(handler)
 
 	// Execute the corresponding processing method
+	start := time.Now()
 	request.Call()
+	mh.observeHandled(request, msgId, start)
 
 	// 执行完成后回收 Request 对象回对象池
 	PutRequest(request)
@@ -395,7 +598,9 @@ func (mh *MsgHandle) doMsgHandlerSlices(request ziface.IRequest, workerID int) {
 	}
 
 	request.BindRouterSlices(handlers)
+	start := time.Now()
 	request.RouterSlicesNext()
+	mh.observeHandled(request, msgId, start)
 	// 执行完成后回收 Request 对象回对象池
 	PutRequest(request)
 }
@@ -404,16 +609,42 @@ func (mh *MsgHandle) StopOneWorker(workerID int) {
 	zlog.Ins().DebugF("stop Worker ID = %d ", workerID)
 	// Stop the worker by closing the corresponding taskQueue
 	// (停止一个Worker，通过关闭对应的taskQueue)
-	close(mh.TaskQueue[workerID])
+	if mh.spill == nil {
+		close(mh.TaskQueue[workerID])
+		return
+	}
+	for _, request := range mh.spill[workerID].close(mh.TaskQueue[workerID]) {
+		atomic.AddInt64(&mh.inFlight, -1)
+		if mh.scale != nil {
+			mh.scale.finish(request.GetConnection(), uint32(workerID))
+		}
+		PutRequest(request)
+	}
 }
 
 // StartOneWorker starts a worker workflow
 // (启动一个Worker工作流程)
 func (mh *MsgHandle) StartOneWorker(workerID int, taskQueue chan ziface.IRequest) {
+	mh.workerLoop(workerID, taskQueue, nil)
+}
+
+// workerLoop handles the requests of taskQueue, refilled from the spill lane of the worker as it empties,
+// until taskQueue is closed or quit is closed
+// (处理taskQueue中的请求, 队列空时从该worker的溢出队列补充, 直到taskQueue或quit被关闭)
+func (mh *MsgHandle) workerLoop(workerID int, taskQueue chan ziface.IRequest, quit chan struct{}) {
 	zlog.Ins().DebugF("Worker ID = %d is started.", workerID)
 	// Continuously wait for messages in the queue
 	// (不断地等待队列中的消息)
 	for {
+		select {
+		case <-quit:
+			return
+		default:
+		}
+		if mh.spill != nil && len(taskQueue) == 0 {
+			mh.spill[workerID].refill(taskQueue)
+		}
+
 		select {
 		// If there is a message, take out the Request from the queue and execute the bound business method
 		// (有消息则取出队列的Request，并执行绑定的业务方法)
@@ -424,27 +655,64 @@ func (mh *MsgHandle) StartOneWorker(workerID int, taskQueue chan ziface.IRequest
 				zlog.Ins().ErrorF(" taskQueue is closed, Worker ID = %d quit", workerID)
 				return
 			}
-			switch req := request.(type) {
+			mh.handleTask(request, workerID)
+		case <-quit:
+			// Adaptive pool, the worker is no longer needed (可伸缩的池中不再需要该worker)
+			zlog.Ins().DebugF("Worker ID = %d quit", workerID)
+			return
+		}
+	}
+}
 
-			case ziface.IFuncRequest:
-				// Internal function call request (内部函数调用request)
+// handleTask executes one request taken by a worker (执行worker取出的一个请求)
+func (mh *MsgHandle) handleTask(request ziface.IRequest, workerID int) {
+	var start time.Time
+	var conn ziface.IConnection
+	if mh.scale != nil {
+		// The request is put back once handled (请求处理完成后会被归还)
+		start, conn = time.Now(), request.GetConnection()
+	}
 
-				mh.doFuncHandler(req, workerID)
+	switch req := request.(type) {
 
-			case ziface.IRequest: // Client message request
+	case ziface.IFuncRequest:
+		// Internal function call request (内部函数调用request)
 
-				if !zconf.GlobalObject.RouterSlicesMode {
-					mh.doMsgHandler(req, workerID)
-				} else if zconf.GlobalObject.RouterSlicesMode {
-					mh.doMsgHandlerSlices(req, workerID)
-				}
-			}
+		mh.doFuncHandler(req, workerID)
+
+	case ziface.IRequest: // Client message request
+
+		if !zconf.GlobalObject.RouterSlicesMode {
+			mh.doMsgHandler(req, workerID)
+		} else if zconf.GlobalObject.RouterSlicesMode {
+			mh.doMsgHandlerSlices(req, workerID)
 		}
 	}
+	atomic.AddInt64(&mh.inFlight, -1)
+	if workerID < len(mh.handled) {
+		atomic.AddUint64(&mh.handled[workerID], 1)
+	}
+	if mh.scale != nil {
+		mh.scale.observe(time.Since(start))
+		mh.scale.finish(conn, uint32(workerID))
+	}
 }
 
 // StartWorkerPool starts the worker pool
 func (mh *MsgHandle) StartWorkerPool() {
+	if mh.scale != nil {
+		// Every queue of an adaptive pool exists from the start, only WorkerPoolSize workers run
+		// (可伸缩的池一开始就创建所有队列, 只运行WorkerPoolSize个worker)
+		for i := range mh.TaskQueue {
+			mh.TaskQueue[i] = make(chan ziface.IRequest, zconf.GlobalObject.MaxWorkerTaskLen)
+		}
+		for i := uint32(0); i < mh.scale.min; i++ {
+			mh.startScaledWorker(i)
+		}
+		go mh.scaleWorkers()
+		return
+	}
+
 	// Iterate through the required number of workers and start them one by one
 	// (遍历需要启动worker的数量，依此启动)
 	for i := 0; i < int(mh.WorkerPoolSize); i++ {
@@ -458,3 +726,42 @@ func (mh *MsgHandle) StartWorkerPool() {
 		go mh.StartOneWorker(i, mh.TaskQueue[i])
 	}
 }
+
+// observeHandled records the request count and handling latency of one routed request
+// (记录一次路由处理的请求数和耗时)
+func (mh *MsgHandle) observeHandled(request ziface.IRequest, msgID uint32, start time.Time) {
+	if !zmetrics.Enabled() {
+		return
+	}
+	conn := request.GetConnection()
+	if conn == nil {
+		return
+	}
+	zmetrics.Metrics().Handled(conn.GetName(), msgID, time.Since(start))
+}
+
+// registerQueueMetrics exports the depth of every worker's TaskQueue under the given server name
+// (导出每个Worker任务队列的长度)
+func (mh *MsgHandle) registerQueueMetrics(serverName string) {
+	workers := int(mh.WorkerPoolSize)
+	if mh.scale != nil {
+		workers = int(mh.scale.max)
+	}
+	for i := 0; i < workers; i++ {
+		taskQueue := mh.TaskQueue[i]
+		zmetrics.Metrics().RegisterTaskQueue(serverName, i, func() int64 {
+			return int64(len(taskQueue))
+		})
+	}
+}
+
+// Drain stops accepting new client requests and blocks until every dispatched request
+// (including those still waiting in the TaskQueues) has been handled, or ctx is done.
+// Internal function requests (e.g. zasync_op completions) are still accepted while draining.
+// (停止接收新的客户端请求，并等待所有已分发的请求(包括TaskQueue中排队的)处理完毕，或ctx结束)
+func (mh *MsgHandle) Drain(ctx context.Context) error {
+	atomic.StoreInt32(&mh.draining, 1)
+	return waitUntil(ctx, func() bool {
+		return atomic.LoadInt64(&mh.inFlight) == 0
+	})
+}
@@ -1,10 +1,12 @@
 package znet
 
 import (
+	"crypto/tls"
 	"net/http"
 	"net/url"
 
 	"github.com/aceld/zinx/ziface"
+	"github.com/aceld/zinx/zlog"
 )
 
 // Options for Server
@@ -20,6 +22,64 @@ func WithPacket(pack ziface.IDataPack) Option {
 	}
 }
 
+// WithCodec sets the codec used by typed routers and IConnection.SendTyped, JSON by default
+// (设置类型化路由和IConnection.SendTyped使用的编解码器, 默认为JSON)
+func WithCodec(codec ziface.ICodec) Option {
+	return func(s *Server) {
+		s.SetCodec(codec)
+	}
+}
+
+// WithTLSConfig sets the TLS settings of the tcp and websocket listeners instead of the TLS fields of zconf
+// (设置tcp和websocket监听的TLS配置, 代替zconf中的TLS参数)
+func WithTLSConfig(config *tls.Config) Option {
+	return func(s *Server) {
+		s.tlsConfig = config
+	}
+}
+
+// WithCompression sets the compression accepted when clients negotiate, see IServer.SetCompression
+// (设置客户端协商时可接受的压缩算法, 参见IServer.SetCompression)
+func WithCompression(threshold int, names ...string) Option {
+	return func(s *Server) {
+		s.SetCompression(threshold, names...)
+	}
+}
+
+// WithEncryption encrypts the messages of the connections, see IServer.SetEncryption
+// (加密连接的消息, 参见IServer.SetEncryption)
+func WithEncryption(option *ziface.EncryptOption) Option {
+	return func(s *Server) {
+		s.SetEncryption(option)
+	}
+}
+
+// WithReliable lets clients open reliable sessions, see IServer.SetReliable
+// (允许客户端开启可靠会话, 参见IServer.SetReliable)
+func WithReliable(option *ziface.ReliableOption) Option {
+	return func(s *Server) {
+		s.SetReliable(option)
+	}
+}
+
+// WithDispatchKey sets the dispatch key of the requests in zconf.WorkerModeKey, see IServer.SetDispatchKey
+// (设置zconf.WorkerModeKey模式下请求的分发key, 参见IServer.SetDispatchKey)
+func WithDispatchKey(fn ziface.DispatchKeyFunc) Option {
+	return func(s *Server) {
+		s.SetDispatchKey(fn)
+	}
+}
+
+// WithListener adds a listener to the server, see IServer.AddListener
+// (为Server添加一个监听器, 参见IServer.AddListener)
+func WithListener(conf ziface.ListenerConfig) Option {
+	return func(s *Server) {
+		if err := s.AddListener(conf); err != nil {
+			panic(err)
+		}
+	}
+}
+
 // Options for Client
 type ClientOption func(c ziface.IClient)
 
@@ -31,6 +91,81 @@ func WithPacketClient(pack ziface.IDataPack) ClientOption {
 	}
 }
 
+// WithCodecClient sets the codec used by typed routers and IConnection.SendTyped for client, JSON by default
+func WithCodecClient(codec ziface.ICodec) ClientOption {
+	return func(c ziface.IClient) {
+		c.SetCodec(codec)
+	}
+}
+
+// WithCompressionClient offers the compression algorithms in names to the server once connected, in order of preference,
+// message data shorter than threshold is never compressed
+// (连接后按优先级向服务端提供names中的压缩算法, 长度小于threshold的消息数据不压缩)
+func WithCompressionClient(threshold int, names ...string) ClientOption {
+	return func(c ziface.IClient) {
+		if client, ok := c.(*Client); ok {
+			client.compress = newCompressOption(threshold, names)
+		}
+	}
+}
+
+// WithEncryptionClient exchanges keys with the server once connected and encrypts the messages,
+// the messages sent before the exchange finishes are held until then
+// (连接后与服务端交换密钥并加密消息, 交换完成前发送的消息会被暂存)
+func WithEncryptionClient(option *ziface.EncryptOption) ClientOption {
+	return func(c ziface.IClient) {
+		if client, ok := c.(*Client); ok {
+			client.encrypt = newEncryptOption(option)
+		}
+	}
+}
+
+// WithReliableClient opens a reliable session resumed on every connection of the client, see IClient.GetReliableSession
+// (开启在客户端每个连接上恢复的可靠会话, 参见IClient.GetReliableSession)
+func WithReliableClient(option *ziface.ReliableOption) ClientOption {
+	return func(c ziface.IClient) {
+		if client, ok := c.(*Client); ok {
+			client.reliable = newReliableManager(newReliableOption(option), true)
+			if mh, ok := client.msgHandler.(*MsgHandle); ok {
+				mh.reliable = client.reliable
+			}
+		}
+	}
+}
+
+// WithInsecureSkipVerifyClient makes the client dial with TLS without verifying the server certificate,
+// only for testing with self-signed certificates, use WithTLSConfigClient otherwise
+// (客户端使用TLS连接且不校验服务端证书, 仅用于自签名证书的测试, 其他情况请使用WithTLSConfigClient)
+func WithInsecureSkipVerifyClient() ClientOption {
+	return func(c ziface.IClient) {
+		if client, ok := c.(*Client); ok {
+			client.useTLS = true
+			client.insecureSkipVerify = true
+			if client.dialer != nil {
+				client.dialer.TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
+			}
+		}
+	}
+}
+
+// WithTLSConfigClient makes the client dial with TLS and verify the server with config,
+// set RootCAs (see LoadCertPool) and ServerName to verify a server with a private CA,
+// and Certificates for servers that require client certificates
+// (客户端使用TLS连接并按config校验服务端, 私有CA签发的服务端证书需设置RootCAs(参见LoadCertPool)和ServerName,
+// 服务端要求客户端证书时设置Certificates)
+func WithTLSConfigClient(config *tls.Config) ClientOption {
+	return func(c ziface.IClient) {
+		if client, ok := c.(*Client); ok {
+			client.useTLS = true
+			client.tlsConfig = config
+			// Used by wss:// urls of websocket clients (websocket客户端连接wss://地址时使用)
+			if client.dialer != nil {
+				client.dialer.TLSClientConfig = config
+			}
+		}
+	}
+}
+
 // Set client name
 func WithNameClient(name string) ClientOption {
 	return func(c ziface.IClient) {
@@ -50,3 +185,53 @@ func WithWsHeader(header http.Header) ClientOption {
 		c.SetWsHeader(header)
 	}
 }
+
+// WithReconnect makes the client redial with backoff when the connection is lost or the first dial fails
+// (开启客户端断线自动重连, 按退避策略重新拨号)
+func WithReconnect(policy ReconnectPolicy) ClientOption {
+	return func(c ziface.IClient) {
+		if client, ok := c.(*Client); ok {
+			policy = policy.withDefaults()
+			client.reconnect = &policy
+		}
+	}
+}
+
+// WithOnReconnecting sets the hook called before every reconnect attempt,
+// err is the failure of the previous attempt, nil for the first attempt after the connection is lost
+// (设置每次尝试重连前的Hook, err为上一次重连失败的原因, 断线后的第一次重连为nil)
+func WithOnReconnecting(hook func(attempt int, err error)) ClientOption {
+	return func(c ziface.IClient) {
+		if client, ok := c.(*Client); ok {
+			client.onReconnecting = hook
+		}
+	}
+}
+
+// WithOnReconnected sets the hook called once the client is connected again
+// (设置重连成功后的Hook)
+func WithOnReconnected(hook func(conn ziface.IConnection)) ClientOption {
+	return func(c ziface.IClient) {
+		if client, ok := c.(*Client); ok {
+			client.onReconnected = hook
+		}
+	}
+}
+
+// WithShutdownMsg sends the given message to every connection right before Shutdown closes it
+// (Shutdown关闭连接之前向每个连接发送一条"服务器关闭"消息)
+func WithShutdownMsg(msgID uint32, data []byte) Option {
+	return WithShutdownHook(func(conn ziface.IConnection) {
+		if err := conn.SendMsg(msgID, data); err != nil {
+			zlog.Ins().ErrorF("send shutdown msg to ConnID = %d err: %v", conn.GetConnID(), err)
+		}
+	})
+}
+
+// WithShutdownHook calls hook on every connection right before Shutdown closes it
+// (Shutdown关闭连接之前对每个连接调用hook)
+func WithShutdownHook(hook func(conn ziface.IConnection)) Option {
+	return func(s *Server) {
+		s.onShutdown = hook
+	}
+}
@@ -0,0 +1,61 @@
+package znet
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aceld/zinx/zconf"
+	"github.com/aceld/zinx/ziface"
+)
+
+// run in terminal:
+// go test -v ./znet -run=TestRateLimitUnwrapped
+
+func TestRateLimitUnwrapped(t *testing.T) {
+	conf := *zconf.GlobalObject
+	conf.Name = "RateLimitTest"
+	conf.Host = "127.0.0.1"
+	conf.TCPPort = 19028
+	conf.RateLimitMsgRate, conf.RateLimitMsgBurst = 0.01, 2
+	conf.RateLimitAction = zconf.RateLimitActionDrop
+
+	s := newServerWithConfig(&conf, "tcp", WithCompression(64, ziface.ZinxCompressGzip))
+	s.AddRouter(1, &RPCEchoRouter{})
+	s.AddRouter(2, &RPCEchoRouter{})
+	s.Start()
+	defer s.Stop()
+	time.Sleep(time.Second * 1)
+
+	client := NewClient("127.0.0.1", 19028, WithCompressionClient(64, ziface.ZinxCompressGzip)).(*Client)
+	client.Start()
+	defer client.Stop()
+	time.Sleep(time.Second * 1)
+
+	conn := client.Conn()
+	if conn == nil {
+		t.Fatal("client not connected")
+	}
+	call := func(msgID uint32, data string) error {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*500)
+		defer cancel()
+		_, err := conn.Call(ctx, msgID, []byte(data))
+		return err
+	}
+
+	// Compressed and plain RPC calls count under the MsgID called, each with its own bucket
+	// (压缩和未压缩的RPC调用都计入被调用的MsgID, 各自使用自己的令牌桶)
+	long := strings.Repeat("state sync ", 100)
+	for _, c := range []struct {
+		msgID uint32
+		data  string
+	}{{1, long}, {1, "short"}, {2, long}, {2, "short"}} {
+		if err := call(c.msgID, c.data); err != nil {
+			t.Fatalf("call msgID = %d of %d bytes err: %v", c.msgID, len(c.data), err)
+		}
+	}
+	if err := call(1, "short"); err == nil {
+		t.Fatal("third call of msgID 1 not limited")
+	}
+}
@@ -0,0 +1,214 @@
+package znet
+
+import (
+	"context"
+	"errors"
+	"math"
+	"math/rand"
+	"time"
+
+	"github.com/aceld/zinx/ziface"
+	"github.com/aceld/zinx/zlog"
+	"github.com/aceld/zinx/zpack"
+)
+
+// ReconnectPolicy describes how a Client redials after its connection is lost.
+// Zero fields fall back to the values of DefaultReconnectPolicy.
+// (Client断线重连策略, 值为零的字段使用DefaultReconnectPolicy中的默认值)
+type ReconnectPolicy struct {
+	// Delay before the first attempt, doubled (Multiplier) after each failed attempt up to MaxBackoff
+	// (第一次重连前的等待时间, 每失败一次乘以Multiplier, 最大为MaxBackoff)
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+	Multiplier float64
+
+	// Randomizes every delay by ±Jitter (0~1), so that a fleet of clients does not redial at the same moment
+	// (每次等待时间随机浮动±Jitter(0~1), 避免大量客户端同时重连)
+	Jitter float64
+
+	// Give up after MaxAttempts failed dials in a row, 0 means retry forever
+	// (连续失败MaxAttempts次后放弃, 0表示无限重试)
+	MaxAttempts int
+
+	// Timeout of a single dial (单次拨号超时时间)
+	DialTimeout time.Duration
+
+	// Keep the messages sent by Client.SendBuffMsg while disconnected and send them once reconnected,
+	// at most MaxReplayMsgs messages are kept
+	// (断线期间通过Client.SendBuffMsg发送的消息先缓存起来, 重连成功后按顺序发送, 最多缓存MaxReplayMsgs条)
+	ReplayBuffered bool
+	MaxReplayMsgs  int
+}
+
+// DefaultReconnectPolicy returns the default reconnect policy
+// (默认的重连策略)
+func DefaultReconnectPolicy() ReconnectPolicy {
+	return ReconnectPolicy{
+		MinBackoff:    500 * time.Millisecond,
+		MaxBackoff:    30 * time.Second,
+		Multiplier:    2,
+		Jitter:        0.2,
+		MaxAttempts:   0,
+		DialTimeout:   5 * time.Second,
+		MaxReplayMsgs: 1024,
+	}
+}
+
+func (p ReconnectPolicy) withDefaults() ReconnectPolicy {
+	def := DefaultReconnectPolicy()
+	if p.MinBackoff <= 0 {
+		p.MinBackoff = def.MinBackoff
+	}
+	if p.MaxBackoff < p.MinBackoff {
+		p.MaxBackoff = def.MaxBackoff
+		if p.MaxBackoff < p.MinBackoff {
+			p.MaxBackoff = p.MinBackoff
+		}
+	}
+	if p.Multiplier < 1 {
+		p.Multiplier = def.Multiplier
+	}
+	if p.Jitter < 0 || p.Jitter > 1 {
+		p.Jitter = def.Jitter
+	}
+	if p.DialTimeout <= 0 {
+		p.DialTimeout = def.DialTimeout
+	}
+	if p.MaxReplayMsgs <= 0 {
+		p.MaxReplayMsgs = def.MaxReplayMsgs
+	}
+	return p
+}
+
+// backoff returns how long to wait before the given attempt, attempt starts from 1
+// (第attempt次重连前的等待时间, attempt从1开始)
+func (p ReconnectPolicy) backoff(attempt int) time.Duration {
+	d := float64(p.MinBackoff) * math.Pow(p.Multiplier, float64(attempt-1))
+	if d > float64(p.MaxBackoff) {
+		d = float64(p.MaxBackoff)
+	}
+	if p.Jitter > 0 {
+		d += d * p.Jitter * (rand.Float64()*2 - 1)
+	}
+	return time.Duration(d)
+}
+
+// reconnectHooks is the IClient handed to the connections of a Client that reconnects automatically.
+// It wraps the user's OnConnStart/OnConnStop hooks to replay buffered messages and to notice lost connections.
+// (开启自动重连时传给连接的IClient, 包装用户的连接创建/断开Hook, 用于重放缓存消息和感知断线)
+type reconnectHooks struct {
+	*Client
+	reconnected bool
+	closed      chan struct{}
+}
+
+func (h *reconnectHooks) GetOnConnStart() func(ziface.IConnection) {
+	return func(conn ziface.IConnection) {
+		if h.onConnStart != nil {
+			h.onConnStart(conn)
+		}
+		h.replay(conn)
+		if h.reconnected && h.onReconnected != nil {
+			h.onReconnected(conn)
+		}
+	}
+}
+
+func (h *reconnectHooks) GetOnConnStop() func(ziface.IConnection) {
+	return func(conn ziface.IConnection) {
+		if h.onConnStop != nil {
+			h.onConnStop(conn)
+		}
+		h.replayMux.Lock()
+		h.disconnected = true
+		h.replayMux.Unlock()
+		close(h.closed)
+	}
+}
+
+// redial dials again with backoff until it succeeds, the client is stopped or MaxAttempts is reached
+// (按退避策略重新拨号, 直到成功、客户端停止或达到最大重试次数)
+func (c *Client) redial(lastErr error) (ziface.IConnection, chan struct{}) {
+	policy := c.reconnect
+
+	for attempt := 1; policy.MaxAttempts <= 0 || attempt <= policy.MaxAttempts; attempt++ {
+		if c.ctx.Err() != nil {
+			return nil, nil
+		}
+		if c.onReconnecting != nil {
+			c.onReconnecting(attempt, lastErr)
+		}
+
+		delay := policy.backoff(attempt)
+		zlog.Ins().InfoF("[RECONNECT] Zinx Client %s:%d attempt %d after %v", c.Ip, c.Port, attempt, delay)
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-c.ctx.Done():
+			timer.Stop()
+			return nil, nil
+		case <-timer.C:
+		}
+
+		dialCtx, cancel := context.WithTimeout(c.ctx, policy.DialTimeout)
+		connect, closed, err := c.dial(dialCtx, true)
+		cancel()
+		if err == nil {
+			return connect, closed
+		}
+		lastErr = err
+	}
+
+	zlog.Ins().ErrorF("[RECONNECT] Zinx Client %s:%d give up after %d attempts, err: %v", c.Ip, c.Port, policy.MaxAttempts, lastErr)
+	c.dropReplay()
+	c.notifyErr(lastErr)
+	return nil, nil
+}
+
+// SendBuffMsg sends a message through the current connection with buffering.
+// When ReconnectPolicy.ReplayBuffered is on and the client is reconnecting, the message is kept and sent after reconnecting.
+// (通过当前连接发送有缓冲的消息, 开启ReplayBuffered且正在重连时, 消息会缓存到重连成功后再发送)
+func (c *Client) SendBuffMsg(msgID uint32, data []byte, opts ...ziface.MsgSendOption) error {
+	c.replayMux.Lock()
+	if c.disconnected && c.reconnect != nil && c.reconnect.ReplayBuffered {
+		defer c.replayMux.Unlock()
+		if len(c.replayMsgs) >= c.reconnect.MaxReplayMsgs {
+			return errors.New("reconnect replay queue is full")
+		}
+		c.replayMsgs = append(c.replayMsgs, zpack.NewMsgPackage(msgID, append([]byte(nil), data...)))
+		return nil
+	}
+	c.replayMux.Unlock()
+
+	conn := c.Conn()
+	if conn == nil {
+		return errors.New("client is not connected")
+	}
+	return conn.SendBuffMsg(msgID, data, opts...)
+}
+
+// replay sends the messages kept while disconnected through the new connection
+// (通过新连接发送断线期间缓存的消息)
+func (c *Client) replay(conn ziface.IConnection) {
+	c.replayMux.Lock()
+	defer c.replayMux.Unlock()
+
+	for _, msg := range c.replayMsgs {
+		if err := conn.SendBuffMsg(msg.GetMsgID(), msg.GetData()); err != nil {
+			zlog.Ins().ErrorF("[RECONNECT] replay msgID = %d err: %v", msg.GetMsgID(), err)
+		}
+	}
+	c.replayMsgs = nil
+	c.disconnected = false
+}
+
+func (c *Client) dropReplay() {
+	c.replayMux.Lock()
+	defer c.replayMux.Unlock()
+
+	if len(c.replayMsgs) > 0 {
+		zlog.Ins().ErrorF("[RECONNECT] drop %d buffered msgs", len(c.replayMsgs))
+	}
+	c.replayMsgs = nil
+	c.disconnected = false
+}
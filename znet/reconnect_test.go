@@ -0,0 +1,104 @@
+package znet
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/aceld/zinx/zconf"
+	"github.com/aceld/zinx/ziface"
+)
+
+// run in terminal:
+// go test -v ./znet -run=TestClientReconnect
+
+type ReconnectCountRouter struct {
+	BaseRouter
+	count *int32
+}
+
+func (r *ReconnectCountRouter) Handle(req ziface.IRequest) {
+	atomic.AddInt32(r.count, 1)
+}
+
+func newReconnectTestServer(port int, count *int32) ziface.IServer {
+	conf := *zconf.GlobalObject
+	conf.Name = "ReconnectTest"
+	conf.Host = "127.0.0.1"
+	conf.TCPPort = port
+
+	s := newServerWithConfig(&conf, "tcp")
+	s.AddRouter(1, &ReconnectCountRouter{count: count})
+	s.Start()
+	return s
+}
+
+func TestClientReconnect(t *testing.T) {
+	var received, attempts int32
+	reconnected := make(chan struct{}, 1)
+
+	s := newReconnectTestServer(19002, &received)
+	time.Sleep(time.Second * 1)
+
+	policy := DefaultReconnectPolicy()
+	policy.MinBackoff = 100 * time.Millisecond
+	policy.MaxBackoff = 200 * time.Millisecond
+	policy.ReplayBuffered = true
+
+	client := NewClient("127.0.0.1", 19002,
+		WithReconnect(policy),
+		WithOnReconnecting(func(attempt int, err error) {
+			atomic.AddInt32(&attempts, 1)
+		}),
+		WithOnReconnected(func(conn ziface.IConnection) {
+			reconnected <- struct{}{}
+		}),
+	)
+	client.Start()
+	defer client.Stop()
+	time.Sleep(time.Second * 1)
+
+	// Restart the server, the messages sent meanwhile are replayed after reconnecting
+	// (重启服务端, 断线期间发送的消息在重连后重放)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+	defer cancel()
+	if err := s.Shutdown(ctx); err != nil {
+		t.Fatalf("shutdown err: %v", err)
+	}
+	time.Sleep(time.Millisecond * 300)
+
+	for i := 0; i < 3; i++ {
+		if err := client.SendBuffMsg(1, []byte("replay")); err != nil {
+			t.Fatalf("SendBuffMsg err: %v", err)
+		}
+	}
+
+	s = newReconnectTestServer(19002, &received)
+	defer s.Stop()
+
+	select {
+	case <-reconnected:
+	case <-time.After(time.Second * 5):
+		t.Fatal("client did not reconnect")
+	}
+	if atomic.LoadInt32(&attempts) == 0 {
+		t.Fatal("OnReconnecting was not called")
+	}
+
+	time.Sleep(time.Millisecond * 500)
+	if n := atomic.LoadInt32(&received); n != 3 {
+		t.Fatalf("server received %d replayed msgs, want 3", n)
+	}
+}
+
+func TestReconnectBackoff(t *testing.T) {
+	policy := ReconnectPolicy{MinBackoff: time.Second, MaxBackoff: 4 * time.Second, Multiplier: 2}.withDefaults()
+	policy.Jitter = 0
+
+	for attempt, want := range []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 4 * time.Second} {
+		if got := policy.backoff(attempt + 1); got != want {
+			t.Fatalf("backoff(%d) = %v, want %v", attempt+1, got, want)
+		}
+	}
+}
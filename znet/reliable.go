@@ -0,0 +1,568 @@
+package znet
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/aceld/zinx/ziface"
+	"github.com/aceld/zinx/zlog"
+	"github.com/aceld/zinx/zpack"
+)
+
+const (
+	// reliableHelloTimeout is how long a client waits for the server to resume its session
+	// (客户端等待服务端恢复会话的时长)
+	reliableHelloTimeout = 10 * time.Second
+
+	// The receiver acknowledges every reliableAckEvery messages, or reliableAckDelay after the first one not acknowledged
+	// (接收方每收到reliableAckEvery条消息确认一次, 或在第一条未确认的消息到达reliableAckDelay后确认)
+	reliableAckEvery = 32
+	reliableAckDelay = 50 * time.Millisecond
+
+	reliableTokenLen = 16
+)
+
+var (
+	// ErrReliableWindowFull is returned by IReliableSession.Send when too many messages are not acknowledged yet
+	// (未确认的消息过多时IReliableSession.Send返回的错误)
+	ErrReliableWindowFull = errors.New("reliable session window is full")
+
+	// ErrReliableSessionClosed is returned by IReliableSession.Send after the server dropped the session
+	// (服务端丢弃会话后IReliableSession.Send返回的错误)
+	ErrReliableSessionClosed = errors.New("reliable session closed")
+)
+
+// reliableOption is the reliable session setting of a Server or Client
+// (Server或Client的可靠会话设置)
+type reliableOption struct {
+	window        int
+	resumeTimeout time.Duration
+}
+
+func newReliableOption(option *ziface.ReliableOption) *reliableOption {
+	o := &reliableOption{window: ziface.ReliableDefaultWindow, resumeTimeout: ziface.ReliableDefaultResumeTimeout}
+	if option != nil {
+		if option.Window > 0 {
+			o.window = option.Window
+		}
+		if option.ResumeTimeout > 0 {
+			o.resumeTimeout = option.ResumeTimeout
+		}
+	}
+	return o
+}
+
+// reliableMsg is a message sent through a session and not acknowledged yet (通过会话发送且未确认的消息)
+type reliableMsg struct {
+	seq   uint64
+	msgID uint32
+	data  []byte
+}
+
+// reliableSession numbers the messages it sends and keeps them until acknowledged,
+// and delivers the messages it receives once and in order
+// (为发送的消息编号并保留到被确认, 收到的消息按顺序且只投递一次)
+type reliableSession struct {
+	manager *reliableManager
+	token   string
+	// The identity the connection was authenticated as when the session was created, only resumed by the same identity
+	// (创建会话时连接认证的身份, 只能由同一身份恢复)
+	identity interface{}
+
+	// Held while sending, so that the messages keep their order (发送期间持有, 保证消息的顺序)
+	lock   sync.Mutex
+	conn   ziface.IConnection // nil while detached (断开期间为nil)
+	closed bool
+
+	sendSeq   uint64
+	window    []reliableMsg
+	peerAcked uint64 // Acknowledged by the peer, stored by the reader without waiting for lock (对端已确认的序号, 读协程写入, 无需等待lock)
+
+	// The reader never waits for a sender writing to the peer (读协程从不等待正在向对端写数据的发送方)
+	recvLock sync.Mutex
+	recvSeq  uint64 // The last message delivered (最后投递的消息序号)
+	pending  int    // Messages delivered and not acknowledged yet (已投递未确认的消息数)
+	ackTimer *time.Timer
+
+	expireTimer *time.Timer
+}
+
+func (s *reliableSession) Token() string {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.token
+}
+
+func (s *reliableSession) GetConnection() ziface.IConnection {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.conn
+}
+
+func (s *reliableSession) Unacked() int {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.prune()
+	return len(s.window)
+}
+
+// Send writes the message directly so that it keeps its order with the retransmissions on resume,
+// a message that can not be written detaches the session and waits for the resume
+// (直接写出消息以保证与恢复时的重传保持顺序, 消息写出失败时会话断开绑定, 等待恢复)
+func (s *reliableSession) Send(msgID uint32, data []byte) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.closed {
+		return ErrReliableSessionClosed
+	}
+	s.prune()
+	if len(s.window) >= s.manager.option.window {
+		return ErrReliableWindowFull
+	}
+	s.sendSeq++
+	msg := reliableMsg{seq: s.sendSeq, msgID: msgID, data: append([]byte{}, data...)}
+	s.window = append(s.window, msg)
+	if s.conn != nil {
+		s.write(msg)
+	}
+	return nil
+}
+
+// write sends a message on the bound connection, s.lock must be held
+// (在绑定的连接上发送消息, 调用时需持有s.lock)
+func (s *reliableSession) write(msg reliableMsg) bool {
+	if err := s.conn.SendMsg(ziface.ReliableMsgID, zpack.PackReliable(msg.seq, msg.msgID, msg.data)); err != nil {
+		zlog.Ins().ErrorF("reliable session send msgID = %d seq = %d err: %v, wait for resume", msg.msgID, msg.seq, err)
+		s.unbind()
+		return false
+	}
+	return true
+}
+
+// retransmit sends every message not acknowledged yet, s.lock must be held
+// (重新发送所有未确认的消息, 调用时需持有s.lock)
+func (s *reliableSession) retransmit() {
+	s.prune()
+	for _, msg := range s.window {
+		if !s.write(msg) {
+			return
+		}
+	}
+}
+
+// acked records that the peer acknowledged the messages up to seq (记录对端已确认seq及之前的消息)
+func (s *reliableSession) acked(seq uint64) {
+	for {
+		old := atomic.LoadUint64(&s.peerAcked)
+		if seq <= old || atomic.CompareAndSwapUint64(&s.peerAcked, old, seq) {
+			return
+		}
+	}
+}
+
+// prune drops the messages the peer acknowledged, s.lock must be held
+// (丢弃对端已确认的消息, 调用时需持有s.lock)
+func (s *reliableSession) prune() {
+	seq := atomic.LoadUint64(&s.peerAcked)
+	i := 0
+	for i < len(s.window) && s.window[i].seq <= seq {
+		i++
+	}
+	if i > 0 {
+		s.window = append(s.window[:0], s.window[i:]...)
+	}
+}
+
+// received reports whether the message seq arriving on conn is the next one to deliver, duplicates
+// and messages after a gap are dropped, the gap is filled by the retransmission on resume
+// (返回conn上到达的消息seq是否是下一条应投递的消息, 重复的消息和缺口之后的消息会被丢弃, 缺口由恢复时的重传补齐)
+func (s *reliableSession) received(conn ziface.IConnection, seq uint64) bool {
+	s.recvLock.Lock()
+	deliver := seq == s.recvSeq+1
+	if deliver {
+		s.recvSeq = seq
+	} else if seq > s.recvSeq {
+		s.recvLock.Unlock()
+		zlog.Ins().DebugF("reliable session got seq = %d after %d, drop it", seq, s.recvSeq)
+		return false
+	}
+
+	// Duplicates are acknowledged again so that the sender drops them (重复的消息也会再次确认, 以便发送方丢弃)
+	s.pending++
+	var ack uint64
+	if s.pending >= reliableAckEvery {
+		ack = s.takeAck()
+	} else if s.ackTimer == nil {
+		s.ackTimer = time.AfterFunc(reliableAckDelay, func() {
+			s.recvLock.Lock()
+			ack := s.takeAck()
+			s.recvLock.Unlock()
+			sendReliableAck(conn, ack)
+		})
+	}
+	s.recvLock.Unlock()
+
+	sendReliableAck(conn, ack)
+	return deliver
+}
+
+// takeAck returns the sequence number to acknowledge, 0 when there is nothing to, s.recvLock must be held
+// (返回需要确认的序号, 没有需要确认的消息时为0, 调用时需持有s.recvLock)
+func (s *reliableSession) takeAck() uint64 {
+	if s.ackTimer != nil {
+		s.ackTimer.Stop()
+		s.ackTimer = nil
+	}
+	if s.pending == 0 {
+		return 0
+	}
+	s.pending = 0
+	return s.recvSeq
+}
+
+func sendReliableAck(conn ziface.IConnection, seq uint64) {
+	if seq == 0 || conn.Context().Err() != nil {
+		return
+	}
+	data := make([]byte, 8)
+	binary.BigEndian.PutUint64(data, seq)
+	if err := conn.SendMsg(ziface.ReliableAckMsgID, data); err != nil {
+		zlog.Ins().ErrorF("reliable session ack seq = %d err: %v", seq, err)
+	}
+}
+
+// bind binds the session to conn, s.lock must be held (将会话绑定到conn, 调用时需持有s.lock)
+func (s *reliableSession) bind(conn ziface.IConnection) {
+	m := s.manager
+	m.lock.Lock()
+	if s.conn != nil {
+		delete(m.conns, s.conn)
+	}
+	m.conns[conn] = s
+	m.lock.Unlock()
+
+	s.conn = conn
+	if s.expireTimer != nil {
+		s.expireTimer.Stop()
+		s.expireTimer = nil
+	}
+}
+
+// watch detaches the session when conn closes. It is called without s.lock,
+// as the close callbacks of a connection run under a lock of the connection.
+// (conn关闭时解除会话的绑定; 调用时不能持有s.lock, 因为连接的关闭回调在连接自身的锁内执行)
+func (s *reliableSession) watch(conn ziface.IConnection) {
+	conn.AddCloseCallback(s.manager, s, func() {
+		s.detach(conn)
+	})
+	// Closed before the callback was added (添加回调之前已关闭)
+	if conn.Context().Err() != nil {
+		s.detach(conn)
+	}
+}
+
+func (s *reliableSession) detach(conn ziface.IConnection) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.conn == conn {
+		s.unbind()
+	}
+}
+
+// unbind detaches the session from its connection, the server drops it unless it is resumed in time,
+// s.lock must be held
+// (解除会话与连接的绑定, 服务端会丢弃未及时恢复的会话, 调用时需持有s.lock)
+func (s *reliableSession) unbind() {
+	m := s.manager
+	m.lock.Lock()
+	delete(m.conns, s.conn)
+	m.lock.Unlock()
+
+	s.conn = nil
+	if m.client || s.expireTimer != nil {
+		return
+	}
+	s.expireTimer = time.AfterFunc(m.option.resumeTimeout, func() {
+		s.lock.Lock()
+		if s.conn != nil || s.closed {
+			s.lock.Unlock()
+			return
+		}
+		s.closed = true
+		s.window = nil
+		s.lock.Unlock()
+
+		m.lock.Lock()
+		delete(m.sessions, s.token)
+		m.lock.Unlock()
+		zlog.Ins().DebugF("reliable session %s not resumed in %v, drop it", s.token, m.option.resumeTimeout)
+	})
+}
+
+// helloData is the data of the hello and of its reply, the token follows the sequence number delivered last
+// (握手消息及其应答的数据, 最后投递的序号后面跟随token)
+func (s *reliableSession) helloData() []byte {
+	s.recvLock.Lock()
+	defer s.recvLock.Unlock()
+	data := make([]byte, 8, 8+len(s.token))
+	binary.BigEndian.PutUint64(data, s.recvSeq)
+	return append(data, s.token...)
+}
+
+func parseHello(data []byte) (seq uint64, token string, ok bool) {
+	if len(data) < 8 {
+		return 0, "", false
+	}
+	return binary.BigEndian.Uint64(data[:8]), string(data[8:]), true
+}
+
+// reliableManager keeps the reliable sessions of a Server by token, a Client has a single session
+// (按token保存Server的可靠会话, Client只有一个会话)
+type reliableManager struct {
+	option *reliableOption
+	client bool
+
+	lock     sync.Mutex
+	sessions map[string]*reliableSession
+	conns    map[ziface.IConnection]*reliableSession
+
+	// The session of a client (客户端的会话)
+	session *reliableSession
+}
+
+func newReliableManager(option *reliableOption, client bool) *reliableManager {
+	m := &reliableManager{
+		option:   option,
+		client:   client,
+		sessions: make(map[string]*reliableSession),
+		conns:    make(map[ziface.IConnection]*reliableSession),
+	}
+	if client {
+		m.session = &reliableSession{manager: m}
+	}
+	return m
+}
+
+func (m *reliableManager) get(conn ziface.IConnection) *reliableSession {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	return m.conns[conn]
+}
+
+// receive handles the acknowledgements and restores the MsgID and data of the ziface.ReliableMsgID messages
+// of a reliable session before they are routed, other messages are left alone. false means the request
+// should be dropped. The client resumes its session
+// when the reply of the hello arrives, in the reader, before the retransmitted messages following it.
+// (在路由前处理确认消息并还原ziface.ReliableMsgID可靠会话消息的MsgID和数据, 其他消息保持不变; 返回false表示应丢弃该请求。
+// 客户端在读协程中收到握手应答时恢复会话, 先于紧随其后的重传消息)
+func (m *reliableManager) receive(request ziface.IRequest) bool {
+	msg := request.GetMessage()
+	if msg == nil {
+		return true
+	}
+	conn := request.GetConnection()
+
+	switch msg.GetMsgID() {
+	case ziface.ReliableAckMsgID:
+		if s := m.get(conn); s != nil && len(msg.GetData()) == 8 {
+			s.acked(binary.BigEndian.Uint64(msg.GetData()))
+		}
+		return false
+	case ziface.ReliableHelloMsgID:
+		if m.client {
+			m.resumed(request)
+		}
+		return true
+	}
+
+	if msg.GetMsgID() != ziface.ReliableMsgID {
+		return true
+	}
+	seq, msgID, payload, ok := zpack.UnpackReliable(msg.GetData())
+	if !ok {
+		zlog.Ins().ErrorF("ConnID = %d malformed reliable frame, drop it", conn.GetConnID())
+		return false
+	}
+	s := m.get(conn)
+	if s == nil {
+		zlog.Ins().ErrorF("ConnID = %d msgID = %d of no reliable session, drop it", conn.GetConnID(), msgID)
+		return false
+	}
+	if !s.received(conn, seq) {
+		return false
+	}
+	msg.SetMsgID(msgID)
+	msg.SetData(payload)
+	msg.SetDataLen(uint32(len(payload)))
+	return true
+}
+
+// resumed binds the session of the client to conn as the server replied, a new token means the server
+// dropped the old session, the messages not acknowledged are then numbered again in the new one
+// (服务端应答后将客户端的会话绑定到conn; token变化说明服务端已丢弃旧会话, 此时未确认的消息在新会话中重新编号)
+func (m *reliableManager) resumed(request ziface.IRequest) {
+	if req, ok := request.(*Request); !ok || req.rpcFlag != zpack.RPCFlagReply {
+		return
+	}
+	seq, token, ok := parseHello(request.GetData())
+	if !ok {
+		return
+	}
+	conn := request.GetConnection()
+
+	s := m.session
+	s.lock.Lock()
+	if token != s.token {
+		if s.token != "" {
+			zlog.Ins().InfoF("reliable session %s expired on the server, resend %d messages in session %s", s.token, len(s.window), token)
+		}
+		s.prune()
+		s.token = token
+		s.recvLock.Lock()
+		s.recvSeq, s.pending = 0, 0
+		s.recvLock.Unlock()
+		atomic.StoreUint64(&s.peerAcked, 0)
+		for i := range s.window {
+			s.window[i].seq = uint64(i + 1)
+		}
+		s.sendSeq = uint64(len(s.window))
+	}
+	s.acked(seq)
+	s.bind(conn)
+	s.retransmit()
+	s.lock.Unlock()
+
+	s.watch(conn)
+}
+
+// hello binds the session of the token the client sent to its new connection, or a new session when
+// the token is unknown, replies with the token and retransmits the messages not acknowledged yet.
+// A session is only resumed by a connection authenticated as the identity it was created by
+// (将客户端发送的token对应的会话绑定到新连接, token未知时创建新会话, 应答token并重传未确认的消息; 会话只能由创建时认证的同一身份恢复)
+func (m *reliableManager) hello(request ziface.IRequest) {
+	seq, token, ok := parseHello(request.GetData())
+	if !ok {
+		_ = request.ReplyError(errors.New("invalid reliable hello"))
+		return
+	}
+	conn := request.GetConnection()
+
+	identity := conn.GetIdentity()
+
+	m.lock.Lock()
+	s := m.sessions[token]
+	if s == nil {
+		s = &reliableSession{manager: m, token: newReliableToken(), identity: identity}
+		m.sessions[s.token] = s
+		seq = 0
+	} else if !reflect.DeepEqual(s.identity, identity) {
+		// The token alone does not let another user take the session over (仅凭token不能接管其他用户的会话)
+		m.lock.Unlock()
+		zlog.Ins().InfoF("ConnID = %d authenticated as %v can not resume reliable session %s of %v", conn.GetConnID(), identity, token, s.identity)
+		_ = request.ReplyError(errors.New("reliable session of another identity"))
+		return
+	}
+	m.lock.Unlock()
+
+	// Messages sent meanwhile wait for the reply and the retransmissions (期间发送的消息等待应答和重传完成)
+	s.lock.Lock()
+	old := s.conn
+	if old == conn {
+		s.lock.Unlock()
+		_ = request.ReplyError(errors.New("reliable session already bound"))
+		return
+	}
+	s.acked(seq)
+	s.bind(conn)
+	if err := request.Reply(s.helloData()); err != nil {
+		s.unbind()
+	} else {
+		s.retransmit()
+	}
+	s.lock.Unlock()
+
+	s.watch(conn)
+	// A connection replaced before its close was noticed is half open (连接在其断开被发现之前就被替换, 说明已半开)
+	if old != nil {
+		old.RemoveCloseCallback(m, s)
+		zlog.Ins().InfoF("reliable session %s moved from ConnID = %d to %d", s.token, old.GetConnID(), conn.GetConnID())
+		old.Stop()
+	}
+}
+
+// resumeReliable answers the hello of a client binding its new connection to its reliable session
+// (应答客户端将新连接绑定到其可靠会话的握手消息)
+func (mh *MsgHandle) resumeReliable(request ziface.IRequest) {
+	if mh.reliable == nil || mh.reliable.client {
+		_ = request.ReplyError(errors.New("reliable session not enabled"))
+		return
+	}
+	mh.reliable.hello(request)
+}
+
+func newReliableToken() string {
+	token := make([]byte, reliableTokenLen)
+	if _, err := rand.Read(token); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(token)
+}
+
+// resumeReliable binds the connection to the session of the client, the connection is closed if it fails
+// (将连接绑定到客户端的会话, 失败时关闭连接)
+func (c *Client) resumeReliable(conn ziface.IConnection) {
+	s := c.reliable.session
+	s.lock.Lock()
+	data := s.helloData()
+	s.lock.Unlock()
+
+	ctx, cancel := context.WithTimeout(conn.Context(), reliableHelloTimeout)
+	defer cancel()
+
+	// The reader resumed the session when the reply arrived (应答到达时读协程已恢复会话)
+	if _, err := conn.Call(ctx, ziface.ReliableHelloMsgID, data); err != nil {
+		zlog.Ins().ErrorF("reliable session resume err: %v, close the connection", err)
+		conn.Stop()
+	}
+}
+
+// GetReliableSession returns the reliable session of the client, nil without WithReliableClient
+// (返回客户端的可靠会话, 未设置WithReliableClient时为nil)
+func (c *Client) GetReliableSession() ziface.IReliableSession {
+	if c.reliable == nil {
+		return nil
+	}
+	return c.reliable.session
+}
+
+// SetReliable lets clients open reliable sessions, see IReliableSession
+// (允许客户端开启可靠会话, 参见IReliableSession)
+func (s *Server) SetReliable(option *ziface.ReliableOption) {
+	s.reliable = newReliableManager(newReliableOption(option), false)
+	if mh, ok := s.msgHandler.(*MsgHandle); ok {
+		mh.reliable = s.reliable
+	}
+	if connMgr, ok := s.ConnMgr.(*ConnManager); ok {
+		connMgr.setReliable(s.reliable)
+	}
+}
+
+// GetReliableSession returns the reliable session conn is bound to, nil before the client resumed one
+// (返回conn绑定的可靠会话, 客户端恢复会话之前为nil)
+func (s *Server) GetReliableSession(conn ziface.IConnection) ziface.IReliableSession {
+	if s.reliable == nil {
+		return nil
+	}
+	if session := s.reliable.get(conn); session != nil {
+		return session
+	}
+	return nil
+}
@@ -0,0 +1,178 @@
+package znet
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aceld/zinx/zconf"
+	"github.com/aceld/zinx/ziface"
+	"github.com/aceld/zinx/zpack"
+)
+
+// run in terminal:
+// go test -v ./znet -run=TestReliable
+
+type reliableRecorder struct {
+	BaseRouter
+	lock sync.Mutex
+	got  []string
+	// Called with each message before it is recorded (记录每条消息前调用)
+	before func(request ziface.IRequest)
+}
+
+func (r *reliableRecorder) Handle(request ziface.IRequest) {
+	if r.before != nil {
+		r.before(request)
+	}
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.got = append(r.got, string(request.GetData()))
+}
+
+func (r *reliableRecorder) wait(t *testing.T, want string) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		r.lock.Lock()
+		got := strings.Join(r.got, ",")
+		r.lock.Unlock()
+		if got == want {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("got %q, want %q", got, want)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+func TestReliableResume(t *testing.T) {
+	conf := *zconf.GlobalObject
+	conf.Name = "ReliableTest"
+	conf.Host = "127.0.0.1"
+	conf.TCPPort = 19016
+
+	s := newServerWithConfig(&conf, "tcp", WithReliable(nil))
+	var serverSession ziface.IReliableSession
+	sessionReady := make(chan struct{})
+	serverGot := &reliableRecorder{}
+	serverGot.before = func(request ziface.IRequest) {
+		if string(request.GetData()) != "3" {
+			return
+		}
+		// Drop the connection before acknowledging, with a message of the server in flight
+		// (在确认之前断开连接, 同时服务端有一条消息正在发送)
+		serverSession = s.GetReliableSession(request.GetConnection())
+		_ = serverSession.Send(2, []byte("s1"))
+		request.GetConnection().Stop()
+		close(sessionReady)
+	}
+	s.AddRouter(1, serverGot)
+	s.Start()
+	defer s.Stop()
+	time.Sleep(time.Second * 1)
+
+	policy := DefaultReconnectPolicy()
+	policy.MinBackoff = 200 * time.Millisecond
+	policy.MaxBackoff = 200 * time.Millisecond
+	client := NewClient("127.0.0.1", 19016, WithReconnect(policy), WithReliableClient(nil))
+	clientGot := &reliableRecorder{}
+	client.AddRouter(2, clientGot)
+	client.Start()
+	defer client.Stop()
+
+	// Sent before the session is resumed, then held (会话恢复前发送的消息会被保留)
+	session := client.GetReliableSession()
+	for _, data := range []string{"1", "2", "3", "4", "5"} {
+		if err := session.Send(1, []byte(data)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	select {
+	case <-sessionReady:
+	case <-time.After(5 * time.Second):
+		t.Fatal("server session not ready")
+	}
+	// Sent while the client is away (客户端断开期间发送)
+	_ = serverSession.Send(2, []byte("s2"))
+
+	serverGot.wait(t, "1,2,3,4,5")
+	clientGot.wait(t, "s1,s2")
+	if serverSession.Token() != session.Token() || session.Token() == "" {
+		t.Fatalf("resumed session %q, want %q", session.Token(), serverSession.Token())
+	}
+
+	// Everything gets acknowledged (所有消息最终都被确认)
+	time.Sleep(200 * time.Millisecond)
+	if n, m := session.Unacked(), serverSession.Unacked(); n != 0 || m != 0 {
+		t.Fatalf("unacked client %d server %d", n, m)
+	}
+}
+
+func TestReliableReceived(t *testing.T) {
+	s := &reliableSession{manager: newReliableManager(newReliableOption(nil), true)}
+	conn := &reliableTestConn{}
+	for _, c := range []struct {
+		seq     uint64
+		deliver bool
+	}{{1, true}, {3, false}, {2, true}, {2, false}, {1, false}, {3, true}} {
+		if got := s.received(conn, c.seq); got != c.deliver {
+			t.Fatalf("seq %d delivered %v, want %v", c.seq, got, c.deliver)
+		}
+	}
+
+	s.window = []reliableMsg{{seq: 1}, {seq: 2}, {seq: 3}}
+	s.acked(2)
+	s.acked(1)
+	s.prune()
+	if len(s.window) != 1 || s.window[0].seq != 3 {
+		t.Fatalf("window after ack 2: %+v", s.window)
+	}
+}
+
+func TestReliableReceive(t *testing.T) {
+	m := newReliableManager(newReliableOption(nil), false)
+	conn := &reliableTestConn{}
+	frame := zpack.PackReliable(1, 7, []byte("data"))
+
+	// Other messages are left alone, whatever their data starts with (其他消息无论数据以什么开头都保持不变)
+	request := &Request{conn: conn, msg: zpack.NewMsgPackage(1, frame)}
+	if !m.receive(request) || request.GetMsgID() != 1 || !bytes.Equal(request.GetData(), frame) {
+		t.Fatal("message not sent through a session rewritten")
+	}
+
+	request = &Request{conn: conn, msg: zpack.NewMsgPackage(ziface.ReliableMsgID, frame)}
+	if m.receive(request) {
+		t.Fatal("session message of no session accepted")
+	}
+
+	m.conns[conn] = &reliableSession{manager: m}
+	request = &Request{conn: conn, msg: zpack.NewMsgPackage(ziface.ReliableMsgID, []byte("short"))}
+	if m.receive(request) {
+		t.Fatal("malformed session message accepted")
+	}
+	request = &Request{conn: conn, msg: zpack.NewMsgPackage(ziface.ReliableMsgID, frame)}
+	if !m.receive(request) || request.GetMsgID() != 7 || string(request.GetData()) != "data" {
+		t.Fatal("session message not restored")
+	}
+}
+
+// reliableTestConn is a closed connection, the acknowledgements are not sent
+type reliableTestConn struct {
+	ziface.IConnection
+}
+
+func (c *reliableTestConn) GetConnID() uint64 {
+	return 0
+}
+
+func (c *reliableTestConn) Context() context.Context {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	return ctx
+}
@@ -1,9 +1,11 @@
 package znet
 
 import (
+	"errors"
 	"math"
 	"sync"
 
+	"github.com/aceld/zinx/zbuffer"
 	"github.com/aceld/zinx/zconf"
 	"github.com/aceld/zinx/ziface"
 	"github.com/aceld/zinx/zpack"
@@ -38,6 +40,10 @@ type Request struct {
 	handlers []ziface.RouterHandler // router function slice(路由函数切片)
 	index    int8                   // router function slice index(路由函数切片索引)
 	keys     map[string]interface{} // keys 路由处理时可能会存取的上下文信息
+	callID   uint64                 // correlation ID of the RPC call this request belongs to (RPC调用的关联ID)
+	isCall   bool                   // whether the request is an RPC call waiting for a reply (是否是等待应答的RPC调用)
+	rpcFlag  uint8                  // zpack.RPCFlag* of an ziface.RPCMsgID frame, 0 for other messages (RPC帧的标志, 其他消息为0)
+	buf      []byte                 // pooled frame buffer released by PutRequest (由PutRequest归还的池化帧缓冲)
 }
 
 func (r *Request) GetResponse() ziface.IcResp {
@@ -72,13 +78,39 @@ func GetRequest(conn ziface.IConnection, msg ziface.IMessage) ziface.IRequest {
 	return NewRequest(conn, msg)
 }
 
+// getRequestWithBuffer gets a Request for a frame taken from zbuffer.
+// With RequestPoolMode on, the frame goes back to zbuffer together with the Request in PutRequest;
+// otherwise it is left to the GC because nothing tells when the handlers are done with it.
+// (为取自zbuffer的帧获取Request。开启RequestPoolMode时帧缓冲随Request在PutRequest中归还,
+// 否则无法确定路由何时不再使用, 交给GC回收)
+func getRequestWithBuffer(conn ziface.IConnection, msg ziface.IMessage, buf []byte) ziface.IRequest {
+	request := GetRequest(conn, msg)
+	if r, ok := request.(*Request); ok && zconf.GlobalObject.RequestPoolMode {
+		r.buf = buf
+	}
+	return request
+}
+
 func PutRequest(request ziface.IRequest) {
 	// 判断是否开启了对象池模式
 	if zconf.GlobalObject.RequestPoolMode {
+		// 归还帧缓冲, 此后不能再使用该请求的数据
+		if r, ok := request.(*Request); ok && r.buf != nil {
+			zbuffer.Put(r.buf)
+			r.buf = nil
+		}
 		RequestPool.Put(request)
 	}
 }
 
+// detachBuffer keeps the frame buffer away from PutRequest, for data that outlives the request
+// (使帧缓冲不被PutRequest归还, 用于生命周期长于请求的数据)
+func detachBuffer(request ziface.IRequest) {
+	if r, ok := request.(*Request); ok {
+		r.buf = nil
+	}
+}
+
 func allocateRequest() ziface.IRequest {
 	req := new(Request)
 	req.steps = PRE_HANDLE
@@ -94,7 +126,10 @@ func (r *Request) Reset(conn ziface.IConnection, msg ziface.IMessage) {
 	r.needNext = true
 	r.index = -1
 	r.keys = nil
-
+	r.callID = 0
+	r.isCall = false
+	r.rpcFlag = 0
+	r.buf = nil
 }
 
 // Copy 在执行路由函数的时候可能会出现需要再起一个协程的需求,但是 Request 对象由对象池管理后无法保证新协程中的 Request 参数一致
@@ -126,8 +161,12 @@ func (r *Request) Copy() ziface.IRequest {
 	for _, v := range newIcResp {
 		newRequest.icResp = v
 	}
-	// 复制一份原本的 msg 信息
-	newRequest.msg = zpack.NewMessageByMsgId(r.msg.GetMsgID(), r.msg.GetDataLen(), r.msg.GetRawData())
+	// 复制一份原本的 msg 信息, 池化的帧缓冲会随原请求归还, 因此需要拷贝数据
+	rawData := r.msg.GetRawData()
+	if r.buf != nil {
+		rawData = append([]byte(nil), rawData...)
+	}
+	newRequest.msg = zpack.NewMessageByMsgId(r.msg.GetMsgID(), r.msg.GetDataLen(), rawData)
 
 	return newRequest
 }
@@ -233,3 +272,29 @@ func (r *Request) RouterSlicesNext() {
 		r.index++
 	}
 }
+
+// Reply answers the request. RPC calls are answered with the same CallID so that the reply
+// is routed back to the waiting caller, other requests get a normal message with the same MsgID.
+// (应答请求。RPC调用会带回相同的CallID以便投递给等待的调用方, 普通请求则发送相同MsgID的普通消息)
+func (r *Request) Reply(data []byte) error {
+	if r.conn == nil {
+		return errors.New("request has no connection to reply")
+	}
+	if !r.isCall {
+		return r.conn.SendMsg(r.GetMsgID(), data)
+	}
+	return r.conn.SendMsg(ziface.RPCMsgID, zpack.PackRPC(zpack.RPCFlagReply, r.callID, r.GetMsgID(), data))
+}
+
+// ReplyError answers an RPC call with an error, IConnection.Call on the peer returns it as *RPCError.
+// Other requests get a normal message carrying the error text.
+// (以错误应答RPC调用, 对端的IConnection.Call会返回*RPCError, 普通请求则发送携带错误信息的普通消息)
+func (r *Request) ReplyError(err error) error {
+	if r.conn == nil {
+		return errors.New("request has no connection to reply")
+	}
+	if !r.isCall {
+		return r.conn.SendMsg(r.GetMsgID(), []byte(err.Error()))
+	}
+	return r.conn.SendMsg(ziface.RPCMsgID, zpack.PackRPC(zpack.RPCFlagError, r.callID, r.GetMsgID(), []byte(err.Error())))
+}
@@ -6,6 +6,7 @@ type RequestFunc struct {
 	ziface.BaseRequest
 	conn     ziface.IConnection
 	callFunc func()
+	key      string // Dispatch key in zconf.WorkerModeKey (zconf.WorkerModeKey模式下的分发key)
 }
 
 func (rf *RequestFunc) GetConnection() ziface.IConnection {
@@ -24,3 +25,14 @@ func NewFuncRequest(conn ziface.IConnection, callFunc func()) ziface.IRequest {
 	req.callFunc = callFunc
 	return req
 }
+
+// NewKeyFuncRequest creates a function request that runs on the worker of the dispatch key in zconf.WorkerModeKey,
+// in the other modes it runs on the worker of conn like NewFuncRequest
+// (创建在zconf.WorkerModeKey模式下由分发key所属worker执行的函数请求, 其他模式下与NewFuncRequest相同, 由conn所属worker执行)
+func NewKeyFuncRequest(conn ziface.IConnection, key string, callFunc func()) ziface.IRequest {
+	req := new(RequestFunc)
+	req.conn = conn
+	req.key = key
+	req.callFunc = callFunc
+	return req
+}
@@ -0,0 +1,149 @@
+package znet
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+
+	"github.com/aceld/zinx/ziface"
+	"github.com/aceld/zinx/zlog"
+	"github.com/aceld/zinx/zpack"
+)
+
+// rpcCalls keeps the calls of one connection that are still waiting for their reply
+// (记录一个连接上仍在等待应答的RPC调用)
+type rpcCalls struct {
+	seq     uint64
+	lock    sync.Mutex
+	pending map[uint64]chan rpcReply
+}
+
+// rpcReply is the reply message of a call, or the error sent back by IRequest.ReplyError
+// (RPC调用的应答消息, 或IRequest.ReplyError发回的错误)
+type rpcReply struct {
+	msg ziface.IMessage
+	err error
+}
+
+// RPCError is returned by IConnection.Call when the peer answered with IRequest.ReplyError
+// (对端通过IRequest.ReplyError应答时, IConnection.Call返回的错误)
+type RPCError struct {
+	MsgID   uint32
+	Message string
+}
+
+func (e *RPCError) Error() string {
+	return fmt.Sprintf("rpc msgID = %d: %s", e.MsgID, e.Message)
+}
+
+// rpcConn is implemented by connections that can route replies back to their callers
+// (可以把应答投递给调用方的连接)
+type rpcConn interface {
+	deliverReply(callID uint64, reply rpcReply) bool
+}
+
+// call sends data tagged with a new CallID and blocks until the reply arrives,
+// ctx is done or the connection is closed
+// (发送带有新CallID的请求, 阻塞直到收到应答、ctx结束或连接关闭)
+func (r *rpcCalls) call(ctx context.Context, conn ziface.IConnection, msgID uint32, data []byte) (ziface.IMessage, error) {
+	callID := atomic.AddUint64(&r.seq, 1)
+	replyChan := make(chan rpcReply, 1)
+
+	r.lock.Lock()
+	if r.pending == nil {
+		r.pending = make(map[uint64]chan rpcReply)
+	}
+	r.pending[callID] = replyChan
+	r.lock.Unlock()
+
+	defer func() {
+		r.lock.Lock()
+		delete(r.pending, callID)
+		r.lock.Unlock()
+	}()
+
+	if err := conn.SendMsg(ziface.RPCMsgID, zpack.PackRPC(zpack.RPCFlagRequest, callID, msgID, data)); err != nil {
+		return nil, err
+	}
+
+	var connDone <-chan struct{}
+	if connCtx := conn.Context(); connCtx != nil {
+		connDone = connCtx.Done()
+	}
+
+	select {
+	case reply := <-replyChan:
+		return reply.msg, reply.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-connDone:
+		return nil, errors.New("connection closed when waiting for rpc reply")
+	}
+}
+
+// deliver hands the reply to the call waiting for callID, returns false if nobody is waiting
+// (把应答交给等待callID的调用, 没有调用在等待时返回false)
+func (r *rpcCalls) deliver(callID uint64, reply rpcReply) bool {
+	r.lock.Lock()
+	replyChan, ok := r.pending[callID]
+	delete(r.pending, callID)
+	r.lock.Unlock()
+
+	if !ok {
+		return false
+	}
+	replyChan <- reply
+	return true
+}
+
+// unwrapRPC strips the RPC extended header from an ziface.RPCMsgID request, which then carries the MsgID
+// called. RPC requests remember their CallID so that IRequest.Reply can answer them. Other requests are
+// left alone, false means the request is a malformed RPC frame and should be dropped.
+// (剥离ziface.RPCMsgID请求的RPC扩展报头, 之后请求携带被调用的MsgID; RPC请求记录CallID, 以便通过IRequest.Reply应答。
+// 其他请求保持不变, 返回false表示请求是格式错误的RPC帧, 应丢弃)
+func unwrapRPC(request ziface.IRequest) bool {
+	msg := request.GetMessage()
+	if msg == nil || msg.GetMsgID() != ziface.RPCMsgID {
+		return true
+	}
+
+	header, payload, ok := zpack.UnpackRPC(msg.GetData())
+	if !ok {
+		zlog.Ins().ErrorF("ConnID = %d malformed rpc frame, drop it", request.GetConnection().GetConnID())
+		return false
+	}
+	msg.SetMsgID(header.MsgID)
+	msg.SetData(payload)
+	msg.SetDataLen(uint32(len(payload)))
+	if req, ok := request.(*Request); ok {
+		req.rpcFlag, req.callID = header.Flag, header.CallID
+		req.isCall = header.Flag == zpack.RPCFlagRequest
+	}
+	return true
+}
+
+// handleRPC delivers the reply of a call unwrapped by unwrapRPC to the waiting caller and returns true,
+// so replies never reach the routers
+// (将unwrapRPC解开的调用应答交给等待的调用方并返回true, 应答不再经过路由)
+func handleRPC(request ziface.IRequest) bool {
+	req, ok := request.(*Request)
+	if !ok || (req.rpcFlag != zpack.RPCFlagReply && req.rpcFlag != zpack.RPCFlagError) {
+		return false
+	}
+
+	msg := request.GetMessage()
+	reply := rpcReply{msg: msg}
+	if req.rpcFlag == zpack.RPCFlagError {
+		reply = rpcReply{err: &RPCError{MsgID: msg.GetMsgID(), Message: string(msg.GetData())}}
+	}
+	// The reply is read by the caller after the request has been put back
+	// (请求归还后调用方仍会读取应答数据)
+	detachBuffer(request)
+	conn, ok := request.GetConnection().(rpcConn)
+	if !ok || !conn.deliverReply(req.callID, reply) {
+		zlog.Ins().DebugF("no rpc call waiting for CallID = %d msgID = %d, drop reply", req.callID, msg.GetMsgID())
+	}
+	return true
+}
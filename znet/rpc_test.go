@@ -0,0 +1,98 @@
+package znet
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/aceld/zinx/zconf"
+	"github.com/aceld/zinx/ziface"
+	"github.com/aceld/zinx/zpack"
+)
+
+// run in terminal:
+// go test -v ./znet -run=TestRPC
+
+type RPCEchoRouter struct {
+	BaseRouter
+}
+
+func (r *RPCEchoRouter) Handle(req ziface.IRequest) {
+	_ = req.Reply(append([]byte("echo:"), req.GetData()...))
+}
+
+type RPCSilentRouter struct {
+	BaseRouter
+}
+
+// RPCPlainRouter hands the plain messages received to a channel (将收到的普通消息交给channel)
+type RPCPlainRouter struct {
+	BaseRouter
+	got chan []byte
+}
+
+func (r *RPCPlainRouter) Handle(req ziface.IRequest) {
+	r.got <- append([]byte{}, req.GetData()...)
+}
+
+func TestRPCCall(t *testing.T) {
+	conf := *zconf.GlobalObject
+	conf.Name = "RPCTest"
+	conf.Host = "127.0.0.1"
+	conf.TCPPort = 19001
+
+	s := newServerWithConfig(&conf, "tcp")
+	s.AddRouter(1, &RPCEchoRouter{})
+	s.AddRouter(2, &RPCSilentRouter{})
+	s.Start()
+	defer s.Stop()
+	time.Sleep(time.Second * 1)
+
+	plain := &RPCPlainRouter{got: make(chan []byte, 1)}
+	client := NewClient("127.0.0.1", 19001)
+	client.AddRouter(1, plain)
+	client.Start()
+	defer client.Stop()
+	time.Sleep(time.Second * 1)
+
+	conn := client.Conn()
+	if conn == nil {
+		t.Fatal("client not connected")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+	defer cancel()
+	for _, data := range []string{"a", "b", "c"} {
+		reply, err := conn.Call(ctx, 1, []byte(data))
+		if err != nil {
+			t.Fatalf("call err: %v", err)
+		}
+		if reply.GetMsgID() != 1 || string(reply.GetData()) != "echo:"+data {
+			t.Fatalf("reply = %d %q, want 1 %q", reply.GetMsgID(), reply.GetData(), "echo:"+data)
+		}
+	}
+
+	// A plain message whose data looks like an RPC header is routed untouched
+	// (数据看起来像RPC报头的普通消息原样路由)
+	raw := zpack.PackRPC(zpack.RPCFlagReply, 1, 1, []byte("not a call"))
+	if err := conn.SendMsg(1, raw); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case data := <-plain.got:
+		if !bytes.Equal(data, append([]byte("echo:"), raw...)) {
+			t.Fatalf("plain reply = %q", data)
+		}
+	case <-time.After(time.Second * 3):
+		t.Fatal("plain message taken for an RPC frame")
+	}
+
+	// A call nobody answers gives up when ctx is done (无人应答的调用在ctx结束时放弃)
+	timeoutCtx, timeoutCancel := context.WithTimeout(context.Background(), time.Millisecond*200)
+	defer timeoutCancel()
+	if _, err := conn.Call(timeoutCtx, 2, []byte("ping")); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("err = %v, want context.DeadlineExceeded", err)
+	}
+}
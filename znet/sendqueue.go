@@ -0,0 +1,252 @@
+package znet
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/aceld/zinx/zconf"
+	"github.com/aceld/zinx/ziface"
+)
+
+// sendQueueBlockTimeout is how long the "block" policy waits when SendBuffMsg is given no timeout
+// (SendBuffMsg未指定超时时"block"策略的等待时长)
+const sendQueueBlockTimeout = 5 * time.Millisecond
+
+var (
+	errSendQueueFull    = errors.New("send buff msg channel is full")
+	errSendQueueTimeout = errors.New("send buff msg timeout")
+	errSendQueueClosed  = errors.New("connection closed when send buff msg")
+	errSlowConsumer     = errors.New("send buff msg channel is full, slow connection closed")
+)
+
+// ring is a FIFO of sendItem that grows up to its limit
+// (容量逐步增长到上限的sendItem先进先出队列)
+type ring struct {
+	items []sendItem
+	head  int
+	size  int
+	limit int
+}
+
+func (r *ring) full() bool {
+	return r.size >= r.limit
+}
+
+func (r *ring) push(item sendItem) {
+	if r.size == len(r.items) {
+		n := len(r.items) * 2
+		if n == 0 {
+			n = 16
+		}
+		if n > r.limit {
+			n = r.limit
+		}
+		items := make([]sendItem, n)
+		for i := 0; i < r.size; i++ {
+			items[i] = r.items[(r.head+i)%len(r.items)]
+		}
+		r.items, r.head = items, 0
+	}
+	r.items[(r.head+r.size)%len(r.items)] = item
+	r.size++
+}
+
+// replace puts item in the place of the newest queued message with the same MsgID
+// (用item替换队列中MsgID相同的最新消息, 保持该消息在队列中的位置)
+func (r *ring) replace(item sendItem) (sendItem, bool) {
+	for i := r.size - 1; i >= 0; i-- {
+		at := (r.head + i) % len(r.items)
+		if old := r.items[at]; old.keyed && old.key == item.key {
+			r.items[at] = item
+			return old, true
+		}
+	}
+	return sendItem{}, false
+}
+
+func (r *ring) pop() sendItem {
+	item := r.items[r.head]
+	r.items[r.head] = sendItem{}
+	r.head = (r.head + 1) % len(r.items)
+	r.size--
+	return item
+}
+
+// sendQueue holds the messages waiting for the writer goroutine, one bounded queue per ziface.MsgPriority.
+// The writer always takes the highest priority message first.
+// (等待写协程发送的消息, 每个优先级一个有界队列, 写协程总是先取优先级最高的消息)
+type sendQueue struct {
+	lock     sync.Mutex
+	queues   [ziface.MsgPriorityLevels]ring
+	policies [ziface.MsgPriorityLevels]string
+	slow     [ziface.MsgPriorityLevels]bool // Overflowed since the queue was last empty (上次排空后是否溢出过)
+	closed   bool
+
+	// notify wakes the writer goroutine (唤醒写协程)
+	notify chan struct{}
+
+	// freed is closed and replaced whenever the writer takes a message, waking blocked senders
+	// (写协程每取走一条消息就关闭并替换freed, 唤醒阻塞等待的发送方)
+	freed chan struct{}
+}
+
+// newSendQueue creates the queues from zconf, defaultPolicy is used for the priorities without a policy
+// (按zconf创建发送队列, 未配置策略的优先级使用defaultPolicy)
+func newSendQueue(defaultPolicy string) *sendQueue {
+	conf := zconf.GlobalObject
+	q := &sendQueue{
+		notify: make(chan struct{}, 1),
+		freed:  make(chan struct{}),
+	}
+	sizes := [ziface.MsgPriorityLevels]uint32{conf.SendQueueLowSize, conf.SendQueueNormalSize, conf.SendQueueHighSize}
+	policies := [ziface.MsgPriorityLevels]string{conf.SendQueueLowPolicy, conf.SendQueueNormalPolicy, conf.SendQueueHighPolicy}
+	for i := range q.queues {
+		if sizes[i] == 0 {
+			sizes[i] = conf.MaxMsgChanLen
+		}
+		if sizes[i] == 0 {
+			sizes[i] = 1
+		}
+		q.queues[i].limit = int(sizes[i])
+
+		q.policies[i] = policies[i]
+		if q.policies[i] == "" {
+			q.policies[i] = defaultPolicy
+		}
+	}
+	return q
+}
+
+// push queues item with the priority of opt. When the queue is full the sender waits up to opt.Timeout for room
+// ("block" always waits, sendQueueBlockTimeout by default), then the policy applies.
+// A message removed by "drop-oldest" or "coalesce" is returned so the caller can release it,
+// event is set the first time the queue overflows until it drains, done aborts the wait when the connection closes.
+// (按opt中的优先级将item入队; 队列满时发送方最多等待opt.Timeout("block"总会等待, 默认sendQueueBlockTimeout), 之后执行溢出策略;
+// "drop-oldest"或"coalesce"移除的消息会返回给调用方释放, 队列排空前第一次溢出时返回event, done用于在连接关闭时结束等待)
+func (q *sendQueue) push(item sendItem, opt ziface.MsgSendOptionObj, done <-chan struct{}) (dropped *sendItem, event *ziface.SlowConsumerEvent, err error) {
+	priority := opt.Priority
+	if priority >= ziface.MsgPriorityLevels {
+		priority = ziface.MsgPriorityHigh
+	}
+	policy := q.policies[priority]
+
+	timeout := opt.Timeout
+	if timeout <= 0 && policy == zconf.SendQueueBlock {
+		timeout = sendQueueBlockTimeout
+	}
+	var timer *time.Timer
+	defer func() {
+		if timer != nil {
+			timer.Stop()
+		}
+	}()
+	expired := timeout <= 0
+
+	for {
+		q.lock.Lock()
+		if q.closed {
+			q.lock.Unlock()
+			return nil, nil, errSendQueueClosed
+		}
+		queue := &q.queues[priority]
+		if !queue.full() {
+			queue.push(item)
+			q.lock.Unlock()
+			q.wake()
+			return nil, nil, nil
+		}
+
+		if !expired {
+			freed := q.freed
+			q.lock.Unlock()
+			if timer == nil {
+				timer = time.NewTimer(timeout)
+			}
+			select {
+			case <-freed:
+			case <-timer.C:
+				expired = true
+			case <-done:
+				return nil, nil, errSendQueueClosed
+			}
+			continue
+		}
+
+		if !q.slow[priority] {
+			q.slow[priority] = true
+			event = &ziface.SlowConsumerEvent{Priority: priority, Queued: queue.size, Policy: policy}
+		}
+
+		switch policy {
+		case zconf.SendQueueDropOldest:
+			oldest := queue.pop()
+			dropped = &oldest
+			queue.push(item)
+		case zconf.SendQueueCoalesce:
+			err = errSendQueueFull
+			if item.keyed {
+				if old, ok := queue.replace(item); ok {
+					dropped, err = &old, nil
+				}
+			}
+		case zconf.SendQueueBlock:
+			err = errSendQueueTimeout
+		case zconf.SendQueueDisconnect:
+			err = errSlowConsumer
+		default:
+			err = errSendQueueFull
+		}
+		q.lock.Unlock()
+		if dropped != nil {
+			q.wake()
+		}
+		return dropped, event, err
+	}
+}
+
+func (q *sendQueue) wake() {
+	select {
+	case q.notify <- struct{}{}:
+	default:
+	}
+}
+
+// pop takes the oldest message of the highest non-empty priority
+// (取出最高非空优先级中最早的消息)
+func (q *sendQueue) pop() (sendItem, bool) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	for i := len(q.queues) - 1; i >= 0; i-- {
+		if q.queues[i].size > 0 {
+			item := q.queues[i].pop()
+			if q.queues[i].size == 0 {
+				q.slow[i] = false
+			}
+			close(q.freed)
+			q.freed = make(chan struct{})
+			return item, true
+		}
+	}
+	return sendItem{}, false
+}
+
+// close refuses further messages and returns the ones still queued
+// (关闭队列, 拒绝后续消息并返回仍在队列中的消息)
+func (q *sendQueue) close() []sendItem {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	if q.closed {
+		return nil
+	}
+	q.closed = true
+	close(q.freed)
+
+	var rest []sendItem
+	for i := range q.queues {
+		for q.queues[i].size > 0 {
+			rest = append(rest, q.queues[i].pop())
+		}
+	}
+	return rest
+}
@@ -0,0 +1,337 @@
+package znet
+
+import (
+	"context"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/aceld/zinx/zbuffer"
+	"github.com/aceld/zinx/zconf"
+	"github.com/aceld/zinx/ziface"
+	"github.com/aceld/zinx/zpack"
+)
+
+func withSendQueueConf(t *testing.T, size uint32, policy string) {
+	conf := zconf.GlobalObject
+	sizes := []uint32{conf.SendQueueLowSize, conf.SendQueueNormalSize, conf.SendQueueHighSize}
+	policies := []string{conf.SendQueueLowPolicy, conf.SendQueueNormalPolicy, conf.SendQueueHighPolicy}
+	t.Cleanup(func() {
+		conf.SendQueueLowSize, conf.SendQueueNormalSize, conf.SendQueueHighSize = sizes[0], sizes[1], sizes[2]
+		conf.SendQueueLowPolicy, conf.SendQueueNormalPolicy, conf.SendQueueHighPolicy = policies[0], policies[1], policies[2]
+	})
+	zconf.GlobalObject.SendQueueLowSize = size
+	zconf.GlobalObject.SendQueueNormalSize = size
+	zconf.GlobalObject.SendQueueHighSize = size
+	zconf.GlobalObject.SendQueueLowPolicy = policy
+	zconf.GlobalObject.SendQueueNormalPolicy = policy
+	zconf.GlobalObject.SendQueueHighPolicy = policy
+}
+
+func pushString(q *sendQueue, data string, priority ziface.MsgPriority, timeout time.Duration) (*sendItem, error) {
+	opt := ziface.MsgSendOptionObj{Timeout: timeout, Priority: priority}
+	dropped, _, err := q.push(sendItem{data: []byte(data)}, opt, nil)
+	return dropped, err
+}
+
+func pushMsg(q *sendQueue, msgID uint32, data string) (*sendItem, *ziface.SlowConsumerEvent, error) {
+	opt := ziface.MsgSendOptionObj{Priority: ziface.MsgPriorityNormal}
+	return q.push(sendItem{data: []byte(data), msgID: msgID, key: msgID, keyed: true}, opt, nil)
+}
+
+func popAll(q *sendQueue) []string {
+	var out []string
+	for {
+		item, ok := q.pop()
+		if !ok {
+			return out
+		}
+		out = append(out, string(item.data))
+	}
+}
+
+func TestSendQueuePriority(t *testing.T) {
+	withSendQueueConf(t, 100, "")
+	q := newSendQueue(zconf.SendQueueDropNewest)
+
+	for i, p := range []ziface.MsgPriority{ziface.MsgPriorityLow, ziface.MsgPriorityNormal, ziface.MsgPriorityHigh, ziface.MsgPriorityLow, ziface.MsgPriorityHigh} {
+		if _, err := pushString(q, string(rune('a'+i)), p, 0); err != nil {
+			t.Fatal(err)
+		}
+	}
+	got := popAll(q)
+	want := []string{"c", "e", "b", "a", "d"}
+	if len(got) != len(want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("got %v, want %v", got, want)
+		}
+	}
+}
+
+func TestSendQueueGrow(t *testing.T) {
+	withSendQueueConf(t, 100, "")
+	q := newSendQueue(zconf.SendQueueDropNewest)
+
+	// Interleave pushes and pops so the ring wraps while growing
+	for i := 0; i < 100; i++ {
+		if _, _, err := q.push(sendItem{data: []byte{byte(i)}}, ziface.MsgSendOptionObj{Priority: ziface.MsgPriorityNormal}, nil); err != nil {
+			t.Fatal(err)
+		}
+		if i%3 == 0 {
+			q.push(sendItem{data: []byte{byte(i)}}, ziface.MsgSendOptionObj{Priority: ziface.MsgPriorityNormal}, nil)
+			q.pop()
+		}
+	}
+	prev := -1
+	for {
+		item, ok := q.pop()
+		if !ok {
+			break
+		}
+		if int(item.data[0]) < prev {
+			t.Fatalf("out of order: %d after %d", item.data[0], prev)
+		}
+		prev = int(item.data[0])
+	}
+}
+
+func TestSendQueuePolicies(t *testing.T) {
+	t.Run("drop-newest", func(t *testing.T) {
+		withSendQueueConf(t, 2, zconf.SendQueueDropNewest)
+		q := newSendQueue(zconf.SendQueueBlock)
+		pushString(q, "a", ziface.MsgPriorityNormal, 0)
+		pushString(q, "b", ziface.MsgPriorityNormal, 0)
+		if _, err := pushString(q, "c", ziface.MsgPriorityNormal, 0); err != errSendQueueFull {
+			t.Fatalf("err = %v, want %v", err, errSendQueueFull)
+		}
+		// Other priorities have their own room
+		if _, err := pushString(q, "d", ziface.MsgPriorityHigh, 0); err != nil {
+			t.Fatal(err)
+		}
+		if got := popAll(q); len(got) != 3 || got[0] != "d" || got[2] != "b" {
+			t.Fatalf("got %v", got)
+		}
+	})
+
+	t.Run("timeout", func(t *testing.T) {
+		withSendQueueConf(t, 1, zconf.SendQueueDropNewest)
+		q := newSendQueue(zconf.SendQueueBlock)
+		pushString(q, "a", ziface.MsgPriorityNormal, 0)
+
+		// WithSendMsgTimeout waits for room before the policy applies
+		go func() {
+			time.Sleep(20 * time.Millisecond)
+			q.pop()
+		}()
+		if _, err := pushString(q, "b", ziface.MsgPriorityNormal, time.Second); err != nil {
+			t.Fatal(err)
+		}
+		start := time.Now()
+		if _, err := pushString(q, "c", ziface.MsgPriorityNormal, 30*time.Millisecond); err != errSendQueueFull {
+			t.Fatalf("err = %v, want %v", err, errSendQueueFull)
+		}
+		if time.Since(start) < 30*time.Millisecond {
+			t.Fatal("push returned before the timeout")
+		}
+	})
+
+	t.Run("drop-oldest", func(t *testing.T) {
+		withSendQueueConf(t, 2, zconf.SendQueueDropOldest)
+		q := newSendQueue(zconf.SendQueueDropNewest)
+		pushString(q, "a", ziface.MsgPriorityLow, 0)
+		pushString(q, "b", ziface.MsgPriorityLow, 0)
+		dropped, err := pushString(q, "c", ziface.MsgPriorityLow, 0)
+		if err != nil || dropped == nil || string(dropped.data) != "a" {
+			t.Fatalf("dropped = %v, err = %v", dropped, err)
+		}
+		if got := popAll(q); len(got) != 2 || got[0] != "b" || got[1] != "c" {
+			t.Fatalf("got %v", got)
+		}
+	})
+
+	t.Run("block", func(t *testing.T) {
+		withSendQueueConf(t, 1, "")
+		q := newSendQueue(zconf.SendQueueBlock)
+		pushString(q, "a", ziface.MsgPriorityNormal, 0)
+
+		start := time.Now()
+		if _, err := pushString(q, "b", ziface.MsgPriorityNormal, 50*time.Millisecond); err != errSendQueueTimeout {
+			t.Fatalf("err = %v, want %v", err, errSendQueueTimeout)
+		}
+		if time.Since(start) < 50*time.Millisecond {
+			t.Fatal("push returned before the timeout")
+		}
+
+		go func() {
+			time.Sleep(20 * time.Millisecond)
+			q.pop()
+		}()
+		if _, err := pushString(q, "c", ziface.MsgPriorityNormal, time.Second); err != nil {
+			t.Fatal(err)
+		}
+		if got := popAll(q); len(got) != 1 || got[0] != "c" {
+			t.Fatalf("got %v", got)
+		}
+
+		pushString(q, "d", ziface.MsgPriorityNormal, 0)
+		go func() {
+			time.Sleep(20 * time.Millisecond)
+			q.close()
+		}()
+		if _, err := pushString(q, "e", ziface.MsgPriorityNormal, time.Second); err != errSendQueueClosed {
+			t.Fatalf("err = %v, want %v", err, errSendQueueClosed)
+		}
+	})
+
+	t.Run("coalesce", func(t *testing.T) {
+		withSendQueueConf(t, 3, zconf.SendQueueCoalesce)
+		q := newSendQueue(zconf.SendQueueDropNewest)
+		pushMsg(q, 1, "a1")
+		pushMsg(q, 2, "b1")
+		pushMsg(q, 1, "a2")
+
+		dropped, _, err := pushMsg(q, 1, "a3")
+		if err != nil || dropped == nil || string(dropped.data) != "a2" {
+			t.Fatalf("dropped = %v, err = %v", dropped, err)
+		}
+		if _, _, err := pushMsg(q, 3, "c1"); err != errSendQueueFull {
+			t.Fatalf("err = %v, want %v", err, errSendQueueFull)
+		}
+		if _, err := pushString(q, "raw", ziface.MsgPriorityNormal, 0); err != errSendQueueFull {
+			t.Fatalf("err = %v, want %v", err, errSendQueueFull)
+		}
+		if got := popAll(q); len(got) != 3 || got[0] != "a1" || got[1] != "b1" || got[2] != "a3" {
+			t.Fatalf("got %v", got)
+		}
+	})
+
+	t.Run("disconnect", func(t *testing.T) {
+		withSendQueueConf(t, 1, zconf.SendQueueDisconnect)
+		q := newSendQueue(zconf.SendQueueDropNewest)
+		pushMsg(q, 1, "a")
+		if _, event, err := pushMsg(q, 1, "b"); err != errSlowConsumer || event == nil || event.Policy != zconf.SendQueueDisconnect {
+			t.Fatalf("event = %v, err = %v", event, err)
+		}
+	})
+}
+
+func TestSendQueueSlowEvent(t *testing.T) {
+	withSendQueueConf(t, 1, zconf.SendQueueDropOldest)
+	q := newSendQueue(zconf.SendQueueDropNewest)
+	pushMsg(q, 1, "a")
+
+	// Reported once until the queue drains
+	_, event, _ := pushMsg(q, 1, "b")
+	if event == nil || event.Queued != 1 || event.Priority != ziface.MsgPriorityNormal {
+		t.Fatalf("event = %v", event)
+	}
+	if _, event, _ = pushMsg(q, 1, "c"); event != nil {
+		t.Fatalf("event reported twice: %v", event)
+	}
+
+	q.pop()
+	pushMsg(q, 1, "d")
+	if _, event, _ = pushMsg(q, 1, "e"); event == nil {
+		t.Fatal("event not reported after the queue drained")
+	}
+}
+
+// run in terminal:
+// go test -v ./znet -run=TestSlowConsumer
+
+func TestSlowConsumer(t *testing.T) {
+	withSendQueueConf(t, 4, zconf.SendQueueDisconnect)
+
+	conf := *zconf.GlobalObject
+	conf.Name = "SlowConsumerTest"
+	conf.Host = "127.0.0.1"
+	conf.TCPPort = 19013
+
+	events := make(chan ziface.SlowConsumerEvent, 1)
+	sendErr := make(chan error, 1)
+	s := newServerWithConfig(&conf, "tcp")
+	s.SetOnSlowConsumer(func(conn ziface.IConnection, event ziface.SlowConsumerEvent) {
+		events <- event
+	})
+	s.SetOnConnStart(func(conn ziface.IConnection) {
+		go func() {
+			// Flood a client that never reads until its queue overflows
+			data := make([]byte, 64*1024)
+			for {
+				if err := conn.SendBuffMsg(1, data); err != nil {
+					sendErr <- err
+					return
+				}
+			}
+		}()
+	})
+	s.Start()
+	defer s.Stop()
+	time.Sleep(time.Second * 1)
+
+	client, err := net.Dial("tcp", "127.0.0.1:19013")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	select {
+	case event := <-events:
+		if event.Policy != zconf.SendQueueDisconnect || event.Priority != ziface.MsgPriorityNormal {
+			t.Fatalf("event = %+v", event)
+		}
+	case <-time.After(time.Second * 10):
+		t.Fatal("slow consumer not reported")
+	}
+	if err := <-sendErr; err != errSlowConsumer {
+		t.Fatalf("send err = %v, want %v", err, errSlowConsumer)
+	}
+	time.Sleep(time.Millisecond * 100)
+	if n := s.GetConnMgr().Len(); n != 0 {
+		t.Fatalf("%d connections left, the slow one should be closed", n)
+	}
+}
+
+// sharedPack packs every message into the same buffer, which it keeps (将每条消息都封包到同一个自己持有的缓冲)
+type sharedPack struct {
+	zpack.DataPack
+	buf []byte
+}
+
+func (p *sharedPack) Pack(msg ziface.IMessage) ([]byte, error) {
+	n := copy(p.buf[8:], msg.GetData())
+	return p.buf[:8+n], nil
+}
+
+func (p *sharedPack) PackPooled() bool {
+	return false
+}
+
+func TestPackedBufferOwnership(t *testing.T) {
+	local, remote := net.Pipe()
+	defer remote.Close()
+	go func() { _, _ = io.Copy(io.Discard, remote) }()
+
+	pack := &sharedPack{buf: make([]byte, zbuffer.MinSize)}
+	c := newServerConn(NewServer(WithPacket(pack)), local, 1).(*Connection)
+	c.ctx, c.cancel = context.WithCancel(context.Background())
+	defer c.cancel()
+
+	// The buffer of a packer not taking it from zbuffer is never put there
+	// (不是取自zbuffer的封包缓冲不会被归还到zbuffer)
+	for i := 0; i < 10; i++ {
+		if err := c.SendMsg(1, []byte("ping")); err != nil {
+			t.Fatal(err)
+		}
+		if buf := zbuffer.Get(zbuffer.MinSize); &buf[0] == &pack.buf[0] {
+			t.Fatal("buffer of the packer returned to zbuffer")
+		}
+	}
+	if !zpack.IsPooled(zpack.NewDataPack()) || zpack.IsPooled(pack) {
+		t.Fatal("IsPooled wrong")
+	}
+}
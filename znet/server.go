@@ -1,7 +1,6 @@
 package znet
 
 import (
-	"crypto/rand"
 	"crypto/tls"
 	"errors"
 	"fmt"
@@ -9,6 +8,7 @@ import (
 	"net/http"
 	"os"
 	"os/signal"
+	"sync"
 	"sync/atomic"
 	"syscall"
 	"time"
@@ -16,9 +16,12 @@ import (
 	"github.com/gorilla/websocket"
 
 	"github.com/aceld/zinx/logo"
+	"github.com/aceld/zinx/zcodec"
 	"github.com/aceld/zinx/zconf"
 	"github.com/aceld/zinx/zdecoder"
+	"github.com/aceld/zinx/zinterceptor"
 	"github.com/aceld/zinx/zlog"
+	"github.com/aceld/zinx/zmetrics"
 
 	"github.com/xtaci/kcp-go"
 
@@ -43,6 +46,8 @@ type Server struct {
 	WsPath string
 	// 服务绑定的kcp 端口 (kcp port the server is bound to)
 	KcpPort int
+	// Unix域套接字文件路径 (Socket file the server is bound to in "unix" mode)
+	UnixPath string
 
 	// Current server's message handler module, used to bind MsgID to corresponding processing methods
 	// (当前Server的消息管理模块，用来绑定MsgID和对应的处理方法)
@@ -63,28 +68,94 @@ type Server struct {
 	// (该Server的连接断开时的Hook函数)
 	onConnStop func(conn ziface.IConnection)
 
+	// Hook function called when the send queue of a connection overflows
+	// (连接发送队列溢出时的Hook函数)
+	onSlowConsumer func(conn ziface.IConnection, event ziface.SlowConsumerEvent)
+
+	// Hook function called when an idle timeout of a connection fires
+	// (连接空闲超时时的Hook函数)
+	onIdle ziface.OnIdle
+
 	// Data packet encapsulation method
 	// (数据报文封包方式)
 	packet ziface.IDataPack
 
+	// Codec of the typed messages
+	// (类型化消息的编解码器)
+	codec ziface.ICodec
+
 	// Asynchronous capture of connection closing status
 	// (异步捕获连接关闭状态)
 	exitChan chan struct{}
+	exitLock sync.Mutex
+
+	// Epoll event loops reading the TCP connections in "epoll" mode
+	// ("epoll"模式下读取TCP连接的epoll事件循环)
+	poller *poller
 
 	// Decoder for dealing with message fragmentation and reassembly
 	// (断粘包解码器)
 	decoder ziface.IDecoder
 
+	// Listeners added by AddListener, served instead of the ones chosen by zconf.GlobalObject.Mode
+	// (通过AddListener添加的监听器, 代替根据zconf.GlobalObject.Mode选择的监听)
+	listeners []*listener
+
+	// Backoff of the WebSocket listener chosen by zconf.GlobalObject.Mode (根据Mode开启的WebSocket监听的退避)
+	wsDelay acceptDelay
+
+	// Head interceptor decoding by the listener of each connection, set when some listeners have their own decoder
+	// (按连接所属监听器解码的头拦截器, 部分监听器设置了自己的解码器时使用)
+	connDecoder *connDecoder
+
 	// Heartbeat checker
 	// (心跳检测器)
 	hc ziface.IHeartbeatChecker
 
+	// Config the server was created with, zconf.GlobalObject unless created by NewUserConfServer
+	// (创建Server时的配置, 除NewUserConfServer创建的以外为zconf.GlobalObject)
+	config *zconf.Config
+
+	// Unregisters the hot reload handler of the server, called by Stop
+	// (取消Server的热加载回调, 由Stop调用)
+	stopReload func()
+
+	// Handshake of the new connections, set by SetAuthenticator
+	// (新连接的握手认证, 通过SetAuthenticator设置)
+	auth *authenticator
+
+	// Compression accepted when clients negotiate, set by SetCompression
+	// (客户端协商时可接受的压缩设置, 通过SetCompression设置)
+	compress *compressOption
+
+	// Encryption of the connections, set by SetEncryption
+	// (连接的加密设置, 通过SetEncryption设置)
+	encrypt *encryptOption
+
+	// Reliable sessions of the clients, set by SetReliable
+	// (客户端的可靠会话, 通过SetReliable设置)
+	reliable *reliableManager
+
+	// TLS settings of the tcp and websocket listeners, built from zconf.GlobalObject when not set by WithTLSConfig
+	// (tcp和websocket监听的TLS配置, 未通过WithTLSConfig设置时根据zconf.GlobalObject创建)
+	tlsConfig *tls.Config
+	tlsOnce   sync.Once
+	tlsErr    error
+
 	// websocket
 	upgrader *websocket.Upgrader
 
 	// websocket connection authentication
 	websocketAuth func(r *http.Request) error
 
+	// websocket http server, kept so that it can be closed on shutdown
+	// (websocket的http服务，用于关闭服务时停止监听)
+	wsServer *http.Server
+
+	// Hook function called for every connection before it is closed by Shutdown
+	// (Shutdown关闭连接之前对每个连接调用的Hook函数)
+	onShutdown func(conn ziface.IConnection)
+
 	kcpConfig *KcpConfig
 
 	// connection id
@@ -137,6 +208,8 @@ func newServerWithConfig(config *zconf.Config, ipVersion string, opts ...Option)
 		WsPort:           config.WsPort,
 		WsPath:           config.WsPath,
 		KcpPort:          config.KcpPort,
+		UnixPath:         config.UnixSocketPath,
+		config:           config,
 		msgHandler:       newMsgHandle(),
 		RouterSlicesMode: config.RouterSlicesMode,
 		RequestPoolMode:  config.RequestPoolMode,
@@ -145,6 +218,7 @@ func newServerWithConfig(config *zconf.Config, ipVersion string, opts ...Option)
 		// Default to using Zinx's TLV data pack format
 		// (默认使用zinx的TLV封包方式)
 		packet:  zpack.Factory().NewPack(ziface.ZinxDataPack),
+		codec:   zcodec.Default(),         // Default to using JSON (默认使用JSON编解码)
 		decoder: zdecoder.NewTLVDecoder(), // Default to using TLV decode (默认使用TLV的解码方式)
 		upgrader: &websocket.Upgrader{
 			ReadBufferSize: int(config.IOReadBuffSize),
@@ -170,6 +244,19 @@ func newServerWithConfig(config *zconf.Config, ipVersion string, opts ...Option)
 		opt(s)
 	}
 
+	// Install the token bucket rate limiter, it lets all requests through until a limit is configured
+	// (添加令牌桶限流拦截器, 在配置限流参数之前放行所有请求)
+	limiter := zinterceptor.NewRateLimiter(config)
+	s.AddInterceptor(limiter)
+
+	// Follow the hot reloads of the config (跟随配置的热加载更新运行中的组件)
+	s.stopReload = zconf.OnReload(func(old, conf *zconf.Config, report *zconf.ReloadReport) {
+		if conf == config && report.Changed(zconf.RateLimitFields...) {
+			limiter.Update(conf)
+		}
+		followHeartbeatMax(s.hc, old, conf, report)
+	})
+
 	// Display current configuration information
 	// (提示当前配置信息)
 	config.Show()
@@ -187,11 +274,10 @@ func NewServer(opts ...Option) ziface.IServer {
 // (创建一个服务器句柄)
 func NewUserConfServer(config *zconf.Config, opts ...Option) ziface.IServer {
 
-	// Refresh user configuration to global configuration variable
-	// (刷新用户配置到全局配置变量)
-	zconf.UserConfToGlobal(config)
-
-	s := newServerWithConfig(zconf.GlobalObject, "tcp4", opts...)
+	// Refresh user configuration to global configuration variable,
+	// the rate limits and idle timeouts of the server are the ones of config
+	// (刷新用户配置到全局配置变量, 该Server的限流和空闲超时取config中的值)
+	s := newServerWithConfig(zconf.UserServerConf(config), "tcp4", opts...)
 	return s
 }
 
@@ -214,9 +300,7 @@ func NewUserConfDefaultRouterSlicesServer(config *zconf.Config, opts ...Option)
 	}
 
 	// Refresh user configuration to global configuration variable (刷新用户配置到全局配置变量)
-	zconf.UserConfToGlobal(config)
-
-	s := newServerWithConfig(zconf.GlobalObject, "tcp4", opts...)
+	s := newServerWithConfig(zconf.UserServerConf(config), "tcp4", opts...)
 	s.Use(RouterRecovery)
 	return s
 }
@@ -231,12 +315,24 @@ func (s *Server) StartConn(conn ziface.IConnection) {
 		heartBeatChecker.BindConn(conn)
 	}
 
+	// Handshake before routing (路由前的握手认证)
+	if s.auth != nil {
+		s.auth.begin(conn)
+	}
+
+	// Messages are held until the keys are exchanged (密钥交换完成前暂存消息)
+	if s.encrypt != nil {
+		s.encrypt.begin(conn)
+	}
+
 	// Start processing business for the current connection
 	conn.Start()
 }
 
 func (s *Server) ListenTcpConn() {
 	zlog.Ins().InfoF("[START] TCP Server name: %s,listener at IP: %s, Port %d is starting", s.Name, s.IP, s.Port)
+	delay := &acceptDelay{}
+
 	// 1. Get a TCP address
 	addr, err := net.ResolveTCPAddr(s.IPVersion, fmt.Sprintf("%s:%d", s.IP, s.Port))
 	if err != nil {
@@ -246,18 +342,12 @@ func (s *Server) ListenTcpConn() {
 
 	// 2. Listen to the server address
 	var listener net.Listener
-	if zconf.GlobalObject.CertFile != "" && zconf.GlobalObject.PrivateKeyFile != "" {
-		// Read certificate and private key
-		crt, err := tls.LoadX509KeyPair(zconf.GlobalObject.CertFile, zconf.GlobalObject.PrivateKeyFile)
-		if err != nil {
-			panic(err)
-		}
-
+	tlsConfig, err := s.getTLSConfig()
+	if err != nil {
+		panic(err)
+	}
+	if tlsConfig != nil {
 		// TLS connection
-		tlsConfig := &tls.Config{}
-		tlsConfig.Certificates = []tls.Certificate{crt}
-		tlsConfig.Time = time.Now
-		tlsConfig.Rand = rand.Reader
 		listener, err = tls.Listen(s.IPVersion, fmt.Sprintf("%s:%d", s.IP, s.Port), tlsConfig)
 		if err != nil {
 			panic(err)
@@ -274,9 +364,9 @@ func (s *Server) ListenTcpConn() {
 		for {
 			// 3.1 Set the maximum connection control for the server. If it exceeds the maximum connection, wait.
 			// (设置服务器最大连接控制,如果超过最大连接，则等待)
-			if s.ConnMgr.Len() >= zconf.GlobalObject.MaxConn {
-				zlog.Ins().InfoF("Exceeded the maxConnNum:%d, Wait:%d", zconf.GlobalObject.MaxConn, AcceptDelay.duration)
-				AcceptDelay.Delay()
+			if s.ConnMgr.Len() >= zconf.GlobalObject.GetMaxConn() {
+				zlog.Ins().InfoF("Exceeded the maxConnNum:%d, Wait:%d", zconf.GlobalObject.GetMaxConn(), delay.Duration())
+				delay.Delay()
 				continue
 			}
 			// 3.2 Block and wait for a client to establish a connection request.
@@ -289,16 +379,22 @@ func (s *Server) ListenTcpConn() {
 					return
 				}
 				zlog.Ins().ErrorF("Accept err: %v", err)
-				AcceptDelay.Delay()
+				if zmetrics.Enabled() {
+					zmetrics.Metrics().AcceptError(s.Name)
+				}
+				delay.Delay()
 				continue
 			}
 
-			AcceptDelay.Reset()
+			delay.Reset()
 
 			// 3.4 Handle the business method for this new connection request. At this time, the handler and conn should be bound.
 			// (处理该新连接请求的 业务 方法， 此时应该有 handler 和 conn是绑定的)
 			newCid := atomic.AddUint64(&s.cID, 1)
 			dealConn := newServerConn(s, conn, newCid)
+			if s.poller != nil {
+				s.poller.attach(dealConn.(*Connection))
+			}
 
 			go s.StartConn(dealConn)
 
@@ -310,69 +406,101 @@ func (s *Server) ListenTcpConn() {
 		if err != nil {
 			zlog.Ins().ErrorF("listener close err: %v", err)
 		}
+		if s.poller != nil {
+			s.poller.close()
+		}
 	}
 }
 
 func (s *Server) ListenWebsocketConn() {
 	zlog.Ins().InfoF("[START] WEBSOCKET Server name: %s,listener at IP: %s, Port %d, Path %s is starting", s.Name, s.IP, s.WsPort, s.WsPath)
 	http.HandleFunc(s.WsPath, func(w http.ResponseWriter, r *http.Request) {
-		// 1. Check if the server has reached the maximum allowed number of connections
-		// (设置服务器最大连接控制,如果超过最大连接，则等待)
-		if s.ConnMgr.Len() >= zconf.GlobalObject.MaxConn {
-			zlog.Ins().InfoF("Exceeded the maxConnNum:%d, Wait:%d", zconf.GlobalObject.MaxConn, AcceptDelay.duration)
-			AcceptDelay.Delay()
-			return
-		}
-		// 2. If websocket authentication is required, set the authentication information
-		// (如果需要 websocket 认证请设置认证信息)
-		if s.websocketAuth != nil {
-			err := s.websocketAuth(r)
-			if err != nil {
-				zlog.Ins().ErrorF(" websocket auth err:%v", err)
-				w.WriteHeader(401)
-				AcceptDelay.Delay()
-				return
-			}
-		}
-		// 3. Check if there is a subprotocol specified in the header
-		// (判断 header 里面是有子协议)
-		if len(r.Header.Get("Sec-Websocket-Protocol")) > 0 {
-			s.upgrader.Subprotocols = websocket.Subprotocols(r)
-		}
-		// 4. Upgrade the connection to a websocket connection
-		// (升级成 websocket 连接)
-		conn, err := s.upgrader.Upgrade(w, r, nil)
-		if err != nil {
-			zlog.Ins().ErrorF("new websocket err:%v", err)
-			w.WriteHeader(500)
-			AcceptDelay.Delay()
-			return
-		}
-		AcceptDelay.Reset()
-		// 5. Handle the business logic of the new connection, which should already be bound to a handler and conn
-		// 5. 处理该新连接请求的 业务 方法， 此时应该有 handler 和 conn是绑定的
-		newCid := atomic.AddUint64(&s.cID, 1)
-		wsConn := newWebsocketConn(s, conn, newCid, r)
-		go s.StartConn(wsConn)
-
+		s.serveWebsocket(nil, w, r)
 	})
 
-	if zconf.GlobalObject.CertFile != "" && zconf.GlobalObject.PrivateKeyFile != "" {
-		err := http.ListenAndServeTLS(fmt.Sprintf("%s:%d", s.IP, s.WsPort), zconf.GlobalObject.CertFile, zconf.GlobalObject.PrivateKeyFile, nil)
-		if err != nil {
+	wsServer := &http.Server{Addr: fmt.Sprintf("%s:%d", s.IP, s.WsPort)}
+	s.exitLock.Lock()
+	s.wsServer = wsServer
+	s.exitLock.Unlock()
+
+	tlsConfig, err := s.getTLSConfig()
+	if err != nil {
+		panic(err)
+	}
+	if tlsConfig != nil {
+		wsServer.TLSConfig = tlsConfig
+		err := wsServer.ListenAndServeTLS("", "")
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
 			panic(err)
 		}
 	} else {
-		err := http.ListenAndServe(fmt.Sprintf("%s:%d", s.IP, s.WsPort), nil)
-		if err != nil {
+		err := wsServer.ListenAndServe()
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
 			panic(err)
 		}
 	}
 
 }
 
+// serveWebsocket upgrades a WebSocket request of the legacy WebSocket listener (l is nil) or of l
+// (升级WebSocket请求, l为nil时表示由Mode开启的WebSocket监听)
+func (s *Server) serveWebsocket(l *listener, w http.ResponseWriter, r *http.Request) {
+	delay := &s.wsDelay
+	if l != nil {
+		delay = &l.delay
+	}
+	// 1. Check if the server has reached the maximum allowed number of connections
+	// (设置服务器最大连接控制,如果超过最大连接，则等待)
+	if s.ConnMgr.Len() >= zconf.GlobalObject.GetMaxConn() || l != nil && l.full() {
+		zlog.Ins().InfoF("Exceeded the maxConnNum:%d, Wait:%d", zconf.GlobalObject.GetMaxConn(), delay.Duration())
+		delay.Delay()
+		return
+	}
+	// 2. If websocket authentication is required, set the authentication information
+	// (如果需要 websocket 认证请设置认证信息)
+	if s.websocketAuth != nil {
+		err := s.websocketAuth(r)
+		if err != nil {
+			zlog.Ins().ErrorF(" websocket auth err:%v", err)
+			w.WriteHeader(401)
+			delay.Delay()
+			return
+		}
+	}
+	// 3. Check if there is a subprotocol specified in the header
+	// (判断 header 里面是有子协议)
+	if len(r.Header.Get("Sec-Websocket-Protocol")) > 0 {
+		s.upgrader.Subprotocols = websocket.Subprotocols(r)
+	}
+	// 4. Upgrade the connection to a websocket connection
+	// (升级成 websocket 连接)
+	conn, err := s.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		zlog.Ins().ErrorF("new websocket err:%v", err)
+		if zmetrics.Enabled() {
+			zmetrics.Metrics().AcceptError(s.Name)
+		}
+		w.WriteHeader(500)
+		delay.Delay()
+		return
+	}
+	delay.Reset()
+	// 5. Handle the business logic of the new connection, which should already be bound to a handler and conn
+	// 5. 处理该新连接请求的 业务 方法， 此时应该有 handler 和 conn是绑定的
+	newCid := atomic.AddUint64(&s.cID, 1)
+	if l == nil {
+		go s.StartConn(newWebsocketConn(s, conn, newCid, r))
+		return
+	}
+	wsConn := newWebsocketConn(l.connServer(), conn, newCid, r)
+	l.track(wsConn)
+	go s.StartConn(wsConn)
+}
+
 func (s *Server) ListenKcpConn() {
 
+	delay := &acceptDelay{}
+
 	// 1. Listen to the server address
 	listener, err := kcp.ListenWithOptions(fmt.Sprintf("%s:%d", s.IP, s.KcpPort), nil, s.kcpConfig.KcpFecDataShards, s.kcpConfig.KcpFecParityShards)
 	if err != nil {
@@ -386,31 +514,37 @@ func (s *Server) ListenKcpConn() {
 		for {
 			// 2.1 Set the maximum connection control for the server. If it exceeds the maximum connection, wait.
 			// (设置服务器最大连接控制,如果超过最大连接，则等待)
-			if s.ConnMgr.Len() >= zconf.GlobalObject.MaxConn {
-				zlog.Ins().InfoF("Exceeded the maxConnNum:%d, Wait:%d", zconf.GlobalObject.MaxConn, AcceptDelay.duration)
-				AcceptDelay.Delay()
+			if s.ConnMgr.Len() >= zconf.GlobalObject.GetMaxConn() {
+				zlog.Ins().InfoF("Exceeded the maxConnNum:%d, Wait:%d", zconf.GlobalObject.GetMaxConn(), delay.Duration())
+				delay.Delay()
 				continue
 			}
 			// 2.2 Block and wait for a client to establish a connection request.
 			// (阻塞等待客户端建立连接请求)
 			conn, err := listener.Accept()
 			if err != nil {
+				select {
+				case <-s.exitChan:
+					zlog.Ins().ErrorF("KCP Listener closed")
+					return
+				default:
+				}
 				zlog.Ins().ErrorF("Accept KCP err: %v", err)
-				AcceptDelay.Delay()
+				if zmetrics.Enabled() {
+					zmetrics.Metrics().AcceptError(s.Name)
+				}
+				delay.Delay()
 				continue
 			}
 
-			AcceptDelay.Reset()
+			delay.Reset()
 
 			// 3.4 Handle the business method for this new connection request. At this time, the handler and conn should be bound.
 			// (处理该新连接请求的 业务 方法， 此时应该有 handler 和 conn 是绑定的)
 			newCid := atomic.AddUint64(&s.cID, 1)
 
 			kcpConn := conn.(*kcp.UDPSession)
-			kcpConn.SetACKNoDelay(s.kcpConfig.KcpACKNoDelay)
-			kcpConn.SetStreamMode(s.kcpConfig.KcpStreamMode)
-			kcpConn.SetNoDelay(s.kcpConfig.KcpNoDelay, s.kcpConfig.KcpInterval, s.kcpConfig.KcpResend, s.kcpConfig.KcpNc)
-			kcpConn.SetWindowSize(s.kcpConfig.KcpSendWindow, s.kcpConfig.KcpRecvWindow)
+			s.setupKcpSession(kcpConn)
 
 			dealConn := newKcpServerConn(s, kcpConn, newCid)
 
@@ -426,20 +560,61 @@ func (s *Server) ListenKcpConn() {
 	}
 }
 
+// getTLSConfig returns the TLS settings of the server, nil when TLS is not enabled
+// (获取Server的TLS配置, 未开启TLS时返回nil)
+func (s *Server) getTLSConfig() (*tls.Config, error) {
+	s.tlsOnce.Do(func() {
+		if s.tlsConfig == nil {
+			s.tlsConfig, s.tlsErr = NewServerTLSConfig(zconf.GlobalObject)
+		}
+	})
+	return s.tlsConfig, s.tlsErr
+}
+
+// setupKcpSession applies the KCP settings of the server to an accepted session
+// (将Server的KCP配置应用到接入的会话)
+func (s *Server) setupKcpSession(kcpConn *kcp.UDPSession) {
+	kcpConn.SetACKNoDelay(s.kcpConfig.KcpACKNoDelay)
+	kcpConn.SetStreamMode(s.kcpConfig.KcpStreamMode)
+	kcpConn.SetNoDelay(s.kcpConfig.KcpNoDelay, s.kcpConfig.KcpInterval, s.kcpConfig.KcpResend, s.kcpConfig.KcpNc)
+	kcpConn.SetWindowSize(s.kcpConfig.KcpSendWindow, s.kcpConfig.KcpRecvWindow)
+}
+
 // Start the network service
 // (开启网络服务)
 func (s *Server) Start() {
-	s.exitChan = make(chan struct{})
+	s.exitLock.Lock()
+	exitChan := make(chan struct{})
+	s.exitChan = exitChan
+	s.exitLock.Unlock()
 
 	// Add decoder to interceptors head
 	// (将解码器添加到拦截器最前面)
-	if s.decoder != nil {
-		s.msgHandler.SetHeadInterceptor(s.decoder)
+	if head := s.headInterceptor(); head != nil {
+		s.msgHandler.SetHeadInterceptor(head)
 	}
 	// Start worker pool mechanism
 	// (启动worker工作池机制)
 	s.msgHandler.StartWorkerPool()
 
+	// Export the built-in metrics if enabled
+	// (开启内置指标统计)
+	if zmetrics.Enabled() {
+		if mh, ok := s.msgHandler.(*MsgHandle); ok {
+			mh.registerQueueMetrics(s.Name)
+		}
+		zmetrics.RunMetricsService(zconf.GlobalObject)
+	}
+
+	// Serve the listeners added by AddListener, if any
+	// (如果通过AddListener添加了监听器, 则只开启这些监听器)
+	if len(s.listeners) > 0 {
+		for _, l := range s.listeners {
+			go l.serve(exitChan)
+		}
+		return
+	}
+
 	// Start a goroutine to handle server listener business
 	// (开启一个go去做服务端Listener业务)
 	switch zconf.GlobalObject.Mode {
@@ -449,6 +624,15 @@ func (s *Server) Start() {
 		go s.ListenWebsocketConn()
 	case zconf.ServerModeKcp:
 		go s.ListenKcpConn()
+	case zconf.ServerModeUnix:
+		go s.ListenUnixConn(exitChan)
+	case zconf.ServerModeEpoll:
+		p, err := newPoller(zconf.GlobalObject.EpollLoops)
+		if err != nil {
+			zlog.Ins().ErrorF("[START] epoll mode err: %v, use tcp mode instead", err)
+		}
+		s.poller = p
+		go s.ListenTcpConn()
 	default:
 		go s.ListenTcpConn()
 		go s.ListenWebsocketConn()
@@ -463,7 +647,30 @@ func (s *Server) Stop() {
 	// Clear other connection information or other information that needs to be cleaned up
 	// (将其他需要清理的连接信息或者其他信息 也要一并停止或者清理)
 	s.ConnMgr.ClearConn()
-	close(s.exitChan)
+	s.stopListen()
+	s.stopReload()
+	if mh, ok := s.msgHandler.(*MsgHandle); ok {
+		mh.stopScaling()
+	}
+}
+
+// stopListen closes all listeners of the server, it is safe to call more than once
+// (关闭服务器的全部监听，可重复调用)
+func (s *Server) stopListen() {
+	s.exitLock.Lock()
+	defer s.exitLock.Unlock()
+
+	if s.exitChan != nil {
+		select {
+		case <-s.exitChan:
+		default:
+			close(s.exitChan)
+		}
+	}
+	if s.wsServer != nil {
+		_ = s.wsServer.Close()
+		s.wsServer = nil
+	}
 }
 
 // Serve runs the server (运行服务)
@@ -525,12 +732,39 @@ func (s *Server) GetOnConnStop() func(ziface.IConnection) {
 	return s.onConnStop
 }
 
+func (s *Server) SetOnSlowConsumer(hookFunc func(ziface.IConnection, ziface.SlowConsumerEvent)) {
+	s.onSlowConsumer = hookFunc
+}
+
+func (s *Server) GetOnSlowConsumer() func(ziface.IConnection, ziface.SlowConsumerEvent) {
+	return s.onSlowConsumer
+}
+
+func (s *Server) SetOnIdle(hookFunc ziface.OnIdle) {
+	s.onIdle = hookFunc
+}
+
+func (s *Server) GetOnIdle() ziface.OnIdle {
+	return s.onIdle
+}
+
 func (s *Server) GetPacket() ziface.IDataPack {
 	return s.packet
 }
 
 func (s *Server) SetPacket(packet ziface.IDataPack) {
 	s.packet = packet
+	if connMgr, ok := s.ConnMgr.(*ConnManager); ok {
+		connMgr.setPacket(packet)
+	}
+}
+
+func (s *Server) GetCodec() ziface.ICodec {
+	return s.codec
+}
+
+func (s *Server) SetCodec(codec ziface.ICodec) {
+	s.codec = codec
 }
 
 func (s *Server) GetMsgHandler() ziface.IMsgHandle {
@@ -615,4 +849,8 @@ func (s *Server) ServerName() string {
 	return s.Name
 }
 
+func (s *Server) IsRouterSlicesMode() bool {
+	return s.RouterSlicesMode
+}
+
 func init() {}
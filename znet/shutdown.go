@@ -0,0 +1,118 @@
+package znet
+
+import (
+	"context"
+	"time"
+
+	"github.com/aceld/zinx/ziface"
+	"github.com/aceld/zinx/zlog"
+)
+
+// drainPollInterval is how often Shutdown re-checks whether draining has finished
+// (优雅关闭时检查排空状态的间隔)
+const drainPollInterval = 10 * time.Millisecond
+
+// sendQueueDrainer is implemented by connections whose buffered send queue can be waited on
+// (可以等待发送队列排空的连接)
+type sendQueueDrainer interface {
+	drainSendQueue(ctx context.Context) error
+}
+
+// waitUntil polls cond until it returns true or ctx is done
+// (轮询cond直到其返回true或ctx结束)
+func waitUntil(ctx context.Context, cond func() bool) error {
+	if cond() {
+		return nil
+	}
+
+	ticker := time.NewTicker(drainPollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+			if cond() {
+				return nil
+			}
+		}
+	}
+}
+
+// Shutdown gracefully stops the server:
+// 1. stop accepting new connections;
+// 2. refuse new requests and wait for the requests already in the worker TaskQueues to be handled;
+// 3. wait for every connection to flush the messages queued by SendBuffMsg;
+// 4. call the hook set by WithShutdownMsg/WithShutdownHook on every connection;
+// 5. close all connections.
+// If ctx is done before draining finishes, the remaining connections are closed immediately and ctx.Err() is returned.
+// (优雅关闭服务器：停止Accept、等待worker队列中已有请求处理完毕、等待每个连接的发送缓冲刷新、
+// 调用关闭通知Hook、最后关闭全部连接。ctx到期时立即关闭剩余连接并返回ctx.Err())
+func (s *Server) Shutdown(ctx context.Context) error {
+	zlog.Ins().InfoF("[SHUTDOWN] Zinx server , name %s", s.Name)
+
+	// 1. Stop accepting new connections and following the hot reloads (停止接收新连接, 不再跟随热加载)
+	s.stopListen()
+	s.stopReload()
+
+	// Make sure the sockets are closed whatever happens below (无论如何最终都要关闭全部连接)
+	defer s.ConnMgr.ClearConn()
+
+	// 2. Let in-flight worker tasks finish, then stop scaling the worker pool
+	// (等待已分发的请求处理完毕, 之后停止工作池的自动伸缩)
+	if mh, ok := s.msgHandler.(*MsgHandle); ok {
+		defer mh.stopScaling()
+		if err := mh.Drain(ctx); err != nil {
+			zlog.Ins().ErrorF("[SHUTDOWN] drain worker pool err: %v", err)
+			return err
+		}
+	}
+
+	// 3. Flush every connection's send queue (刷新每个连接的发送缓冲)
+	var conns []ziface.IConnection
+	_ = s.ConnMgr.Range2(func(_ string, conn ziface.IConnection, _ interface{}) error {
+		conns = append(conns, conn)
+		return nil
+	}, nil)
+
+	for _, conn := range conns {
+		drainer, ok := conn.(sendQueueDrainer)
+		if !ok {
+			continue
+		}
+		if err := drainer.drainSendQueue(ctx); err != nil {
+			zlog.Ins().ErrorF("[SHUTDOWN] flush ConnID = %d err: %v", conn.GetConnID(), err)
+			return err
+		}
+	}
+
+	// 4. Tell the clients that the server is closing (通知客户端服务器即将关闭)
+	if s.onShutdown != nil {
+		for _, conn := range conns {
+			s.onShutdown(conn)
+		}
+	}
+
+	// 5. Close the sockets and wait for them to be released (关闭连接并等待连接释放)
+	for _, conn := range conns {
+		closeConnSocket(conn)
+	}
+	return waitUntil(ctx, func() bool {
+		return s.ConnMgr.Len() == 0
+	})
+}
+
+// closeConnSocket stops the connection and closes its raw socket, so that a reader
+// blocked in Read returns at once instead of waiting for the next packet from the peer
+// (停止连接并关闭原始socket，使阻塞在Read上的读协程立即退出)
+func closeConnSocket(conn ziface.IConnection) {
+	conn.Stop()
+	if wsConn := conn.GetWsConn(); wsConn != nil {
+		_ = wsConn.Close()
+		return
+	}
+	if rawConn := conn.GetConnection(); rawConn != nil {
+		_ = rawConn.Close()
+	}
+}
@@ -0,0 +1,108 @@
+package znet
+
+import (
+	"context"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/aceld/zinx/zconf"
+	"github.com/aceld/zinx/ziface"
+	"github.com/aceld/zinx/zpack"
+)
+
+// run in terminal:
+// go test -v ./znet -run=TestShutdown
+
+const shutdownMsgID = 999
+
+type ShutdownEchoRouter struct {
+	BaseRouter
+}
+
+func (r *ShutdownEchoRouter) Handle(req ziface.IRequest) {
+	_ = req.GetConnection().SendBuffMsg(req.GetMsgID(), req.GetData())
+}
+
+func TestShutdown(t *testing.T) {
+	conf := *zconf.GlobalObject
+	conf.Name = "ShutdownTest"
+	conf.Host = "127.0.0.1"
+	conf.TCPPort = 18999
+
+	s := newServerWithConfig(&conf, "tcp", WithShutdownMsg(shutdownMsgID, []byte("bye")))
+	s.AddRouter(1, &ShutdownEchoRouter{})
+	var unsubscribed bool
+	stopReload := s.(*Server).stopReload
+	s.(*Server).stopReload = func() {
+		unsubscribed = true
+		stopReload()
+	}
+	s.Start()
+	time.Sleep(time.Second * 1)
+
+	conn, err := net.Dial("tcp", "127.0.0.1:18999")
+	if err != nil {
+		t.Fatalf("dial err: %v", err)
+	}
+	defer conn.Close()
+
+	dp := zpack.Factory().NewPack(ziface.ZinxDataPack)
+	pack, _ := dp.Pack(zpack.NewMsgPackage(1, []byte("ping")))
+	if _, err := conn.Write(pack); err != nil {
+		t.Fatalf("write err: %v", err)
+	}
+	time.Sleep(time.Millisecond * 200)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+	defer cancel()
+	if err := s.Shutdown(ctx); err != nil {
+		t.Fatalf("shutdown err: %v", err)
+	}
+	if !unsubscribed {
+		t.Fatal("hot reload handler still registered after shutdown")
+	}
+
+	// The echo reply must arrive before the shutdown notice, then the socket is closed
+	// (先收到回显消息，再收到关闭通知，最后连接被关闭)
+	for _, want := range []uint32{1, shutdownMsgID} {
+		head := make([]byte, dp.GetHeadLen())
+		if _, err := io.ReadFull(conn, head); err != nil {
+			t.Fatalf("read head err: %v", err)
+		}
+		msg, err := dp.Unpack(head)
+		if err != nil {
+			t.Fatalf("unpack err: %v", err)
+		}
+		if msg.GetMsgID() != want {
+			t.Fatalf("msgID = %d, want %d", msg.GetMsgID(), want)
+		}
+		if _, err := io.ReadFull(conn, make([]byte, msg.GetDataLen())); err != nil {
+			t.Fatalf("read data err: %v", err)
+		}
+	}
+
+	_ = conn.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err := conn.Read(make([]byte, 1)); err != io.EOF {
+		t.Fatalf("expected EOF after shutdown, got %v", err)
+	}
+}
+
+func TestShutdownStopsScaling(t *testing.T) {
+	s := newServerWithConfig(zconf.GlobalObject, "tcp").(*Server)
+	mh := newPoolMsgHandle(t, 1, 3, 4, zconf.WorkerOverflowBlock)
+	s.msgHandler = mh
+	mh.StartWorkerPool()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+	defer cancel()
+	if err := s.Shutdown(ctx); err != nil {
+		t.Fatalf("shutdown err: %v", err)
+	}
+	select {
+	case <-mh.scale.stop:
+	default:
+		t.Fatal("worker pool still scaling after shutdown")
+	}
+}
@@ -0,0 +1,225 @@
+package znet
+
+import (
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/aceld/zinx/zconf"
+	"github.com/aceld/zinx/zlog"
+)
+
+// certCheckInterval is how often the certificate files are checked for changes, at most
+// (检查证书文件是否修改的最短间隔)
+const certCheckInterval = time.Second
+
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+var tlsClientAuths = map[string]tls.ClientAuthType{
+	zconf.TLSClientAuthNone:             tls.NoClientCert,
+	zconf.TLSClientAuthRequest:          tls.RequestClientCert,
+	zconf.TLSClientAuthRequire:          tls.RequireAnyClientCert,
+	zconf.TLSClientAuthVerify:           tls.VerifyClientCertIfGiven,
+	zconf.TLSClientAuthRequireAndVerify: tls.RequireAndVerifyClientCert,
+}
+
+// NewServerTLSConfig builds the server side tls.Config from the TLS fields of conf,
+// it returns nil when no certificate is configured
+// (根据conf中的TLS参数创建服务端tls.Config, 未配置证书时返回nil)
+func NewServerTLSConfig(conf *zconf.Config) (*tls.Config, error) {
+	var files []zconf.TLSCertificate
+	if conf.CertFile != "" && conf.PrivateKeyFile != "" {
+		files = append(files, zconf.TLSCertificate{CertFile: conf.CertFile, PrivateKeyFile: conf.PrivateKeyFile})
+	}
+	files = append(files, conf.TLSCertificates...)
+	if len(files) == 0 {
+		return nil, nil
+	}
+
+	store := &certStore{files: files}
+	if err := store.load(); err != nil {
+		return nil, err
+	}
+
+	tlsConfig := &tls.Config{
+		GetCertificate: store.getCertificate,
+		MinVersion:     tls.VersionTLS12,
+		Time:           time.Now,
+		Rand:           rand.Reader,
+	}
+
+	if conf.TLSMinVersion != "" {
+		version, ok := tlsVersions[conf.TLSMinVersion]
+		if !ok {
+			return nil, fmt.Errorf("unknown TLS version %q", conf.TLSMinVersion)
+		}
+		tlsConfig.MinVersion = version
+	}
+
+	for _, name := range conf.TLSCipherSuites {
+		id, err := cipherSuiteID(name)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.CipherSuites = append(tlsConfig.CipherSuites, id)
+	}
+
+	if conf.TLSClientCAFile != "" {
+		pool, err := LoadCertPool(conf.TLSClientCAFile)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.ClientCAs = pool
+		tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	if conf.TLSClientAuth != "" {
+		clientAuth, ok := tlsClientAuths[conf.TLSClientAuth]
+		if !ok {
+			return nil, fmt.Errorf("unknown TLS client auth %q", conf.TLSClientAuth)
+		}
+		tlsConfig.ClientAuth = clientAuth
+	}
+	if err := checkClientCAs(tlsConfig); err != nil {
+		return nil, err
+	}
+
+	return tlsConfig, nil
+}
+
+// checkClientCAs rejects verifying the client certificates without a CA, which would verify them
+// against the system pool instead
+// (拒绝没有CA却要求校验客户端证书的配置, 否则会使用系统证书池进行校验)
+func checkClientCAs(config *tls.Config) error {
+	if config.ClientAuth >= tls.VerifyClientCertIfGiven && config.ClientCAs == nil && config.GetConfigForClient == nil {
+		return errors.New("TLS client certificates are verified but no client CA is set, set TLSClientCAFile")
+	}
+	return nil
+}
+
+// LoadCertPool loads the PEM certificates of the files into a pool,
+// such as the CA bundle a client verifies the server with
+// (将文件中的PEM证书加载到证书池, 例如客户端校验服务端所用的CA证书)
+func LoadCertPool(files ...string) (*x509.CertPool, error) {
+	pool := x509.NewCertPool()
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		if !pool.AppendCertsFromPEM(data) {
+			return nil, fmt.Errorf("no certificate found in %s", file)
+		}
+	}
+	return pool, nil
+}
+
+func cipherSuiteID(name string) (uint16, error) {
+	for _, suite := range tls.CipherSuites() {
+		if suite.Name == name {
+			return suite.ID, nil
+		}
+	}
+	for _, suite := range tls.InsecureCipherSuites() {
+		if suite.Name == name {
+			return suite.ID, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown cipher suite %q", name)
+}
+
+// peerCertificates returns the certificates presented by the peer of a TLS connection
+// (获取TLS连接对端提供的证书)
+func peerCertificates(conn net.Conn) []*x509.Certificate {
+	tlsConn, ok := conn.(*tls.Conn)
+	if !ok {
+		return nil
+	}
+	return tlsConn.ConnectionState().PeerCertificates
+}
+
+// certStore holds the server certificates, picks one by SNI and reloads them when their files change
+// (保存服务端证书, 按SNI选择证书, 证书文件修改后重新加载)
+type certStore struct {
+	files []zconf.TLSCertificate
+
+	lock    sync.Mutex
+	certs   []*tls.Certificate
+	modTime time.Time // The latest modification time of the files (证书文件的最新修改时间)
+	checked time.Time
+}
+
+func (s *certStore) load() error {
+	certs := make([]*tls.Certificate, 0, len(s.files))
+	for _, file := range s.files {
+		cert, err := tls.LoadX509KeyPair(file.CertFile, file.PrivateKeyFile)
+		if err != nil {
+			return err
+		}
+		certs = append(certs, &cert)
+	}
+
+	s.certs = certs
+	s.modTime = s.latestModTime()
+	s.checked = time.Now()
+	return nil
+}
+
+func (s *certStore) latestModTime() time.Time {
+	var latest time.Time
+	for _, file := range s.files {
+		for _, name := range []string{file.CertFile, file.PrivateKeyFile} {
+			if info, err := os.Stat(name); err == nil && info.ModTime().After(latest) {
+				latest = info.ModTime()
+			}
+		}
+	}
+	return latest
+}
+
+// reloadIfChanged reloads the certificates when the files have changed, the old ones are kept if loading fails
+// (证书文件修改后重新加载, 加载失败时继续使用原证书)
+func (s *certStore) reloadIfChanged() {
+	now := time.Now()
+	if now.Sub(s.checked) < certCheckInterval {
+		return
+	}
+	s.checked = now
+
+	if !s.latestModTime().After(s.modTime) {
+		return
+	}
+
+	if err := s.load(); err != nil {
+		zlog.Ins().ErrorF("reload TLS certificates err: %v, keep the old ones", err)
+		return
+	}
+	zlog.Ins().InfoF("TLS certificates reloaded")
+}
+
+func (s *certStore) getCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	s.lock.Lock()
+	s.reloadIfChanged()
+	certs := s.certs
+	s.lock.Unlock()
+
+	if len(certs) == 0 {
+		return nil, errors.New("no TLS certificate")
+	}
+	for _, cert := range certs {
+		if hello.SupportsCertificate(cert) == nil {
+			return cert, nil
+		}
+	}
+	return certs[0], nil
+}
@@ -0,0 +1,262 @@
+package znet
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/aceld/zinx/zconf"
+	"github.com/aceld/zinx/ziface"
+)
+
+// run in terminal:
+// go test -v ./znet -run=TestMutualTLS
+
+type testCert struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+}
+
+// issue creates a certificate for name signed by parent, a nil parent makes a self-signed CA
+// (为name签发证书, parent为nil时生成自签名的CA证书)
+func issue(t *testing.T, name string, parent *testCert) *testCert {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	serial, _ := rand.Int(rand.Reader, big.NewInt(1<<62))
+	tmpl := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		DNSNames:     []string{name},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	signer, signerKey := tmpl, key
+	if parent == nil {
+		tmpl.IsCA, tmpl.BasicConstraintsValid = true, true
+	} else {
+		signer, signerKey = parent.cert, parent.key
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, signer, &key.PublicKey, signerKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testCert{cert: cert, key: key}
+}
+
+// write saves the certificate and its key as PEM files in dir (将证书和私钥保存为PEM文件)
+func (c *testCert) write(t *testing.T, dir, name string) (certFile, keyFile string) {
+	keyDER, err := x509.MarshalECPrivateKey(c.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certFile, keyFile = filepath.Join(dir, name+".crt"), filepath.Join(dir, name+".key")
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: c.cert.Raw}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return certFile, keyFile
+}
+
+func (c *testCert) tlsCertificate() tls.Certificate {
+	return tls.Certificate{Certificate: [][]byte{c.cert.Raw}, PrivateKey: c.key, Leaf: c.cert}
+}
+
+// PeerNameRouter replies the common name of the client certificate (应答客户端证书的CommonName)
+type PeerNameRouter struct {
+	BaseRouter
+}
+
+func (r *PeerNameRouter) Handle(req ziface.IRequest) {
+	certs := req.GetConnection().GetPeerCertificates()
+	if len(certs) == 0 {
+		_ = req.Reply(nil)
+		return
+	}
+	_ = req.Reply([]byte(certs[0].Subject.CommonName))
+}
+
+func TestMutualTLS(t *testing.T) {
+	dir := t.TempDir()
+	ca := issue(t, "zinx-ca", nil)
+	caFile, _ := ca.write(t, dir, "ca")
+	certFile, keyFile := issue(t, "localhost", ca).write(t, dir, "server")
+
+	conf := *zconf.GlobalObject
+	conf.Name = "MutualTLSTest"
+	conf.Host = "127.0.0.1"
+	conf.TCPPort = 19009
+	conf.CertFile, conf.PrivateKeyFile = certFile, keyFile
+	conf.TLSClientCAFile = caFile
+	conf.TLSMinVersion = "1.3"
+
+	tlsConfig, err := NewServerTLSConfig(&conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tlsConfig.ClientAuth != tls.RequireAndVerifyClientCert || tlsConfig.MinVersion != tls.VersionTLS13 {
+		t.Fatalf("ClientAuth = %v MinVersion = %x", tlsConfig.ClientAuth, tlsConfig.MinVersion)
+	}
+
+	s := newServerWithConfig(&conf, "tcp", WithTLSConfig(tlsConfig))
+	s.AddRouter(1, &PeerNameRouter{})
+	s.Start()
+	defer s.Stop()
+	time.Sleep(time.Second * 1)
+
+	roots, err := LoadCertPool(caFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := NewClient("127.0.0.1", 19009, WithTLSConfigClient(&tls.Config{
+		RootCAs:      roots,
+		ServerName:   "localhost",
+		Certificates: []tls.Certificate{issue(t, "agent-1", ca).tlsCertificate()},
+	})).(*Client)
+	client.Start()
+	defer client.Stop()
+	time.Sleep(time.Second * 1)
+
+	conn := client.Conn()
+	if conn == nil {
+		t.Fatal("client not connected")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+	defer cancel()
+	reply, err := conn.Call(ctx, 1, nil)
+	if err != nil {
+		t.Fatalf("call err: %v", err)
+	}
+	// Handlers can authorize by the client certificate (handler可以根据客户端证书鉴权)
+	if string(reply.GetData()) != "agent-1" {
+		t.Fatalf("peer name = %q, want agent-1", reply.GetData())
+	}
+
+	// A client without a certificate is rejected (没有证书的客户端被拒绝)
+	raw, err := tls.Dial("tcp", "127.0.0.1:19009", &tls.Config{RootCAs: roots, ServerName: "localhost"})
+	if err == nil {
+		_, err = raw.Read(make([]byte, 1))
+		_ = raw.Close()
+	}
+	if err == nil {
+		t.Fatal("client without certificate accepted")
+	}
+}
+
+func TestCertStoreReload(t *testing.T) {
+	dir := t.TempDir()
+	ca := issue(t, "zinx-ca", nil)
+	certFile, keyFile := issue(t, "a.example.com", ca).write(t, dir, "a")
+	otherCert, otherKey := issue(t, "b.example.com", ca).write(t, dir, "b")
+
+	store := &certStore{files: []zconf.TLSCertificate{
+		{CertFile: certFile, PrivateKeyFile: keyFile},
+		{CertFile: otherCert, PrivateKeyFile: otherKey},
+	}}
+	if err := store.load(); err != nil {
+		t.Fatal(err)
+	}
+
+	commonName := func(serverName string) string {
+		cert, err := store.getCertificate(&tls.ClientHelloInfo{
+			ServerName:        serverName,
+			SupportedVersions: []uint16{tls.VersionTLS13},
+			SignatureSchemes:  []tls.SignatureScheme{tls.ECDSAWithP256AndSHA256},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return cert.Leaf.Subject.CommonName
+	}
+
+	// The certificate is chosen by SNI (按SNI选择证书)
+	if name := commonName("b.example.com"); name != "b.example.com" {
+		t.Fatalf("certificate for b.example.com = %s", name)
+	}
+
+	// Replace the first certificate on disk (替换磁盘上的第一个证书)
+	issue(t, "a.example.com", ca).write(t, dir, "a")
+	future := time.Now().Add(time.Minute)
+	_ = os.Chtimes(certFile, future, future)
+	_ = os.Chtimes(keyFile, future, future)
+	old := store.certs[0]
+	store.checked = time.Time{}
+
+	if commonName("a.example.com") != "a.example.com" || store.certs[0] == old {
+		t.Fatal("certificate not reloaded")
+	}
+}
+
+func TestTLSVerifyRequired(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := issue(t, "localhost", nil).write(t, dir, "self")
+
+	// Verifying client certificates without a CA fails fast (没有CA却要求校验客户端证书时直接报错)
+	conf := *zconf.GlobalObject
+	conf.CertFile, conf.PrivateKeyFile = certFile, keyFile
+	conf.TLSClientAuth = zconf.TLSClientAuthVerify
+	if _, err := NewServerTLSConfig(&conf); err == nil {
+		t.Fatal("client verification without TLSClientCAFile accepted")
+	}
+	if err := NewServer().AddListener(ziface.ListenerConfig{
+		Network:   ziface.ListenerTLS,
+		Addr:      "127.0.0.1:19023",
+		TLSConfig: &tls.Config{ClientAuth: tls.RequireAndVerifyClientCert},
+	}); err == nil {
+		t.Fatal("TLS listener verifying clients without ClientCAs accepted")
+	}
+
+	conf = *zconf.GlobalObject
+	conf.Name = "TLSVerifyTest"
+	conf.Host = "127.0.0.1"
+	conf.TCPPort = 19023
+	conf.CertFile, conf.PrivateKeyFile = certFile, keyFile
+	tlsConfig, err := NewServerTLSConfig(&conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := newServerWithConfig(&conf, "tcp", WithTLSConfig(tlsConfig))
+	s.AddRouter(1, &PeerNameRouter{})
+	s.Start()
+	defer s.Stop()
+	time.Sleep(time.Second * 1)
+
+	// The self-signed server is refused unless skipping the verification is asked for
+	// (除非明确要求跳过校验, 否则拒绝自签名证书的服务端)
+	verified := NewTLSClient("127.0.0.1", 19023).(*Client)
+	verified.Start()
+	defer verified.Stop()
+	insecure := NewTLSClient("127.0.0.1", 19023, WithInsecureSkipVerifyClient()).(*Client)
+	insecure.Start()
+	defer insecure.Stop()
+	time.Sleep(time.Second * 1)
+
+	if verified.Conn() != nil {
+		t.Fatal("self-signed server certificate accepted without WithInsecureSkipVerifyClient")
+	}
+	if insecure.Conn() == nil {
+		t.Fatal("client with WithInsecureSkipVerifyClient not connected")
+	}
+}
@@ -0,0 +1,153 @@
+package znet
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/aceld/zinx/ziface"
+	"github.com/aceld/zinx/zlog"
+)
+
+// TypedHandler handles a decoded request and returns the response to send back,
+// a nil response with a nil error sends nothing back (except to an RPC call, which gets an empty reply).
+// (处理解码后的请求并返回需要应答的响应, 响应和错误都为nil时不应答(RPC调用会收到空应答))
+type TypedHandler[Req, Resp any] func(ctx context.Context, req *Req) (*Resp, error)
+
+// Validator is implemented by request types that check themselves after decoding,
+// a non-nil error is replied to the peer and the handler is skipped
+// (解码后自行校验的请求类型需实现该接口, 校验失败时直接应答错误, 不再调用handler)
+type Validator interface {
+	Validate() error
+}
+
+// TypedRouter decodes the message data into Req with the codec of the connection,
+// calls the handler and replies Resp with the same msgID
+// (使用连接的编解码器将消息数据解码为Req, 调用handler后以相同的msgID应答Resp)
+type TypedRouter[Req, Resp any] struct {
+	BaseRouter
+	handler TypedHandler[Req, Resp]
+}
+
+// NewTypedRouter creates a TypedRouter for the given handler
+// (为handler创建TypedRouter)
+func NewTypedRouter[Req, Resp any](handler TypedHandler[Req, Resp]) *TypedRouter[Req, Resp] {
+	return &TypedRouter[Req, Resp]{handler: handler}
+}
+
+// AddTypedRouter registers a typed handler for msgID on a Server or Client
+// (在Server或Client上为msgID注册类型化的handler)
+//
+//	znet.AddTypedRouter(s, 1, func(ctx context.Context, req *PingReq) (*PingResp, error) {
+//		return &PingResp{Text: "pong"}, nil
+//	})
+func AddTypedRouter[Req, Resp any](r interface {
+	AddRouter(msgID uint32, router ziface.IRouter)
+}, msgID uint32, handler TypedHandler[Req, Resp]) {
+	r.AddRouter(msgID, NewTypedRouter(handler))
+}
+
+// TypedRouterHandler adapts a typed handler to a RouterHandler, for servers in RouterSlicesMode
+// (将类型化的handler转换为RouterHandler, 用于RouterSlicesMode模式的Server)
+func TypedRouterHandler[Req, Resp any](handler TypedHandler[Req, Resp]) ziface.RouterHandler {
+	return NewTypedRouter(handler).Handle
+}
+
+func (r *TypedRouter[Req, Resp]) Handle(request ziface.IRequest) {
+	conn := request.GetConnection()
+	codec := conn.GetCodec()
+
+	req := new(Req)
+	if err := codec.Unmarshal(request.GetData(), req); err != nil {
+		r.fail(request, fmt.Errorf("decode %s request err: %v", codec.Name(), err))
+		return
+	}
+
+	if v, ok := interface{}(req).(Validator); ok {
+		if err := v.Validate(); err != nil {
+			r.fail(request, err)
+			return
+		}
+	}
+
+	resp, err := r.handler(newRequestContext(conn.Context(), request), req)
+	if err != nil {
+		r.fail(request, err)
+		return
+	}
+
+	if resp == nil {
+		if isCall(request) {
+			if err := request.Reply(nil); err != nil {
+				zlog.Ins().ErrorF("typed router reply msgID = %d err: %v", request.GetMsgID(), err)
+			}
+		}
+		return
+	}
+
+	data, err := codec.Marshal(resp)
+	if err != nil {
+		r.fail(request, fmt.Errorf("encode %s response err: %v", codec.Name(), err))
+		return
+	}
+	if err := request.Reply(data); err != nil {
+		zlog.Ins().ErrorF("typed router reply msgID = %d err: %v", request.GetMsgID(), err)
+	}
+}
+
+// fail replies the error to an RPC call, other requests only log it since the peer has no way to tell it apart
+// (将错误应答给RPC调用方, 普通请求无法区分错误应答, 只记录日志)
+func (r *TypedRouter[Req, Resp]) fail(request ziface.IRequest, err error) {
+	if !isCall(request) {
+		zlog.Ins().ErrorF("typed router ConnID = %d msgID = %d err: %v", request.GetConnection().GetConnID(), request.GetMsgID(), err)
+		return
+	}
+	if replyErr := request.ReplyError(err); replyErr != nil {
+		zlog.Ins().ErrorF("typed router reply error msgID = %d err: %v", request.GetMsgID(), replyErr)
+	}
+}
+
+// CallTyped sends req as an RPC call with the codec of the connection and decodes the reply into Resp
+// (使用连接的编解码器将req作为RPC调用发送, 并将应答解码为Resp)
+func CallTyped[Resp any](ctx context.Context, conn ziface.IConnection, msgID uint32, req interface{}) (*Resp, error) {
+	codec := conn.GetCodec()
+
+	data, err := codec.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+
+	msg, err := conn.Call(ctx, msgID, data)
+	if err != nil {
+		return nil, err
+	}
+
+	resp := new(Resp)
+	if len(msg.GetData()) == 0 {
+		return resp, nil
+	}
+	if err := codec.Unmarshal(msg.GetData(), resp); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+type requestCtxKey struct{}
+
+func newRequestContext(parent context.Context, request ziface.IRequest) context.Context {
+	if parent == nil {
+		parent = context.Background()
+	}
+	return context.WithValue(parent, requestCtxKey{}, request)
+}
+
+// RequestFromContext returns the request being handled by a TypedHandler
+// (获取TypedHandler正在处理的请求)
+func RequestFromContext(ctx context.Context) (ziface.IRequest, bool) {
+	request, ok := ctx.Value(requestCtxKey{}).(ziface.IRequest)
+	return request, ok
+}
+
+func isCall(request ziface.IRequest) bool {
+	req, ok := request.(*Request)
+	return ok && req.isCall
+}
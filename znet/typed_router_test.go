@@ -0,0 +1,81 @@
+package znet
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/aceld/zinx/zcodec"
+	"github.com/aceld/zinx/zconf"
+	"github.com/aceld/zinx/ziface"
+)
+
+// run in terminal:
+// go test -v ./znet -run=TestTypedRouter
+
+type typedAddReq struct {
+	A int `json:"a" msgpack:"a"`
+	B int `json:"b" msgpack:"b"`
+}
+
+func (r *typedAddReq) Validate() error {
+	if r.A < 0 || r.B < 0 {
+		return errors.New("negative operand")
+	}
+	return nil
+}
+
+type typedAddResp struct {
+	Sum int `json:"sum" msgpack:"sum"`
+}
+
+func TestTypedRouter(t *testing.T) {
+	for i, codec := range []ziface.ICodec{zcodec.Get(ziface.ZinxCodecJSON), zcodec.Get(ziface.ZinxCodecMsgpack)} {
+		port := 19003 + i
+		t.Run(codec.Name(), func(t *testing.T) {
+			conf := *zconf.GlobalObject
+			conf.Name = "TypedRouterTest"
+			conf.Host = "127.0.0.1"
+			conf.TCPPort = port
+
+			s := newServerWithConfig(&conf, "tcp", WithCodec(codec))
+			AddTypedRouter(s, 1, func(ctx context.Context, req *typedAddReq) (*typedAddResp, error) {
+				if _, ok := RequestFromContext(ctx); !ok {
+					return nil, errors.New("no request in ctx")
+				}
+				return &typedAddResp{Sum: req.A + req.B}, nil
+			})
+			s.Start()
+			defer s.Stop()
+			time.Sleep(time.Second * 1)
+
+			client := NewClient("127.0.0.1", port, WithCodecClient(codec))
+			client.Start()
+			defer client.Stop()
+			time.Sleep(time.Second * 1)
+
+			conn := client.Conn()
+			if conn == nil {
+				t.Fatal("client not connected")
+			}
+
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+			defer cancel()
+
+			resp, err := CallTyped[typedAddResp](ctx, conn, 1, &typedAddReq{A: 1, B: 2})
+			if err != nil {
+				t.Fatalf("call err: %v", err)
+			}
+			if resp.Sum != 3 {
+				t.Fatalf("sum = %d, want 3", resp.Sum)
+			}
+
+			// Validation errors come back as *RPCError (校验失败以*RPCError返回)
+			var rpcErr *RPCError
+			if _, err := CallTyped[typedAddResp](ctx, conn, 1, &typedAddReq{A: -1, B: 2}); !errors.As(err, &rpcErr) {
+				t.Fatalf("err = %v, want *RPCError", err)
+			}
+		})
+	}
+}
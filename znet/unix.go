@@ -0,0 +1,102 @@
+package znet
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/aceld/zinx/ziface"
+	"github.com/aceld/zinx/zlog"
+)
+
+// listenUnix listens on the socket file path. A socket file left behind by a crashed process is removed first,
+// a socket still accepting connections or a file that is not a socket is an error.
+// The file gets perm when it is not 0, and is removed again when the listener is closed.
+// (监听path处的套接字文件。异常退出的进程遗留的套接字文件会先被删除, 仍在接收连接的套接字或非套接字文件则返回错误。
+// perm不为0时设置文件权限, 关闭监听时删除该文件)
+func listenUnix(path string, perm os.FileMode) (net.Listener, error) {
+	if info, err := os.Lstat(path); err == nil {
+		if info.Mode()&os.ModeSocket == 0 {
+			return nil, fmt.Errorf("%s exists and is not a socket", path)
+		}
+		if conn, err := net.DialTimeout("unix", path, time.Second); err == nil {
+			_ = conn.Close()
+			return nil, fmt.Errorf("%s is in use by another process", path)
+		}
+		if err := os.Remove(path); err != nil {
+			return nil, err
+		}
+		zlog.Ins().InfoF("removed stale unix socket %s", path)
+	}
+
+	if perm == 0 {
+		return net.Listen("unix", path)
+	}
+
+	// Bound and chmoded in a private directory, then renamed into place, so no client connects before it has perm.
+	// The process umask is left alone, other goroutines may be creating files meanwhile.
+	// (在私有目录中创建并设置权限后再移动到path, 因此在设置权限之前不会有客户端连接; 不修改进程的umask, 其他协程可能同时在创建文件)
+	dir, err := os.MkdirTemp(filepath.Dir(path), ".zinx-sock-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+
+	tmp := filepath.Join(dir, "s")
+	ln, err := net.Listen("unix", tmp)
+	if err != nil {
+		return nil, err
+	}
+	ul := ln.(*net.UnixListener)
+	// The listener would unlink tmp, path is removed by unixListener instead (监听器会删除tmp, 改由unixListener删除path)
+	ul.SetUnlinkOnClose(false)
+
+	if err := os.Chmod(tmp, perm); err != nil {
+		_ = ul.Close()
+		return nil, err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		_ = ul.Close()
+		return nil, err
+	}
+	return &unixListener{UnixListener: ul, path: path}, nil
+}
+
+// unixListener removes the socket file renamed to path when it is closed (关闭时删除移动到path的套接字文件)
+type unixListener struct {
+	*net.UnixListener
+	path      string
+	closeOnce sync.Once
+}
+
+func (l *unixListener) Close() error {
+	err := l.UnixListener.Close()
+	l.closeOnce.Do(func() {
+		_ = os.Remove(l.path)
+	})
+	return err
+}
+
+// ListenUnixConn serves the Unix domain socket at UnixPath until exitChan is closed
+// (在UnixPath处监听Unix域套接字, 直到exitChan关闭)
+func (s *Server) ListenUnixConn(exitChan chan struct{}) {
+	perm, err := s.config.UnixSocketFileMode()
+	if err != nil {
+		zlog.Ins().ErrorF("[START] unix socket err: %v", err)
+		return
+	}
+
+	l := &listener{
+		conf: ziface.ListenerConfig{
+			Name:     "unix://" + s.UnixPath,
+			Network:  ziface.ListenerUnix,
+			Addr:     s.UnixPath,
+			FileMode: perm,
+		},
+		server: s,
+	}
+	l.serve(exitChan)
+}
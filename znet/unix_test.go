@@ -0,0 +1,114 @@
+package znet
+
+import (
+	"context"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/aceld/zinx/zconf"
+)
+
+// run in terminal:
+// go test -v ./znet -run=TestUnixServer
+
+func TestUnixServer(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "zinx.sock")
+
+	// A socket file left behind by a crashed server (异常退出的服务遗留的套接字文件)
+	stale, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stale.(*net.UnixListener).SetUnlinkOnClose(false)
+	_ = stale.Close()
+
+	mode, perm := zconf.GlobalObject.Mode, zconf.GlobalObject.UnixSocketPerm
+	zconf.GlobalObject.Mode = zconf.ServerModeUnix
+	defer func() { zconf.GlobalObject.Mode, zconf.GlobalObject.UnixSocketPerm = mode, perm }()
+
+	// The permission comes from the config of the server, not from GlobalObject (权限取自Server的配置, 而不是GlobalObject)
+	conf := *zconf.GlobalObject
+	conf.Name = "UnixTest"
+	conf.UnixSocketPath = path
+	conf.UnixSocketPerm = "0600"
+	zconf.GlobalObject.UnixSocketPerm = "0666"
+
+	s := newServerWithConfig(&conf, "tcp")
+	s.AddRouter(1, &RPCEchoRouter{})
+	s.Start()
+	time.Sleep(time.Second * 1)
+
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Fatalf("socket perm = %v, want 0600", info.Mode().Perm())
+	}
+
+	// A second server must not take over a socket in use (不能占用正在使用的套接字)
+	if _, err := listenUnix(path, 0); err == nil {
+		t.Fatal("listened on a socket in use")
+	}
+
+	client := NewUnixClient(path).(*Client)
+	client.Start()
+	time.Sleep(time.Second * 1)
+
+	conn := client.Conn()
+	if conn == nil {
+		t.Fatal("client not connected")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+	defer cancel()
+	reply, err := conn.Call(ctx, 1, []byte("hi"))
+	if err != nil {
+		t.Fatalf("call err: %v", err)
+	}
+	if string(reply.GetData()) != "echo:hi" {
+		t.Fatalf("reply = %q, want %q", reply.GetData(), "echo:hi")
+	}
+
+	client.Stop()
+	s.Stop()
+	time.Sleep(time.Millisecond * 200)
+
+	// The socket file is removed with the listener (关闭监听时删除套接字文件)
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Fatalf("socket file not removed, err: %v", err)
+	}
+}
+
+func TestListenUnixPerm(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "zinx.sock")
+
+	ln, err := listenUnix(path, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Fatalf("socket perm = %v, want 0600", info.Mode().Perm())
+	}
+
+	// The private directory it was created in is gone (创建时使用的私有目录已删除)
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Fatalf("%d files left in the directory, want the socket only", len(entries))
+	}
+
+	_ = ln.Close()
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Fatalf("socket file not removed, err: %v", err)
+	}
+}
@@ -0,0 +1,432 @@
+package znet
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/aceld/zinx/zconf"
+	"github.com/aceld/zinx/ziface"
+	"github.com/aceld/zinx/zlog"
+)
+
+// workerScaleIdleChecks is how many idle checks in a row shrink the pool by one worker
+// (连续多少次空闲检查后缩容一个worker)
+const workerScaleIdleChecks = 3
+
+// ErrWorkerBusy is replied to the client when its request is rejected because the worker queue is full
+// (worker任务队列满、请求被拒绝时回复给客户端的错误)
+var ErrWorkerBusy = errors.New("server busy, request rejected")
+
+// workerScaler grows and shrinks the worker pool of zconf.WorkerModeHash between WorkerPoolSize and WorkerPoolMaxSize.
+// The requests of a connection go to the worker of its lane, which moves to the least loaded active worker whenever
+// none of its requests is queued, so the connections already open spread over the workers added and leave the workers
+// retired, while the requests of a connection are always handled in order.
+// A worker above the active count keeps running until nothing is queued for it.
+// (在WorkerPoolSize和WorkerPoolMaxSize之间对zconf.WorkerModeHash的worker池扩缩容;
+// 连接的请求交给其lane对应的worker, 当该连接没有排队中的请求时, lane会移到负载最低的活跃worker上,
+// 因此已有连接也会分散到新增的worker并离开被缩掉的worker, 同时同一连接的请求始终按顺序处理; 超出active的worker在没有排队的请求后才退出)
+type workerScaler struct {
+	min, max uint32
+	interval time.Duration
+	latency  time.Duration
+	queueCap uint32
+
+	active  uint32  // Workers the lanes move to (lane可移入的worker数)
+	running []int32 // 1 while the goroutine of the worker runs (worker协程运行中为1)
+	pending []int32 // Senders about to put a request into the queue of the worker (正在向该worker队列投递请求的发送方数)
+	load    []int32 // Requests queued for the worker or being handled by it (排在该worker上或正在处理的请求数)
+	quit    []chan struct{}
+	done    []chan struct{}
+
+	lanes sync.Map // ziface.IConnection -> *connLane
+
+	// Handling time of the requests since the last check (上次检查以来的请求处理耗时)
+	latencySum   int64
+	latencyCount int64
+
+	// Only touched by the scaling goroutine (只由扩缩容协程访问)
+	idle int
+
+	stop     chan struct{}
+	stopOnce sync.Once
+}
+
+// connLane is the worker the requests of a connection go to, it only moves while queued is 0
+// (连接的请求所交给的worker, 只在queued为0时移动)
+type connLane struct {
+	lock   sync.Mutex
+	worker uint32
+	queued int32 // Requests of the connection queued or being handled (该连接排队中或正在处理的请求数)
+}
+
+func newWorkerScaler(min, max uint32) *workerScaler {
+	conf := zconf.GlobalObject
+	interval := time.Duration(conf.WorkerScaleInterval) * time.Millisecond
+	if interval <= 0 {
+		interval = time.Second
+	}
+	return &workerScaler{
+		min:      min,
+		max:      max,
+		interval: interval,
+		latency:  time.Duration(conf.WorkerScaleLatency) * time.Millisecond,
+		queueCap: conf.MaxWorkerTaskLen,
+		active:   min,
+		running:  make([]int32, max),
+		pending:  make([]int32, max),
+		load:     make([]int32, max),
+		quit:     make([]chan struct{}, max),
+		done:     make([]chan struct{}, max),
+		stop:     make(chan struct{}),
+	}
+}
+
+// assign picks the first worker of a new connection among the active ones
+// (在活跃的worker中为新连接选择最初的worker)
+func (s *workerScaler) assign(connID uint64) uint32 {
+	return uint32(connID % uint64(atomic.LoadUint32(&s.active)))
+}
+
+// release forgets the lane of a closed connection (删除已关闭连接的lane)
+func (s *workerScaler) release(conn ziface.IConnection) {
+	s.lanes.Delete(conn)
+}
+
+// enter marks a sender of a request of conn and returns the worker to queue it to, workerID is the worker
+// of a connection without a lane yet. leave must be called with the returned worker once the request is queued,
+// and finish once it is handled or dropped.
+// (登记conn的一个请求的发送方并返回其应投递的worker, workerID为尚无lane的连接的worker;
+// 请求入队后需用返回的worker调用leave, 处理完成或被丢弃后调用finish)
+func (s *workerScaler) enter(conn ziface.IConnection, workerID uint32) uint32 {
+	if conn == nil {
+		workerID = s.pick(workerID)
+		atomic.AddInt32(&s.load[workerID], 1)
+		return workerID
+	}
+	value, ok := s.lanes.Load(conn)
+	if !ok {
+		value, _ = s.lanes.LoadOrStore(conn, &connLane{worker: workerID})
+	}
+	lane := value.(*connLane)
+
+	lane.lock.Lock()
+	defer lane.lock.Unlock()
+	if lane.queued == 0 {
+		// Nothing of the connection is queued, it can move without changing the order (该连接没有排队的请求, 移动不会改变顺序)
+		lane.worker = s.pick(lane.worker)
+	} else {
+		// The worker can not retire while load counts these requests (load计入这些请求时worker不会退出)
+		atomic.AddInt32(&s.pending[lane.worker], 1)
+	}
+	lane.queued++
+	atomic.AddInt32(&s.load[lane.worker], 1)
+	return lane.worker
+}
+
+// pick chooses current unless it is retired or more loaded than the least loaded active worker,
+// and marks a sender of the worker chosen
+// (除非current已退出或负载高于负载最低的活跃worker, 否则选择current; 并登记所选worker的发送方)
+func (s *workerScaler) pick(current uint32) uint32 {
+	for {
+		active := atomic.LoadUint32(&s.active)
+		workerID := current
+		if current >= active || atomic.LoadInt32(&s.load[current]) > 0 {
+			best := uint32(0)
+			for i := uint32(1); i < active; i++ {
+				if atomic.LoadInt32(&s.load[i]) < atomic.LoadInt32(&s.load[best]) {
+					best = i
+				}
+			}
+			if current >= active || atomic.LoadInt32(&s.load[current]) > atomic.LoadInt32(&s.load[best])+1 {
+				workerID = best
+			}
+		}
+		atomic.AddInt32(&s.pending[workerID], 1)
+		if atomic.LoadInt32(&s.running[workerID]) == 1 {
+			return workerID
+		}
+		// Retired in the meantime, try again (期间该worker已退出, 重新选择)
+		atomic.AddInt32(&s.pending[workerID], -1)
+		current = active
+	}
+}
+
+func (s *workerScaler) leave(workerID uint32) {
+	atomic.AddInt32(&s.pending[workerID], -1)
+}
+
+// finish marks a request of conn queued to workerID as handled or dropped (标记conn投递到workerID的请求已处理或已丢弃)
+func (s *workerScaler) finish(conn ziface.IConnection, workerID uint32) {
+	if conn != nil {
+		if value, ok := s.lanes.Load(conn); ok {
+			lane := value.(*connLane)
+			lane.lock.Lock()
+			lane.queued--
+			lane.lock.Unlock()
+		}
+	}
+	atomic.AddInt32(&s.load[workerID], -1)
+}
+
+func (s *workerScaler) observe(d time.Duration) {
+	atomic.AddInt64(&s.latencySum, int64(d))
+	atomic.AddInt64(&s.latencyCount, 1)
+}
+
+// startScaledWorker starts the goroutine of a worker that is not running
+// (启动一个未运行worker的协程)
+func (mh *MsgHandle) startScaledWorker(workerID uint32) {
+	s := mh.scale
+	if s.done[workerID] != nil {
+		// Wait for the previous goroutine of this queue to leave (等待该队列之前的协程退出)
+		<-s.done[workerID]
+	}
+	quit, done := make(chan struct{}), make(chan struct{})
+	s.quit[workerID], s.done[workerID] = quit, done
+	atomic.StoreInt32(&s.running[workerID], 1)
+	go func() {
+		defer close(done)
+		mh.workerLoop(int(workerID), mh.TaskQueue[workerID], quit)
+	}()
+}
+
+// retireWorker stops a worker above the active count once nothing is queued for it, the lanes still
+// on it move to an active worker with their next request
+// (超出活跃数的worker没有排队的请求时将其停止, 仍指向它的lane在下一个请求时移到活跃的worker)
+func (mh *MsgHandle) retireWorker(workerID uint32) {
+	s := mh.scale
+	if atomic.LoadInt32(&s.load[workerID]) != 0 || len(mh.TaskQueue[workerID]) != 0 || mh.spillLen(workerID) != 0 {
+		return
+	}
+	atomic.StoreInt32(&s.running[workerID], 0)
+	if atomic.LoadInt32(&s.pending[workerID]) != 0 || atomic.LoadInt32(&s.load[workerID]) != 0 ||
+		len(mh.TaskQueue[workerID]) != 0 || mh.spillLen(workerID) != 0 {
+		atomic.StoreInt32(&s.running[workerID], 1)
+		return
+	}
+	close(s.quit[workerID])
+	zlog.Ins().DebugF("worker pool shrinks, Worker ID = %d retired", workerID)
+}
+
+// scaleWorkers runs the scaling checks until the server stops
+// (定期执行扩缩容检查, 直到服务器停止)
+func (mh *MsgHandle) scaleWorkers() {
+	ticker := time.NewTicker(mh.scale.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			mh.scaleCheck()
+		case <-mh.scale.stop:
+			return
+		}
+	}
+}
+
+// scaleCheck grows the pool when the queues of the active workers are half full or the handling is slower
+// than WorkerScaleLatency, and shrinks it after workerScaleIdleChecks checks in a row with empty queues
+// (活跃worker的队列半满或处理耗时超过WorkerScaleLatency时扩容, 连续workerScaleIdleChecks次检查队列都为空时缩容)
+func (mh *MsgHandle) scaleCheck() {
+	s := mh.scale
+	active := atomic.LoadUint32(&s.active)
+
+	depth := 0
+	for i := uint32(0); i < active; i++ {
+		depth += len(mh.TaskQueue[i]) + mh.spillLen(i)
+	}
+	count := atomic.SwapInt64(&s.latencyCount, 0)
+	sum := atomic.SwapInt64(&s.latencySum, 0)
+	slow := s.latency > 0 && count > 0 && time.Duration(sum/count) > s.latency
+	busy := slow || uint32(depth)*2 >= active*s.queueCap
+
+	switch {
+	case busy && active < s.max:
+		if atomic.LoadInt32(&s.running[active]) == 0 {
+			mh.startScaledWorker(active)
+		}
+		atomic.StoreUint32(&s.active, active+1)
+		s.idle = 0
+		zlog.Ins().InfoF("worker pool grows to %d workers, queued = %d", active+1, depth)
+	case !busy && depth == 0 && active > s.min:
+		if s.idle++; s.idle >= workerScaleIdleChecks {
+			atomic.StoreUint32(&s.active, active-1)
+			s.idle = 0
+			zlog.Ins().InfoF("worker pool shrinks to %d workers", active-1)
+		}
+	default:
+		s.idle = 0
+	}
+
+	for id := atomic.LoadUint32(&s.active); id < s.max; id++ {
+		if atomic.LoadInt32(&s.running[id]) == 1 {
+			mh.retireWorker(id)
+		}
+	}
+}
+
+// stopScaling stops the scaling goroutine, the running workers are kept
+// (停止扩缩容协程, 运行中的worker保持不变)
+func (mh *MsgHandle) stopScaling() {
+	if mh.scale != nil {
+		mh.scale.stopOnce.Do(func() {
+			close(mh.scale.stop)
+		})
+	}
+}
+
+// overflowed handles a client request whose worker queue is full according to zconf.WorkerOverflowAction,
+// a request that finds the spill lane of its worker full too is rejected
+// (按zconf.WorkerOverflowAction处理worker任务队列已满的客户端请求, 溢出队列也已满时拒绝该请求)
+func (mh *MsgHandle) overflowed(request ziface.IRequest, workerID uint32) {
+	action := mh.overflow
+	switch action {
+	case zconf.WorkerOverflowReject, zconf.WorkerOverflowSpill:
+		action = zconf.WorkerOverflowReject
+		atomic.AddUint64(&mh.rejected, 1)
+		if err := mh.reject(request); err != nil {
+			zlog.Ins().ErrorF("reject msgID = %d err: %v", request.GetMsgID(), err)
+		}
+	default:
+		atomic.AddUint64(&mh.shed, 1)
+	}
+	zlog.Ins().DebugF("Worker ID = %d queue is full, %s msgID = %d", workerID, action, request.GetMsgID())
+	atomic.AddInt64(&mh.inFlight, -1)
+	if mh.scale != nil {
+		mh.scale.finish(request.GetConnection(), workerID)
+	}
+	PutRequest(request)
+}
+
+// reject answers a rejected request with ErrWorkerBusy, under WorkerRejectMsgID unless it is an RPC call
+// (用ErrWorkerBusy回复被拒绝的请求, 非RPC调用时使用WorkerRejectMsgID)
+func (mh *MsgHandle) reject(request ziface.IRequest) error {
+	if isCall(request) {
+		return request.ReplyError(ErrWorkerBusy)
+	}
+	conn := request.GetConnection()
+	if conn == nil {
+		return errors.New("request has no connection to reply")
+	}
+	return conn.SendMsg(mh.rejectMsgID, []byte(ErrWorkerBusy.Error()))
+}
+
+// spillLane holds the requests of a worker whose TaskQueue is full, for zconf.WorkerOverflowSpill.
+// They are moved into the TaskQueue as it empties, and no request goes to the TaskQueue
+// while the lane is not empty, so the requests of a connection or a key keep their order.
+// (zconf.WorkerOverflowSpill下worker任务队列满时暂存请求。队列变空时按顺序移入TaskQueue,
+// 溢出队列非空时请求不会直接进入TaskQueue, 因此同一连接或同一key的请求保持顺序)
+type spillLane struct {
+	lock     sync.Mutex
+	requests []ziface.IRequest
+}
+
+// send queues request behind the requests already spilled, spilled tells whether it went to the lane
+// and ok is false when the lane is full. force spills the request even beyond zconf.GlobalObject.MaxWorkerTaskLen.
+// (将请求排在已溢出的请求之后, spilled表示请求是否进入溢出队列, 溢出队列已满时ok为false; force为true时可超过MaxWorkerTaskLen)
+func (l *spillLane) send(request ziface.IRequest, taskQueue chan ziface.IRequest, force bool) (spilled, ok bool) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	if len(l.requests) == 0 {
+		select {
+		case taskQueue <- request:
+			return false, true
+		default:
+		}
+	}
+	if !force && len(l.requests) >= int(zconf.GlobalObject.MaxWorkerTaskLen) {
+		return false, false
+	}
+	l.requests = append(l.requests, request)
+	return true, true
+}
+
+// refill moves the spilled requests into taskQueue in order, as many as it has room for
+// (按顺序将溢出的请求移入taskQueue, 直到队列放满)
+func (l *spillLane) refill(taskQueue chan ziface.IRequest) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	n := 0
+	for n < len(l.requests) {
+		select {
+		case taskQueue <- l.requests[n]:
+			l.requests[n] = nil
+			n++
+			continue
+		default:
+		}
+		break
+	}
+	l.requests = l.requests[n:]
+	if len(l.requests) == 0 {
+		l.requests = nil
+	}
+}
+
+// close closes taskQueue and returns the requests left in the lane
+// (关闭taskQueue并返回溢出队列中剩余的请求)
+func (l *spillLane) close(taskQueue chan ziface.IRequest) []ziface.IRequest {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	close(taskQueue)
+	requests := l.requests
+	l.requests = nil
+	return requests
+}
+
+func (l *spillLane) len() int {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	return len(l.requests)
+}
+
+// spillLen returns the number of requests in the spill lane of a worker
+// (返回worker溢出队列中的请求数)
+func (mh *MsgHandle) spillLen(workerID uint32) int {
+	if mh.spill == nil {
+		return 0
+	}
+	return mh.spill[workerID].len()
+}
+
+// GetWorkerStats returns the queue depth and counters of every worker
+// (返回每个worker的队列长度和计数)
+func (mh *MsgHandle) GetWorkerStats() ziface.WorkerStats {
+	stats := ziface.WorkerStats{
+		Active:   int(mh.WorkerPoolSize),
+		Rejected: atomic.LoadUint64(&mh.rejected),
+		Shed:     atomic.LoadUint64(&mh.shed),
+		Spilled:  atomic.LoadUint64(&mh.spilled),
+	}
+	if mh.scale != nil {
+		stats.Active = int(atomic.LoadUint32(&mh.scale.active))
+	}
+
+	// The extra workers of zconf.WorkerModeDynamicBind are created under this lock (DynamicBind模式的额外worker在该锁下创建)
+	mh.extraFreeWorkerMu.Lock()
+	defer mh.extraFreeWorkerMu.Unlock()
+	for i, queue := range mh.TaskQueue {
+		if queue == nil {
+			continue
+		}
+		stats.SpillLen += mh.spillLen(uint32(i))
+		running := true
+		if mh.scale != nil {
+			running = atomic.LoadInt32(&mh.scale.running[i]) == 1
+		} else if _, free := mh.extraFreeWorkers[uint32(i)]; free {
+			running = false
+		}
+		stats.Workers = append(stats.Workers, ziface.WorkerStat{
+			WorkerID: i,
+			QueueLen: len(queue),
+			QueueCap: cap(queue),
+			Running:  running,
+			Handled:  atomic.LoadUint64(&mh.handled[i]),
+		})
+	}
+	return stats
+}
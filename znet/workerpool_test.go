@@ -0,0 +1,261 @@
+package znet
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/aceld/zinx/zconf"
+	"github.com/aceld/zinx/ziface"
+	"github.com/aceld/zinx/zpack"
+)
+
+func newPoolMsgHandle(t *testing.T, size, maxSize, taskLen uint32, overflow string) *MsgHandle {
+	conf := zconf.GlobalObject
+	mode, poolSize, poolMaxSize, maxTaskLen := conf.WorkerMode, conf.WorkerPoolSize, conf.WorkerPoolMaxSize, conf.MaxWorkerTaskLen
+	action, interval := conf.WorkerOverflowAction, conf.WorkerScaleInterval
+	t.Cleanup(func() {
+		conf.WorkerMode, conf.WorkerPoolSize, conf.WorkerPoolMaxSize, conf.MaxWorkerTaskLen = mode, poolSize, poolMaxSize, maxTaskLen
+		conf.WorkerOverflowAction, conf.WorkerScaleInterval = action, interval
+	})
+	conf.WorkerMode, conf.WorkerPoolSize, conf.WorkerPoolMaxSize, conf.MaxWorkerTaskLen = zconf.WorkerModeHash, size, maxSize, taskLen
+	conf.WorkerOverflowAction, conf.WorkerScaleInterval = overflow, 20
+	return newMsgHandle()
+}
+
+func TestWorkerOverflow(t *testing.T) {
+	for _, action := range []string{zconf.WorkerOverflowShed, zconf.WorkerOverflowReject, zconf.WorkerOverflowSpill} {
+		t.Run(action, func(t *testing.T) {
+			mh := newPoolMsgHandle(t, 1, 0, 2, action)
+			// The worker is not started so the queue stays full (不启动worker, 队列保持满)
+			mh.TaskQueue[0] = make(chan ziface.IRequest, 2)
+
+			conn := &Connection{workerID: 0}
+			for i := 0; i < 4; i++ {
+				mh.SendMsgToTaskQueue(NewRequest(conn, zpack.NewMsgPackage(1, nil)))
+			}
+
+			stats := mh.GetWorkerStats()
+			if len(stats.Workers) != 1 || stats.Workers[0].QueueLen != 2 || stats.Workers[0].QueueCap != 2 {
+				t.Fatalf("stats = %+v", stats)
+			}
+			var dropped uint64
+			switch action {
+			case zconf.WorkerOverflowShed:
+				dropped = stats.Shed
+			case zconf.WorkerOverflowReject:
+				dropped = stats.Rejected
+			case zconf.WorkerOverflowSpill:
+				dropped = stats.Spilled
+				if stats.SpillLen != 2 {
+					t.Fatalf("spill queue has %d requests, want 2", stats.SpillLen)
+				}
+			}
+			if dropped != 2 {
+				t.Fatalf("%s %d requests, want 2: %+v", action, dropped, stats)
+			}
+
+			// The spilled requests are still handled (溢出到共享队列的请求仍会被处理)
+			want := int64(4)
+			if action != zconf.WorkerOverflowSpill {
+				want = 2
+			}
+			if mh.inFlight != want {
+				t.Fatalf("inFlight = %d, want %d", mh.inFlight, want)
+			}
+		})
+	}
+}
+
+type rejectTestConn struct {
+	ziface.IConnection
+	lock   sync.Mutex
+	msgIDs []uint32
+}
+
+func (c *rejectTestConn) GetWorkerID() uint32 { return 0 }
+
+func (c *rejectTestConn) SendMsg(msgID uint32, data []byte) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.msgIDs = append(c.msgIDs, msgID)
+	return nil
+}
+
+func TestWorkerOverflowReject(t *testing.T) {
+	rejectMsgID := zconf.GlobalObject.WorkerRejectMsgID
+	defer func() { zconf.GlobalObject.WorkerRejectMsgID = rejectMsgID }()
+	zconf.GlobalObject.WorkerRejectMsgID = 500
+
+	mh := newPoolMsgHandle(t, 1, 0, 1, zconf.WorkerOverflowReject)
+	mh.TaskQueue[0] = make(chan ziface.IRequest, 1)
+	conn := &rejectTestConn{}
+	mh.SendMsgToTaskQueue(NewRequest(conn, zpack.NewMsgPackage(1, nil)))
+
+	// A plain request is answered under WorkerRejectMsgID, not its own MsgID (普通请求以WorkerRejectMsgID回复, 而不是其自身的MsgID)
+	mh.SendMsgToTaskQueue(NewRequest(conn, zpack.NewMsgPackage(1, nil)))
+	// An RPC call gets an RPC error (RPC调用得到RPC错误)
+	call := NewRequest(conn, zpack.NewMsgPackage(1, nil)).(*Request)
+	call.isCall, call.callID = true, 7
+	mh.SendMsgToTaskQueue(call)
+
+	if len(conn.msgIDs) != 2 || conn.msgIDs[0] != 500 || conn.msgIDs[1] != ziface.RPCMsgID {
+		t.Fatalf("replied msgIDs = %v, want [500 %d]", conn.msgIDs, ziface.RPCMsgID)
+	}
+}
+
+func TestWorkerOverflowSpillOrder(t *testing.T) {
+	mh := newPoolMsgHandle(t, 1, 0, 2, zconf.WorkerOverflowSpill)
+	notify := &NotifyRouter{data: make(chan string, 1000)}
+	mh.Apis[1] = notify
+	mh.TaskQueue[0] = make(chan ziface.IRequest, 2)
+
+	// The queue and the spill lane fill up, then the reader is not blocked (队列和溢出队列都满后不阻塞读协程)
+	conn := &Connection{workerID: 0}
+	sent := make(chan struct{})
+	go func() {
+		for i := 0; i < 5; i++ {
+			mh.SendMsgToTaskQueue(NewRequest(conn, zpack.NewMsgPackage(1, []byte{byte(i)})))
+		}
+		close(sent)
+	}()
+	select {
+	case <-sent:
+	case <-time.After(time.Second):
+		t.Fatal("reader blocked by a full spill lane")
+	}
+	if stats := mh.GetWorkerStats(); stats.Spilled != 2 || stats.SpillLen != 2 || stats.Rejected != 1 {
+		t.Fatalf("stats = %+v", stats)
+	}
+
+	// The requests of a connection keep their order through the spill lane (同一连接的请求经过溢出队列后保持顺序)
+	zconf.GlobalObject.MaxWorkerTaskLen = 1000
+	go mh.StartOneWorker(0, mh.TaskQueue[0])
+	defer mh.StopOneWorker(0)
+	for i := 5; i < 200; i++ {
+		mh.SendMsgToTaskQueue(NewRequest(conn, zpack.NewMsgPackage(1, []byte{byte(i)})))
+	}
+	stats := waitWorkerStats(t, mh, func(stats ziface.WorkerStats) bool {
+		return atomic.LoadInt64(&mh.inFlight) == 0
+	})
+	if stats.Spilled <= 2 {
+		t.Fatalf("no request spilled while the worker ran: %+v", stats)
+	}
+	close(notify.data)
+	last := -1
+	for data := range notify.data {
+		if int(data[0]) <= last {
+			t.Fatalf("request %d handled after %d", data[0], last)
+		}
+		last = int(data[0])
+	}
+	if last != 199 {
+		t.Fatalf("last request handled = %d, want 199", last)
+	}
+}
+
+func TestWorkerOverflowFuncRequest(t *testing.T) {
+	mh := newPoolMsgHandle(t, 1, 0, 1, zconf.WorkerOverflowShed)
+	mh.TaskQueue[0] = make(chan ziface.IRequest, 1)
+
+	conn := &Connection{workerID: 0}
+	mh.SendMsgToTaskQueue(NewFuncRequest(conn, func() {}))
+	sent := make(chan struct{})
+	go func() {
+		mh.SendMsgToTaskQueue(NewFuncRequest(conn, func() {}))
+		close(sent)
+	}()
+
+	// Function requests wait for room instead of being shed (函数请求等待空位而不会被丢弃)
+	select {
+	case <-sent:
+		t.Fatal("func request was not queued behind the full queue")
+	case <-time.After(50 * time.Millisecond):
+	}
+	go mh.StartOneWorker(0, mh.TaskQueue[0])
+	defer mh.StopOneWorker(0)
+	select {
+	case <-sent:
+	case <-time.After(time.Second):
+		t.Fatal("func request not queued")
+	}
+	if stats := mh.GetWorkerStats(); stats.Shed != 0 {
+		t.Fatalf("shed = %d, want 0", stats.Shed)
+	}
+}
+
+func waitWorkerStats(t *testing.T, mh *MsgHandle, ok func(ziface.WorkerStats) bool) ziface.WorkerStats {
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		stats := mh.GetWorkerStats()
+		if ok(stats) {
+			return stats
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("stats = %+v", stats)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestWorkerScale(t *testing.T) {
+	mh := newPoolMsgHandle(t, 1, 3, 4, zconf.WorkerOverflowBlock)
+	mh.StartWorkerPool()
+	defer mh.stopScaling()
+
+	// Block worker 0 and fill its queue (阻塞0号worker并填满它的队列)
+	release := make(chan struct{})
+	busy := &Connection{workerID: mh.scale.assign(0)}
+	for i := 0; i < 5; i++ {
+		mh.SendMsgToTaskQueue(NewFuncRequest(busy, func() { <-release }))
+	}
+
+	stats := waitWorkerStats(t, mh, func(stats ziface.WorkerStats) bool {
+		return stats.Active == 3
+	})
+	for _, worker := range stats.Workers {
+		if !worker.Running {
+			t.Fatalf("worker %d not running: %+v", worker.WorkerID, stats)
+		}
+	}
+
+	// A connection of worker 0 with nothing queued moves to an added worker (0号worker上没有排队请求的连接移到新增的worker)
+	idle := &Connection{workerID: 0}
+	handled := make(chan struct{})
+	mh.SendMsgToTaskQueue(NewFuncRequest(idle, func() { close(handled) }))
+	select {
+	case <-handled:
+	case <-time.After(time.Second):
+		t.Fatal("request of an existing connection stuck behind the busy worker")
+	}
+
+	// The added workers retire although the connection moved there is still open (即使移过去的连接仍然存在, 新增的worker也会退出)
+	close(release)
+	stats = waitWorkerStats(t, mh, func(stats ziface.WorkerStats) bool {
+		return stats.Active == 1 && !stats.Workers[1].Running && !stats.Workers[2].Running
+	})
+	if stats.Workers[0].Handled != 5 || stats.Workers[1].Handled+stats.Workers[2].Handled != 1 {
+		t.Fatalf("stats = %+v", stats)
+	}
+
+	// Its next requests go back to an active worker, in order (其后续请求按顺序回到活跃的worker)
+	order := make(chan int, 100)
+	for i := 0; i < 100; i++ {
+		i := i
+		mh.SendMsgToTaskQueue(NewFuncRequest(idle, func() { order <- i }))
+	}
+	for i := 0; i < 100; i++ {
+		select {
+		case got := <-order:
+			if got != i {
+				t.Fatalf("request %d handled before %d", got, i)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("request of a connection on a retired worker not handled")
+		}
+	}
+	waitWorkerStats(t, mh, func(stats ziface.WorkerStats) bool {
+		return stats.Workers[0].Handled == 105
+	})
+}
@@ -2,18 +2,23 @@ package znet
 
 import (
 	"context"
+	"crypto/x509"
 	"encoding/hex"
 	"errors"
 	"net"
 	"net/http"
 	"strconv"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/aceld/zinx/zbuffer"
+	"github.com/aceld/zinx/zcodec"
 	"github.com/aceld/zinx/zconf"
 	"github.com/aceld/zinx/ziface"
 	"github.com/aceld/zinx/zinterceptor"
 	"github.com/aceld/zinx/zlog"
+	"github.com/aceld/zinx/zmetrics"
 	"github.com/aceld/zinx/zpack"
 	"github.com/gorilla/websocket"
 )
@@ -52,14 +57,22 @@ type WsConnection struct {
 	ctx    context.Context
 	cancel context.CancelFunc
 
-	// msgBuffChan is a buffered channel used for message communication between the read and write goroutines.
-	// (有缓冲管道，用于读、写两个goroutine之间的消息通信)
-	msgBuffChan chan []byte
+	// sendQueue holds the messages waiting for the writer goroutine, one queue per priority.
+	// (等待写协程发送的消息, 每个优先级一个队列)
+	sendQueue *sendQueue
+
+	// startWriterFlag marks that the writer goroutine has been started.
+	// (写协程已启动标志)
+	startWriterFlag int32
 
 	// msgLock is used for locking when users send and receive messages.
 	// (用户收发消息的Lock)
 	msgLock sync.Mutex
 
+	// pendingSend is the number of queued messages not yet written to the socket.
+	// (已入队但尚未写入socket的消息数)
+	pendingSend int64
+
 	// property is the connection attribute. (连接属性)
 	property map[string]interface{}
 
@@ -80,10 +93,18 @@ type WsConnection struct {
 	// (当前连接断开时的Hook函数)
 	onConnStop func(conn ziface.IConnection)
 
+	// onSlowConsumer is the Hook function when the send queue overflows, inherited from the Server.
+	// (发送队列溢出时的Hook函数, 继承自Server)
+	onSlowConsumer func(conn ziface.IConnection, event ziface.SlowConsumerEvent)
+
 	// packet is the data packet format.
 	// (数据报文封包方式)
 	packet ziface.IDataPack
 
+	// Codec of the typed messages
+	// (类型化消息的编解码器)
+	codec ziface.ICodec
+
 	// lastActivityTime is the last time the connection was active.
 	// (最后一次活动时间)
 	lastActivityTime time.Time
@@ -95,6 +116,10 @@ type WsConnection struct {
 	// hc is the Heartbeat Checker. (心跳检测器)
 	hc ziface.IHeartbeatChecker
 
+	// idle is the idle timeouts checked on the shared time wheel, nil when none is configured.
+	// (在共享时间轮上检查的空闲超时, 未配置时为nil)
+	idle *idleWatch
+
 	// name is the name of the connection and is the same as the Name of the Server/Client that created the connection.
 	// (连接名称，默认与创建连接的Server/Client的Name一致)
 	name string
@@ -110,6 +135,18 @@ type WsConnection struct {
 
 	// Close callback mutex
 	closeCallbackMutex sync.RWMutex
+
+	// RPC calls waiting for their reply
+	// (等待应答的RPC调用)
+	rpc rpcCalls
+
+	// Compression negotiated for the messages sent
+	// (发送消息时使用的协商好的压缩方式)
+	compression connCompression
+
+	// Session keys and sequence numbers of the encrypted messages
+	// (加密消息的会话密钥及序号)
+	encryption connEncryption
 }
 
 // newServerConn: for Server, a method to create a connection with Server characteristics
@@ -119,17 +156,18 @@ type WsConnection struct {
 func newWebsocketConn(server ziface.IServer, conn *websocket.Conn, connID uint64, r *http.Request) ziface.IConnection {
 	// Initialize Conn properties (初始化Conn属性)
 	c := &WsConnection{
-		ctx:         context.WithValue(context.Background(), WsConnectionHttpReqCtxKey{}, r.Context()), // websocketAuth可以在上下文中传递特殊的参数或信息;比如鉴权后，设置一些用户信息或房间id
-		conn:        conn,
-		connID:      connID,
-		connIdStr:   strconv.FormatUint(connID, 10),
-		isClosed:    false,
-		msgBuffChan: nil,
-		property:    nil,
-		name:        server.ServerName(),
-		localAddr:   conn.LocalAddr().String(),
-		remoteAddr:  conn.RemoteAddr().String(),
-	}
+		ctx:        context.WithValue(context.Background(), WsConnectionHttpReqCtxKey{}, r.Context()), // websocketAuth可以在上下文中传递特殊的参数或信息;比如鉴权后，设置一些用户信息或房间id
+		conn:       conn,
+		connID:     connID,
+		connIdStr:  strconv.FormatUint(connID, 10),
+		isClosed:   false,
+		sendQueue:  newSendQueue(zconf.SendQueueBlock),
+		property:   nil,
+		name:       server.ServerName(),
+		localAddr:  conn.LocalAddr().String(),
+		remoteAddr: conn.RemoteAddr().String(),
+	}
+	c.ctx, c.cancel = context.WithCancel(c.ctx)
 
 	lengthField := server.GetLengthField()
 	if lengthField != nil {
@@ -138,8 +176,11 @@ func newWebsocketConn(server ziface.IServer, conn *websocket.Conn, connID uint64
 
 	// Inherited attributes from server (从server继承过来的属性)
 	c.packet = server.GetPacket()
+	c.codec = server.GetCodec()
 	c.onConnStart = server.GetOnConnStart()
 	c.onConnStop = server.GetOnConnStop()
+	c.onSlowConsumer = server.GetOnSlowConsumer()
+	c.idle = newIdleWatch(c, server)
 	c.msgHandler = server.GetMsgHandler()
 
 	// Bind the current Connection to the Server's ConnManager (将当前的Connection与Server的ConnManager绑定)
@@ -155,16 +196,17 @@ func newWebsocketConn(server ziface.IServer, conn *websocket.Conn, connID uint64
 // (newClientConn :for Client, 创建一个Client服务端特性的连接的方法)
 func newWsClientConn(client ziface.IClient, conn *websocket.Conn) ziface.IConnection {
 	c := &WsConnection{
-		conn:        conn,
-		connID:      0,  // client ignore
-		connIdStr:   "", // client ignore
-		isClosed:    false,
-		msgBuffChan: nil,
-		property:    nil,
-		name:        client.GetName(),
-		localAddr:   conn.LocalAddr().String(),
-		remoteAddr:  conn.RemoteAddr().String(),
-	}
+		conn:       conn,
+		connID:     0,  // client ignore
+		connIdStr:  "", // client ignore
+		isClosed:   false,
+		sendQueue:  newSendQueue(zconf.SendQueueBlock),
+		property:   nil,
+		name:       client.GetName(),
+		localAddr:  conn.LocalAddr().String(),
+		remoteAddr: conn.RemoteAddr().String(),
+	}
+	c.ctx, c.cancel = context.WithCancel(context.Background())
 
 	lengthField := client.GetLengthField()
 	if lengthField != nil {
@@ -173,6 +215,7 @@ func newWsClientConn(client ziface.IClient, conn *websocket.Conn) ziface.IConnec
 
 	// Inherit properties from client (从client继承过来的属性)
 	c.packet = client.GetPacket()
+	c.codec = client.GetCodec()
 	c.onConnStart = client.GetOnConnStart()
 	c.onConnStop = client.GetOnConnStop()
 	c.msgHandler = client.GetMsgHandler()
@@ -188,16 +231,18 @@ func (c *WsConnection) StartWriter() {
 
 	for {
 		select {
-		case data, ok := <-c.msgBuffChan:
-			if ok {
-				if err := c.Send(data); err != nil {
-					zlog.Ins().ErrorF("Send Buff Data error:, %s Conn Writer exit", err)
+		case <-c.sendQueue.notify:
+			for {
+				item, ok := c.sendQueue.pop()
+				if !ok {
 					break
 				}
-
-			} else {
-				zlog.Ins().ErrorF("msgBuffChan is Closed")
-				break
+				err := c.sendItem(item)
+				atomic.AddInt64(&c.pendingSend, -1)
+				if err != nil {
+					zlog.Ins().ErrorF("Send Buff Data error:, %s Conn Writer exit", err)
+					return
+				}
 			}
 		case <-c.ctx.Done():
 			return
@@ -228,6 +273,7 @@ func (c *WsConnection) StartReader() {
 			}
 			if messageType == websocket.PingMessage {
 				c.updateActivity()
+				c.idle.read()
 				continue
 			}
 			n := len(buffer)
@@ -236,12 +282,16 @@ func (c *WsConnection) StartReader() {
 				return
 			}
 			zlog.Ins().DebugF("read buffer %s \n", hex.EncodeToString(buffer[0:n]))
+			if zmetrics.Enabled() {
+				zmetrics.Metrics().ReceivedBytes(c.name, n)
+			}
 
 			// Update the Active status of heartbeat detection normally after reading data from the peer.
 			// (正常读取到对端数据，更新心跳检测Active状态)
 			if n > 0 && c.hc != nil {
 				c.updateActivity()
 			}
+			c.idle.read()
 
 			// Handle custom protocol fragmentation and packet sticking issues add by uuxia 2023-03-21
 			// (处理自定义协议断粘包问题)
@@ -257,7 +307,7 @@ func (c *WsConnection) StartReader() {
 					msg := zpack.NewMessage(uint32(len(bytes)), bytes)
 					// Get the Request data requested by the current client.
 					// (得到当前客户端请求的Request数据)
-					req := GetRequest(c, msg)
+					req := getRequestWithBuffer(c, msg, bytes)
 					c.msgHandler.Execute(req)
 				}
 			} else {
@@ -274,11 +324,6 @@ func (c *WsConnection) StartReader() {
 // Start starts the connection and makes it work.
 // (Start 启动连接，让当前连接开始工作)
 func (c *WsConnection) Start() {
-	ctx := c.ctx
-	if ctx == nil {
-		ctx = context.Background()
-	}
-	c.ctx, c.cancel = context.WithCancel(ctx)
 	// Execute the hook method according to the business needs of creating the connection passed in by the user.
 	// (按照用户传递进来的创建连接时需要处理的业务，执行钩子方法)
 	c.callOnConnStart()
@@ -289,6 +334,7 @@ func (c *WsConnection) Start() {
 		c.hc.Start()
 		c.updateActivity()
 	}
+	c.idle.start()
 
 	// 占用workerid
 	c.workerID = useWorker(c)
@@ -354,6 +400,12 @@ func (c *WsConnection) Send(data []byte) error {
 	}
 
 	err := c.conn.WriteMessage(websocket.BinaryMessage, data)
+	if err == nil && zmetrics.Enabled() {
+		zmetrics.Metrics().SentBytes(c.name, len(data))
+	}
+	if err == nil {
+		c.idle.wrote()
+	}
 	if err != nil {
 		zlog.Ins().ErrorF("SendMsg err data = %+v, err = %+v", data, err)
 		return err
@@ -363,49 +415,67 @@ func (c *WsConnection) Send(data []byte) error {
 }
 
 func (c *WsConnection) SendToQueue(data []byte, opts ...ziface.MsgSendOption) error {
-	c.msgLock.Lock()
-	defer c.msgLock.Unlock()
+	if c.isClosed == true {
+		return errors.New("WsConnection closed when send buff msg")
+	}
 
-	if c.msgBuffChan == nil {
-		c.msgBuffChan = make(chan []byte, zconf.GlobalObject.MaxMsgChanLen)
-		// Start a goroutine for writing data back to the client,
-		// which only reads data from MsgBuffChan and hasn't allocated memory or started the coroutine until SendBuffMsg is called
+	if data == nil {
+		zlog.Ins().ErrorF("Pack data is nil")
+		return errors.New("Pack data is nil")
+	}
+
+	return c.queue(sendItem{data: data}, opts...)
+}
+
+// queue puts data into the send queue of its priority, starting the writer goroutine on first use
+// (将数据放入对应优先级的发送队列, 首次使用时启动写协程)
+func (c *WsConnection) queue(item sendItem, opts ...ziface.MsgSendOption) error {
+	if atomic.LoadInt32(&c.startWriterFlag) == 0 && atomic.CompareAndSwapInt32(&c.startWriterFlag, 0, 1) {
+		// Start a Goroutine to write data back to the client
+		// This method only reads data from the send queue without allocating memory or starting a Goroutine
 		// (开启用于写回客户端数据流程的Goroutine
-		// 此方法只读取MsgBuffChan中的数据没调用SendBuffMsg可以分配内存和启用协程)
+		// 此方法只读取发送队列中的数据没调用SendBuffMsg可以分配内存和启用协程)
 		go c.StartWriter()
 	}
 
 	opt := ziface.MsgSendOptionObj{
-		Timeout: 5 * time.Millisecond,
+		Priority: ziface.MsgPriorityNormal,
 	}
 
 	for _, o := range opts {
 		o(&opt)
 	}
 
-	idleTimeout := time.NewTimer(opt.Timeout)
-	defer idleTimeout.Stop()
-
-	if c.isClosed == true {
-		return errors.New("WsConnection closed when send buff msg")
+	var done <-chan struct{}
+	if c.ctx != nil {
+		done = c.ctx.Done()
 	}
 
-	if data == nil {
-		zlog.Ins().ErrorF("Pack data is nil")
-		return errors.New("Pack data is nil ")
+	atomic.AddInt64(&c.pendingSend, 1)
+	dropped, event, err := c.sendQueue.push(item, opt, done)
+	if event != nil {
+		c.callOnSlowConsumer(*event)
 	}
-
-	select {
-	case <-idleTimeout.C:
-		return errors.New("send buff msg timeout")
-	case c.msgBuffChan <- data:
-		return nil
+	if err != nil || dropped != nil {
+		atomic.AddInt64(&c.pendingSend, -1)
+	}
+	if err == errSlowConsumer {
+		c.Stop()
 	}
+	return err
 }
 
 // SendMsg directly sends the Message data to the remote TCP client.
 // (直接将Message数据发送数据给远程的TCP客户端)
 func (c *WsConnection) SendMsg(msgID uint32, data []byte) error {
+	if held, err := c.encryption.hold(msgID, data, false, nil); held {
+		return err
+	}
+	return c.sendMsg(msgID, data)
+}
+
+// sendMsg packs and sends a message without holding it for the key exchange (封包并发送消息, 不会等待密钥交换)
+func (c *WsConnection) sendMsg(msgID uint32, data []byte) error {
 	c.msgLock.Lock()
 	defer c.msgLock.Unlock()
 	if c.isClosed == true {
@@ -414,7 +484,8 @@ func (c *WsConnection) SendMsg(msgID uint32, data []byte) error {
 
 	// Package data and send
 	// (将data封包，并且发送)
-	msg, err := c.packet.Pack(zpack.NewMsgPackage(msgID, data))
+	wireID, data := c.compression.compress(msgID, data)
+	msg, err := c.packet.Pack(zpack.NewMsgPackage(wireID, c.encryption.seal(wireID, data)))
 	if err != nil {
 		zlog.Ins().ErrorF("Pack error msg ID = %d", msgID)
 		return errors.New("Pack error msg ")
@@ -422,58 +493,63 @@ func (c *WsConnection) SendMsg(msgID uint32, data []byte) error {
 
 	// Write back to the client
 	err = c.conn.WriteMessage(websocket.BinaryMessage, msg)
+	if err == nil && zmetrics.Enabled() {
+		zmetrics.Metrics().SentBytes(c.name, len(msg))
+	}
+	if err == nil {
+		c.idle.wrote()
+	}
 	if err != nil {
 		zlog.Ins().ErrorF("SendMsg err msg ID = %d, data = %+v, err = %+v", msgID, string(msg), err)
-		return err
 	}
+	putPacked(c.packet, msg)
 
-	return nil
+	return err
 }
 
-// SendBuffMsg sends BuffMsg
-func (c *WsConnection) SendBuffMsg(msgID uint32, data []byte, opts ...ziface.MsgSendOption) error {
-	c.msgLock.Lock()
-	defer c.msgLock.Unlock()
-
-	if c.msgBuffChan == nil {
-		c.msgBuffChan = make(chan []byte, zconf.GlobalObject.MaxMsgChanLen)
-		// Start the Goroutine for writing back to the client data stream
-		// This method only reads data from MsgBuffChan, allocating memory and starting Goroutine without calling SendBuffMsg
-		// (开启用于写回客户端数据流程的Goroutine
-		// 此方法只读取MsgBuffChan中的数据没调用SendBuffMsg可以分配内存和启用协程)
-		go c.StartWriter()
+// sendItem writes a queued item, sealing and packing it first when it comes from an encrypted SendBuffMsg
+// (写出队列中的数据, 来自加密连接SendBuffMsg的数据先加密封包)
+func (c *WsConnection) sendItem(item sendItem) error {
+	if item.seal {
+		var err error
+		if item, err = c.encryption.packSealed(c.packet, item); err != nil {
+			zlog.Ins().ErrorF("Pack error msg ID = %d, drop it", item.msgID)
+			return nil
+		}
 	}
-
-	opt := ziface.MsgSendOptionObj{
-		Timeout: 5 * time.Millisecond,
+	err := c.Send(item.data)
+	if item.pooled {
+		zbuffer.Put(item.data)
 	}
+	return err
+}
 
-	for _, o := range opts {
-		o(&opt)
+func (c *WsConnection) SendBuffMsg(msgID uint32, data []byte, opts ...ziface.MsgSendOption) error {
+	if held, err := c.encryption.hold(msgID, data, true, opts); held {
+		return err
 	}
+	return c.sendBuffMsg(msgID, data, opts...)
+}
 
-	idleTimeout := time.NewTimer(opt.Timeout)
-	defer idleTimeout.Stop()
-
+// SendBuffMsg sends BuffMsg
+func (c *WsConnection) sendBuffMsg(msgID uint32, data []byte, opts ...ziface.MsgSendOption) error {
 	if c.isClosed == true {
 		return errors.New("WsConnection closed when send buff msg")
 	}
 
 	// Package data and send
 	// (将data封包，并且发送)
-	msg, err := c.packet.Pack(zpack.NewMsgPackage(msgID, data))
+	wireID, data := c.compression.compress(msgID, data)
+	if c.encryption.sealing(wireID, data) {
+		return c.queue(sendItem{data: append([]byte(nil), data...), msgID: wireID, key: msgID, keyed: true, seal: true}, opts...)
+	}
+	msg, err := c.packet.Pack(zpack.NewMsgPackage(wireID, data))
 	if err != nil {
 		zlog.Ins().ErrorF("Pack error msg ID = %d", msgID)
 		return errors.New("Pack error msg ")
 	}
 
-	// Send timeout
-	select {
-	case <-idleTimeout.C:
-		return errors.New("send buff msg timeout")
-	case c.msgBuffChan <- msg:
-		return nil
-	}
+	return c.queue(sendItem{data: msg, msgID: wireID, key: msgID, keyed: true}, opts...)
 }
 
 func (c *WsConnection) SetProperty(key string, value interface{}) {
@@ -529,6 +605,7 @@ func (c *WsConnection) finalizer() {
 	if c.hc != nil {
 		c.hc.Stop()
 	}
+	c.idle.stop()
 
 	// Close the socket connection.
 	// (关闭socket连接)
@@ -542,9 +619,7 @@ func (c *WsConnection) finalizer() {
 
 	// Close all channels associated with this connection.
 	// (关闭该连接全部管道)
-	if c.msgBuffChan != nil {
-		close(c.msgBuffChan)
-	}
+	c.sendQueue.close()
 
 	// Set the flag to indicate that the connection is closed. (设置标志位)
 	c.isClosed = true
@@ -576,6 +651,15 @@ func (c *WsConnection) callOnConnStop() {
 	}
 }
 
+// callOnSlowConsumer reports an overflowing send queue to the log and the Server hook
+// (将发送队列溢出记录日志并通知Server的Hook函数)
+func (c *WsConnection) callOnSlowConsumer(event ziface.SlowConsumerEvent) {
+	zlog.Ins().ErrorF("ZINX slow consumer ConnID = %d, priority = %d, queued = %d, policy = %s", c.connID, event.Priority, event.Queued, event.Policy)
+	if c.onSlowConsumer != nil {
+		c.onSlowConsumer(c, event)
+	}
+}
+
 func (c *WsConnection) IsAlive() bool {
 	if c.isClosed {
 		return false
@@ -586,6 +670,14 @@ func (c *WsConnection) IsAlive() bool {
 	return time.Now().Sub(c.lastActivityTime) < zconf.GlobalObject.HeartbeatMaxDuration()
 }
 
+// drainSendQueue blocks until every queued message has been written to the socket.
+// (等待入队的消息全部写入socket)
+func (c *WsConnection) drainSendQueue(ctx context.Context) error {
+	return waitUntil(ctx, func() bool {
+		return c.ctx == nil || c.ctx.Err() != nil || atomic.LoadInt64(&c.pendingSend) == 0
+	})
+}
+
 func (c *WsConnection) updateActivity() {
 	c.lastActivityTime = time.Now()
 }
@@ -633,3 +725,49 @@ func (s *WsConnection) InvokeCloseCallbacks() {
 	defer s.closeCallbackMutex.RUnlock()
 	s.closeCallback.Invoke()
 }
+
+// Call sends an RPC request and waits for the reply, see ziface.IConnection
+// (发送RPC请求并等待应答)
+func (c *WsConnection) Call(ctx context.Context, msgID uint32, data []byte) (ziface.IMessage, error) {
+	return c.rpc.call(ctx, c, msgID, data)
+}
+
+func (c *WsConnection) deliverReply(callID uint64, reply rpcReply) bool {
+	return c.rpc.deliver(callID, reply)
+}
+
+func (c *WsConnection) GetCodec() ziface.ICodec {
+	if c.codec == nil {
+		return zcodec.Default()
+	}
+	return c.codec
+}
+
+func (c *WsConnection) GetIdentity() interface{} {
+	identity, _ := c.GetProperty(ziface.PropertyIdentity)
+	return identity
+}
+
+func (c *WsConnection) GetCompressor() ziface.ICompressor {
+	return c.compression.compressor()
+}
+
+func (c *WsConnection) getEncryption() *connEncryption {
+	return &c.encryption
+}
+
+func (c *WsConnection) getCompression() *connCompression {
+	return &c.compression
+}
+
+func (c *WsConnection) GetPeerCertificates() []*x509.Certificate {
+	return peerCertificates(c.conn.UnderlyingConn())
+}
+
+func (c *WsConnection) SendTyped(msgID uint32, v interface{}) error {
+	data, err := c.GetCodec().Marshal(v)
+	if err != nil {
+		return err
+	}
+	return c.SendMsg(msgID, data)
+}
@@ -0,0 +1,39 @@
+package zpack
+
+import (
+	"encoding/binary"
+)
+
+// Compression extended header, carried at the front of the data of the frames with MsgID ziface.CompressMsgID,
+// messages sent uncompressed keep their MsgID and data untouched.
+// (压缩扩展报头, 放在MsgID为ziface.CompressMsgID的帧的数据最前面, 未压缩发送的消息的MsgID和数据保持不变)
+//
+// +--------------+---------------+-----------------------+
+// |  Algorithm   |     MsgID     |  Compressed Payload   |
+// | uint8(1byte) | uint32(4byte) |        n byte         |
+// +--------------+---------------+-----------------------+
+// Algorithm: ID of the compressor of the payload, see ziface.ICompressor (payload使用的压缩算法ID)
+// MsgID:     the MsgID of the message compressed, which the request is routed by (被压缩消息的MsgID, 请求按其路由)
+const (
+	CompressHeaderLen = 1 + 4
+)
+
+// PackCompressed prepends the compression extended header to the compressed payload of a message
+// (为消息压缩后的payload添加压缩扩展报头)
+func PackCompressed(algorithm uint8, msgID uint32, payload []byte) []byte {
+	data := make([]byte, CompressHeaderLen+len(payload))
+	data[0] = algorithm
+	binary.BigEndian.PutUint32(data[1:CompressHeaderLen], msgID)
+	copy(data[CompressHeaderLen:], payload)
+	return data
+}
+
+// UnpackCompressed splits the data of an ziface.CompressMsgID frame into the algorithm ID, the MsgID
+// compressed and the compressed payload, ok is false when the header is malformed
+// (拆分ziface.CompressMsgID帧数据中的压缩算法ID、被压缩的MsgID和压缩后的payload, 报头格式错误时ok为false)
+func UnpackCompressed(data []byte) (algorithm uint8, msgID uint32, payload []byte, ok bool) {
+	if len(data) < CompressHeaderLen || data[0] == 0 {
+		return 0, 0, data, false
+	}
+	return data[0], binary.BigEndian.Uint32(data[1:CompressHeaderLen]), data[CompressHeaderLen:], true
+}
@@ -1,10 +1,11 @@
 package zpack
 
 import (
-	"bytes"
 	"encoding/binary"
 	"errors"
+	"io"
 
+	"github.com/aceld/zinx/zbuffer"
 	"github.com/aceld/zinx/zconf"
 	"github.com/aceld/zinx/ziface"
 )
@@ -30,47 +31,30 @@ func (dp *DataPackLtv) GetHeadLen() uint32 {
 // Pack packs the message (compresses the data)
 // (封包方法,压缩数据)
 func (dp *DataPackLtv) Pack(msg ziface.IMessage) ([]byte, error) {
-	// Create a buffer to store the bytes
-	// (创建一个存放bytes字节的缓冲)
-	dataBuff := bytes.NewBuffer([]byte{})
+	// Take the buffer from zbuffer, it can be returned with zbuffer.Put once the data has been written
+	// (从zbuffer中获取缓冲, 数据写出后可通过zbuffer.Put归还)
+	data := msg.GetData()
+	dataBuff := zbuffer.Get(int(defaultHeaderLen) + len(data))
 
-	// Write the data length
-	if err := binary.Write(dataBuff, binary.LittleEndian, msg.GetDataLen()); err != nil {
-		return nil, err
-	}
-
-	// Write the message ID
-	if err := binary.Write(dataBuff, binary.LittleEndian, msg.GetMsgID()); err != nil {
-		return nil, err
-	}
-
-	// Write the data
-	if err := binary.Write(dataBuff, binary.LittleEndian, msg.GetData()); err != nil {
-		return nil, err
-	}
+	binary.LittleEndian.PutUint32(dataBuff[0:4], msg.GetDataLen())
+	binary.LittleEndian.PutUint32(dataBuff[4:8], msg.GetMsgID())
+	copy(dataBuff[defaultHeaderLen:], data)
 
-	return dataBuff.Bytes(), nil
+	return dataBuff, nil
 }
 
 // Unpack unpacks the message (decompresses the data)
 // (拆包方法,解压数据)
 func (dp *DataPackLtv) Unpack(binaryData []byte) (ziface.IMessage, error) {
-	// Create an ioReader for the input binary data
-	dataBuff := bytes.NewReader(binaryData)
+	if len(binaryData) < int(defaultHeaderLen) {
+		return nil, io.ErrUnexpectedEOF
+	}
 
 	// Only unpack the header information to obtain the data length and message ID
 	// (只解压head的信息，得到dataLen和msgID)
 	msg := &Message{}
-
-	// Read the data length
-	if err := binary.Read(dataBuff, binary.LittleEndian, &msg.DataLen); err != nil {
-		return nil, err
-	}
-
-	// Read the message ID
-	if err := binary.Read(dataBuff, binary.LittleEndian, &msg.ID); err != nil {
-		return nil, err
-	}
+	msg.DataLen = binary.LittleEndian.Uint32(binaryData[0:4])
+	msg.ID = binary.LittleEndian.Uint32(binaryData[4:8])
 
 	// Check whether the data length exceeds the maximum allowed packet size
 	// (判断dataLen的长度是否超出我们允许的最大包长度)
@@ -0,0 +1,40 @@
+package zpack
+
+import (
+	"encoding/binary"
+)
+
+// Encryption extended header, carried at the front of the message data of the connections that exchanged
+// their session keys, followed by the sealed data and its authentication tag.
+// (加密扩展报头, 放在已交换会话密钥的连接的消息数据最前面, 后面是加密后的数据及其认证标签)
+//
+// +---------------+---------------+-------------------------+
+// |    Magic      |      Seq      |  Sealed Payload + Tag   |
+// | uint32(4byte) | uint64(8byte) |         n byte          |
+// +---------------+---------------+-------------------------+
+// Magic: always 0x5A454E43 ("ZENC"), marks the data as encrypted (加密标记)
+// Seq:   sequence number of the sender starting from 1, used as the nonce and against replays (发送方从1开始的序号, 用作nonce及防重放)
+const (
+	EncryptMagic     uint32 = 0x5A454E43
+	EncryptHeaderLen        = 4 + 8
+)
+
+// PackEncrypted prepends the encryption extended header to the sealed payload
+// (为加密后的payload添加加密扩展报头)
+func PackEncrypted(seq uint64, payload []byte) []byte {
+	data := make([]byte, EncryptHeaderLen+len(payload))
+	binary.BigEndian.PutUint32(data[0:4], EncryptMagic)
+	binary.BigEndian.PutUint64(data[4:EncryptHeaderLen], seq)
+	copy(data[EncryptHeaderLen:], payload)
+	return data
+}
+
+// UnpackEncrypted splits data into the sequence number and the sealed payload,
+// ok is false when data is not encrypted
+// (拆分序号和加密后的payload, data未加密时ok为false)
+func UnpackEncrypted(data []byte) (seq uint64, payload []byte, ok bool) {
+	if len(data) < EncryptHeaderLen || binary.BigEndian.Uint32(data[0:4]) != EncryptMagic {
+		return 0, data, false
+	}
+	return binary.BigEndian.Uint64(data[4:EncryptHeaderLen]), data[EncryptHeaderLen:], true
+}
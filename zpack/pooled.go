@@ -0,0 +1,29 @@
+package zpack
+
+import "github.com/aceld/zinx/ziface"
+
+// PooledPack is implemented by the IDataPack whose Pack takes every buffer it returns from zbuffer,
+// so the connection may return the buffer with zbuffer.Put once it has been written. The output of
+// any other IDataPack is left alone. A type embedding DataPack that overrides Pack must also
+// override PackPooled unless its Pack still returns buffers of zbuffer.
+// (Pack返回的缓冲全部取自zbuffer的IDataPack实现该接口, 连接写出数据后才会通过zbuffer.Put归还缓冲;
+// 其他IDataPack的输出不会被归还. 嵌入DataPack并重写Pack的类型, 除非其Pack仍返回zbuffer的缓冲, 否则也需重写PackPooled)
+type PooledPack interface {
+	PackPooled() bool
+}
+
+// IsPooled tells whether the buffers packed by dp belong to zbuffer (判断dp封包的缓冲是否属于zbuffer)
+func IsPooled(dp ziface.IDataPack) bool {
+	p, ok := dp.(PooledPack)
+	return ok && p.PackPooled()
+}
+
+// PackPooled reports that Pack takes its buffers from zbuffer (Pack的缓冲取自zbuffer)
+func (dp *DataPack) PackPooled() bool {
+	return true
+}
+
+// PackPooled reports that Pack takes its buffers from zbuffer (Pack的缓冲取自zbuffer)
+func (dp *DataPackLtv) PackPooled() bool {
+	return true
+}
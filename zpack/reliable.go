@@ -0,0 +1,45 @@
+package zpack
+
+import (
+	"encoding/binary"
+)
+
+// Reliable extended header, carried at the front of the data of the frames with MsgID ziface.ReliableMsgID,
+// which are the messages sent through a reliable session. The receiver delivers them in the order of their
+// sequence numbers and acknowledges them.
+// (可靠传输扩展报头, 放在MsgID为ziface.ReliableMsgID的帧的数据最前面, 即通过可靠会话发送的消息;
+// 接收方按序号顺序投递消息并确认)
+//
+// +---------------+---------------+-----------------+
+// |      Seq      |     MsgID     |     Payload     |
+// | uint64(8byte) | uint32(4byte) |     n byte      |
+// +---------------+---------------+-----------------+
+// Seq:   sequence number in the session starting from 1 (会话内从1开始的序号)
+// MsgID: the MsgID sent through the session, which the request is routed by (通过会话发送的MsgID, 请求按其路由)
+const (
+	ReliableHeaderLen = 8 + 4
+)
+
+// PackReliable prepends the reliable extended header to payload
+// (为payload添加可靠传输扩展报头)
+func PackReliable(seq uint64, msgID uint32, payload []byte) []byte {
+	data := make([]byte, ReliableHeaderLen+len(payload))
+	binary.BigEndian.PutUint64(data[0:8], seq)
+	binary.BigEndian.PutUint32(data[8:ReliableHeaderLen], msgID)
+	copy(data[ReliableHeaderLen:], payload)
+	return data
+}
+
+// UnpackReliable splits the data of an ziface.ReliableMsgID frame into the sequence number, the MsgID
+// sent through the session and the payload, ok is false when the header is malformed
+// (拆分ziface.ReliableMsgID帧数据中的序号、通过会话发送的MsgID和payload, 报头格式错误时ok为false)
+func UnpackReliable(data []byte) (seq uint64, msgID uint32, payload []byte, ok bool) {
+	if len(data) < ReliableHeaderLen {
+		return 0, 0, data, false
+	}
+	seq = binary.BigEndian.Uint64(data[0:8])
+	if seq == 0 {
+		return 0, 0, data, false
+	}
+	return seq, binary.BigEndian.Uint32(data[8:ReliableHeaderLen]), data[ReliableHeaderLen:], true
+}
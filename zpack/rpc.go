@@ -0,0 +1,71 @@
+package zpack
+
+import (
+	"encoding/binary"
+)
+
+// RPC extended header, carried at the front of the data of the frames with MsgID ziface.RPCMsgID,
+// so that it works with every IDataPack.
+// (RPC扩展报头, 放在MsgID为ziface.RPCMsgID的帧的数据最前面, 因此可以和任意IDataPack配合使用)
+//
+// +-------------+----------------+---------------+-----------+
+// |    Flag     |     CallID     |     MsgID     |  Payload  |
+// | uint8(1byte)| uint64(8byte)  | uint32(4byte) |  n byte   |
+// +-------------+----------------+---------------+-----------+
+// Flag:   RPCFlagRequest, RPCFlagReply or RPCFlagError (请求、应答或错误应答)
+// CallID: correlation ID chosen by the caller and echoed back by the reply (调用方生成的关联ID, 应答原样带回)
+// MsgID:  the MsgID called, which the request is routed by (被调用的MsgID, 请求按其路由)
+const (
+	RPCHeaderLen = 1 + 8 + 4
+
+	RPCFlagRequest uint8 = 1 // The frame is a call waiting for a reply (请求帧)
+	RPCFlagReply   uint8 = 2 // The frame is the reply of a call (应答帧)
+	RPCFlagError   uint8 = 3 // The frame is a failed reply, the payload is the error text (错误应答帧, payload为错误信息)
+)
+
+// RPCHeader is the decoded RPC extended header
+// (解析后的RPC扩展报头)
+type RPCHeader struct {
+	Flag   uint8
+	CallID uint64
+	MsgID  uint32
+}
+
+// PackRPC prepends the RPC extended header to payload
+// (为payload添加RPC扩展报头)
+func PackRPC(flag uint8, callID uint64, msgID uint32, payload []byte) []byte {
+	data := make([]byte, RPCHeaderLen+len(payload))
+	data[0] = flag
+	binary.BigEndian.PutUint64(data[1:9], callID)
+	binary.BigEndian.PutUint32(data[9:RPCHeaderLen], msgID)
+	copy(data[RPCHeaderLen:], payload)
+	return data
+}
+
+// UnpackRPC splits the data of an ziface.RPCMsgID frame into the RPC extended header and the payload,
+// ok is false when the header is malformed
+// (拆分ziface.RPCMsgID帧数据中的RPC扩展报头和payload, 报头格式错误时ok为false)
+func UnpackRPC(data []byte) (header RPCHeader, payload []byte, ok bool) {
+	if len(data) < RPCHeaderLen {
+		return header, data, false
+	}
+
+	header.Flag = data[0]
+	if header.Flag != RPCFlagRequest && header.Flag != RPCFlagReply && header.Flag != RPCFlagError {
+		return header, data, false
+	}
+	header.CallID = binary.BigEndian.Uint64(data[1:9])
+	header.MsgID = binary.BigEndian.Uint32(data[9:RPCHeaderLen])
+
+	return header, data[RPCHeaderLen:], true
+}
+
+// RPCCalledMsgID returns the MsgID called by the data of an ziface.RPCMsgID frame, 0 when it is malformed
+// (返回ziface.RPCMsgID帧数据中被调用的MsgID, 格式错误时返回0)
+func RPCCalledMsgID(data []byte) uint32 {
+	header, _, ok := UnpackRPC(data)
+	if !ok {
+		return 0
+	}
+	return header.MsgID
+}
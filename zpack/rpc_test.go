@@ -0,0 +1,35 @@
+package zpack
+
+import (
+	"bytes"
+	"testing"
+)
+
+// run in terminal:
+// go test -v ./zpack -run=TestRPC
+
+func TestRPCHeader(t *testing.T) {
+	data := PackRPC(RPCFlagReply, 42, 7, []byte("hello"))
+
+	header, payload, ok := UnpackRPC(data)
+	if !ok {
+		t.Fatal("UnpackRPC failed")
+	}
+	if header.Flag != RPCFlagReply || header.CallID != 42 || header.MsgID != 7 {
+		t.Fatalf("header = %+v, want flag %d callID 42 msgID 7", header, RPCFlagReply)
+	}
+	if !bytes.Equal(payload, []byte("hello")) {
+		t.Fatalf("payload = %q, want %q", payload, "hello")
+	}
+	if RPCCalledMsgID(data) != 7 {
+		t.Fatalf("RPCCalledMsgID = %d, want 7", RPCCalledMsgID(data))
+	}
+
+	// Malformed headers are rejected (格式错误的报头被拒绝)
+	if _, _, ok := UnpackRPC([]byte("short")); ok {
+		t.Fatal("short data taken for an RPC header")
+	}
+	if _, _, ok := UnpackRPC(PackRPC(9, 1, 1, nil)); ok {
+		t.Fatal("unknown flag accepted")
+	}
+}
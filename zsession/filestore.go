@@ -0,0 +1,125 @@
+package zsession
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/aceld/zinx/ziface"
+	"github.com/aceld/zinx/zlog"
+)
+
+const fileExt = ".json"
+
+// fileStore keeps each session in a JSON file of dir named after the hex of the user ID,
+// so the sessions survive a restart of the server
+// (每个会话保存为dir下以用户ID的十六进制命名的JSON文件, 服务重启后会话仍然存在)
+type fileStore struct {
+	dir  string
+	lock sync.Mutex
+}
+
+// NewFileStore returns a session store keeping the sessions in files of dir, which is created if missing
+// (返回将会话保存在dir目录文件中的会话存储, 目录不存在时创建)
+func NewFileStore(dir string) (ziface.ISessionStore, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, err
+	}
+	return &fileStore{dir: dir}, nil
+}
+
+func (s *fileStore) path(userID string) string {
+	return filepath.Join(s.dir, hex.EncodeToString([]byte(userID))+fileExt)
+}
+
+func (s *fileStore) Load(userID string) (*ziface.SessionData, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return readData(s.path(userID))
+}
+
+// Save writes a temporary file and renames it, a crash never leaves a session half written
+// (先写临时文件再重命名, 崩溃时不会留下写了一半的会话)
+func (s *fileStore) Save(data *ziface.SessionData) error {
+	buf, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	tmp, err := os.CreateTemp(s.dir, "session-*.tmp")
+	if err != nil {
+		return err
+	}
+	if _, err = tmp.Write(buf); err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), s.path(data.UserID))
+	}
+	if err != nil {
+		_ = os.Remove(tmp.Name())
+	}
+	return err
+}
+
+func (s *fileStore) Delete(userID string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if err := os.Remove(s.path(userID)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+func (s *fileStore) List() ([]*ziface.SessionData, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return nil, err
+	}
+	var list []*ziface.SessionData
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), fileExt) {
+			continue
+		}
+		data, err := readData(filepath.Join(s.dir, entry.Name()))
+		if err != nil {
+			// A damaged file does not keep the other sessions from loading (损坏的文件不影响其他会话的加载)
+			zlog.Ins().ErrorF("load session file %s err: %v", entry.Name(), err)
+			continue
+		}
+		if data != nil {
+			list = append(list, data)
+		}
+	}
+	return list, nil
+}
+
+func readData(path string) (*ziface.SessionData, error) {
+	buf, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	data := &ziface.SessionData{}
+	if err := json.Unmarshal(buf, data); err != nil {
+		return nil, err
+	}
+	if data.Values == nil {
+		data.Values = make(map[string]string)
+	}
+	return data, nil
+}
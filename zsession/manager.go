@@ -0,0 +1,403 @@
+package zsession
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/aceld/zinx/ziface"
+	"github.com/aceld/zinx/zlog"
+	"github.com/aceld/zinx/ztimer"
+)
+
+// DefaultTTL is how long a session is kept after its user went offline, by default
+// (用户离线后会话默认保留的时长)
+const DefaultTTL = 30 * time.Minute
+
+// ErrSessionNotFound is returned for a user without a session (用户没有会话时返回的错误)
+var ErrSessionNotFound = errors.New("session not found")
+
+// Option sets up a session manager (会话管理器的设置)
+type Option func(m *manager)
+
+// WithTTL sets how long a session is kept after its user went offline, DefaultTTL by default
+// (设置用户离线后会话保留的时长, 默认为DefaultTTL)
+func WithTTL(ttl time.Duration) Option {
+	return func(m *manager) {
+		if ttl > 0 {
+			m.ttl = ttl
+		}
+	}
+}
+
+// WithScheduler runs the expiry timers on ts instead of a scheduler of the manager's own,
+// ts must execute its timers, see ztimer.NewAutoExecTimerScheduler
+// (在ts上运行过期定时器, 代替管理器自己的调度器; ts需要自动执行定时器, 参见ztimer.NewAutoExecTimerScheduler)
+func WithScheduler(ts *ztimer.TimerScheduler) Option {
+	return func(m *manager) {
+		m.scheduler = ts
+	}
+}
+
+// WithUserID sets how the user ID is taken from the identity accepted by the AuthHandler, fmt.Sprint by default
+// (设置如何从AuthHandler认证得到的身份中获取用户ID, 默认为fmt.Sprint)
+func WithUserID(fn func(identity interface{}) string) Option {
+	return func(m *manager) {
+		m.userID = fn
+	}
+}
+
+// WithNotify keeps n pointing at the current connection of every online user, the user IDs must be numbers
+// (使n始终指向每个在线用户当前的连接, 用户ID必须为数字)
+func WithNotify(n ziface.Inotify) Option {
+	return func(m *manager) {
+		m.notify = n
+	}
+}
+
+// manager keeps the sessions of the users by user ID, and the connections they are bound to
+// (按用户ID保存用户的会话及其绑定的连接)
+type manager struct {
+	store     ziface.ISessionStore
+	ttl       time.Duration
+	scheduler *ztimer.TimerScheduler
+	userID    func(identity interface{}) string
+	notify    ziface.Inotify
+
+	// Locked after session.lock when both are needed (同时需要时在session.lock之后加锁)
+	lock     sync.Mutex
+	sessions map[string]*session
+	conns    map[ziface.IConnection]*session
+}
+
+// NewManager returns a session manager keeping the sessions in store, the sessions already in store
+// are restored and expire a TTL after the restart at the earliest
+// (返回将会话保存在store中的会话管理器, store中已有的会话会被恢复, 最早在重启TTL时长后过期)
+func NewManager(store ziface.ISessionStore, opts ...Option) (ziface.ISessionManager, error) {
+	m := &manager{
+		store:    store,
+		ttl:      DefaultTTL,
+		userID:   func(identity interface{}) string { return fmt.Sprint(identity) },
+		sessions: make(map[string]*session),
+		conns:    make(map[ziface.IConnection]*session),
+	}
+	for _, opt := range opts {
+		opt(m)
+	}
+	if m.scheduler == nil {
+		m.scheduler = ztimer.NewAutoExecTimerScheduler()
+	}
+
+	list, err := store.List()
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	for _, data := range list {
+		if !data.ExpiresAt.IsZero() && !data.ExpiresAt.After(now) {
+			if err := store.Delete(data.UserID); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		m.restore(data)
+	}
+	return m, nil
+}
+
+// restore caches a session loaded from the store, the users are offline after a restart,
+// so the sessions online when the server stopped start their TTL now
+// (缓存从存储加载的会话; 重启后用户均为离线, 服务停止时在线的会话从现在开始计算TTL)
+func (m *manager) restore(data *ziface.SessionData) *session {
+	if data.Values == nil {
+		data.Values = make(map[string]string)
+	}
+	s := &session{manager: m, data: data}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	m.lock.Lock()
+	if cached := m.sessions[data.UserID]; cached != nil {
+		m.lock.Unlock()
+		return cached
+	}
+	m.sessions[data.UserID] = s
+	m.lock.Unlock()
+
+	if data.ExpiresAt.IsZero() {
+		data.ExpiresAt = time.Now().Add(m.ttl)
+		if err := m.store.Save(data); err != nil {
+			zlog.Ins().ErrorF("save session of user %s err: %v", data.UserID, err)
+		}
+	}
+	m.armExpiry(s)
+	return s
+}
+
+// armExpiry sets the expiry timer, s.lock must be held (设置过期定时器, 调用时需持有s.lock)
+func (m *manager) armExpiry(s *session) {
+	s.cancelExpiry()
+	df := ztimer.NewDelayFunc(func(v ...interface{}) {
+		m.expire(s)
+	}, nil)
+	tID, err := m.scheduler.CreateTimerAt(df, s.data.ExpiresAt.UnixNano())
+	if err != nil {
+		zlog.Ins().ErrorF("session of user %s expiry timer err: %v", s.data.UserID, err)
+		return
+	}
+	s.timerID = tID
+}
+
+// expire drops a session still offline at its expiry time, a timer firing early is set again
+// (会话在过期时间仍离线时将其删除, 提前触发的定时器会重新设置)
+func (m *manager) expire(s *session) {
+	s.lock.Lock()
+	if s.removed || s.conn != nil || s.data.ExpiresAt.IsZero() {
+		s.lock.Unlock()
+		return
+	}
+	if time.Now().Before(s.data.ExpiresAt) {
+		m.armExpiry(s)
+		s.lock.Unlock()
+		return
+	}
+	s.timerID = 0
+	s.removed = true
+	s.lock.Unlock()
+
+	m.drop(s)
+	zlog.Ins().DebugF("session of user %s expired", s.data.UserID)
+}
+
+// drop removes a session marked removed from the manager and the store
+// (从管理器和存储中删除已标记为删除的会话)
+func (m *manager) drop(s *session) {
+	m.lock.Lock()
+	if m.sessions[s.data.UserID] == s {
+		delete(m.sessions, s.data.UserID)
+	}
+	m.lock.Unlock()
+
+	if err := m.store.Delete(s.data.UserID); err != nil {
+		zlog.Ins().ErrorF("delete session of user %s err: %v", s.data.UserID, err)
+	}
+}
+
+// load returns the session of the user, from the store when another process created it
+// (返回用户的会话, 会话由其他进程创建时从存储中加载)
+func (m *manager) load(userID string) (*session, error) {
+	m.lock.Lock()
+	s := m.sessions[userID]
+	m.lock.Unlock()
+	if s != nil {
+		return s, nil
+	}
+
+	data, err := m.store.Load(userID)
+	if err != nil {
+		return nil, err
+	}
+	if data == nil || (!data.ExpiresAt.IsZero() && !data.ExpiresAt.After(time.Now())) {
+		return nil, ErrSessionNotFound
+	}
+	return m.restore(data), nil
+}
+
+// OnAuthAccept binds the session of the user to conn, a new session is created when the user has none.
+// The previous connection of the user is left open and no longer bound.
+// (将用户的会话绑定到conn, 用户没有会话时创建新会话; 用户之前的连接保持打开, 但不再绑定)
+func (m *manager) OnAuthAccept(conn ziface.IConnection, identity interface{}) {
+	userID := m.userID(identity)
+	if userID == "" {
+		zlog.Ins().ErrorF("ConnID = %d authenticated without a user ID, no session", conn.GetConnID())
+		return
+	}
+
+	for {
+		s, err := m.load(userID)
+		if err == ErrSessionNotFound {
+			s, err = m.create(userID)
+		}
+		if err != nil {
+			zlog.Ins().ErrorF("session of user %s err: %v", userID, err)
+			return
+		}
+		if m.bind(s, conn) {
+			return
+		}
+		// The session expired meanwhile (会话在此期间已过期)
+	}
+}
+
+func (m *manager) create(userID string) (*session, error) {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return nil, err
+	}
+	s := &session{manager: m, data: &ziface.SessionData{
+		ID:        hex.EncodeToString(id),
+		UserID:    userID,
+		Values:    make(map[string]string),
+		CreatedAt: time.Now(),
+	}}
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if cached := m.sessions[userID]; cached != nil {
+		return cached, nil
+	}
+	m.sessions[userID] = s
+	return s, nil
+}
+
+// bind binds s to conn, false when s was removed meanwhile (将s绑定到conn, s在此期间已被删除时返回false)
+func (m *manager) bind(s *session, conn ziface.IConnection) bool {
+	s.lock.Lock()
+	if s.removed {
+		s.lock.Unlock()
+		return false
+	}
+	old := s.conn
+	s.conn = conn
+	s.cancelExpiry()
+	s.data.ExpiresAt = time.Time{}
+	if err := m.store.Save(s.data); err != nil {
+		zlog.Ins().ErrorF("save session of user %s err: %v", s.data.UserID, err)
+	}
+
+	m.lock.Lock()
+	if old != nil {
+		delete(m.conns, old)
+	}
+	m.conns[conn] = s
+	m.lock.Unlock()
+	m.setNotify(s.data.UserID, conn)
+	s.lock.Unlock()
+
+	// The close callbacks of a connection run under a lock of the connection, so s.lock is not held here
+	// (连接的关闭回调在连接自身的锁内执行, 因此这里不持有s.lock)
+	if old != nil && old != conn {
+		old.RemoveCloseCallback(m, s)
+	}
+	conn.AddCloseCallback(m, s, func() {
+		m.offline(s, conn)
+	})
+	if conn.Context().Err() != nil {
+		// Closed before the callback was added (添加回调之前已关闭)
+		m.offline(s, conn)
+	}
+
+	zlog.Ins().DebugF("session of user %s bound to ConnID = %d", s.data.UserID, conn.GetConnID())
+	return true
+}
+
+// offline starts the TTL of the session when its connection closes (会话的连接关闭时开始计算TTL)
+func (m *manager) offline(s *session, conn ziface.IConnection) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.conn != conn {
+		return
+	}
+	s.conn = nil
+
+	m.lock.Lock()
+	delete(m.conns, conn)
+	m.lock.Unlock()
+	m.setNotify(s.data.UserID, nil)
+
+	if s.removed {
+		return
+	}
+	s.data.ExpiresAt = time.Now().Add(m.ttl)
+	if err := m.store.Save(s.data); err != nil {
+		zlog.Ins().ErrorF("save session of user %s err: %v", s.data.UserID, err)
+	}
+	m.armExpiry(s)
+}
+
+// setNotify points the notify at the connection of the user, nil removes the user
+// (将notify指向用户的连接, conn为nil时删除该用户)
+func (m *manager) setNotify(userID string, conn ziface.IConnection) {
+	if m.notify == nil {
+		return
+	}
+	id, err := strconv.ParseUint(userID, 10, 64)
+	if err != nil {
+		zlog.Ins().ErrorF("user %s is not a number, not added to the notify", userID)
+		return
+	}
+	if conn == nil {
+		m.notify.DelNotifyByID(id)
+	} else {
+		m.notify.SetNotifyID(id, conn)
+	}
+}
+
+func (m *manager) Get(userID string) (ziface.ISession, error) {
+	s, err := m.load(userID)
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (m *manager) GetByConn(conn ziface.IConnection) ziface.ISession {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if s, ok := m.conns[conn]; ok {
+		return s
+	}
+	return nil
+}
+
+func (m *manager) GetConnection(userID string) (ziface.IConnection, error) {
+	s, err := m.load(userID)
+	if err != nil {
+		return nil, err
+	}
+	conn := s.GetConnection()
+	if conn == nil {
+		return nil, fmt.Errorf("user %s is offline", userID)
+	}
+	return conn, nil
+}
+
+func (m *manager) Remove(userID string) error {
+	s, err := m.load(userID)
+	if err != nil {
+		return err
+	}
+
+	s.lock.Lock()
+	if s.removed {
+		s.lock.Unlock()
+		return ErrSessionNotFound
+	}
+	s.removed = true
+	s.cancelExpiry()
+	conn := s.conn
+	s.conn = nil
+	if conn != nil {
+		m.lock.Lock()
+		delete(m.conns, conn)
+		m.lock.Unlock()
+		m.setNotify(s.data.UserID, nil)
+	}
+	s.lock.Unlock()
+
+	if conn != nil {
+		conn.RemoveCloseCallback(m, s)
+	}
+	m.drop(s)
+	return nil
+}
+
+func (m *manager) OnlineCount() int {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	return len(m.conns)
+}
@@ -0,0 +1,150 @@
+package zsession
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aceld/zinx/ziface"
+	"github.com/aceld/zinx/znotify"
+)
+
+// run in terminal:
+// go test -v ./zsession -run=TestManager
+
+// testConn implements what the manager uses of a connection, close runs its close callbacks
+type testConn struct {
+	ziface.IConnection
+	id     uint64
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	lock      sync.Mutex
+	callbacks map[interface{}]func()
+}
+
+func newTestConn(id uint64) *testConn {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &testConn{id: id, ctx: ctx, cancel: cancel, callbacks: make(map[interface{}]func())}
+}
+
+func (c *testConn) GetConnID() uint64            { return c.id }
+func (c *testConn) Context() context.Context     { return c.ctx }
+func (c *testConn) SendMsg(uint32, []byte) error { return nil }
+
+func (c *testConn) AddCloseCallback(handler, key interface{}, callback func()) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.callbacks[[2]interface{}{handler, key}] = callback
+}
+
+func (c *testConn) RemoveCloseCallback(handler, key interface{}) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	delete(c.callbacks, [2]interface{}{handler, key})
+}
+
+func (c *testConn) close() {
+	c.cancel()
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	for _, callback := range c.callbacks {
+		callback()
+	}
+}
+
+func TestManagerReconnect(t *testing.T) {
+	notify := znotify.NewZNotify()
+	m, err := NewManager(NewMemoryStore(), WithTTL(time.Second), WithNotify(notify))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	conn1 := newTestConn(1)
+	m.OnAuthAccept(conn1, 1001)
+	s, err := m.Get("1001")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.GetByConn(conn1) != s || !s.ExpiresAt().IsZero() || m.OnlineCount() != 1 {
+		t.Fatal("session not bound to the connection")
+	}
+	if err := s.Set("room", "7"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Offline, the session waits a TTL for the user (离线后会话等待用户TTL时长)
+	conn1.close()
+	if s.GetConnection() != nil || s.ExpiresAt().IsZero() || m.OnlineCount() != 0 || notify.HasIdConn(1001) {
+		t.Fatal("session still online")
+	}
+	if _, err := m.GetConnection("1001"); err == nil {
+		t.Fatal("offline user has a connection")
+	}
+
+	// Back before the TTL, the same session with its values (在TTL之前重连, 得到原会话及其数据)
+	conn2 := newTestConn(2)
+	m.OnAuthAccept(conn2, 1001)
+	again, _ := m.Get("1001")
+	if again.ID() != s.ID() || !again.ExpiresAt().IsZero() {
+		t.Fatal("reconnected to another session")
+	}
+	if room, _ := again.Get("room"); room != "7" {
+		t.Fatalf("room = %q after reconnect", room)
+	}
+	if conn, err := m.GetConnection("1001"); err != nil || conn != conn2 {
+		t.Fatalf("GetConnection = %v, %v", conn, err)
+	}
+	if conn, _ := notify.GetNotifyByID(1001); conn != conn2 {
+		t.Fatal("notify not updated")
+	}
+
+	// Offline longer than the TTL (离线超过TTL)
+	conn2.close()
+	time.Sleep(1500 * time.Millisecond)
+	if _, err := m.Get("1001"); err != ErrSessionNotFound {
+		t.Fatalf("Get after the TTL: %v", err)
+	}
+	if err := s.Set("room", "8"); err != ErrSessionNotFound {
+		t.Fatalf("Set on an expired session: %v", err)
+	}
+
+	conn3 := newTestConn(3)
+	m.OnAuthAccept(conn3, 1001)
+	if fresh, _ := m.Get("1001"); fresh.ID() == s.ID() {
+		t.Fatal("expired session reused")
+	}
+	if err := m.Remove("1001"); err != nil || m.GetByConn(conn3) != nil {
+		t.Fatalf("Remove: %v", err)
+	}
+}
+
+func TestManagerRestart(t *testing.T) {
+	store, err := NewFileStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, _ := NewManager(store, WithTTL(time.Second))
+	m.OnAuthAccept(newTestConn(1), "alice")
+	s, _ := m.Get("alice")
+	_ = s.Set("level", "3")
+
+	// The server stops while alice is online (服务在alice在线时停止)
+	restarted, err := NewManager(store, WithTTL(time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+	again, err := restarted.Get("alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if level, _ := again.Get("level"); level != "3" || again.ID() != s.ID() || again.ExpiresAt().IsZero() {
+		t.Fatalf("restored session %+v", again)
+	}
+
+	time.Sleep(1500 * time.Millisecond)
+	if data, _ := store.Load("alice"); data != nil {
+		t.Fatal("expired session still in the store")
+	}
+}
@@ -0,0 +1,74 @@
+package zsession
+
+import (
+	"sync"
+	"time"
+
+	"github.com/aceld/zinx/ziface"
+)
+
+// session is the session of a user kept by a manager (manager保存的用户会话)
+type session struct {
+	manager *manager
+
+	lock    sync.Mutex
+	data    *ziface.SessionData
+	conn    ziface.IConnection // nil while offline (离线时为nil)
+	timerID uint32             // Expiry timer while offline (离线时的过期定时器)
+	removed bool
+}
+
+func (s *session) ID() string {
+	return s.data.ID
+}
+
+func (s *session) UserID() string {
+	return s.data.UserID
+}
+
+func (s *session) GetConnection() ziface.IConnection {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.conn
+}
+
+func (s *session) Get(key string) (string, bool) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	value, ok := s.data.Values[key]
+	return value, ok
+}
+
+func (s *session) Set(key, value string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.removed {
+		return ErrSessionNotFound
+	}
+	s.data.Values[key] = value
+	return s.manager.store.Save(s.data)
+}
+
+func (s *session) Remove(key string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.removed {
+		return ErrSessionNotFound
+	}
+	delete(s.data.Values, key)
+	return s.manager.store.Save(s.data)
+}
+
+func (s *session) ExpiresAt() time.Time {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.data.ExpiresAt
+}
+
+// cancelExpiry stops the expiry timer, s.lock must be held (停止过期定时器, 调用时需持有s.lock)
+func (s *session) cancelExpiry() {
+	if s.timerID != 0 {
+		s.manager.scheduler.CancelTimer(s.timerID)
+		s.timerID = 0
+	}
+}
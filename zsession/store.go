@@ -0,0 +1,63 @@
+package zsession
+
+import (
+	"sync"
+
+	"github.com/aceld/zinx/ziface"
+)
+
+// memoryStore keeps the sessions in memory, they are lost when the process exits
+// (在内存中保存会话, 进程退出后丢失)
+type memoryStore struct {
+	lock     sync.RWMutex
+	sessions map[string]*ziface.SessionData
+}
+
+// NewMemoryStore returns a session store in memory (返回基于内存的会话存储)
+func NewMemoryStore() ziface.ISessionStore {
+	return &memoryStore{sessions: make(map[string]*ziface.SessionData)}
+}
+
+func (s *memoryStore) Load(userID string) (*ziface.SessionData, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	if data, ok := s.sessions[userID]; ok {
+		return cloneData(data), nil
+	}
+	return nil, nil
+}
+
+func (s *memoryStore) Save(data *ziface.SessionData) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.sessions[data.UserID] = cloneData(data)
+	return nil
+}
+
+func (s *memoryStore) Delete(userID string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	delete(s.sessions, userID)
+	return nil
+}
+
+func (s *memoryStore) List() ([]*ziface.SessionData, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	list := make([]*ziface.SessionData, 0, len(s.sessions))
+	for _, data := range s.sessions {
+		list = append(list, cloneData(data))
+	}
+	return list, nil
+}
+
+// cloneData copies data so that the store and the session never share the values
+// (复制data, 使存储与会话不共享Values)
+func cloneData(data *ziface.SessionData) *ziface.SessionData {
+	c := *data
+	c.Values = make(map[string]string, len(data.Values))
+	for k, v := range data.Values {
+		c.Values[k] = v
+	}
+	return &c
+}
@@ -0,0 +1,60 @@
+package zsession
+
+import (
+	"testing"
+	"time"
+
+	"github.com/aceld/zinx/ziface"
+)
+
+// run in terminal:
+// go test -v ./zsession -run=TestStore
+
+func testStore(t *testing.T, store ziface.ISessionStore) {
+	if data, err := store.Load("1001"); data != nil || err != nil {
+		t.Fatalf("Load of a missing user = %v, %v", data, err)
+	}
+
+	data := &ziface.SessionData{ID: "s1", UserID: "1001", Values: map[string]string{"room": "7"}, CreatedAt: time.Now().Truncate(time.Second)}
+	if err := store.Save(data); err != nil {
+		t.Fatal(err)
+	}
+	// The store keeps its own copy (存储保存自己的副本)
+	data.Values["room"] = "8"
+
+	got, err := store.Load("1001")
+	if err != nil || got == nil {
+		t.Fatalf("Load = %v, %v", got, err)
+	}
+	if got.ID != "s1" || got.Values["room"] != "7" || !got.CreatedAt.Equal(data.CreatedAt) {
+		t.Fatalf("Load = %+v", got)
+	}
+
+	_ = store.Save(&ziface.SessionData{ID: "s2", UserID: "user/2"})
+	list, err := store.List()
+	if err != nil || len(list) != 2 {
+		t.Fatalf("List = %v, %v", list, err)
+	}
+
+	if err := store.Delete("1001"); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Delete("1001"); err != nil {
+		t.Fatalf("deleting twice: %v", err)
+	}
+	if data, _ := store.Load("1001"); data != nil {
+		t.Fatal("deleted session loaded")
+	}
+}
+
+func TestStoreMemory(t *testing.T) {
+	testStore(t, NewMemoryStore())
+}
+
+func TestStoreFile(t *testing.T) {
+	store, err := NewFileStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	testStore(t, store)
+}
@@ -0,0 +1,222 @@
+package ztimer
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/*
+	cron表达式, 支持标准的5个字段或带秒的6个字段:
+
+	[秒] 分 时 日 月 周
+	 *    *  *  *  *  *
+
+	每个字段支持 * ? 数值 a-b 列表(a,b,c) 步长(*\/n, a-b/n, a/n), 月和周支持英文缩写(JAN, MON),
+	周的0和7都表示周日; 日和周都被限定时满足其中之一即可触发。
+	另外支持 @yearly @monthly @weekly @daily @midnight @hourly 描述符。
+*/
+
+// cronField describes the range and the names of one field (cron字段的取值范围及名称)
+type cronField struct {
+	min, max uint
+	names    map[string]uint
+}
+
+var (
+	cronSeconds = cronField{0, 59, nil}
+	cronMinutes = cronField{0, 59, nil}
+	cronHours   = cronField{0, 23, nil}
+	cronDom     = cronField{1, 31, nil}
+	cronMonths  = cronField{1, 12, map[string]uint{
+		"jan": 1, "feb": 2, "mar": 3, "apr": 4, "may": 5, "jun": 6,
+		"jul": 7, "aug": 8, "sep": 9, "oct": 10, "nov": 11, "dec": 12,
+	}}
+	cronDow = cronField{0, 7, map[string]uint{
+		"sun": 0, "mon": 1, "tue": 2, "wed": 3, "thu": 4, "fri": 5, "sat": 6,
+	}}
+)
+
+var cronDescriptors = map[string]string{
+	"@yearly":   "0 0 0 1 1 *",
+	"@annually": "0 0 0 1 1 *",
+	"@monthly":  "0 0 0 1 * *",
+	"@weekly":   "0 0 0 * * 0",
+	"@daily":    "0 0 0 * * *",
+	"@midnight": "0 0 0 * * *",
+	"@hourly":   "0 0 * * * *",
+}
+
+// CronExpr is a parsed cron expression (解析后的cron表达式)
+type CronExpr struct {
+	spec string
+
+	// One bit per allowed value (每个允许的取值占一位)
+	second, minute, hour, dom, month, dow uint64
+
+	// Whether the day fields are "*", see dayMatches (日、周字段是否为"*")
+	domStar, dowStar bool
+}
+
+// ParseCron parses a cron expression of 5 fields, or 6 fields starting with the seconds
+// (解析5个字段或以秒开头的6个字段的cron表达式)
+func ParseCron(spec string) (*CronExpr, error) {
+	fields := strings.Fields(spec)
+	if len(fields) == 1 {
+		if expanded, ok := cronDescriptors[strings.ToLower(fields[0])]; ok {
+			fields = strings.Fields(expanded)
+		}
+	}
+	switch len(fields) {
+	case 5:
+		fields = append([]string{"0"}, fields...)
+	case 6:
+	default:
+		return nil, fmt.Errorf("cron %q: expected 5 or 6 fields, got %d", spec, len(fields))
+	}
+
+	expr := &CronExpr{spec: spec}
+	var err error
+	parsers := []struct {
+		bits  *uint64
+		field cronField
+	}{
+		{&expr.second, cronSeconds},
+		{&expr.minute, cronMinutes},
+		{&expr.hour, cronHours},
+		{&expr.dom, cronDom},
+		{&expr.month, cronMonths},
+		{&expr.dow, cronDow},
+	}
+	for i, p := range parsers {
+		if *p.bits, err = p.field.parse(fields[i]); err != nil {
+			return nil, fmt.Errorf("cron %q: %v", spec, err)
+		}
+	}
+	// Sunday is both 0 and 7 (周日可以写作0或7)
+	if expr.dow&(1<<7) != 0 {
+		expr.dow |= 1
+	}
+	expr.domStar = fields[3] == "*" || fields[3] == "?"
+	expr.dowStar = fields[5] == "*" || fields[5] == "?"
+	return expr, nil
+}
+
+// MustParseCron is like ParseCron but panics when the expression is invalid (与ParseCron相同, 表达式错误时panic)
+func MustParseCron(spec string) *CronExpr {
+	expr, err := ParseCron(spec)
+	if err != nil {
+		panic(err)
+	}
+	return expr
+}
+
+// String returns the expression as it was given (返回原始表达式)
+func (c *CronExpr) String() string {
+	return c.spec
+}
+
+func (f cronField) parse(field string) (uint64, error) {
+	var bits uint64
+	for _, part := range strings.Split(field, ",") {
+		low, high, step := f.min, f.max, uint(1)
+
+		rangePart := part
+		if i := strings.IndexByte(part, '/'); i >= 0 {
+			n, err := strconv.ParseUint(part[i+1:], 10, 8)
+			if err != nil || n == 0 {
+				return 0, fmt.Errorf("invalid step in %q", part)
+			}
+			step, rangePart = uint(n), part[:i]
+		}
+
+		switch {
+		case rangePart == "*" || rangePart == "?":
+		case strings.IndexByte(rangePart, '-') > 0:
+			i := strings.IndexByte(rangePart, '-')
+			var err error
+			if low, err = f.value(rangePart[:i]); err != nil {
+				return 0, err
+			}
+			if high, err = f.value(rangePart[i+1:]); err != nil {
+				return 0, err
+			}
+		default:
+			var err error
+			if low, err = f.value(rangePart); err != nil {
+				return 0, err
+			}
+			// "a/n" runs from a to the end, "a" is a single value ("a/n"从a到最大值, "a"为单个值)
+			if step == 1 {
+				high = low
+			}
+		}
+		if low > high {
+			return 0, fmt.Errorf("invalid range in %q", part)
+		}
+		for v := low; v <= high; v += step {
+			bits |= 1 << v
+		}
+	}
+	return bits, nil
+}
+
+func (f cronField) value(s string) (uint, error) {
+	if v, ok := f.names[strings.ToLower(s)]; ok {
+		return v, nil
+	}
+	n, err := strconv.ParseUint(s, 10, 8)
+	if err != nil {
+		return 0, fmt.Errorf("invalid value %q", s)
+	}
+	if uint(n) < f.min || uint(n) > f.max {
+		return 0, fmt.Errorf("value %d out of range [%d, %d]", n, f.min, f.max)
+	}
+	return uint(n), nil
+}
+
+// Next returns the first time after t matched by the expression, in the location of t.
+// The zero time is returned when nothing matches within five years (e.g. "0 0 30 2 *").
+// (返回t之后第一个匹配的时间, 使用t的时区; 五年内没有匹配的时间(如"0 0 30 2 *")时返回零值)
+func (c *CronExpr) Next(t time.Time) time.Time {
+	loc := t.Location()
+	t = t.Truncate(time.Second).Add(time.Second)
+	yearLimit := t.Year() + 5
+
+	for t.Year() <= yearLimit {
+		if c.month&(1<<uint(t.Month())) == 0 {
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, loc)
+			continue
+		}
+		if !c.dayMatches(t) {
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, loc)
+			continue
+		}
+		if c.hour&(1<<uint(t.Hour())) == 0 {
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, loc)
+			continue
+		}
+		if c.minute&(1<<uint(t.Minute())) == 0 {
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute()+1, 0, 0, loc)
+			continue
+		}
+		if c.second&(1<<uint(t.Second())) == 0 {
+			t = t.Add(time.Second)
+			continue
+		}
+		return t
+	}
+	return time.Time{}
+}
+
+// dayMatches follows the usual cron rule: when both the day of month and the day of week are restricted,
+// matching either of them is enough (与常见cron一致: 日和周都被限定时满足其中之一即可)
+func (c *CronExpr) dayMatches(t time.Time) bool {
+	domMatch := c.dom&(1<<uint(t.Day())) != 0
+	dowMatch := c.dow&(1<<uint(t.Weekday())) != 0
+	if c.domStar || c.dowStar {
+		return domMatch && dowMatch
+	}
+	return domMatch || dowMatch
+}
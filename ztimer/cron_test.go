@@ -0,0 +1,56 @@
+package ztimer
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseCron(t *testing.T) {
+	for _, spec := range []string{"* * *", "60 * * * *", "* * * 13 *", "*/0 * * * *", "5-1 * * * *", "* * * * foo", "1 2 3 4 5 6 7"} {
+		if _, err := ParseCron(spec); err == nil {
+			t.Errorf("ParseCron(%q) should fail", spec)
+		}
+	}
+	for _, spec := range []string{"0 4 * * *", "*/15 * * * * *", "0 0 9-18/3 * * mon-fri", "30 5 1,15 * *", "@daily", "0 0 * * 7"} {
+		if _, err := ParseCron(spec); err != nil {
+			t.Errorf("ParseCron(%q): %v", spec, err)
+		}
+	}
+}
+
+func TestCronNext(t *testing.T) {
+	at := func(s string) time.Time {
+		v, err := time.ParseInLocation("2006-01-02 15:04:05", s, time.UTC)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return v
+	}
+
+	cases := []struct {
+		spec, from, want string
+	}{
+		// Daily reset at 04:00 (每日4点重置)
+		{"0 4 * * *", "2024-03-10 03:59:59", "2024-03-10 04:00:00"},
+		{"0 4 * * *", "2024-03-10 04:00:00", "2024-03-11 04:00:00"},
+		{"@daily", "2024-12-31 12:00:00", "2025-01-01 00:00:00"},
+		{"*/15 * * * * *", "2024-03-10 10:00:16", "2024-03-10 10:00:30"},
+		{"0 0 9-18/3 * * mon-fri", "2024-03-08 19:00:00", "2024-03-11 09:00:00"},
+		{"30 5 1,15 * *", "2024-01-15 05:30:00", "2024-02-01 05:30:00"},
+		{"0 0 29 2 *", "2024-03-01 00:00:00", "2028-02-29 00:00:00"},
+		// Sunday written as 7 (周日写作7)
+		{"0 0 * * 7", "2024-03-05 00:00:00", "2024-03-10 00:00:00"},
+		// Day of month or day of week (日或周满足其一)
+		{"0 0 13 * fri", "2024-09-01 00:00:00", "2024-09-06 00:00:00"},
+	}
+	for _, c := range cases {
+		got := MustParseCron(c.spec).Next(at(c.from))
+		if want := at(c.want); !got.Equal(want) {
+			t.Errorf("%q after %s = %s, want %s", c.spec, c.from, got, want)
+		}
+	}
+
+	if got := MustParseCron("0 0 30 2 *").Next(at("2024-01-01 00:00:00")); !got.IsZero() {
+		t.Errorf("Feb 30 matched %s", got)
+	}
+}
@@ -0,0 +1,367 @@
+package ztimer
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/aceld/zinx/ziface"
+)
+
+// ErrTimerNotFound is returned for an unknown or finished timer (定时器不存在或已结束)
+var ErrTimerNotFound = errors.New("timer not found")
+
+// TimerOption configures a timer created by TimerScheduler (定时器选项)
+type TimerOption func(o *timerOptions)
+
+type timerOptions struct {
+	conn ziface.IConnection
+	name string
+	loc  *time.Location
+}
+
+// WithTimerConn runs the callback on the MsgHandle worker of conn, single-threaded with the handlers of that connection.
+// Without a worker pool the handlers have no worker, the callback then runs like the timers without a connection.
+// The callback is dropped once the connection is closed.
+// (在conn所属的MsgHandle worker中执行回调, 与该连接的业务处理在同一协程; 未开启worker池时业务处理没有所属的worker,
+// 回调与不指定连接的定时器一样执行; 连接关闭后不再执行)
+func WithTimerConn(conn ziface.IConnection) TimerOption {
+	return func(o *timerOptions) {
+		o.conn = conn
+	}
+}
+
+// WithTimerName names the timer so it is kept by Snapshot and bound again by Restore
+// (为定时器命名, 使其能被Snapshot保存并被Restore重新绑定)
+func WithTimerName(name string) TimerOption {
+	return func(o *timerOptions) {
+		o.name = name
+	}
+}
+
+// WithTimerLocation sets the time zone of a cron timer, time.Local by default
+// (设置cron定时器的时区, 默认为time.Local)
+func WithTimerLocation(loc *time.Location) TimerOption {
+	return func(o *timerOptions) {
+		o.loc = loc
+	}
+}
+
+// timerRequest runs a timer callback on a MsgHandle worker (在MsgHandle worker中执行定时回调的请求)
+type timerRequest struct {
+	ziface.BaseRequest
+	conn ziface.IConnection
+	df   *DelayFunc
+}
+
+func (r *timerRequest) GetConnection() ziface.IConnection {
+	return r.conn
+}
+
+func (r *timerRequest) CallFunc() {
+	r.df.Call()
+}
+
+// onConnWorker wraps df so calling it queues df on the worker of conn, or calls df when there is no worker pool
+// (包装df, 调用时将其投递到conn所属的worker, 没有worker池时直接调用df)
+func onConnWorker(conn ziface.IConnection, df *DelayFunc) *DelayFunc {
+	return NewDelayFunc(func(v ...interface{}) {
+		if !conn.IsAlive() {
+			return
+		}
+		mh := conn.GetMsgHandler()
+		if len(mh.GetWorkerStats().Workers) == 0 {
+			df.Call()
+			return
+		}
+		mh.SendMsgToTaskQueue(&timerRequest{conn: conn, df: df})
+	}, nil)
+}
+
+// schedule is the state of a timer in TimerScheduler (定时器在调度器中的状态)
+type schedule struct {
+	df   *DelayFunc
+	name string
+	// Whether df runs on the worker of a connection (df是否在连接所属的worker中执行)
+	onConn bool
+
+	// Unix time in ms of the next call (下次调用的unix时间, 单位ms)
+	next int64
+	// Period of a repeating timer in ms (重复定时器的周期, 单位ms)
+	every int64
+	cron  *CronExpr
+	loc   *time.Location
+
+	paused bool
+	// Time left in ms when the timer was paused (暂停时剩余的时间, 单位ms)
+	remaining int64
+}
+
+func (s *schedule) repeat() bool {
+	return s.every > 0 || s.cron != nil
+}
+
+// following returns the call after the current one, missed periods are skipped rather than run late one after another.
+// A repeating timer keeps to its own timeline, so the time taken by the callbacks does not add up.
+// (返回本次之后的下一次调用时间, 错过的周期直接跳过; 重复定时器按自身的时间线计算, 回调耗时不会累积误差)
+func (s *schedule) following(now int64) int64 {
+	if s.cron != nil {
+		from := s.next
+		if now > from {
+			from = now
+		}
+		next := s.cron.Next(time.UnixMilli(from).In(s.loc))
+		if next.IsZero() {
+			return 0
+		}
+		return next.UnixMilli()
+	}
+
+	next := s.next + s.every
+	if next <= now {
+		next += ((now-next)/s.every + 1) * s.every
+	}
+	return next
+}
+
+// CreateTimerEvery creates a timer calling df every interval until cancelled, the first call is after one interval.
+// The precision is MaxTimeDelay ms.
+// (创建每隔interval调用一次df的定时器, 直到被取消, 第一次调用在一个interval之后; 精度为MaxTimeDelay毫秒)
+func (ts *TimerScheduler) CreateTimerEvery(df *DelayFunc, interval time.Duration, opts ...TimerOption) (uint32, error) {
+	every := interval.Milliseconds()
+	if every <= 0 {
+		return 0, fmt.Errorf("timer interval %v is less than 1ms", interval)
+	}
+
+	ts.Lock()
+	defer ts.Unlock()
+
+	return ts.addSchedule(&schedule{next: UnixMilli() + every, every: every}, df, opts)
+}
+
+// CreateTimerCron creates a timer calling df at the times of a cron expression, see ParseCron
+// (创建按cron表达式调用df的定时器, 见ParseCron)
+func (ts *TimerScheduler) CreateTimerCron(df *DelayFunc, spec string, opts ...TimerOption) (uint32, error) {
+	expr, err := ParseCron(spec)
+	if err != nil {
+		return 0, err
+	}
+
+	ts.Lock()
+	defer ts.Unlock()
+
+	return ts.addSchedule(&schedule{cron: expr}, df, opts)
+}
+
+// addSchedule registers s and puts it on the time wheels, ts must be locked
+// (注册s并将其加入时间轮, 调用方需持有ts的锁)
+func (ts *TimerScheduler) addSchedule(s *schedule, df *DelayFunc, opts []TimerOption) (uint32, error) {
+	var o timerOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+	if o.conn != nil {
+		df, s.onConn = onConnWorker(o.conn, df), true
+	}
+	s.df, s.name, s.loc = df, o.name, o.loc
+	if s.loc == nil {
+		s.loc = time.Local
+	}
+	if s.cron != nil {
+		next := s.cron.Next(time.Now().In(s.loc))
+		if next.IsZero() {
+			return 0, fmt.Errorf("cron %q never fires", s.cron)
+		}
+		s.next = next.UnixMilli()
+	}
+
+	// After wrapping around, skip 0 and the IDs of the timers still scheduled (回绕后跳过0和仍在调度中的定时器ID)
+	for {
+		ts.IDGen++
+		if _, used := ts.schedules[ts.IDGen]; ts.IDGen != 0 && !used {
+			break
+		}
+	}
+	if !s.paused {
+		if err := ts.tw.AddTimer(ts.IDGen, &Timer{delayFunc: s.df, unixts: s.next}); err != nil {
+			return 0, err
+		}
+	}
+	ts.schedules[ts.IDGen] = s
+	return ts.IDGen, nil
+}
+
+// fired is called when the wheels give out a timer, it returns the function to call or nil when the timer
+// was cancelled, paused or rescheduled meanwhile. Repeating timers are put back on the wheels.
+// (时间轮取出定时器时调用, 返回需要执行的函数; 期间已被取消、暂停或重新调度的定时器返回nil, 重复定时器重新加入时间轮)
+func (ts *TimerScheduler) fired(tID uint32, timer *Timer) *DelayFunc {
+	ts.Lock()
+	defer ts.Unlock()
+
+	s, ok := ts.schedules[tID]
+	if !ok || s.paused || s.next != timer.unixts {
+		return nil
+	}
+	if !s.repeat() {
+		delete(ts.schedules, tID)
+		return s.df
+	}
+
+	if s.next = s.following(UnixMilli()); s.next == 0 {
+		delete(ts.schedules, tID)
+	} else {
+		_ = ts.tw.AddTimer(tID, &Timer{delayFunc: s.df, unixts: s.next})
+	}
+	return s.df
+}
+
+// removeTimer takes tID off every time wheel, ts must be locked (从所有时间轮上移除tID, 调用方需持有ts的锁)
+func (ts *TimerScheduler) removeTimer(tID uint32) {
+	tw := ts.tw
+	for tw != nil {
+		tw.RemoveTimer(tID)
+		tw = tw.nextTimeWheel
+	}
+}
+
+// PauseTimer stops a timer without losing it, ResumeTimer continues it (暂停定时器, 可通过ResumeTimer恢复)
+func (ts *TimerScheduler) PauseTimer(tID uint32) error {
+	ts.Lock()
+	defer ts.Unlock()
+
+	s, ok := ts.schedules[tID]
+	if !ok {
+		return ErrTimerNotFound
+	}
+	if s.paused {
+		return nil
+	}
+	s.paused = true
+	if s.remaining = s.next - UnixMilli(); s.remaining < 0 {
+		s.remaining = 0
+	}
+	ts.removeTimer(tID)
+	return nil
+}
+
+// ResumeTimer continues a paused timer. One-shot and repeating timers get the time that was left when paused,
+// cron timers go on with their next time from now.
+// (恢复暂停的定时器; 一次性和重复定时器继续暂停时剩余的时间, cron定时器从当前时间计算下一次)
+func (ts *TimerScheduler) ResumeTimer(tID uint32) error {
+	ts.Lock()
+	defer ts.Unlock()
+
+	s, ok := ts.schedules[tID]
+	if !ok {
+		return ErrTimerNotFound
+	}
+	if !s.paused {
+		return nil
+	}
+	now := UnixMilli()
+	if s.cron != nil {
+		next := s.cron.Next(time.UnixMilli(now).In(s.loc))
+		if next.IsZero() {
+			delete(ts.schedules, tID)
+			return ErrTimerNotFound
+		}
+		s.next = next.UnixMilli()
+	} else {
+		s.next = now + s.remaining
+	}
+	s.paused = false
+	return ts.tw.AddTimer(tID, &Timer{delayFunc: s.df, unixts: s.next})
+}
+
+// TimerState is the saved state of a named timer, see Snapshot (命名定时器的保存状态, 见Snapshot)
+type TimerState struct {
+	Name      string `json:"name"`
+	Cron      string `json:"cron,omitempty"`
+	Location  string `json:"location,omitempty"`
+	Every     int64  `json:"every,omitempty"`     // Period in ms (周期, 单位ms)
+	Next      int64  `json:"next"`                // Unix time in ms of the next call (下次调用的unix时间, 单位ms)
+	Paused    bool   `json:"paused,omitempty"`    // Whether the timer is paused (是否暂停)
+	Remaining int64  `json:"remaining,omitempty"` // Time left in ms when paused (暂停时剩余的时间, 单位ms)
+}
+
+// Snapshot returns the state of the timers created with WithTimerName, for example to save them as JSON
+// before the server restarts. Timers of WithTimerConn are not saved since connections do not survive a restart.
+// (返回通过WithTimerName创建的定时器状态, 可在服务器重启前保存为JSON; WithTimerConn的定时器不保存, 连接在重启后不再存在)
+func (ts *TimerScheduler) Snapshot() []TimerState {
+	ts.RLock()
+	defer ts.RUnlock()
+
+	ids := make([]uint32, 0, len(ts.schedules))
+	for tID, s := range ts.schedules {
+		if s.name != "" && !s.onConn {
+			ids = append(ids, tID)
+		}
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+
+	states := make([]TimerState, 0, len(ids))
+	for _, tID := range ids {
+		s := ts.schedules[tID]
+		state := TimerState{
+			Name:      s.name,
+			Every:     s.every,
+			Next:      s.next,
+			Paused:    s.paused,
+			Remaining: s.remaining,
+		}
+		if s.cron != nil {
+			state.Cron = s.cron.String()
+			state.Location = s.loc.String()
+		}
+		states = append(states, state)
+	}
+	return states
+}
+
+// Restore creates the timers saved by Snapshot again, resolve returns the function of a timer name, timers it
+// returns nil for are skipped. Calls missed while the server was down run once as soon as possible for one-shot
+// timers and are skipped for repeating ones.
+// (重新创建Snapshot保存的定时器, resolve根据定时器名称返回对应的函数, 返回nil的定时器被跳过;
+// 停机期间错过的调用, 一次性定时器尽快执行一次, 重复定时器直接跳过)
+func (ts *TimerScheduler) Restore(states []TimerState, resolve func(name string) *DelayFunc) error {
+	ts.Lock()
+	defer ts.Unlock()
+
+	now := UnixMilli()
+	for _, state := range states {
+		df := resolve(state.Name)
+		if df == nil {
+			continue
+		}
+		s := &schedule{
+			next:      state.Next,
+			every:     state.Every,
+			paused:    state.Paused,
+			remaining: state.Remaining,
+		}
+		opts := []TimerOption{WithTimerName(state.Name)}
+		if state.Cron != "" {
+			expr, err := ParseCron(state.Cron)
+			if err != nil {
+				return err
+			}
+			s.cron = expr
+			loc, err := time.LoadLocation(state.Location)
+			if err != nil {
+				return err
+			}
+			opts = append(opts, WithTimerLocation(loc))
+		} else if s.next < now && !s.paused {
+			if s.every > 0 {
+				s.next = s.following(now)
+			} else {
+				s.next = now
+			}
+		}
+		if _, err := ts.addSchedule(s, df, opts); err != nil {
+			return err
+		}
+	}
+	return nil
+}
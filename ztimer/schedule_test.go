@@ -0,0 +1,201 @@
+package ztimer
+
+import (
+	"math"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/aceld/zinx/ziface"
+)
+
+func countFunc(n *int32) *DelayFunc {
+	return NewDelayFunc(func(v ...interface{}) {
+		atomic.AddInt32(n, 1)
+	}, nil)
+}
+
+func TestTimerEvery(t *testing.T) {
+	ts := NewAutoExecTimerScheduler()
+
+	var n int32
+	tID, err := ts.CreateTimerEvery(countFunc(&n), 200*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(1100 * time.Millisecond)
+	if got := atomic.LoadInt32(&n); got < 4 || got > 6 {
+		t.Fatalf("called %d times in 1.1s, want about 5", got)
+	}
+
+	// Paused timers do not fire and keep their remaining time (暂停期间不触发)
+	if err := ts.PauseTimer(tID); err != nil {
+		t.Fatal(err)
+	}
+	paused := atomic.LoadInt32(&n)
+	time.Sleep(500 * time.Millisecond)
+	if got := atomic.LoadInt32(&n); got != paused {
+		t.Fatalf("called %d times while paused", got-paused)
+	}
+	if err := ts.ResumeTimer(tID); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(500 * time.Millisecond)
+	if got := atomic.LoadInt32(&n); got == paused {
+		t.Fatal("not called after resume")
+	}
+
+	ts.CancelTimer(tID)
+	if err := ts.PauseTimer(tID); err != ErrTimerNotFound {
+		t.Fatalf("err = %v, want %v", err, ErrTimerNotFound)
+	}
+	if _, err := ts.CreateTimerEvery(countFunc(&n), 0); err == nil {
+		t.Fatal("zero interval accepted")
+	}
+}
+
+func TestTimerIDWrap(t *testing.T) {
+	ts := NewAutoExecTimerScheduler()
+
+	var n int32
+	first, err := ts.CreateTimerEvery(countFunc(&n), time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ts.CancelTimer(first)
+
+	// The IDs wrap around without reusing the one of a live timer (ID回绕后不会复用仍存在的定时器的ID)
+	ts.Lock()
+	ts.IDGen = math.MaxUint32
+	ts.Unlock()
+	tID, err := ts.CreateTimerEvery(countFunc(&n), time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tID == 0 || tID == first {
+		t.Fatalf("timer ID = %d, the first timer has %d", tID, first)
+	}
+	ts.CancelTimer(tID)
+	if err := ts.PauseTimer(first); err != nil {
+		t.Fatalf("first timer lost: %v", err)
+	}
+}
+
+func TestTimerEveryDrift(t *testing.T) {
+	s := &schedule{next: 1000, every: 100}
+	// The slow callback does not push the timeline (回调耗时不推迟时间线)
+	if next := s.following(1030); next != 1100 {
+		t.Fatalf("next = %d, want 1100", next)
+	}
+	// Missed periods are skipped (错过的周期直接跳过)
+	if next := s.following(1350); next != 1400 {
+		t.Fatalf("next = %d, want 1400", next)
+	}
+}
+
+func TestTimerCron(t *testing.T) {
+	ts := NewAutoExecTimerScheduler()
+
+	var n int32
+	tID, err := ts.CreateTimerCron(countFunc(&n), "* * * * * *")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ts.CancelTimer(tID)
+	time.Sleep(2500 * time.Millisecond)
+	if got := atomic.LoadInt32(&n); got < 2 || got > 3 {
+		t.Fatalf("called %d times in 2.5s, want 2 or 3", got)
+	}
+	if _, err := ts.CreateTimerCron(countFunc(&n), "0 0 30 2 *"); err == nil {
+		t.Fatal("cron that never fires accepted")
+	}
+}
+
+func TestTimerSnapshot(t *testing.T) {
+	ts := NewTimerScheduler()
+	var n int32
+	ts.CreateTimerCron(countFunc(&n), "0 4 * * *", WithTimerName("daily-reset"), WithTimerLocation(time.UTC))
+	every, _ := ts.CreateTimerEvery(countFunc(&n), time.Minute, WithTimerName("save"))
+	ts.PauseTimer(every)
+	ts.CreateTimerAfter(countFunc(&n), time.Minute)
+
+	states := ts.Snapshot()
+	if len(states) != 2 || states[0].Cron != "0 4 * * *" || states[0].Location != "UTC" || !states[1].Paused {
+		t.Fatalf("states = %+v", states)
+	}
+
+	restored := NewTimerScheduler()
+	if err := restored.Restore(states, func(name string) *DelayFunc {
+		return countFunc(&n)
+	}); err != nil {
+		t.Fatal(err)
+	}
+	again := restored.Snapshot()
+	if len(again) != 2 || again[0] != states[0] || again[1] != states[1] {
+		t.Fatalf("restored %+v, want %+v", again, states)
+	}
+}
+
+// fakeConn and fakeMsgHandle implement only what a timer of WithTimerConn uses
+type fakeConn struct {
+	ziface.IConnection
+	mh *fakeMsgHandle
+}
+
+func (c *fakeConn) IsAlive() bool                    { return true }
+func (c *fakeConn) GetMsgHandler() ziface.IMsgHandle { return c.mh }
+
+type fakeMsgHandle struct {
+	ziface.IMsgHandle
+	queue   chan ziface.IRequest
+	workers int
+}
+
+func (mh *fakeMsgHandle) GetWorkerStats() ziface.WorkerStats {
+	return ziface.WorkerStats{Workers: make([]ziface.WorkerStat, mh.workers)}
+}
+
+func (mh *fakeMsgHandle) SendMsgToTaskQueue(request ziface.IRequest) {
+	mh.queue <- request
+}
+
+func TestTimerConn(t *testing.T) {
+	ts := NewAutoExecTimerScheduler()
+	conn := &fakeConn{mh: &fakeMsgHandle{queue: make(chan ziface.IRequest, 1), workers: 1}}
+
+	var n int32
+	if _, err := ts.CreateTimerAfter(countFunc(&n), 100*time.Millisecond, WithTimerConn(conn)); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case request := <-conn.mh.queue:
+		// Not run until the worker takes it (由worker取出后才执行)
+		if atomic.LoadInt32(&n) != 0 || request.GetConnection() != conn {
+			t.Fatal("timer ran outside the worker")
+		}
+		request.(ziface.IFuncRequest).CallFunc()
+		if atomic.LoadInt32(&n) != 1 {
+			t.Fatal("timer not run by the worker")
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("timer not queued on the worker")
+	}
+}
+
+func TestTimerConnWithoutWorkerPool(t *testing.T) {
+	ts := NewAutoExecTimerScheduler()
+	conn := &fakeConn{mh: &fakeMsgHandle{}}
+
+	// Nothing is queued without a worker pool, the callback runs at once (没有worker池时不投递, 直接执行回调)
+	var n int32
+	if _, err := ts.CreateTimerAfter(countFunc(&n), 100*time.Millisecond, WithTimerConn(conn)); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for atomic.LoadInt32(&n) == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("timer not run without a worker pool")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -32,6 +32,8 @@ type TimerScheduler struct {
 	IDGen uint32
 	//已经触发定时器的channel
 	triggerChan chan *DelayFunc
+	//全部未结束定时器的调度状态(一次性、重复、cron)
+	schedules map[uint32]*schedule
 	//互斥锁
 	sync.RWMutex
 }
@@ -58,25 +60,24 @@ func NewTimerScheduler() *TimerScheduler {
 	return &TimerScheduler{
 		tw:          hourTw,
 		triggerChan: make(chan *DelayFunc, MaxChanBuff),
+		schedules:   make(map[uint32]*schedule),
 	}
 }
 
 // CreateTimerAt 创建一个定点Timer 并将Timer添加到分层时间轮中， 返回Timer的tID
-func (ts *TimerScheduler) CreateTimerAt(df *DelayFunc, unixNano int64) (uint32, error) {
+func (ts *TimerScheduler) CreateTimerAt(df *DelayFunc, unixNano int64, opts ...TimerOption) (uint32, error) {
 	ts.Lock()
 	defer ts.Unlock()
 
-	ts.IDGen++
-	return ts.IDGen, ts.tw.AddTimer(ts.IDGen, NewTimerAt(df, unixNano))
+	return ts.addSchedule(&schedule{next: unixNano / 1e6}, df, opts)
 }
 
 // CreateTimerAfter 创建一个延迟Timer 并将Timer添加到分层时间轮中， 返回Timer的tID
-func (ts *TimerScheduler) CreateTimerAfter(df *DelayFunc, duration time.Duration) (uint32, error) {
+func (ts *TimerScheduler) CreateTimerAfter(df *DelayFunc, duration time.Duration, opts ...TimerOption) (uint32, error) {
 	ts.Lock()
 	defer ts.Unlock()
 
-	ts.IDGen++
-	return ts.IDGen, ts.tw.AddTimer(ts.IDGen, NewTimerAfter(df, duration))
+	return ts.addSchedule(&schedule{next: (time.Now().UnixNano() + int64(duration)) / 1e6}, df, opts)
 }
 
 // CancelTimer 删除timer
@@ -84,11 +85,8 @@ func (ts *TimerScheduler) CancelTimer(tID uint32) {
 	ts.Lock()
 	defer ts.Unlock()
 
-	tw := ts.tw
-	for tw != nil {
-		tw.RemoveTimer(tID)
-		tw = tw.nextTimeWheel
-	}
+	delete(ts.schedules, tID)
+	ts.removeTimer(tID)
 }
 
 // GetTriggerChan 获取计时结束的延迟执行函数通道
@@ -104,12 +102,17 @@ func (ts *TimerScheduler) Start() {
 			now := UnixMilli()
 			//获取最近MaxTimeDelay 毫秒的超时定时器集合
 			timerList := ts.tw.GetTimerWithIn(MaxTimeDelay * time.Millisecond)
-			for _, timer := range timerList {
+			for tID, timer := range timerList {
+				//已取消、暂停或重新调度的定时器不再触发, 重复定时器重新加入时间轮
+				df := ts.fired(tID, timer)
+				if df == nil {
+					continue
+				}
 				if math.Abs(float64(now-timer.unixts)) > MaxTimeDelay {
 					//已经超时的定时器，报警
 					zlog.Error("want call at ", timer.unixts, "; real call at", now, "; delay ", now-timer.unixts)
 				}
-				ts.triggerChan <- timer.delayFunc
+				ts.triggerChan <- df
 			}
 			time.Sleep(MaxTimeDelay / 2 * time.Millisecond)
 		}